@@ -0,0 +1,15 @@
+package checker
+
+import (
+	"fmt"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"os/exec"
+)
+
+// CheckExecutable check that executable of interpreter is installed
+func (ch *Checker) CheckExecutable(name string) error {
+	if _, err := exec.LookPath(name); err != nil {
+		return fmt.Errorf("%w: %s", projectErrors.ErrInterpreterNotFound, name)
+	}
+	return nil
+}
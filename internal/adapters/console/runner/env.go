@@ -0,0 +1,55 @@
+package runner
+
+import (
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// serverVariables are variables of server config (see internal/config), they are not passed to commands.
+// Secrets key decrypts every secret and admin password gives access to everything
+var serverVariables = []string{
+	"ENV_FILE", "PORT", "LOG_LEVEL", "CONSOLE",
+	"SESSION_DETACH_TIMEOUT", "SESSION_SCROLLBACK_SIZE", "SESSION_OVERFLOW_POLICY",
+	"KILL_INTERRUPT_GRACE", "KILL_TERMINATE_GRACE", "WEBSOCKET_PING_INTERVAL", "WEBSOCKET_PING_TIMEOUT",
+	"COMMANDS_ENV_FILE", "SECRETS_KEY", "SECRETS_KEY_FILE", "ADMIN_USERNAME", "ADMIN_PASSWORD",
+	"LOGIN_SESSION_TTL", "LOGIN_COOKIE_SECURE", "CONFIG_FREEZE", "FROZEN_CONFIG_FILE",
+}
+
+// baseEnv return environment of current process without server config variables
+func baseEnv() []string {
+	env := os.Environ()
+	result := make([]string, 0, len(env))
+	for _, variable := range env {
+		name, _, _ := strings.Cut(variable, "=")
+		if runtime.GOOS == "windows" {
+			name = strings.ToUpper(name)
+		}
+		if !slices.Contains(serverVariables, name) {
+			result = append(result, variable)
+		}
+	}
+	return result
+}
+
+// mergeEnv add variables to environment of current process, server config variables are removed from it.
+// Later value override earlier one with same name, names on windows are case-insensitive.
+func mergeEnv(variables ...string) []string {
+	env := append(baseEnv(), variables...)
+	result := make([]string, 0, len(env))
+	index := make(map[string]int, len(env))
+	for _, variable := range env {
+		name, _, _ := strings.Cut(variable, "=")
+		if runtime.GOOS == "windows" {
+			name = strings.ToUpper(name)
+		}
+		if i, ok := index[name]; ok {
+			result[i] = variable
+			continue
+		}
+		index[name] = len(result)
+		result = append(result, variable)
+	}
+	return result
+}
@@ -0,0 +1,15 @@
+package runner
+
+import (
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/utils"
+	"strings"
+)
+
+// expandInterpreter return argv of interpreter with {{command}} replaced by command text
+func expandInterpreter(interpreter []string, command string) []string {
+	argv := make([]string, len(interpreter))
+	for i, arg := range interpreter {
+		argv[i] = strings.ReplaceAll(arg, utils.CommandPlaceholder, command)
+	}
+	return argv
+}
@@ -0,0 +1,158 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	"github.com/gofiber/fiber/v2/log"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// pipeDrainTimeout is how long output pipes are read after command exited, processes left in background can hold them open
+const pipeDrainTimeout = 2 * time.Second
+
+type pipeCommand struct {
+	cmd        *exec.Cmd
+	reaper     *reaper
+	tree       processTree
+	stdout     *pipeReader
+	stderr     *pipeReader
+	stdin      io.WriteCloser // nil if stdin is passed in options
+	startedAt  time.Time
+	done       chan struct{}
+	exitStatus entities.ExitStatus
+}
+
+// pipeReader close read end of pipe, when all output is read
+type pipeReader struct {
+	*os.File
+}
+
+func (r pipeReader) Read(p []byte) (int, error) {
+	n, err := r.File.Read(p)
+	if errors.Is(err, io.EOF) {
+		_ = r.File.Close()
+	}
+	return n, err
+}
+
+// closedInput is stdin of command, that got its whole input on start
+type closedInput struct{}
+
+func (closedInput) Write([]byte) (int, error) {
+	return 0, os.ErrClosed
+}
+
+// RunPipedCommand run command with plain pipes instead of pty, so stdout and stderr are separate and have no terminal sequences.
+// Stdin from options is piped to command, if it is nil, stdin stays open for input
+func (r Runner) RunPipedCommand(command string, interpreter []string, options entities.TerminalOptions) (entities.PipedCommand, error) {
+	cmd := r.pipedCmd(command, interpreter)
+	cmd.Dir = options.Dir
+	cmd.Env = mergeEnv(append(options.Env, "PWD="+options.Dir)...)
+
+	stdout, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, stderrWriter, err := os.Pipe()
+	if err != nil {
+		_ = stdout.Close()
+		_ = stdoutWriter.Close()
+		return nil, err
+	}
+	// Command gets write ends as files, so its output is not copied by goroutines of exec
+	cmd.Stdout = stdoutWriter
+	cmd.Stderr = stderrWriter
+	runningCommand := &pipeCommand{cmd: cmd, stdout: &pipeReader{stdout}, stderr: &pipeReader{stderr}, done: make(chan struct{})}
+	if options.Stdin != nil {
+		cmd.Stdin = options.Stdin
+	} else if runningCommand.stdin, err = cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+
+	err = cmd.Start()
+	_ = stdoutWriter.Close()
+	_ = stderrWriter.Close()
+	if err != nil {
+		_ = stdout.Close()
+		_ = stderr.Close()
+		return nil, fmt.Errorf("error starting command: %w", err)
+	}
+	runningCommand.startedAt = time.Now()
+	runningCommand.reaper = newReaper(cmd)
+	if runningCommand.tree, err = newProcessTree(cmd); err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		_ = stdout.Close()
+		_ = stderr.Close()
+		return nil, err
+	}
+	go runningCommand.wait()
+	return runningCommand, nil
+}
+
+func (c *pipeCommand) wait() {
+	defer close(c.done)
+	// Without pty nobody hangs up processes left in background, they are killed like after closing terminal
+	err := c.reaper.wait(func() error {
+		return c.tree.signal(entities.SignalKill)
+	})
+	c.tree.close()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			log.Debug("Error waiting for command ", err)
+		}
+	}
+	c.exitStatus = entities.ExitStatus{
+		Code:       c.cmd.ProcessState.ExitCode(),
+		Signal:     exitSignal(c.cmd.ProcessState),
+		DurationMs: time.Since(c.startedAt).Milliseconds(),
+	}
+	time.AfterFunc(pipeDrainTimeout, func() {
+		_ = c.stdout.Close()
+		_ = c.stderr.Close()
+	})
+	log.Debug("Command finished")
+}
+
+func (c *pipeCommand) GetReader() io.Reader {
+	return c.stdout
+}
+
+func (c *pipeCommand) GetErrorReader() io.Reader {
+	return c.stderr
+}
+
+func (c *pipeCommand) GetWriter() io.Writer {
+	if c.stdin == nil {
+		return closedInput{}
+	}
+	return c.stdin
+}
+
+func (c *pipeCommand) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *pipeCommand) ExitStatus() entities.ExitStatus {
+	return c.exitStatus
+}
+
+func (c *pipeCommand) Signal(signal entities.Signal) error {
+	return c.reaper.signal(func() error {
+		return c.tree.signal(signal)
+	})
+}
+
+// Resize does nothing, command has no terminal
+func (c *pipeCommand) Resize(cols, rows uint16) error {
+	return nil
+}
+
+func (c *pipeCommand) Kill() error {
+	return c.Signal(entities.SignalKill)
+}
@@ -0,0 +1,50 @@
+package runner
+
+import (
+	"github.com/gofiber/fiber/v2/log"
+	"os/exec"
+	"sync"
+)
+
+// reaper waits for command process and reaps it. Signals are sent only before process is reaped,
+// after that its pid and process group can be reused by another process
+type reaper struct {
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	reaped bool
+}
+
+func newReaper(cmd *exec.Cmd) *reaper {
+	return &reaper{cmd: cmd}
+}
+
+// signal call send, if process is not reaped yet
+func (r *reaper) signal(send func() error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.reaped {
+		return nil
+	}
+	return send()
+}
+
+// wait wait for command exit and reap it. Cleanup is called between them, to kill processes left in background,
+// when exited command still holds its pid
+func (r *reaper) wait(cleanup func() error) error {
+	if err := waitExit(r.cmd.Process); err != nil {
+		// Process is only reaped, nothing can be killed safely after it
+		log.Debug("Error waiting for command exit ", err)
+		err := r.cmd.Wait()
+		r.mu.Lock()
+		r.reaped = true
+		r.mu.Unlock()
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := cleanup(); err != nil {
+		log.Debug("Error killing processes left in background ", err)
+	}
+	r.reaped = true
+	return r.cmd.Wait()
+}
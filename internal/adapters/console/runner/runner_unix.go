@@ -3,18 +3,28 @@
 package runner
 
 import (
+	"errors"
 	"fmt"
 	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/utils"
 	"github.com/creack/pty"
 	"github.com/gofiber/fiber/v2/log"
+	"golang.org/x/sys/unix"
 	"io"
 	"os"
 	"os/exec"
+	"syscall"
+	"time"
 )
 
 type unixCommand struct {
-	cmd *exec.Cmd
-	pty *os.File
+	cmd        *exec.Cmd
+	reaper     *reaper
+	pty        *os.File
+	startedAt  time.Time
+	done       chan struct{}
+	exitStatus entities.ExitStatus
 }
 
 type Runner struct {
@@ -32,10 +42,20 @@ func New(
 	}
 }
 
-func (r Runner) RunCommand(command string, options entities.TerminalOptions) (entities.RunningCommand, error) {
-	cmd := exec.Command(r.console, "-c", command)
+// argv return argv of console with command, or of interpreter argv template if it is not nil
+func (r Runner) argv(command string, interpreter []string) []string {
+	if interpreter != nil {
+		return expandInterpreter(interpreter, command)
+	}
+	return []string{r.console, "-c", command}
+}
+
+// RunCommand run command in console, or with interpreter argv template if it is not nil
+func (r Runner) RunCommand(command string, interpreter []string, options entities.TerminalOptions) (entities.RunningCommand, error) {
+	argv := r.argv(command, interpreter)
+	cmd := exec.Command(argv[0], argv[1:]...)
 	cmd.Dir = options.Dir
-	cmd.Env = append(options.Env, "PWD="+options.Dir)
+	cmd.Env = mergeEnv(append(options.Env, "PWD="+options.Dir)...)
 
 	commandPty, err := pty.Start(cmd)
 	if err != nil {
@@ -47,26 +67,123 @@ func (r Runner) RunCommand(command string, options entities.TerminalOptions) (en
 		return nil, fmt.Errorf("error updating pty console size: %w", err)
 	}
 
-	return unixCommand{cmd: cmd, pty: commandPty}, err
+	runningCommand := &unixCommand{cmd: cmd, reaper: newReaper(cmd), pty: commandPty, startedAt: time.Now(), done: make(chan struct{})}
+	go runningCommand.wait()
+	return runningCommand, err
+}
+
+// pipedCmd create command in its own process group, so signals reach all its processes
+func (r Runner) pipedCmd(command string, interpreter []string) *exec.Cmd {
+	argv := r.argv(command, interpreter)
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return cmd
+}
+
+// QuoteArgument quote value in single quotes for sh, where nothing inside is expanded
+func (r Runner) QuoteArgument(argument string) string {
+	return utils.QuoteSh(argument)
+}
+
+func (c *unixCommand) wait() {
+	defer close(c.done)
+	// Processes left in background are killed like after closing terminal
+	err := c.reaper.wait(func() error {
+		return signalGroup(c.cmd.Process.Pid, entities.SignalKill)
+	})
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			log.Debug("Error waiting for command ", err)
+		}
+	}
+	c.exitStatus = entities.ExitStatus{
+		Code:       c.cmd.ProcessState.ExitCode(),
+		Signal:     exitSignal(c.cmd.ProcessState),
+		DurationMs: time.Since(c.startedAt).Milliseconds(),
+	}
+	log.Debug("Command finished")
+}
+
+// exitSignal return name of signal, that killed process, or empty string
+func exitSignal(state *os.ProcessState) string {
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return unix.SignalName(status.Signal())
+	}
+	return ""
 }
 
-func (c unixCommand) GetReader() io.Reader {
+func (c *unixCommand) GetReader() io.Reader {
 	return c.pty
 }
 
-func (c unixCommand) GetWriter() io.Writer {
+func (c *unixCommand) GetWriter() io.Writer {
 	return c.pty
 }
 
-func (c unixCommand) Done() <-chan error {
-	ch := make(chan error)
-	go func() {
-		ch <- c.cmd.Wait()
-		log.Debug("Command finished")
-	}()
-	return ch
+func (c *unixCommand) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *unixCommand) ExitStatus() entities.ExitStatus {
+	return c.exitStatus
 }
 
-func (c unixCommand) Kill() error {
-	return c.cmd.Process.Kill()
+// unixSignals maps command signals to unix ones. Suspend is SIGSTOP, because SIGTSTP
+// is discarded for orphaned process group, and command group has no parent in its session
+var unixSignals = map[entities.Signal]syscall.Signal{
+	entities.SignalInterrupt: unix.SIGINT,
+	entities.SignalTerminate: unix.SIGTERM,
+	entities.SignalKill:      unix.SIGKILL,
+	entities.SignalHangup:    unix.SIGHUP,
+	entities.SignalSuspend:   unix.SIGSTOP,
+	entities.SignalContinue:  unix.SIGCONT,
 }
+
+// signalGroup send signal to process group of leader with pid.
+// Suspended group is continued after signals it should handle, otherwise they wait until continue
+func signalGroup(pid int, signal entities.Signal) error {
+	sig, ok := unixSignals[signal]
+	if !ok {
+		return projectErrors.ErrUnsupportedSignal
+	}
+	err := unix.Kill(-pid, sig)
+	if err == nil && (sig == unix.SIGINT || sig == unix.SIGTERM || sig == unix.SIGHUP) {
+		err = unix.Kill(-pid, unix.SIGCONT)
+	}
+	if errors.Is(err, unix.ESRCH) {
+		return nil
+	}
+	return err
+}
+
+// Signal send signal to whole process group of command, pty started it in new session
+func (c *unixCommand) Signal(signal entities.Signal) error {
+	return c.reaper.signal(func() error {
+		return signalGroup(c.cmd.Process.Pid, signal)
+	})
+}
+
+// Resize change pty size, kernel notifies command with SIGWINCH
+func (c *unixCommand) Resize(cols, rows uint16) error {
+	return pty.Setsize(c.pty, &pty.Winsize{Rows: rows, Cols: cols})
+}
+
+func (c *unixCommand) Kill() error {
+	return c.Signal(entities.SignalKill)
+}
+
+// processTree is process group of piped command, its id is pid of command, so it is not reused until command is reaped
+type processTree struct {
+	pid int
+}
+
+func newProcessTree(cmd *exec.Cmd) (processTree, error) {
+	return processTree{pid: cmd.Process.Pid}, nil
+}
+
+func (t processTree) signal(signal entities.Signal) error {
+	return signalGroup(t.pid, signal)
+}
+
+func (t processTree) close() {}
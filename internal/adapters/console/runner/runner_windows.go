@@ -5,13 +5,38 @@ package runner
 import (
 	"fmt"
 	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/utils"
+	"github.com/gofiber/fiber/v2/log"
 	"github.com/iamacarpet/go-winpty"
+	"golang.org/x/sys/windows"
 	"io"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
 type windowsCommand struct {
-	pty *winpty.WinPTY
+	pty        *winpty.WinPTY
+	startedAt  time.Time
+	killed     atomic.Bool
+	done       chan struct{}
+	exitStatus entities.ExitStatus
+}
+
+// ntStatusSignals maps NTSTATUS exit codes of crashed or interrupted processes to unix signal names
+var ntStatusSignals = map[uint32]string{
+	0xC000013A: "SIGINT",  // STATUS_CONTROL_C_EXIT
+	0x40010004: "SIGKILL", // DBG_TERMINATE_PROCESS
+	0xC0000005: "SIGSEGV", // STATUS_ACCESS_VIOLATION
+	0xC00000FD: "SIGSEGV", // STATUS_STACK_OVERFLOW
+	0xC000001D: "SIGILL",  // STATUS_ILLEGAL_INSTRUCTION
+	0xC0000094: "SIGFPE",  // STATUS_INTEGER_DIVIDE_BY_ZERO
+	0xC0000409: "SIGABRT", // STATUS_STACK_BUFFER_OVERRUN, also raised by abort()
 }
 
 type Runner struct {
@@ -29,46 +54,167 @@ func New(
 	}
 }
 
-func (r Runner) RunCommand(command string, options entities.TerminalOptions) (entities.RunningCommand, error) {
+// RunCommand run command in console, or with interpreter argv template if it is not nil
+func (r Runner) RunCommand(command string, interpreter []string, options entities.TerminalOptions) (entities.RunningCommand, error) {
 	wp, err := winpty.OpenWithOptions(winpty.Options{
 		Dir:         options.Dir,
 		DLLPrefix:   r.ptyDir,
-		Command:     fmt.Sprintf("%s /C %s", r.console, command),
-		Env:         append(append(os.Environ(), "PWD="+options.Dir), options.Env...),
+		Command:     r.commandLine(command, interpreter),
+		Env:         mergeEnv(append(options.Env, "PWD="+options.Dir)...),
 		InitialRows: uint32(options.Rows),
 		InitialCols: uint32(options.Cols),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("error failed to get work dir for winpty: %s", err)
 	}
-	return &windowsCommand{pty: wp}, nil
+	runningCommand := &windowsCommand{pty: wp, startedAt: time.Now(), done: make(chan struct{})}
+	go runningCommand.wait()
+	return runningCommand, nil
+}
+
+// pipedCmd create command in new process group, so Ctrl+Break can be sent to it
+func (r Runner) pipedCmd(command string, interpreter []string) *exec.Cmd {
+	executable := r.console
+	if interpreter != nil {
+		executable = interpreter[0]
+	}
+	cmd := exec.Command(executable)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		CmdLine:       r.commandLine(command, interpreter),
+		CreationFlags: windows.CREATE_NEW_PROCESS_GROUP,
+	}
+	return cmd
 }
 
-func (c windowsCommand) GetReader() io.Reader {
+// QuoteArgument quote value for cmd /C
+func (r Runner) QuoteArgument(argument string) string {
+	return utils.QuoteCmd(argument)
+}
+
+// commandLine build command line for console, or for interpreter argv template if it is not nil.
+// Arguments escaped by CommandLineToArgvW rules, except command passed to cmd, which has its own parsing rules.
+func (r Runner) commandLine(command string, interpreter []string) string {
+	if interpreter == nil {
+		return fmt.Sprintf("%s /C %s", r.console, command)
+	}
+	executable := strings.ToLower(filepath.Base(interpreter[0]))
+	isCmd := executable == "cmd" || executable == "cmd.exe"
+	args := make([]string, len(interpreter))
+	for i, arg := range expandInterpreter(interpreter, command) {
+		if isCmd && strings.Contains(interpreter[i], utils.CommandPlaceholder) {
+			args[i] = arg
+			continue
+		}
+		args[i] = syscall.EscapeArg(arg)
+	}
+	return strings.Join(args, " ")
+}
+
+func (c *windowsCommand) wait() {
+	defer close(c.done)
+	handle := windows.Handle(c.pty.GetProcHandle())
+	var code uint32
+	_, err := windows.WaitForSingleObject(handle, windows.INFINITE)
+	if err == nil {
+		err = windows.GetExitCodeProcess(handle, &code)
+	}
+	c.exitStatus = entities.ExitStatus{
+		Code:       int(int32(code)),
+		Signal:     ntStatusSignals[code],
+		DurationMs: time.Since(c.startedAt).Milliseconds(),
+	}
+	if err != nil {
+		log.Debug("Error waiting for command ", err)
+		c.exitStatus.Code = -1
+	}
+	if c.killed.Load() && (err != nil || c.exitStatus.Signal == "") {
+		// Closed winpty terminates process with its own exit code
+		c.exitStatus.Signal = "SIGKILL"
+	}
+	log.Debug("Command finished")
+}
+
+func (c *windowsCommand) GetReader() io.Reader {
 	return c.pty.StdOut
 }
 
-func (c windowsCommand) GetWriter() io.Writer {
+func (c *windowsCommand) GetWriter() io.Writer {
 	return c.pty.StdIn
 }
 
-func (c windowsCommand) Done() <-chan error {
-	ch := make(chan error)
-	go func() {
-		buf := make([]byte, 1)
-		for {
-			_, err := c.pty.StdOut.Read(buf)
-			if err != nil {
-				c.pty.Close()
-				ch <- err
-				return
-			}
-		}
-	}()
-	return ch
+func (c *windowsCommand) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *windowsCommand) ExitStatus() entities.ExitStatus {
+	return c.exitStatus
 }
 
-func (c windowsCommand) Kill() error {
+// Signal emulate signals with console: interrupt is Ctrl+C typed to console,
+// terminate, kill and hangup close console with all processes attached to it. Suspend and continue are unsupported
+func (c *windowsCommand) Signal(signal entities.Signal) error {
+	switch signal {
+	case entities.SignalInterrupt:
+		_, err := c.pty.StdIn.Write([]byte{0x03})
+		return err
+	case entities.SignalTerminate, entities.SignalKill, entities.SignalHangup:
+		return c.Kill()
+	}
+	return projectErrors.ErrUnsupportedSignal
+}
+
+func (c *windowsCommand) Resize(cols, rows uint16) error {
+	c.pty.SetSize(uint32(cols), uint32(rows))
+	return nil
+}
+
+func (c *windowsCommand) Kill() error {
+	c.killed.Store(true)
 	c.pty.Close()
 	return nil
 }
+
+// exitSignal return unix name of signal for NTSTATUS exit code of crashed or interrupted process
+func exitSignal(state *os.ProcessState) string {
+	return ntStatusSignals[uint32(state.ExitCode())]
+}
+
+// processTree is job object with piped command and processes started by it, so they are killed even after command exited.
+// Processes started before command is assigned to job are not in it
+type processTree struct {
+	pid int
+	job windows.Handle
+}
+
+func newProcessTree(cmd *exec.Cmd) (processTree, error) {
+	job, err := windows.CreateJobObject(nil, nil)
+	if err != nil {
+		return processTree{}, fmt.Errorf("error creating job object: %w", err)
+	}
+	process, err := windows.OpenProcess(windows.PROCESS_SET_QUOTA|windows.PROCESS_TERMINATE, false, uint32(cmd.Process.Pid))
+	if err == nil {
+		err = windows.AssignProcessToJobObject(job, process)
+		_ = windows.CloseHandle(process)
+	}
+	if err != nil {
+		_ = windows.CloseHandle(job)
+		return processTree{}, fmt.Errorf("error assigning command to job object: %w", err)
+	}
+	return processTree{pid: cmd.Process.Pid, job: job}, nil
+}
+
+// signal emulate signals: interrupt is Ctrl+Break sent to process group of command,
+// terminate, kill and hangup kill all processes of job. Suspend and continue are unsupported
+func (t processTree) signal(signal entities.Signal) error {
+	switch signal {
+	case entities.SignalInterrupt:
+		return windows.GenerateConsoleCtrlEvent(windows.CTRL_BREAK_EVENT, uint32(t.pid))
+	case entities.SignalTerminate, entities.SignalKill, entities.SignalHangup:
+		return windows.TerminateJobObject(t.job, 1)
+	}
+	return projectErrors.ErrUnsupportedSignal
+}
+
+func (t processTree) close() {
+	_ = windows.CloseHandle(t.job)
+}
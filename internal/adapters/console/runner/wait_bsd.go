@@ -0,0 +1,34 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package runner
+
+import (
+	"errors"
+	"golang.org/x/sys/unix"
+	"os"
+)
+
+// waitExit wait for process exit without reaping it, so its pid is not reused until Wait
+func waitExit(process *os.Process) error {
+	kq, err := unix.Kqueue()
+	if err != nil {
+		return err
+	}
+	defer unix.Close(kq)
+	changes := make([]unix.Kevent_t, 1)
+	unix.SetKevent(&changes[0], process.Pid, unix.EVFILT_PROC, unix.EV_ADD|unix.EV_ONESHOT)
+	changes[0].Fflags = unix.NOTE_EXIT
+	if _, err := unix.Kevent(kq, changes, nil, nil); errors.Is(err, unix.ESRCH) {
+		// Process already exited, it is zombie until Wait
+		return nil
+	} else if err != nil {
+		return err
+	}
+	events := make([]unix.Kevent_t, 1)
+	for {
+		_, err := unix.Kevent(kq, nil, events, nil)
+		if !errors.Is(err, unix.EINTR) {
+			return err
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package runner
+
+import (
+	"errors"
+	"golang.org/x/sys/unix"
+	"os"
+)
+
+// waitExit wait for process exit without reaping it, so its pid is not reused until Wait
+func waitExit(process *os.Process) error {
+	var info unix.Siginfo
+	for {
+		err := unix.Waitid(unix.P_PID, process.Pid, &info, unix.WEXITED|unix.WNOWAIT, nil)
+		if !errors.Is(err, unix.EINTR) {
+			return err
+		}
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !windows && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package runner
+
+import (
+	"errors"
+	"os"
+)
+
+// waitExit is unsupported, process can be only reaped by Wait
+func waitExit(*os.Process) error {
+	return errors.ErrUnsupported
+}
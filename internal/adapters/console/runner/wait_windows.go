@@ -0,0 +1,17 @@
+package runner
+
+import (
+	"golang.org/x/sys/windows"
+	"os"
+)
+
+// waitExit wait for process exit, process handle of Cmd keeps its pid reserved until Wait
+func waitExit(process *os.Process) error {
+	handle, err := windows.OpenProcess(windows.SYNCHRONIZE, false, uint32(process.Pid))
+	if err != nil {
+		return err
+	}
+	defer windows.CloseHandle(handle)
+	_, err = windows.WaitForSingleObject(handle, windows.INFINITE)
+	return err
+}
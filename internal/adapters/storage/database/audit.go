@@ -0,0 +1,78 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"gorm.io/gorm"
+	"strings"
+)
+
+func (db DB) AppendAuditEntry(entry *entities.AuditEntry) error {
+	result := db.db.Create(entry)
+	if result.Error != nil {
+		return fmt.Errorf("error in db operation %w", result.Error)
+	}
+	return nil
+}
+
+func (db DB) GetLastAuditEntry() (*entities.AuditEntry, error) {
+	var data entities.AuditEntry
+	result := db.db.Order("id desc").Take(&data)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, projectErrors.ErrNotFound
+		} else {
+			return nil, fmt.Errorf("error in db operation %w", result.Error)
+		}
+	}
+	return &data, nil
+}
+
+// GetAuditEntries return entries matching filter, newest first
+func (db DB) GetAuditEntries(filter entities.AuditFilter) ([]entities.AuditEntry, error) {
+	var data []entities.AuditEntry
+	query := db.db.Order("id desc")
+	if filter.Actor != "" {
+		query = query.Where("actor = ?", filter.Actor)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.Target != "" {
+		query = query.Where("target = ? OR target LIKE ? ESCAPE '\\'", filter.Target, escapeLike(filter.Target)+"/%")
+	}
+	if filter.Since != nil {
+		query = query.Where("time >= ?", *filter.Since)
+	}
+	if filter.Until != nil {
+		query = query.Where("time < ?", *filter.Until)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	if filter.Offset > 0 {
+		query = query.Offset(filter.Offset)
+	}
+	result := query.Find(&data)
+	if result.Error != nil {
+		return nil, fmt.Errorf("error in db operation %w", result.Error)
+	}
+	return data, nil
+}
+
+// GetAuditEntriesAfter return up to limit entries with id greater than afterId, oldest first
+func (db DB) GetAuditEntriesAfter(afterId uint, limit int) ([]entities.AuditEntry, error) {
+	var data []entities.AuditEntry
+	result := db.db.Where("id > ?", afterId).Order("id").Limit(limit).Find(&data)
+	if result.Error != nil {
+		return nil, fmt.Errorf("error in db operation %w", result.Error)
+	}
+	return data, nil
+}
+
+// escapeLike escape wildcards of LIKE pattern, pattern must be used with ESCAPE '\'
+func escapeLike(pattern string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(pattern)
+}
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2/log"
+	"testing"
+
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/testutils"
+)
+
+func TestAuditEntries(t *testing.T) {
+	log.SetLevel(0)
+	tempDir, cleanup := testutils.CreateTempDataFolder(t)
+	defer cleanup()
+
+	db, err := Connect(tempDir)
+	if err != nil {
+		t.Fatalf("Cant create db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("Cant close db: %v", err)
+		}
+	}()
+
+	if _, err := db.GetLastAuditEntry(); !errors.Is(err, projectErrors.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	for _, target := range []string{"command:1", "command:1/file:2", "command:10/run:1", "a_b", "axb/run:1", "a%b/run:1"} {
+		if err := db.AppendAuditEntry(&entities.AuditEntry{Action: entities.AuditRunStart, Target: target}); err != nil {
+			t.Fatalf("Cant append entry: %v", err)
+		}
+	}
+	last, err := db.GetLastAuditEntry()
+	if err != nil || last.ID != 6 {
+		t.Errorf("Unexpected last entry: %v %v", last, err)
+	}
+
+	testCases := []struct {
+		target      string
+		expectedIds []uint
+	}{
+		{target: "command:1", expectedIds: []uint{2, 1}},
+		{target: "command:10", expectedIds: []uint{3}},
+		{target: "a_b", expectedIds: []uint{4}},
+		{target: "a%b", expectedIds: []uint{6}},
+	}
+	for _, tc := range testCases {
+		entries, err := db.GetAuditEntries(entities.AuditFilter{Target: tc.target})
+		if err != nil {
+			t.Fatalf("Cant get entries: %v", err)
+		}
+		if len(entries) != len(tc.expectedIds) {
+			t.Errorf("Expected entries %v for %s, got %v", tc.expectedIds, tc.target, entries)
+			continue
+		}
+		for i, id := range tc.expectedIds {
+			if entries[i].ID != id {
+				t.Errorf("Expected entries %v for %s, got %v", tc.expectedIds, tc.target, entries)
+			}
+		}
+	}
+
+	entries, err := db.GetAuditEntriesAfter(2, 3)
+	if err != nil || len(entries) != 3 || entries[0].ID != 3 || entries[2].ID != 5 {
+		t.Errorf("Unexpected entries after 2: %v %v", entries, err)
+	}
+}
@@ -17,13 +17,22 @@ func (db DB) AppendCommand(command *entities.Command) error {
 	return nil
 }
 
+// DeleteCommand delete command with its access grants
 func (db DB) DeleteCommand(id uint) error {
-	result := db.db.Delete(&entities.Command{}, id)
-	if result.RowsAffected == 0 {
-		return projectErrors.ErrNotFound
-	}
-	if result.Error != nil {
-		return fmt.Errorf("error in db operation %w", result.Error)
+	err := db.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(&entities.Command{}, id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return projectErrors.ErrNotFound
+		}
+		return tx.Where("command_id = ?", id).Delete(&entities.CommandGrant{}).Error
+	})
+	if errors.Is(err, projectErrors.ErrNotFound) {
+		return err
+	} else if err != nil {
+		return fmt.Errorf("error in db transaction %w", err)
 	}
 	return nil
 }
@@ -53,7 +62,16 @@ func (db DB) SetCommands(commands []entities.Command) error {
 				return result.Error
 			}
 		}
-		return nil
+		// Grants are kept for commands with the same id
+		ids := make([]uint, len(commands))
+		for i, command := range commands {
+			ids[i] = command.ID
+		}
+		grants := tx.Where("1=1")
+		if len(ids) != 0 {
+			grants = tx.Where("command_id NOT IN ?", ids)
+		}
+		return grants.Delete(&entities.CommandGrant{}).Error
 	})
 	if err != nil {
 		return fmt.Errorf("error in db transaction %w", err)
@@ -45,6 +45,38 @@ func Connect(databaseDirPath string) (DB, error) {
 	if err != nil {
 		return DB{}, fmt.Errorf("cant migrate db %w", err)
 	}
+	err = db.AutoMigrate(&entities.Run{})
+	if err != nil {
+		return DB{}, fmt.Errorf("cant migrate db %w", err)
+	}
+	err = db.AutoMigrate(&entities.EnvVariable{})
+	if err != nil {
+		return DB{}, fmt.Errorf("cant migrate db %w", err)
+	}
+	err = db.AutoMigrate(&entities.Secret{})
+	if err != nil {
+		return DB{}, fmt.Errorf("cant migrate db %w", err)
+	}
+	err = db.AutoMigrate(&entities.User{})
+	if err != nil {
+		return DB{}, fmt.Errorf("cant migrate db %w", err)
+	}
+	err = db.AutoMigrate(&entities.LoginSession{})
+	if err != nil {
+		return DB{}, fmt.Errorf("cant migrate db %w", err)
+	}
+	err = db.AutoMigrate(&entities.CommandGrant{})
+	if err != nil {
+		return DB{}, fmt.Errorf("cant migrate db %w", err)
+	}
+	err = db.AutoMigrate(&entities.ApiToken{})
+	if err != nil {
+		return DB{}, fmt.Errorf("cant migrate db %w", err)
+	}
+	err = db.AutoMigrate(&entities.AuditEntry{})
+	if err != nil {
+		return DB{}, fmt.Errorf("cant migrate db %w", err)
+	}
 	return DB{db: *db}, nil
 }
 
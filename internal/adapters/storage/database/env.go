@@ -0,0 +1,36 @@
+package database
+
+import (
+	"fmt"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	"gorm.io/gorm"
+)
+
+func (db DB) GetEnvVariables() ([]entities.EnvVariable, error) {
+	var data []entities.EnvVariable
+	result := db.db.Order("name").Find(&data)
+	if result.Error != nil {
+		return nil, fmt.Errorf("error in db operation %w", result.Error)
+	}
+	return data, nil
+}
+
+func (db DB) SetEnvVariables(variables []entities.EnvVariable) error {
+	err := db.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("1=1").Delete(&entities.EnvVariable{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if len(variables) != 0 {
+			result = tx.Create(&variables)
+			if result.Error != nil {
+				return result.Error
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error in db transaction %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package database
+
+import (
+	"github.com/gofiber/fiber/v2/log"
+	"reflect"
+	"testing"
+
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/testutils"
+)
+
+func TestEnvVariables(t *testing.T) {
+	log.SetLevel(0)
+	tempDir, cleanup := testutils.CreateTempDataFolder(t)
+	defer cleanup()
+
+	db, err := Connect(tempDir)
+	if err != nil {
+		t.Fatalf("Cant create db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("Cant close db: %v", err)
+		}
+	}()
+
+	testCases := []struct {
+		name      string
+		variables []entities.EnvVariable
+		expected  []entities.EnvVariable
+	}{
+		{
+			name:      "Set variables",
+			variables: []entities.EnvVariable{{Name: "B", Value: "2"}, {Name: "A", Value: "1"}},
+			expected:  []entities.EnvVariable{{Name: "A", Value: "1"}, {Name: "B", Value: "2"}},
+		},
+		{
+			name:      "Replace variables",
+			variables: []entities.EnvVariable{{Name: "C", Value: "3"}},
+			expected:  []entities.EnvVariable{{Name: "C", Value: "3"}},
+		},
+		{
+			name:      "Clear variables",
+			variables: nil,
+			expected:  nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := db.SetEnvVariables(tc.variables); err != nil {
+				t.Fatalf("Cant set variables: %v", err)
+			}
+			variables, err := db.GetEnvVariables()
+			if err != nil {
+				t.Fatalf("Cant get variables: %v", err)
+			}
+			if len(variables) != 0 || len(tc.expected) != 0 {
+				if !reflect.DeepEqual(variables, tc.expected) {
+					t.Errorf("Expected %v, got %v", tc.expected, variables)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"gorm.io/gorm"
+)
+
+func (db DB) GetCommandGrant(commandId, userId uint) (*entities.CommandGrant, error) {
+	var data entities.CommandGrant
+	result := db.db.Where("command_id = ? AND user_id = ?", commandId, userId).Take(&data)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, projectErrors.ErrNotFound
+		} else {
+			return nil, fmt.Errorf("error in db operation %w", result.Error)
+		}
+	}
+	return &data, nil
+}
+
+func (db DB) GetCommandGrants(commandId uint) ([]entities.CommandGrant, error) {
+	var data []entities.CommandGrant
+	result := db.db.Where("command_id = ?", commandId).Order("user_id").Find(&data)
+	if result.Error != nil {
+		return nil, fmt.Errorf("error in db operation %w", result.Error)
+	}
+	return data, nil
+}
+
+func (db DB) GetUserGrants(userId uint) ([]entities.CommandGrant, error) {
+	var data []entities.CommandGrant
+	result := db.db.Where("user_id = ?", userId).Order("command_id").Find(&data)
+	if result.Error != nil {
+		return nil, fmt.Errorf("error in db operation %w", result.Error)
+	}
+	return data, nil
+}
+
+// SetCommandGrant create grant or replace level of existing one
+func (db DB) SetCommandGrant(grant *entities.CommandGrant) error {
+	result := db.db.Save(grant)
+	if result.Error != nil {
+		return fmt.Errorf("error in db operation %w", result.Error)
+	}
+	return nil
+}
+
+func (db DB) DeleteCommandGrant(commandId, userId uint) error {
+	result := db.db.Where("command_id = ? AND user_id = ?", commandId, userId).Delete(&entities.CommandGrant{})
+	if result.Error != nil {
+		return fmt.Errorf("error in db operation %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return projectErrors.ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2/log"
+	"testing"
+
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/testutils"
+)
+
+func TestCommandGrants(t *testing.T) {
+	log.SetLevel(0)
+	tempDir, cleanup := testutils.CreateTempDataFolder(t)
+	defer cleanup()
+
+	db, err := Connect(tempDir)
+	if err != nil {
+		t.Fatalf("Cant create db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("Cant close db: %v", err)
+		}
+	}()
+
+	for _, name := range []string{"first", "second", "third"} {
+		if err := db.AppendCommand(&entities.Command{Name: name, Command: "echo " + name}); err != nil {
+			t.Fatalf("Cant append command: %v", err)
+		}
+	}
+	user := &entities.User{Username: "operator", Role: entities.RoleOperator}
+	if err := db.AppendUser(user); err != nil {
+		t.Fatalf("Cant append user: %v", err)
+	}
+
+	grants := []entities.CommandGrant{
+		{CommandID: 1, UserID: user.ID, Level: entities.AccessView},
+		{CommandID: 1, UserID: user.ID, Level: entities.AccessEdit},
+		{CommandID: 2, UserID: user.ID, Level: entities.AccessNone},
+		{CommandID: 3, UserID: user.ID, Level: entities.AccessRun},
+	}
+	for _, grant := range grants {
+		if err := db.SetCommandGrant(&grant); err != nil {
+			t.Fatalf("Cant set grant: %v", err)
+		}
+	}
+	grant, err := db.GetCommandGrant(1, user.ID)
+	if err != nil || grant.Level != entities.AccessEdit {
+		t.Errorf("Grant is not replaced: %v %v", grant, err)
+	}
+	if _, err := db.GetCommandGrant(1, 100); !errors.Is(err, projectErrors.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	if err := db.DeleteCommand(3); err != nil {
+		t.Fatalf("Cant delete command: %v", err)
+	}
+	userGrants, err := db.GetUserGrants(user.ID)
+	if err != nil || len(userGrants) != 2 {
+		t.Errorf("Grants of deleted command are not deleted: %v %v", userGrants, err)
+	}
+
+	if err := db.SetCommands([]entities.Command{{ID: 2, Name: "second", Command: "echo second"}}); err != nil {
+		t.Fatalf("Cant set commands: %v", err)
+	}
+	userGrants, err = db.GetUserGrants(user.ID)
+	if err != nil || len(userGrants) != 1 || userGrants[0].CommandID != 2 {
+		t.Errorf("Grants of removed commands are not deleted: %v %v", userGrants, err)
+	}
+
+	if err := db.DeleteUser(user.ID); err != nil {
+		t.Fatalf("Cant delete user: %v", err)
+	}
+	commandGrants, err := db.GetCommandGrants(2)
+	if err != nil || len(commandGrants) != 0 {
+		t.Errorf("Grants of deleted user are not deleted: %v %v", commandGrants, err)
+	}
+	if err := db.DeleteCommandGrant(2, user.ID); !errors.Is(err, projectErrors.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"gorm.io/gorm"
+)
+
+func (db DB) AppendRun(run *entities.Run) error {
+	result := db.db.Create(run)
+	if result.Error != nil {
+		return fmt.Errorf("error in db operation %w", result.Error)
+	}
+	return nil
+}
+
+func (db DB) UpdateRun(run *entities.Run) error {
+	result := db.db.Save(run)
+	if result.Error != nil {
+		return fmt.Errorf("error in db operation %w", result.Error)
+	}
+	return nil
+}
+
+func (db DB) GetRun(id uint) (*entities.Run, error) {
+	var data entities.Run
+	result := db.db.Take(&data, id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, projectErrors.ErrNotFound
+		} else {
+			return nil, fmt.Errorf("error in db operation %w", result.Error)
+		}
+	}
+	return &data, nil
+}
+
+func (db DB) GetCommandRuns(commandId uint) ([]entities.Run, error) {
+	var data []entities.Run
+	result := db.db.Where("command_id = ?", commandId).Order("id desc").Find(&data)
+	if result.Error != nil {
+		return data, fmt.Errorf("error in db operation %w", result.Error)
+	}
+	return data, nil
+}
@@ -0,0 +1,72 @@
+package database
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2/log"
+	"testing"
+	"time"
+
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/testutils"
+)
+
+func TestRuns(t *testing.T) {
+	log.SetLevel(0)
+	tempDir, cleanup := testutils.CreateTempDataFolder(t)
+	defer cleanup()
+
+	db, err := Connect(tempDir)
+	if err != nil {
+		t.Fatalf("Cant create db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("Cant close db: %v", err)
+		}
+	}()
+
+	runs := []entities.Run{
+		{CommandID: 1, Command: "echo first", StartedAt: time.Now()},
+		{CommandID: 2, Command: "echo other", StartedAt: time.Now()},
+		{CommandID: 1, Command: "echo second", StartedAt: time.Now()},
+	}
+	for i := range runs {
+		if err := db.AppendRun(&runs[i]); err != nil {
+			t.Fatalf("Cant append run: %v", err)
+		}
+	}
+
+	exitCode := 3
+	finishedAt := time.Now()
+	runs[0].ExitCode = &exitCode
+	runs[0].FinishedAt = &finishedAt
+	runs[0].OutputSize = 11
+	if err := db.UpdateRun(&runs[0]); err != nil {
+		t.Fatalf("Cant update run: %v", err)
+	}
+
+	run, err := db.GetRun(runs[0].ID)
+	if err != nil {
+		t.Fatalf("Cant get run: %v", err)
+	}
+	if run.ExitCode == nil || *run.ExitCode != 3 || run.FinishedAt == nil || run.OutputSize != 11 {
+		t.Errorf("Run result not saved: %+v", run)
+	}
+
+	commandRuns, err := db.GetCommandRuns(1)
+	if err != nil {
+		t.Fatalf("Cant get command runs: %v", err)
+	}
+	if len(commandRuns) != 2 {
+		t.Fatalf("Expected 2 runs, got %d", len(commandRuns))
+	}
+	if commandRuns[0].Command != "echo second" {
+		t.Errorf("Expected newest run first, got %s", commandRuns[0].Command)
+	}
+
+	_, err = db.GetRun(100)
+	if !errors.Is(err, projectErrors.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
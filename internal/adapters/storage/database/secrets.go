@@ -0,0 +1,51 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"gorm.io/gorm"
+)
+
+func (db DB) GetSecrets() ([]entities.Secret, error) {
+	var data []entities.Secret
+	result := db.db.Order("name").Find(&data)
+	if result.Error != nil {
+		return nil, fmt.Errorf("error in db operation %w", result.Error)
+	}
+	return data, nil
+}
+
+func (db DB) GetSecret(name string) (*entities.Secret, error) {
+	var data entities.Secret
+	result := db.db.Where("name = ?", name).Take(&data)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, projectErrors.ErrNotFound
+		} else {
+			return nil, fmt.Errorf("error in db operation %w", result.Error)
+		}
+	}
+	return &data, nil
+}
+
+// SetSecret create secret or replace value of existing one
+func (db DB) SetSecret(secret *entities.Secret) error {
+	result := db.db.Save(secret)
+	if result.Error != nil {
+		return fmt.Errorf("error in db operation %w", result.Error)
+	}
+	return nil
+}
+
+func (db DB) DeleteSecret(name string) error {
+	result := db.db.Where("name = ?", name).Delete(&entities.Secret{})
+	if result.Error != nil {
+		return fmt.Errorf("error in db operation %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return projectErrors.ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package database
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2/log"
+	"testing"
+
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/testutils"
+)
+
+func TestSecrets(t *testing.T) {
+	log.SetLevel(0)
+	tempDir, cleanup := testutils.CreateTempDataFolder(t)
+	defer cleanup()
+
+	db, err := Connect(tempDir)
+	if err != nil {
+		t.Fatalf("Cant create db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("Cant close db: %v", err)
+		}
+	}()
+
+	if err := db.SetSecret(&entities.Secret{Name: "TOKEN", Value: []byte("first")}); err != nil {
+		t.Fatalf("Cant set secret: %v", err)
+	}
+	if err := db.SetSecret(&entities.Secret{Name: "TOKEN", Value: []byte("second")}); err != nil {
+		t.Fatalf("Cant replace secret: %v", err)
+	}
+	secret, err := db.GetSecret("TOKEN")
+	if err != nil {
+		t.Fatalf("Cant get secret: %v", err)
+	}
+	if string(secret.Value) != "second" {
+		t.Errorf("Secret not replaced: %q", secret.Value)
+	}
+	secrets, err := db.GetSecrets()
+	if err != nil || len(secrets) != 1 {
+		t.Fatalf("Unexpected secrets: %v %v", secrets, err)
+	}
+
+	if err := db.DeleteSecret("TOKEN"); err != nil {
+		t.Fatalf("Cant delete secret: %v", err)
+	}
+	if _, err := db.GetSecret("TOKEN"); !errors.Is(err, projectErrors.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if err := db.DeleteSecret("TOKEN"); !errors.Is(err, projectErrors.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"gorm.io/gorm"
+	"time"
+)
+
+func (db DB) AppendApiToken(token *entities.ApiToken) error {
+	result := db.db.Omit("User").Create(token)
+	if result.Error != nil {
+		return fmt.Errorf("error in db operation %w", result.Error)
+	}
+	return nil
+}
+
+// GetApiToken return token with its user
+func (db DB) GetApiToken(tokenHash string) (*entities.ApiToken, error) {
+	var data entities.ApiToken
+	result := db.db.Preload("User").Where("token_hash = ?", tokenHash).Take(&data)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, projectErrors.ErrNotFound
+		} else {
+			return nil, fmt.Errorf("error in db operation %w", result.Error)
+		}
+	}
+	return &data, nil
+}
+
+func (db DB) GetUserApiTokens(userId uint) ([]entities.ApiToken, error) {
+	var data []entities.ApiToken
+	result := db.db.Where("user_id = ?", userId).Order("id").Find(&data)
+	if result.Error != nil {
+		return nil, fmt.Errorf("error in db operation %w", result.Error)
+	}
+	return data, nil
+}
+
+func (db DB) SetApiTokenLastUsed(id uint, lastUsedAt time.Time) error {
+	result := db.db.Model(&entities.ApiToken{}).Where("id = ?", id).Update("last_used_at", lastUsedAt)
+	if result.Error != nil {
+		return fmt.Errorf("error in db operation %w", result.Error)
+	}
+	return nil
+}
+
+// DeleteApiToken delete token of user, tokens of other users are not found
+func (db DB) DeleteApiToken(userId, id uint) error {
+	result := db.db.Where("user_id = ? AND id = ?", userId, id).Delete(&entities.ApiToken{})
+	if result.Error != nil {
+		return fmt.Errorf("error in db operation %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return projectErrors.ErrNotFound
+	}
+	return nil
+}
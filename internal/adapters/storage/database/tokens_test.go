@@ -0,0 +1,80 @@
+package database
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2/log"
+	"testing"
+	"time"
+
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/testutils"
+)
+
+func TestApiTokens(t *testing.T) {
+	log.SetLevel(0)
+	tempDir, cleanup := testutils.CreateTempDataFolder(t)
+	defer cleanup()
+
+	db, err := Connect(tempDir)
+	if err != nil {
+		t.Fatalf("Cant create db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("Cant close db: %v", err)
+		}
+	}()
+
+	user := &entities.User{Username: "operator", Role: entities.RoleOperator}
+	if err := db.AppendUser(user); err != nil {
+		t.Fatalf("Cant append user: %v", err)
+	}
+	tokens := []entities.ApiToken{
+		{TokenHash: "first", UserID: user.ID, Name: "deploy", Scopes: []string{entities.ScopeRunAll}},
+		{TokenHash: "second", UserID: user.ID, Name: "backup", Scopes: []string{"run:command:3", entities.ScopeRead}},
+	}
+	for i := range tokens {
+		if err := db.AppendApiToken(&tokens[i]); err != nil {
+			t.Fatalf("Cant append token: %v", err)
+		}
+	}
+	if err := db.AppendApiToken(&entities.ApiToken{TokenHash: "first", UserID: user.ID, Name: "same hash"}); err == nil {
+		t.Error("Token with same hash is appended")
+	}
+
+	token, err := db.GetApiToken("second")
+	if err != nil || token.User.Username != "operator" || len(token.Scopes) != 2 || token.Scopes[0] != "run:command:3" {
+		t.Errorf("Unexpected token: %v %v", token, err)
+	}
+	if _, err := db.GetApiToken("unknown"); !errors.Is(err, projectErrors.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	lastUsedAt := time.Now().Truncate(time.Second)
+	if err := db.SetApiTokenLastUsed(token.ID, lastUsedAt); err != nil {
+		t.Fatalf("Cant set last use: %v", err)
+	}
+	token, err = db.GetApiToken("second")
+	if err != nil || token.LastUsedAt == nil || !token.LastUsedAt.Equal(lastUsedAt) {
+		t.Errorf("Last use is not saved: %v %v", token, err)
+	}
+
+	if err := db.DeleteApiToken(user.ID+1, token.ID); !errors.Is(err, projectErrors.ErrNotFound) {
+		t.Errorf("Token of other user is deleted: %v", err)
+	}
+	if err := db.DeleteApiToken(user.ID, token.ID); err != nil {
+		t.Fatalf("Cant delete token: %v", err)
+	}
+	userTokens, err := db.GetUserApiTokens(user.ID)
+	if err != nil || len(userTokens) != 1 || userTokens[0].Name != "deploy" {
+		t.Errorf("Unexpected tokens: %v %v", userTokens, err)
+	}
+
+	if err := db.DeleteUser(user.ID); err != nil {
+		t.Fatalf("Cant delete user: %v", err)
+	}
+	if _, err := db.GetApiToken("first"); !errors.Is(err, projectErrors.ErrNotFound) {
+		t.Errorf("Tokens of deleted user are not deleted: %v", err)
+	}
+}
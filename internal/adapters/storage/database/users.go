@@ -0,0 +1,155 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"gorm.io/gorm"
+	"time"
+)
+
+func (db DB) AppendUser(user *entities.User) error {
+	result := db.db.Create(user)
+	if result.Error != nil {
+		return fmt.Errorf("error in db operation %w", result.Error)
+	}
+	return nil
+}
+
+func (db DB) UpdateUser(user *entities.User) error {
+	result := db.db.Save(user)
+	if result.Error != nil {
+		return fmt.Errorf("error in db operation %w", result.Error)
+	}
+	return nil
+}
+
+// DeleteUser delete user with all its login sessions, api tokens and grants
+func (db DB) DeleteUser(id uint) error {
+	err := db.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(&entities.User{}, id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return projectErrors.ErrNotFound
+		}
+		if err := tx.Where("user_id = ?", id).Delete(&entities.CommandGrant{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", id).Delete(&entities.ApiToken{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", id).Delete(&entities.LoginSession{}).Error
+	})
+	if errors.Is(err, projectErrors.ErrNotFound) {
+		return err
+	} else if err != nil {
+		return fmt.Errorf("error in db transaction %w", err)
+	}
+	return nil
+}
+
+func (db DB) GetUsers() ([]entities.User, error) {
+	var data []entities.User
+	result := db.db.Order("id").Find(&data)
+	if result.Error != nil {
+		return nil, fmt.Errorf("error in db operation %w", result.Error)
+	}
+	return data, nil
+}
+
+func (db DB) GetUser(id uint) (*entities.User, error) {
+	var data entities.User
+	result := db.db.Take(&data, id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, projectErrors.ErrNotFound
+		} else {
+			return nil, fmt.Errorf("error in db operation %w", result.Error)
+		}
+	}
+	return &data, nil
+}
+
+func (db DB) GetUserByUsername(username string) (*entities.User, error) {
+	var data entities.User
+	result := db.db.Where("username = ?", username).Take(&data)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, projectErrors.ErrNotFound
+		} else {
+			return nil, fmt.Errorf("error in db operation %w", result.Error)
+		}
+	}
+	return &data, nil
+}
+
+func (db DB) CountUsers() (int64, error) {
+	var count int64
+	result := db.db.Model(&entities.User{}).Count(&count)
+	if result.Error != nil {
+		return 0, fmt.Errorf("error in db operation %w", result.Error)
+	}
+	return count, nil
+}
+
+func (db DB) CountUsersWithRole(role entities.UserRole) (int64, error) {
+	var count int64
+	result := db.db.Model(&entities.User{}).Where("role = ?", role).Count(&count)
+	if result.Error != nil {
+		return 0, fmt.Errorf("error in db operation %w", result.Error)
+	}
+	return count, nil
+}
+
+func (db DB) AppendLoginSession(session *entities.LoginSession) error {
+	result := db.db.Omit("User").Create(session)
+	if result.Error != nil {
+		return fmt.Errorf("error in db operation %w", result.Error)
+	}
+	return nil
+}
+
+// GetLoginSession return session with its user
+func (db DB) GetLoginSession(tokenHash string) (*entities.LoginSession, error) {
+	var data entities.LoginSession
+	result := db.db.Preload("User").Where("token_hash = ?", tokenHash).Take(&data)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, projectErrors.ErrNotFound
+		} else {
+			return nil, fmt.Errorf("error in db operation %w", result.Error)
+		}
+	}
+	return &data, nil
+}
+
+func (db DB) DeleteLoginSession(tokenHash string) error {
+	result := db.db.Where("token_hash = ?", tokenHash).Delete(&entities.LoginSession{})
+	if result.Error != nil {
+		return fmt.Errorf("error in db operation %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return projectErrors.ErrNotFound
+	}
+	return nil
+}
+
+// DeleteUserLoginSessions log out user everywhere
+func (db DB) DeleteUserLoginSessions(userId uint) error {
+	result := db.db.Where("user_id = ?", userId).Delete(&entities.LoginSession{})
+	if result.Error != nil {
+		return fmt.Errorf("error in db operation %w", result.Error)
+	}
+	return nil
+}
+
+func (db DB) DeleteExpiredLoginSessions(now time.Time) error {
+	result := db.db.Where("expires_at <= ?", now).Delete(&entities.LoginSession{})
+	if result.Error != nil {
+		return fmt.Errorf("error in db operation %w", result.Error)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2/log"
+	"testing"
+	"time"
+
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/testutils"
+)
+
+func TestUsers(t *testing.T) {
+	log.SetLevel(0)
+	tempDir, cleanup := testutils.CreateTempDataFolder(t)
+	defer cleanup()
+
+	db, err := Connect(tempDir)
+	if err != nil {
+		t.Fatalf("Cant create db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("Cant close db: %v", err)
+		}
+	}()
+
+	user := &entities.User{Username: "admin", PasswordHash: []byte("hash")}
+	if err := db.AppendUser(user); err != nil {
+		t.Fatalf("Cant append user: %v", err)
+	}
+	if err := db.AppendUser(&entities.User{Username: "admin"}); err == nil {
+		t.Error("Username is not unique")
+	}
+	found, err := db.GetUserByUsername("admin")
+	if err != nil || found.ID != user.ID {
+		t.Fatalf("Unexpected user: %v %v", found, err)
+	}
+	if _, err := db.GetUserByUsername("nobody"); !errors.Is(err, projectErrors.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	count, err := db.CountUsers()
+	if err != nil || count != 1 {
+		t.Errorf("Unexpected count of users: %d %v", count, err)
+	}
+
+	now := time.Now()
+	sessions := []entities.LoginSession{
+		{TokenHash: "alive", UserID: user.ID, CreatedAt: now, ExpiresAt: now.Add(time.Hour)},
+		{TokenHash: "expired", UserID: user.ID, CreatedAt: now, ExpiresAt: now.Add(-time.Hour)},
+	}
+	for _, session := range sessions {
+		if err := db.AppendLoginSession(&session); err != nil {
+			t.Fatalf("Cant append login session: %v", err)
+		}
+	}
+	session, err := db.GetLoginSession("alive")
+	if err != nil {
+		t.Fatalf("Cant get login session: %v", err)
+	}
+	if session.User.Username != "admin" {
+		t.Errorf("User of session is not loaded: %v", session.User)
+	}
+	if err := db.DeleteExpiredLoginSessions(now); err != nil {
+		t.Fatalf("Cant delete expired sessions: %v", err)
+	}
+	if _, err := db.GetLoginSession("expired"); !errors.Is(err, projectErrors.ErrNotFound) {
+		t.Errorf("Expired session is not deleted: %v", err)
+	}
+
+	if err := db.DeleteUser(user.ID); err != nil {
+		t.Fatalf("Cant delete user: %v", err)
+	}
+	if _, err := db.GetLoginSession("alive"); !errors.Is(err, projectErrors.ErrNotFound) {
+		t.Errorf("Session of deleted user is not deleted: %v", err)
+	}
+	if err := db.DeleteUser(user.ID); !errors.Is(err, projectErrors.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package filesystem
+
+import (
+	"errors"
+	"fmt"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// RunLogsAdapter stores output of command runs, log and asciicast recording per run, and sources of output for piped commands
+type RunLogsAdapter struct {
+	runLogsDirPath string
+}
+
+func ConnectRunLogs(runLogsDirPath string) (RunLogsAdapter, error) {
+	err := os.MkdirAll(runLogsDirPath, 0750)
+	if err != nil {
+		return RunLogsAdapter{}, err
+	}
+	return RunLogsAdapter{runLogsDirPath: runLogsDirPath}, nil
+}
+
+func (a RunLogsAdapter) runLogPath(runId uint) string {
+	return filepath.Join(a.runLogsDirPath, fmt.Sprintf("%d.log", runId))
+}
+
+func (a RunLogsAdapter) CreateRunLog(runId uint) (io.WriteCloser, error) {
+	if err := os.MkdirAll(a.runLogsDirPath, 0750); err != nil {
+		return nil, err
+	}
+	return os.Create(a.runLogPath(runId))
+}
+
+func (a RunLogsAdapter) GetRunLog(runId uint) ([]byte, error) {
+	data, err := os.ReadFile(a.runLogPath(runId))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, projectErrors.ErrNotFound
+	}
+	return data, err
+}
+
+func (a RunLogsAdapter) runRecordingPath(runId uint) string {
+	return filepath.Join(a.runLogsDirPath, fmt.Sprintf("%d.cast", runId))
+}
+
+func (a RunLogsAdapter) CreateRunRecording(runId uint) (io.WriteCloser, error) {
+	if err := os.MkdirAll(a.runLogsDirPath, 0750); err != nil {
+		return nil, err
+	}
+	return os.Create(a.runRecordingPath(runId))
+}
+
+func (a RunLogsAdapter) GetRunRecording(runId uint) ([]byte, error) {
+	data, err := os.ReadFile(a.runRecordingPath(runId))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, projectErrors.ErrNotFound
+	}
+	return data, err
+}
+
+func (a RunLogsAdapter) runSourcesPath(runId uint) string {
+	return filepath.Join(a.runLogsDirPath, fmt.Sprintf("%d.sources", runId))
+}
+
+func (a RunLogsAdapter) CreateRunSources(runId uint) (io.WriteCloser, error) {
+	if err := os.MkdirAll(a.runLogsDirPath, 0750); err != nil {
+		return nil, err
+	}
+	return os.Create(a.runSourcesPath(runId))
+}
+
+func (a RunLogsAdapter) GetRunSources(runId uint) ([]byte, error) {
+	data, err := os.ReadFile(a.runSourcesPath(runId))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, projectErrors.ErrNotFound
+	}
+	return data, err
+}
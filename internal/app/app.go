@@ -8,10 +8,17 @@ import (
 	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/adapters/storage/filesystem"
 	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/adapters/url_opener"
 	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/config"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/core/access"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/core/audit"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/core/auth"
 	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/core/commands"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/core/environment"
 	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/core/files"
 	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/core/runner"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/core/runs"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/core/secrets"
 	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/core/userconfig"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
 	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/ui/webserver"
 	"github.com/gofiber/fiber/v2/log"
 	"path/filepath"
@@ -23,6 +30,7 @@ func Run() {
 	cfg := config.Config
 	dataFolderPath := filepath.Join(cfg.RootDir, "data")
 	filesDirPath := filepath.Join(dataFolderPath, "files")
+	runLogsDirPath := filepath.Join(dataFolderPath, "runs")
 	ptyDirPath := filepath.Join(cfg.RootDir, "pty")
 	if err != nil {
 		log.Fatalw("Error while init configs", "error:", err)
@@ -41,27 +49,82 @@ func Run() {
 	if err != nil {
 		log.Fatalw("Error while connecting to storage", "error:", err)
 	}
+	runLogsAdapter, err := filesystem.ConnectRunLogs(runLogsDirPath)
+	if err != nil {
+		log.Fatalw("Error while connecting to storage", "error:", err)
+	}
+	secretsKeyEncoded := cfg.SecretsKey
+	if secretsKeyEncoded == "" {
+		secretsKeyEncoded, err = filesystem.ReadOrCreateKeyFile(cfg.SecretsKeyFile, secrets.KeySize)
+		if err != nil {
+			log.Fatalw("Error while reading secrets key file", "error:", err)
+		}
+	}
+	secretsKey, err := secrets.ParseKey(secretsKeyEncoded)
+	if err != nil {
+		log.Fatalw("Error while parsing secrets key", "error:", err)
+	}
 	consoleChecker := consoleCheckerAdapter.New(ptyDirPath)
 	if err := consoleChecker.CheckAvailability(); err != nil {
 		log.Fatalw("Error while checking availability of console", "error:", err)
 	}
 	runnerAdapter := consoleRunnerAdapter.New(ptyDirPath, cfg.Console)
 
-	commandsService := commands.NewService(dbAdapter, cfg.DefaultCommandRunDir)
-	filesService := files.NewService(filesDirPath, cfg.MaxFileSize, dbAdapter, dbAdapter, fileSystemAdapter)
+	accessService := access.NewService(dbAdapter, dbAdapter, dbAdapter)
+	commandsService := commands.NewService(dbAdapter, cfg.DefaultCommandRunDir, consoleChecker, accessService)
+	filesService := files.NewService(filesDirPath, cfg.MaxFileSize, dbAdapter, dbAdapter, fileSystemAdapter, accessService)
 	userConfigService := userconfig.NewService(dbAdapter, dbAdapter, fileSystemAdapter, cfg.Console)
-	runnerService := runner.NewService(cfg.DefaultCommandRunDir, filesDirPath, runnerAdapter, commandsService, filesService)
+	auditService := audit.NewService(dbAdapter)
+	if cfg.FrozenConfigFile != "" {
+		oldConf, _ := userConfigService.GetUserConfig()
+		if err := userConfigService.LoadUserConfigFile(cfg.FrozenConfigFile); err != nil {
+			log.Fatalw("Error while loading frozen config", "error:", err)
+		}
+		log.Infof("Loaded frozen config from %s", cfg.FrozenConfigFile)
+		newConf, err := userConfigService.GetUserConfig()
+		if err == nil {
+			err = auditService.Record(&entities.AuditEntry{Action: entities.AuditConfigImport}, oldConf, newConf)
+		}
+		if err != nil {
+			log.Warn("Error recording frozen config import to audit log: ", err)
+		}
+	}
+	runsService := runs.NewService(cfg.MaxRunOutputSize, dbAdapter, runLogsAdapter)
+	environmentService := environment.NewService(cfg.CommandsEnvFile, dbAdapter)
+	secretsService := secrets.NewService(secretsKey, dbAdapter)
+	authService := auth.NewService(cfg.LoginSessionTTL, dbAdapter, dbAdapter, dbAdapter)
+	generatedPassword, err := authService.Bootstrap(cfg.AdminUsername, cfg.AdminPassword)
+	if err != nil {
+		log.Fatalw("Error while creating admin account", "error:", err)
+	}
+	if generatedPassword != "" {
+		log.Warnf("Created admin account %q with password %q, change it after login", cfg.AdminUsername, generatedPassword)
+	}
+	defaultKillPolicy := entities.KillPolicy{
+		InterruptGraceMs: uint(cfg.KillInterruptGrace.Milliseconds()),
+		TerminateGraceMs: uint(cfg.KillTerminateGrace.Milliseconds()),
+	}
+	runnerService := runner.NewService(cfg.DefaultCommandRunDir, filesDirPath, cfg.SessionDetachTimeout, cfg.SessionScrollbackSize, entities.OverflowPolicy(cfg.SessionOverflowPolicy), defaultKillPolicy, runnerAdapter, commandsService, filesService, runsService, environmentService, secretsService, accessService)
 
 	webserverApp := webserver.New(
 		cfg.RootDir,
 		cfg.PORT,
 		cfg.Console,
 		cfg.MaxFileSize,
-		cfg.WebsocketWriteInterval,
+		cfg.WebsocketPingInterval,
+		cfg.WebsocketPingTimeout,
+		cfg.LoginCookieSecure,
+		cfg.ConfigFrozen,
 		commandsService,
 		filesService,
 		userConfigService,
 		runnerService,
+		runsService,
+		environmentService,
+		secretsService,
+		authService,
+		accessService,
+		auditService,
 	)
 
 	if config.Config.OpenURLInBrowser {
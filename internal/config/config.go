@@ -15,14 +15,30 @@ import (
 var portFlag int
 
 type StructOfConfig struct {
-	PORT                   int
-	RootDir                string
-	LogLevel               log.Level
-	Console                string // sh or cmd
-	MaxFileSize            int64  // in bytes, for no restrict <=0
-	WebsocketWriteInterval time.Duration
-	DefaultCommandRunDir   string
-	OpenURLInBrowser       bool
+	PORT                  int
+	RootDir               string
+	LogLevel              log.Level
+	Console               string        // sh or cmd
+	MaxFileSize           int64         // in bytes, for no restrict <=0
+	MaxRunOutputSize      int64         // in bytes, stored output of one run, for no restrict <=0
+	SessionDetachTimeout  time.Duration // how long command lives without connected clients
+	SessionScrollbackSize int           // in bytes, output kept for reconnected clients, buffer for lagging client
+	SessionOverflowPolicy string        // drop or block, what to do when client lags more than scrollback size
+	KillInterruptGrace    time.Duration // default wait after SIGINT before SIGTERM when stopping command, 0 for skip SIGINT
+	KillTerminateGrace    time.Duration // default wait after SIGTERM before SIGKILL when stopping command, 0 for skip SIGTERM
+	WebsocketPingInterval time.Duration // how often server pings terminal clients, 0 for no pings
+	WebsocketPingTimeout  time.Duration // client, that sent nothing for ping interval and timeout, is disconnected
+	DefaultCommandRunDir  string
+	CommandsEnvFile       string        // .env file loaded for every command, empty for none
+	SecretsKey            string        // base64 key of secrets encryption, if empty key read from SecretsKeyFile
+	SecretsKeyFile        string        // created with random key if not exists
+	AdminUsername         string        // username of admin account, created on first run
+	AdminPassword         string        // password of admin account created on first run, if empty random one is logged
+	LoginSessionTTL       time.Duration // how long user stays logged in
+	LoginCookieSecure     bool          // send login cookie only over https, set it behind tls proxy
+	ConfigFrozen          bool          // commands and files can not be changed, only run
+	FrozenConfigFile      string        // json config loaded to database on start, empty for none. Freezes config
+	OpenURLInBrowser      bool
 }
 
 var Config *StructOfConfig
@@ -33,6 +49,7 @@ func flagParse(config *StructOfConfig) {
 	}
 	flag.IntVar(&portFlag, "port", -1, "port which the server will listen")
 	flag.BoolVar(&config.OpenURLInBrowser, "browser", false, "open url of ui in default browser")
+	flag.BoolVar(&config.ConfigFrozen, "freeze", false, "forbid changing commands and files, they can only be run")
 	flag.Parse()
 }
 
@@ -65,8 +82,86 @@ func InitConfigs(rootDir string) error {
 	}
 	Config.LogLevel = log.Level(map[string]int{"": 2, "trace": 0, "debug": 1, "info": 2, "warn": 3, "error": 4, "fatal": 5, "panic": 6}[os.Getenv("LOG_LEVEL")])
 	Config.MaxFileSize = -1
-	Config.WebsocketWriteInterval = time.Millisecond * 50
+	Config.MaxRunOutputSize = 5 * 1024 * 1024
+	Config.SessionDetachTimeout = time.Minute * 10
+	if detachTimeout, ok := os.LookupEnv("SESSION_DETACH_TIMEOUT"); ok {
+		if timeout, err := time.ParseDuration(detachTimeout); err == nil {
+			Config.SessionDetachTimeout = timeout
+		}
+	}
+	Config.SessionScrollbackSize = 256 * 1024
+	if scrollbackSize, ok := os.LookupEnv("SESSION_SCROLLBACK_SIZE"); ok {
+		if size, err := strconv.Atoi(scrollbackSize); err == nil && size > 0 {
+			Config.SessionScrollbackSize = size
+		}
+	}
+	Config.SessionOverflowPolicy = "drop"
+	if overflowPolicy := os.Getenv("SESSION_OVERFLOW_POLICY"); overflowPolicy == "block" {
+		Config.SessionOverflowPolicy = overflowPolicy
+	}
+	Config.KillInterruptGrace = time.Second * 2
+	if interruptGrace, ok := os.LookupEnv("KILL_INTERRUPT_GRACE"); ok {
+		if grace, err := time.ParseDuration(interruptGrace); err == nil {
+			Config.KillInterruptGrace = grace
+		}
+	}
+	Config.KillTerminateGrace = time.Second * 5
+	if terminateGrace, ok := os.LookupEnv("KILL_TERMINATE_GRACE"); ok {
+		if grace, err := time.ParseDuration(terminateGrace); err == nil {
+			Config.KillTerminateGrace = grace
+		}
+	}
+	Config.WebsocketPingInterval = time.Second * 30
+	if pingInterval, ok := os.LookupEnv("WEBSOCKET_PING_INTERVAL"); ok {
+		if interval, err := time.ParseDuration(pingInterval); err == nil && interval >= 0 {
+			Config.WebsocketPingInterval = interval
+		}
+	}
+	Config.WebsocketPingTimeout = time.Second * 10
+	if pingTimeout, ok := os.LookupEnv("WEBSOCKET_PING_TIMEOUT"); ok {
+		if timeout, err := time.ParseDuration(pingTimeout); err == nil && timeout > 0 {
+			Config.WebsocketPingTimeout = timeout
+		}
+	}
 	Config.DefaultCommandRunDir = utils.GetHomeDir()
+	if commandsEnvFile := os.Getenv("COMMANDS_ENV_FILE"); commandsEnvFile != "" {
+		if !filepath.IsAbs(commandsEnvFile) {
+			commandsEnvFile = filepath.Join(rootDir, commandsEnvFile)
+		}
+		Config.CommandsEnvFile = commandsEnvFile
+	}
+	Config.SecretsKey = os.Getenv("SECRETS_KEY")
+	Config.SecretsKeyFile = filepath.Join(rootDir, "data", "secrets.key")
+	if secretsKeyFile := os.Getenv("SECRETS_KEY_FILE"); secretsKeyFile != "" {
+		if !filepath.IsAbs(secretsKeyFile) {
+			secretsKeyFile = filepath.Join(rootDir, secretsKeyFile)
+		}
+		Config.SecretsKeyFile = secretsKeyFile
+	}
+	Config.AdminUsername = "admin"
+	if adminUsername := os.Getenv("ADMIN_USERNAME"); adminUsername != "" {
+		Config.AdminUsername = adminUsername
+	}
+	Config.AdminPassword = os.Getenv("ADMIN_PASSWORD")
+	Config.LoginSessionTTL = time.Hour * 24 * 7
+	if sessionTTL, ok := os.LookupEnv("LOGIN_SESSION_TTL"); ok {
+		if ttl, err := time.ParseDuration(sessionTTL); err == nil && ttl > 0 {
+			Config.LoginSessionTTL = ttl
+		}
+	}
+	if cookieSecure, ok := os.LookupEnv("LOGIN_COOKIE_SECURE"); ok {
+		Config.LoginCookieSecure, _ = strconv.ParseBool(cookieSecure)
+	}
+	if configFrozen, ok := os.LookupEnv("CONFIG_FREEZE"); ok && !Config.ConfigFrozen {
+		Config.ConfigFrozen, _ = strconv.ParseBool(configFrozen)
+	}
+	if frozenConfigFile := os.Getenv("FROZEN_CONFIG_FILE"); frozenConfigFile != "" {
+		if !filepath.IsAbs(frozenConfigFile) {
+			frozenConfigFile = filepath.Join(rootDir, frozenConfigFile)
+		}
+		Config.FrozenConfigFile = frozenConfigFile
+		Config.ConfigFrozen = true
+	}
 	log.SetLevel(Config.LogLevel)
 	console, ok := os.LookupEnv("CONSOLE")
 	if ok {
@@ -0,0 +1,168 @@
+package access
+
+import (
+	"errors"
+	"fmt"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/utils"
+)
+
+// Service decide what user can do. Role gives access level to every command, grant replaces it for one command.
+// Admin has full access regardless of grants, nil user is server itself and has full access too
+type Service struct {
+	grantsRepository   GrantsRepository
+	commandsRepository CommandsRepository
+	usersRepository    UsersRepository
+}
+
+func NewService(grantsRepository GrantsRepository, commandsRepository CommandsRepository, usersRepository UsersRepository) *Service {
+	return &Service{
+		grantsRepository:   grantsRepository,
+		commandsRepository: commandsRepository,
+		usersRepository:    usersRepository,
+	}
+}
+
+func roleRank(role entities.UserRole) int {
+	switch role {
+	case entities.RoleViewer:
+		return 1
+	case entities.RoleOperator:
+		return 2
+	case entities.RoleEditor:
+		return 3
+	case entities.RoleAdmin:
+		return 4
+	}
+	return 0
+}
+
+func levelRank(level entities.AccessLevel) int {
+	switch level {
+	case entities.AccessView:
+		return 1
+	case entities.AccessRun:
+		return 2
+	case entities.AccessEdit:
+		return 3
+	}
+	return 0
+}
+
+// roleLevel return access level, that role gives to every command
+func roleLevel(role entities.UserRole) entities.AccessLevel {
+	switch role {
+	case entities.RoleAdmin, entities.RoleEditor:
+		return entities.AccessEdit
+	case entities.RoleOperator:
+		return entities.AccessRun
+	case entities.RoleViewer:
+		return entities.AccessView
+	}
+	return entities.AccessNone
+}
+
+// CheckRole return ErrForbidden, if role of user is lower than role
+func (s Service) CheckRole(user *entities.User, role entities.UserRole) error {
+	if user == nil || roleRank(user.Role) >= roleRank(role) {
+		return nil
+	}
+	return projectErrors.ErrForbidden
+}
+
+func (s Service) CommandAccess(user *entities.User, commandId uint) (entities.AccessLevel, error) {
+	if user == nil || user.Role == entities.RoleAdmin {
+		return entities.AccessEdit, nil
+	}
+	grant, err := s.grantsRepository.GetCommandGrant(commandId, user.ID)
+	if errors.Is(err, projectErrors.ErrNotFound) {
+		return roleLevel(user.Role), nil
+	} else if err != nil {
+		return "", err
+	}
+	return grant.Level, nil
+}
+
+// CheckCommand return ErrForbidden, if access level of user to command is lower than level
+func (s Service) CheckCommand(user *entities.User, commandId uint, level entities.AccessLevel) error {
+	userLevel, err := s.CommandAccess(user, commandId)
+	if err != nil {
+		return err
+	}
+	if levelRank(userLevel) < levelRank(level) {
+		return projectErrors.ErrForbidden
+	}
+	return nil
+}
+
+// VisibleCommands return commands, that user can view, with access level of user set. For nil user commands are not changed
+func (s Service) VisibleCommands(user *entities.User, commands []entities.Command) ([]entities.Command, error) {
+	if user == nil {
+		return commands, nil
+	}
+	if user.Role == entities.RoleAdmin {
+		for i := range commands {
+			commands[i].Access = entities.AccessEdit
+		}
+		return commands, nil
+	}
+	grants, err := s.grantsRepository.GetUserGrants(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	levels := make(map[uint]entities.AccessLevel, len(grants))
+	for _, grant := range grants {
+		levels[grant.CommandID] = grant.Level
+	}
+	res := make([]entities.Command, 0, len(commands))
+	for _, command := range commands {
+		level, ok := levels[command.ID]
+		if !ok {
+			level = roleLevel(user.Role)
+		}
+		if levelRank(level) < levelRank(entities.AccessView) {
+			continue
+		}
+		command.Access = level
+		res = append(res, command)
+	}
+	return res, nil
+}
+
+// GetCommandGrants return grants of command, only admin can see them
+func (s Service) GetCommandGrants(user *entities.User, commandId uint) ([]entities.CommandGrant, error) {
+	if err := s.CheckRole(user, entities.RoleAdmin); err != nil {
+		return nil, err
+	}
+	return s.grantsRepository.GetCommandGrants(commandId)
+}
+
+// SetCommandGrant give access level to command for other user, only admin can do it
+func (s Service) SetCommandGrant(user *entities.User, grant *entities.CommandGrant) error {
+	if err := s.CheckRole(user, entities.RoleAdmin); err != nil {
+		return err
+	}
+	if err := utils.CheckAccessLevel(grant.Level); err != nil {
+		return err
+	}
+	exists, err := s.commandsRepository.CommandExists(grant.CommandID)
+	if err != nil {
+		return fmt.Errorf("cant check command exist: %w", err)
+	}
+	if !exists {
+		return projectErrors.ErrNotFound
+	}
+	if _, err := s.usersRepository.GetUser(grant.UserID); err != nil {
+		return err
+	}
+	return s.grantsRepository.SetCommandGrant(grant)
+}
+
+// DeleteCommandGrant return access level of user to command back to level of its role
+func (s Service) DeleteCommandGrant(user *entities.User, commandId, userId uint) error {
+	if err := s.CheckRole(user, entities.RoleAdmin); err != nil {
+		return err
+	}
+	return s.grantsRepository.DeleteCommandGrant(commandId, userId)
+}
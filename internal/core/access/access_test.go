@@ -0,0 +1,198 @@
+package access
+
+import (
+	"errors"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/adapters/storage/database"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/testutils"
+	"github.com/gofiber/fiber/v2/log"
+	"testing"
+)
+
+func createTestService(t *testing.T) (*Service, database.DB) {
+	tmpDir, cleanup := testutils.CreateTempDataFolder(t)
+	t.Cleanup(cleanup)
+	db, err := database.Connect(tmpDir)
+	if err != nil {
+		t.Fatalf("Cant create db: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("Cant close db: %v", err)
+		}
+	})
+	for _, name := range []string{"restart service", "deploy"} {
+		if err := db.AppendCommand(&entities.Command{Name: name, Command: "echo " + name}); err != nil {
+			t.Fatalf("Cant append command: %v", err)
+		}
+	}
+	return NewService(db, db, db), db
+}
+
+func createTestUser(t *testing.T, db database.DB, username string, role entities.UserRole) *entities.User {
+	user := &entities.User{Username: username, Role: role}
+	if err := db.AppendUser(user); err != nil {
+		t.Fatalf("Cant append user: %v", err)
+	}
+	return user
+}
+
+func TestCheckCommand(t *testing.T) {
+	log.SetLevel(0)
+	service, db := createTestService(t)
+	admin := createTestUser(t, db, "admin", entities.RoleAdmin)
+	editor := createTestUser(t, db, "editor", entities.RoleEditor)
+	operator := createTestUser(t, db, "operator", entities.RoleOperator)
+	viewer := createTestUser(t, db, "viewer", entities.RoleViewer)
+
+	grants := []entities.CommandGrant{
+		{CommandID: 1, UserID: viewer.ID, Level: entities.AccessRun},
+		{CommandID: 2, UserID: operator.ID, Level: entities.AccessNone},
+		{CommandID: 2, UserID: admin.ID, Level: entities.AccessNone},
+	}
+	for _, grant := range grants {
+		if err := service.SetCommandGrant(nil, &grant); err != nil {
+			t.Fatalf("Cant set grant: %v", err)
+		}
+	}
+
+	testCases := []struct {
+		name          string
+		user          *entities.User
+		commandId     uint
+		level         entities.AccessLevel
+		expectedError error
+	}{
+		{name: "Server edits command", user: nil, commandId: 1, level: entities.AccessEdit},
+		{name: "Admin ignores grants", user: admin, commandId: 2, level: entities.AccessEdit},
+		{name: "Editor edits command", user: editor, commandId: 1, level: entities.AccessEdit},
+		{name: "Operator runs command", user: operator, commandId: 1, level: entities.AccessRun},
+		{name: "Operator cant edit command", user: operator, commandId: 1, level: entities.AccessEdit, expectedError: projectErrors.ErrForbidden},
+		{name: "Grant hides command from operator", user: operator, commandId: 2, level: entities.AccessView, expectedError: projectErrors.ErrForbidden},
+		{name: "Viewer views command", user: viewer, commandId: 2, level: entities.AccessView},
+		{name: "Viewer cant run command", user: viewer, commandId: 2, level: entities.AccessRun, expectedError: projectErrors.ErrForbidden},
+		{name: "Grant lets viewer run command", user: viewer, commandId: 1, level: entities.AccessRun},
+		{name: "Grant dont let viewer edit command", user: viewer, commandId: 1, level: entities.AccessEdit, expectedError: projectErrors.ErrForbidden},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := service.CheckCommand(tc.user, tc.commandId, tc.level)
+			if !errors.Is(err, tc.expectedError) {
+				t.Errorf("Expected error %v, got %v", tc.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestCheckRole(t *testing.T) {
+	log.SetLevel(0)
+	service, _ := createTestService(t)
+
+	testCases := []struct {
+		name          string
+		user          *entities.User
+		role          entities.UserRole
+		expectedError error
+	}{
+		{name: "Server", user: nil, role: entities.RoleAdmin},
+		{name: "Same role", user: &entities.User{Role: entities.RoleEditor}, role: entities.RoleEditor},
+		{name: "Higher role", user: &entities.User{Role: entities.RoleAdmin}, role: entities.RoleOperator},
+		{name: "Lower role", user: &entities.User{Role: entities.RoleOperator}, role: entities.RoleEditor, expectedError: projectErrors.ErrForbidden},
+		{name: "Unknown role", user: &entities.User{Role: "root"}, role: entities.RoleViewer, expectedError: projectErrors.ErrForbidden},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := service.CheckRole(tc.user, tc.role)
+			if !errors.Is(err, tc.expectedError) {
+				t.Errorf("Expected error %v, got %v", tc.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestVisibleCommands(t *testing.T) {
+	log.SetLevel(0)
+	service, db := createTestService(t)
+	admin := createTestUser(t, db, "admin", entities.RoleAdmin)
+	operator := createTestUser(t, db, "operator", entities.RoleOperator)
+	if err := service.SetCommandGrant(admin, &entities.CommandGrant{CommandID: 1, UserID: operator.ID, Level: entities.AccessNone}); err != nil {
+		t.Fatalf("Cant set grant: %v", err)
+	}
+	if err := service.SetCommandGrant(admin, &entities.CommandGrant{CommandID: 2, UserID: operator.ID, Level: entities.AccessEdit}); err != nil {
+		t.Fatalf("Cant set grant: %v", err)
+	}
+
+	commands, err := db.GetCommands()
+	if err != nil {
+		t.Fatalf("Cant get commands: %v", err)
+	}
+	visible, err := service.VisibleCommands(operator, commands)
+	if err != nil {
+		t.Fatalf("Cant get visible commands: %v", err)
+	}
+	if len(visible) != 1 || visible[0].ID != 2 || visible[0].Access != entities.AccessEdit {
+		t.Errorf("Unexpected visible commands: %v", visible)
+	}
+
+	visible, err = service.VisibleCommands(admin, commands)
+	if err != nil {
+		t.Fatalf("Cant get visible commands: %v", err)
+	}
+	if len(visible) != 2 || visible[0].Access != entities.AccessEdit || visible[1].Access != entities.AccessEdit {
+		t.Errorf("Unexpected visible commands: %v", visible)
+	}
+}
+
+func TestCommandGrants(t *testing.T) {
+	log.SetLevel(0)
+	service, db := createTestService(t)
+	admin := createTestUser(t, db, "admin", entities.RoleAdmin)
+	editor := createTestUser(t, db, "editor", entities.RoleEditor)
+
+	testCases := []struct {
+		name          string
+		user          *entities.User
+		grant         entities.CommandGrant
+		expectedError error
+	}{
+		{name: "Admin sets grant", user: admin, grant: entities.CommandGrant{CommandID: 1, UserID: editor.ID, Level: entities.AccessView}},
+		{name: "Admin replaces grant", user: admin, grant: entities.CommandGrant{CommandID: 1, UserID: editor.ID, Level: entities.AccessRun}},
+		{name: "Editor cant set grant", user: editor, grant: entities.CommandGrant{CommandID: 2, UserID: editor.ID, Level: entities.AccessEdit}, expectedError: projectErrors.ErrForbidden},
+		{name: "Bad level", user: admin, grant: entities.CommandGrant{CommandID: 2, UserID: editor.ID, Level: "all"}, expectedError: projectErrors.ErrBadAccessLevel},
+		{name: "Unknown command", user: admin, grant: entities.CommandGrant{CommandID: 100, UserID: editor.ID, Level: entities.AccessRun}, expectedError: projectErrors.ErrNotFound},
+		{name: "Unknown user", user: admin, grant: entities.CommandGrant{CommandID: 2, UserID: 100, Level: entities.AccessRun}, expectedError: projectErrors.ErrNotFound},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := service.SetCommandGrant(tc.user, &tc.grant)
+			if !errors.Is(err, tc.expectedError) {
+				t.Errorf("Expected error %v, got %v", tc.expectedError, err)
+			}
+		})
+	}
+
+	if _, err := service.GetCommandGrants(editor, 1); !errors.Is(err, projectErrors.ErrForbidden) {
+		t.Errorf("Expected ErrForbidden, got %v", err)
+	}
+	grants, err := service.GetCommandGrants(admin, 1)
+	if err != nil || len(grants) != 1 || grants[0].Level != entities.AccessRun {
+		t.Fatalf("Unexpected grants: %v %v", grants, err)
+	}
+	if err := service.CheckCommand(editor, 1, entities.AccessEdit); !errors.Is(err, projectErrors.ErrForbidden) {
+		t.Errorf("Grant dont lower access of editor: %v", err)
+	}
+
+	if err := service.DeleteCommandGrant(editor, 1, editor.ID); !errors.Is(err, projectErrors.ErrForbidden) {
+		t.Errorf("Expected ErrForbidden, got %v", err)
+	}
+	if err := service.DeleteCommandGrant(admin, 1, editor.ID); err != nil {
+		t.Fatalf("Cant delete grant: %v", err)
+	}
+	if err := service.DeleteCommandGrant(admin, 1, editor.ID); !errors.Is(err, projectErrors.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if err := service.CheckCommand(editor, 1, entities.AccessEdit); err != nil {
+		t.Errorf("Access of editor is not returned to role level: %v", err)
+	}
+}
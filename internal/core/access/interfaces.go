@@ -0,0 +1,19 @@
+package access
+
+import "github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+
+type GrantsRepository interface {
+	GetCommandGrant(commandId, userId uint) (*entities.CommandGrant, error)
+	GetCommandGrants(commandId uint) ([]entities.CommandGrant, error)
+	GetUserGrants(userId uint) ([]entities.CommandGrant, error)
+	SetCommandGrant(grant *entities.CommandGrant) error
+	DeleteCommandGrant(commandId, userId uint) error
+}
+
+type CommandsRepository interface {
+	CommandExists(id uint) (bool, error)
+}
+
+type UsersRepository interface {
+	GetUser(id uint) (*entities.User, error)
+}
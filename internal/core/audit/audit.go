@@ -0,0 +1,194 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+	verifyBatch  = 500
+)
+
+// ServerActor is actor of actions without user, like runs started by server itself
+const ServerActor = "server"
+
+type Service struct {
+	mu              *sync.Mutex // entries are appended one by one, so every entry is chained to the last one
+	auditRepository AuditRepository
+}
+
+func NewService(auditRepository AuditRepository) *Service {
+	return &Service{
+		mu:              &sync.Mutex{},
+		auditRepository: auditRepository,
+	}
+}
+
+// hashedEntry is fields of entry, that are covered by its hash
+type hashedEntry struct {
+	Time     string                          `json:"time"`
+	UserID   *uint                           `json:"user-id"`
+	Actor    string                          `json:"actor"`
+	IP       string                          `json:"ip"`
+	Action   entities.AuditAction            `json:"action"`
+	Target   string                          `json:"target"`
+	Diff     map[string]entities.AuditChange `json:"diff"`
+	PrevHash string                          `json:"prev-hash"`
+}
+
+func hashEntry(entry *entities.AuditEntry) (string, error) {
+	data, err := json.Marshal(hashedEntry{
+		Time:     entry.Time.UTC().Format(time.RFC3339Nano),
+		UserID:   entry.UserID,
+		Actor:    entry.Actor,
+		IP:       entry.IP,
+		Action:   entry.Action,
+		Target:   entry.Target,
+		Diff:     entry.Diff,
+		PrevHash: entry.PrevHash,
+	})
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// toJsonMap convert value to map of its json fields, nil is empty map
+func toJsonMap(value any) (map[string]any, error) {
+	res := map[string]any{}
+	if value == nil || reflect.ValueOf(value).Kind() == reflect.Pointer && reflect.ValueOf(value).IsNil() {
+		return res, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Diff return changed json fields of two values of the same struct.
+// Old is nil for created object and new is nil for deleted one, then every field is in diff
+func Diff(old, new any) (map[string]entities.AuditChange, error) {
+	oldFields, err := toJsonMap(old)
+	if err != nil {
+		return nil, err
+	}
+	newFields, err := toJsonMap(new)
+	if err != nil {
+		return nil, err
+	}
+	diff := map[string]entities.AuditChange{}
+	// Missing field and null field are the same, so empty fields of created or deleted object are skipped
+	for name, oldValue := range oldFields {
+		if newValue := newFields[name]; !reflect.DeepEqual(oldValue, newValue) {
+			diff[name] = entities.AuditChange{Old: oldValue, New: newValue}
+		}
+	}
+	for name, newValue := range newFields {
+		if _, ok := oldFields[name]; !ok && newValue != nil {
+			diff[name] = entities.AuditChange{New: newValue}
+		}
+	}
+	if len(diff) == 0 {
+		return nil, nil
+	}
+	return diff, nil
+}
+
+// Record append entry to the end of chain. Diff is made from old and new value of changed object,
+// time and hashes are set by it, other fields by caller
+func (s Service) Record(entry *entities.AuditEntry, old, new any) error {
+	diff, err := Diff(old, new)
+	if err != nil {
+		return fmt.Errorf("cant make diff: %w", err)
+	}
+	entry.Diff = diff
+	if entry.Actor == "" {
+		entry.Actor = ServerActor
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry.PrevHash = ""
+	last, err := s.auditRepository.GetLastAuditEntry()
+	if err == nil {
+		entry.PrevHash = last.Hash
+	} else if !errors.Is(err, projectErrors.ErrNotFound) {
+		return err
+	}
+	entry.Time = time.Now().UTC().Truncate(time.Microsecond)
+	entry.Hash, err = hashEntry(entry)
+	if err != nil {
+		return fmt.Errorf("cant hash audit entry: %w", err)
+	}
+	return s.auditRepository.AppendAuditEntry(entry)
+}
+
+// GetEntries return entries matching filter, newest first. Zero limit means default limit
+func (s Service) GetEntries(filter entities.AuditFilter) ([]entities.AuditEntry, error) {
+	if filter.Limit == 0 {
+		filter.Limit = defaultLimit
+	}
+	if filter.Limit < 0 || filter.Limit > maxLimit || filter.Offset < 0 {
+		return nil, projectErrors.ErrBadAuditFilter
+	}
+	if filter.Since != nil {
+		since := filter.Since.UTC()
+		filter.Since = &since
+	}
+	if filter.Until != nil {
+		until := filter.Until.UTC()
+		filter.Until = &until
+	}
+	entries, err := s.auditRepository.GetAuditEntries(filter)
+	if err != nil {
+		return nil, err
+	}
+	if entries == nil {
+		entries = []entities.AuditEntry{}
+	}
+	return entries, nil
+}
+
+// Verify check hashes of all entries and links between them, from the first entry
+func (s Service) Verify() (*entities.AuditVerification, error) {
+	res := &entities.AuditVerification{Valid: true}
+	var lastId uint
+	for {
+		entries, err := s.auditRepository.GetAuditEntriesAfter(lastId, verifyBatch)
+		if err != nil {
+			return nil, err
+		}
+		for i := range entries {
+			hash, err := hashEntry(&entries[i])
+			if err != nil {
+				return nil, fmt.Errorf("cant hash audit entry: %w", err)
+			}
+			if entries[i].PrevHash != res.LastHash || entries[i].Hash != hash {
+				res.Valid = false
+				res.BrokenID = entries[i].ID
+				return res, nil
+			}
+			res.LastHash = entries[i].Hash
+			res.Checked++
+		}
+		if len(entries) < verifyBatch {
+			return res, nil
+		}
+		lastId = entries[len(entries)-1].ID
+	}
+}
@@ -0,0 +1,202 @@
+package audit
+
+import (
+	"errors"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/adapters/storage/database"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/testutils"
+	"github.com/gofiber/fiber/v2/log"
+	"testing"
+	"time"
+)
+
+func connectTestDB(t *testing.T) database.DB {
+	tmpDir, cleanup := testutils.CreateTempDataFolder(t)
+	t.Cleanup(cleanup)
+	db, err := database.Connect(tmpDir)
+	if err != nil {
+		t.Fatalf("Cant create db: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("Cant close db: %v", err)
+		}
+	})
+	return db
+}
+
+// tamperedRepository change entries, when they are read for verification, like someone edited database
+type tamperedRepository struct {
+	database.DB
+	tamper func(entries []entities.AuditEntry) []entities.AuditEntry
+}
+
+func (r tamperedRepository) GetAuditEntriesAfter(afterId uint, limit int) ([]entities.AuditEntry, error) {
+	entries, err := r.DB.GetAuditEntriesAfter(afterId, limit)
+	if err != nil {
+		return nil, err
+	}
+	return r.tamper(entries), nil
+}
+
+func recordTestEntries(t *testing.T, service *Service) {
+	userId := uint(1)
+	entries := []struct {
+		entry entities.AuditEntry
+		old   any
+		new   any
+	}{
+		{entry: entities.AuditEntry{UserID: &userId, Actor: "admin", IP: "10.0.0.1", Action: entities.AuditLogin}},
+		{
+			entry: entities.AuditEntry{UserID: &userId, Actor: "admin", IP: "10.0.0.1", Action: entities.AuditCommandUpdate, Target: "command:1"},
+			old:   &entities.Command{ID: 1, Name: "backup", Command: "pg_dump db"},
+			new:   &entities.Command{ID: 1, Name: "backup", Command: "drop database", Env: map[string]string{"A": "1"}},
+		},
+		{entry: entities.AuditEntry{UserID: &userId, Actor: "admin", IP: "10.0.0.1", Action: entities.AuditRunStart, Target: "command:1/run:1"}},
+		{entry: entities.AuditEntry{Actor: "mallory", IP: "10.0.0.2", Action: entities.AuditLoginFailed}},
+		{
+			entry: entities.AuditEntry{UserID: &userId, Actor: "admin", IP: "10.0.0.1", Action: entities.AuditRunFinish, Target: "command:1/run:1"},
+			new:   &entities.ExitStatus{Code: 1, DurationMs: 20},
+		},
+		{entry: entities.AuditEntry{Action: entities.AuditConfigImport}},
+	}
+	for i := range entries {
+		if err := service.Record(&entries[i].entry, entries[i].old, entries[i].new); err != nil {
+			t.Fatalf("Cant record entry: %v", err)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	testCases := []struct {
+		name     string
+		old      any
+		new      any
+		expected []string
+	}{
+		{name: "Same", old: &entities.Command{Name: "a"}, new: &entities.Command{Name: "a"}, expected: nil},
+		{name: "Changed command", old: &entities.Command{Name: "a", Command: "ls"}, new: &entities.Command{Name: "a", Command: "rm -rf /"}, expected: []string{"command"}},
+		{name: "Added field", old: &entities.Command{Name: "a"}, new: &entities.Command{Name: "a", Env: map[string]string{"A": "1"}}, expected: []string{"env"}},
+		{name: "Created", old: nil, new: &entities.Command{ID: 1, Name: "a"}, expected: []string{"id", "name", "command", "executionDir"}},
+		{name: "Deleted", old: &entities.Command{ID: 1, Name: "a"}, new: (*entities.Command)(nil), expected: []string{"id", "name", "command", "executionDir"}},
+		{name: "Null fields of created object", old: nil, new: &entities.Run{ID: 1}, expected: []string{"id", "command-id", "command", "session-id", "triggered-by", "started-at", "output-size", "output-truncated"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			diff, err := Diff(tc.old, tc.new)
+			if err != nil {
+				t.Fatalf("Cant diff: %v", err)
+			}
+			if len(diff) != len(tc.expected) {
+				t.Fatalf("Expected fields %v, got diff %v", tc.expected, diff)
+			}
+			for _, name := range tc.expected {
+				if _, ok := diff[name]; !ok {
+					t.Errorf("Field %s is not in diff %v", name, diff)
+				}
+			}
+		})
+	}
+	diff, _ := Diff(&entities.Command{Command: "ls"}, &entities.Command{Command: "rm"})
+	if diff["command"].Old != "ls" || diff["command"].New != "rm" {
+		t.Errorf("Unexpected change %v", diff["command"])
+	}
+}
+
+func TestVerify(t *testing.T) {
+	log.SetLevel(0)
+	db := connectTestDB(t)
+	recordTestEntries(t, NewService(db))
+
+	testCases := []struct {
+		name             string
+		tamper           func(entries []entities.AuditEntry) []entities.AuditEntry
+		expectedValid    bool
+		expectedBrokenID uint
+	}{
+		{name: "Untouched", tamper: func(entries []entities.AuditEntry) []entities.AuditEntry { return entries }, expectedValid: true},
+		{name: "Changed diff", tamper: func(entries []entities.AuditEntry) []entities.AuditEntry {
+			entries[1].Diff["command"] = entities.AuditChange{Old: "pg_dump db", New: "echo ok"}
+			return entries
+		}, expectedBrokenID: 2},
+		{name: "Changed actor", tamper: func(entries []entities.AuditEntry) []entities.AuditEntry {
+			entries[3].Actor = "admin"
+			return entries
+		}, expectedBrokenID: 4},
+		{name: "Changed time", tamper: func(entries []entities.AuditEntry) []entities.AuditEntry {
+			entries[2].Time = entries[2].Time.Add(time.Second)
+			return entries
+		}, expectedBrokenID: 3},
+		{name: "Rehashed entry", tamper: func(entries []entities.AuditEntry) []entities.AuditEntry {
+			entries[2].IP = "127.0.0.1"
+			entries[2].Hash, _ = hashEntry(&entries[2])
+			return entries
+		}, expectedBrokenID: 4},
+		{name: "Deleted entry", tamper: func(entries []entities.AuditEntry) []entities.AuditEntry {
+			return append(entries[:1], entries[2:]...)
+		}, expectedBrokenID: 3},
+		{name: "Deleted first entry", tamper: func(entries []entities.AuditEntry) []entities.AuditEntry {
+			return entries[1:]
+		}, expectedBrokenID: 2},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			service := NewService(tamperedRepository{db, tc.tamper})
+			res, err := service.Verify()
+			if err != nil {
+				t.Fatalf("Cant verify: %v", err)
+			}
+			if res.Valid != tc.expectedValid || res.BrokenID != tc.expectedBrokenID {
+				t.Errorf("Expected valid %v and broken id %d, got %v", tc.expectedValid, tc.expectedBrokenID, res)
+			}
+			if tc.expectedValid && (res.Checked != 6 || res.LastHash == "") {
+				t.Errorf("Unexpected verification %v", res)
+			}
+		})
+	}
+}
+
+func TestGetEntries(t *testing.T) {
+	log.SetLevel(0)
+	db := connectTestDB(t)
+	service := NewService(db)
+	recordTestEntries(t, service)
+	hourAgo := time.Now().Add(-time.Hour)
+
+	testCases := []struct {
+		name          string
+		filter        entities.AuditFilter
+		expectedIds   []uint
+		expectedError error
+	}{
+		{name: "All", filter: entities.AuditFilter{}, expectedIds: []uint{6, 5, 4, 3, 2, 1}},
+		{name: "Actor", filter: entities.AuditFilter{Actor: "mallory"}, expectedIds: []uint{4}},
+		{name: "Server actor", filter: entities.AuditFilter{Actor: ServerActor}, expectedIds: []uint{6}},
+		{name: "Action", filter: entities.AuditFilter{Action: entities.AuditCommandUpdate}, expectedIds: []uint{2}},
+		{name: "Target with children", filter: entities.AuditFilter{Target: "command:1"}, expectedIds: []uint{5, 3, 2}},
+		{name: "Exact target", filter: entities.AuditFilter{Target: "command:1/run:1"}, expectedIds: []uint{5, 3}},
+		{name: "Target is not prefix of id", filter: entities.AuditFilter{Target: "command:"}, expectedIds: nil},
+		{name: "Since", filter: entities.AuditFilter{Since: &hourAgo, Limit: 2}, expectedIds: []uint{6, 5}},
+		{name: "Until", filter: entities.AuditFilter{Until: &hourAgo}, expectedIds: nil},
+		{name: "Offset", filter: entities.AuditFilter{Limit: 2, Offset: 3}, expectedIds: []uint{3, 2}},
+		{name: "Too big limit", filter: entities.AuditFilter{Limit: 5000}, expectedError: projectErrors.ErrBadAuditFilter},
+		{name: "Negative offset", filter: entities.AuditFilter{Offset: -1}, expectedError: projectErrors.ErrBadAuditFilter},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			entries, err := service.GetEntries(tc.filter)
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("Expected error %v, got %v", tc.expectedError, err)
+			}
+			if len(entries) != len(tc.expectedIds) {
+				t.Fatalf("Expected entries %v, got %v", tc.expectedIds, entries)
+			}
+			for i, id := range tc.expectedIds {
+				if entries[i].ID != id {
+					t.Errorf("Expected entries %v, got %v", tc.expectedIds, entries)
+				}
+			}
+		})
+	}
+}
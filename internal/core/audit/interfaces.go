@@ -0,0 +1,12 @@
+package audit
+
+import (
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+)
+
+type AuditRepository interface {
+	AppendAuditEntry(entry *entities.AuditEntry) error
+	GetLastAuditEntry() (*entities.AuditEntry, error)
+	GetAuditEntries(filter entities.AuditFilter) ([]entities.AuditEntry, error)
+	GetAuditEntriesAfter(afterId uint, limit int) ([]entities.AuditEntry, error)
+}
@@ -0,0 +1,340 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/utils"
+	"github.com/gofiber/fiber/v2/log"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"time"
+)
+
+const (
+	minPasswordLength = 8
+	maxPasswordLength = 72 // bcrypt uses only first 72 bytes
+	tokenSize         = 32
+	// generatedPasswordSize is size of random bytes of admin password, generated on first run
+	generatedPasswordSize = 12
+	// apiTokenPrefix makes api tokens recognizable, for example by secret scanners
+	apiTokenPrefix = "wbcr_"
+	// lastUsedPrecision is how often last use time of api token is written to database
+	lastUsedPrecision = time.Minute
+)
+
+type Service struct {
+	sessionTTL              time.Duration
+	usersRepository         UsersRepository
+	loginSessionsRepository LoginSessionsRepository
+	apiTokensRepository     ApiTokensRepository
+	dummyHash               []byte // compared with password of unknown user, so login takes the same time for any username
+}
+
+func NewService(sessionTTL time.Duration, usersRepository UsersRepository, loginSessionsRepository LoginSessionsRepository, apiTokensRepository ApiTokensRepository) *Service {
+	dummyHash, err := bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+	if err != nil {
+		log.Warn("Error hashing dummy password: ", err)
+	}
+	return &Service{
+		sessionTTL:              sessionTTL,
+		usersRepository:         usersRepository,
+		loginSessionsRepository: loginSessionsRepository,
+		apiTokensRepository:     apiTokensRepository,
+		dummyHash:               dummyHash,
+	}
+}
+
+func checkPassword(password string) error {
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return projectErrors.ErrBadPassword
+	}
+	return nil
+}
+
+func hashPassword(password string) ([]byte, error) {
+	if err := checkPassword(password); err != nil {
+		return nil, err
+	}
+	return bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+}
+
+// hashToken return hash of login token, that is stored in database. Token is random, so sha256 is enough
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func randomString(size int) (string, error) {
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// Bootstrap create admin account, if there are no users yet. Empty password is replaced with random one,
+// that is returned, so it can be shown to the owner of server once
+func (s Service) Bootstrap(username string, password string) (string, error) {
+	count, err := s.usersRepository.CountUsers()
+	if err != nil {
+		return "", err
+	}
+	if count != 0 {
+		return "", nil
+	}
+	generated := ""
+	if password == "" {
+		generated, err = randomString(generatedPasswordSize)
+		if err != nil {
+			return "", err
+		}
+		password = generated
+	}
+	if _, err := s.CreateUser(username, password, entities.RoleAdmin); err != nil {
+		return "", err
+	}
+	return generated, nil
+}
+
+func (s Service) CreateUser(username string, password string, role entities.UserRole) (*entities.User, error) {
+	if err := utils.CheckName(username); err != nil {
+		return nil, err
+	}
+	if err := utils.CheckRole(role); err != nil {
+		return nil, err
+	}
+	_, err := s.usersRepository.GetUserByUsername(username)
+	if err == nil {
+		return nil, projectErrors.ErrUserExists
+	} else if !errors.Is(err, projectErrors.ErrNotFound) {
+		return nil, err
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	user := &entities.User{Username: username, PasswordHash: hash, Role: role}
+	if err := s.usersRepository.AppendUser(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s Service) GetUsers() ([]entities.User, error) {
+	return s.usersRepository.GetUsers()
+}
+
+// checkNotLastAdmin return ErrLastAdmin, if user is the only admin, otherwise nobody could manage users
+func (s Service) checkNotLastAdmin(user *entities.User) error {
+	if user.Role != entities.RoleAdmin {
+		return nil
+	}
+	count, err := s.usersRepository.CountUsersWithRole(entities.RoleAdmin)
+	if err != nil {
+		return err
+	}
+	if count <= 1 {
+		return projectErrors.ErrLastAdmin
+	}
+	return nil
+}
+
+// DeleteUser delete user and log it out
+func (s Service) DeleteUser(userId uint) error {
+	user, err := s.usersRepository.GetUser(userId)
+	if err != nil {
+		return err
+	}
+	if err := s.checkNotLastAdmin(user); err != nil {
+		return err
+	}
+	return s.usersRepository.DeleteUser(userId)
+}
+
+func (s Service) SetUserRole(userId uint, role entities.UserRole) error {
+	if err := utils.CheckRole(role); err != nil {
+		return err
+	}
+	user, err := s.usersRepository.GetUser(userId)
+	if err != nil {
+		return err
+	}
+	if role != entities.RoleAdmin {
+		if err := s.checkNotLastAdmin(user); err != nil {
+			return err
+		}
+	}
+	user.Role = role
+	return s.usersRepository.UpdateUser(user)
+}
+
+// ChangePassword set new password, if old one is right. Other login sessions of user are closed
+func (s Service) ChangePassword(userId uint, oldPassword string, newPassword string) error {
+	user, err := s.usersRepository.GetUser(userId)
+	if err != nil {
+		return err
+	}
+	if bcrypt.CompareHashAndPassword(user.PasswordHash, []byte(oldPassword)) != nil {
+		return projectErrors.ErrBadCredentials
+	}
+	user.PasswordHash, err = hashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+	if err := s.usersRepository.UpdateUser(user); err != nil {
+		return err
+	}
+	return s.loginSessionsRepository.DeleteUserLoginSessions(userId)
+}
+
+// Login check password and create login session. Returned token must be sent back by client in cookie
+func (s Service) Login(username string, password string, ip string) (string, *entities.LoginSession, error) {
+	user, err := s.usersRepository.GetUserByUsername(username)
+	if errors.Is(err, projectErrors.ErrNotFound) {
+		_ = bcrypt.CompareHashAndPassword(s.dummyHash, []byte(password))
+		return "", nil, projectErrors.ErrBadCredentials
+	} else if err != nil {
+		return "", nil, err
+	}
+	if bcrypt.CompareHashAndPassword(user.PasswordHash, []byte(password)) != nil {
+		return "", nil, projectErrors.ErrBadCredentials
+	}
+
+	token, err := randomString(tokenSize)
+	if err != nil {
+		return "", nil, err
+	}
+	now := time.Now()
+	session := &entities.LoginSession{
+		TokenHash: hashToken(token),
+		UserID:    user.ID,
+		User:      *user,
+		IP:        ip,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.sessionTTL),
+	}
+	if err := s.loginSessionsRepository.AppendLoginSession(session); err != nil {
+		return "", nil, err
+	}
+	if err := s.loginSessionsRepository.DeleteExpiredLoginSessions(now); err != nil {
+		log.Warn("Error deleting expired login sessions: ", err)
+	}
+	return token, session, nil
+}
+
+func (s Service) Logout(token string) error {
+	err := s.loginSessionsRepository.DeleteLoginSession(hashToken(token))
+	if errors.Is(err, projectErrors.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// Authenticate return user of login session with this token
+func (s Service) Authenticate(token string) (*entities.User, error) {
+	if token == "" {
+		return nil, projectErrors.ErrUnauthorized
+	}
+	session, err := s.loginSessionsRepository.GetLoginSession(hashToken(token))
+	if errors.Is(err, projectErrors.ErrNotFound) {
+		return nil, projectErrors.ErrUnauthorized
+	} else if err != nil {
+		return nil, err
+	}
+	if !time.Now().Before(session.ExpiresAt) {
+		if err := s.loginSessionsRepository.DeleteLoginSession(session.TokenHash); err != nil && !errors.Is(err, projectErrors.ErrNotFound) {
+			log.Warn("Error deleting expired login session: ", err)
+		}
+		return nil, projectErrors.ErrUnauthorized
+	}
+	return &session.User, nil
+}
+
+// CreateApiToken create token of user with scopes, nil expiresAt for token without expiry.
+// Returned token is shown to user once, only its hash is stored
+func (s Service) CreateApiToken(userId uint, name string, scopes []string, expiresAt *time.Time) (string, *entities.ApiToken, error) {
+	if err := utils.CheckName(name); err != nil {
+		return "", nil, err
+	}
+	if err := utils.CheckScopes(scopes); err != nil {
+		return "", nil, err
+	}
+	now := time.Now()
+	if expiresAt != nil && !expiresAt.After(now) {
+		return "", nil, projectErrors.ErrBadTokenExpiry
+	}
+	if _, err := s.usersRepository.GetUser(userId); err != nil {
+		return "", nil, err
+	}
+	token, err := randomString(tokenSize)
+	if err != nil {
+		return "", nil, err
+	}
+	token = apiTokenPrefix + token
+	apiToken := &entities.ApiToken{
+		TokenHash: hashToken(token),
+		UserID:    userId,
+		Name:      name,
+		Scopes:    scopes,
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
+	}
+	if err := s.apiTokensRepository.AppendApiToken(apiToken); err != nil {
+		return "", nil, err
+	}
+	return token, apiToken, nil
+}
+
+func (s Service) GetApiTokens(userId uint) ([]entities.ApiToken, error) {
+	return s.apiTokensRepository.GetUserApiTokens(userId)
+}
+
+// RevokeApiToken delete token, user can revoke only own tokens
+func (s Service) RevokeApiToken(userId uint, tokenId uint) error {
+	return s.apiTokensRepository.DeleteApiToken(userId, tokenId)
+}
+
+// AuthenticateApiToken return api token with its user and remember time of its use
+func (s Service) AuthenticateApiToken(token string) (*entities.ApiToken, error) {
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		return nil, projectErrors.ErrUnauthorized
+	}
+	apiToken, err := s.apiTokensRepository.GetApiToken(hashToken(token))
+	if errors.Is(err, projectErrors.ErrNotFound) {
+		return nil, projectErrors.ErrUnauthorized
+	} else if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if apiToken.ExpiresAt != nil && !now.Before(*apiToken.ExpiresAt) {
+		return nil, projectErrors.ErrUnauthorized
+	}
+	if apiToken.LastUsedAt == nil || now.Sub(*apiToken.LastUsedAt) >= lastUsedPrecision {
+		if err := s.apiTokensRepository.SetApiTokenLastUsed(apiToken.ID, now); err != nil {
+			log.Warn("Error saving last use of api token: ", err)
+		}
+		apiToken.LastUsedAt = &now
+	}
+	return apiToken, nil
+}
+
+// CheckScope return ErrForbidden, if api token has no scope. Nil token is login session, that has every scope
+func (s Service) CheckScope(apiToken *entities.ApiToken, scope string) error {
+	if apiToken == nil {
+		return nil
+	}
+	for _, tokenScope := range apiToken.Scopes {
+		if tokenScope == scope {
+			return nil
+		}
+		if prefix, ok := strings.CutSuffix(tokenScope, "*"); ok && strings.HasPrefix(scope, prefix) {
+			return nil
+		}
+	}
+	return projectErrors.ErrForbidden
+}
@@ -0,0 +1,301 @@
+package auth
+
+import (
+	"errors"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/adapters/storage/database"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/testutils"
+	"github.com/gofiber/fiber/v2/log"
+	"testing"
+	"time"
+)
+
+func connectTestDB(t *testing.T) database.DB {
+	tmpDir, cleanup := testutils.CreateTempDataFolder(t)
+	t.Cleanup(cleanup)
+	db, err := database.Connect(tmpDir)
+	if err != nil {
+		t.Fatalf("Cant create db: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("Cant close db: %v", err)
+		}
+	})
+	return db
+}
+
+func TestBootstrap(t *testing.T) {
+	log.SetLevel(0)
+	db := connectTestDB(t)
+	service := NewService(time.Hour, db, db, db)
+
+	generated, err := service.Bootstrap("admin", "")
+	if err != nil {
+		t.Fatalf("Cant bootstrap admin: %v", err)
+	}
+	if generated == "" {
+		t.Fatal("Password of admin is not generated")
+	}
+	if _, _, err := service.Login("admin", generated, "127.0.0.1"); err != nil {
+		t.Errorf("Cant login with generated password: %v", err)
+	}
+
+	// Admin already exists, nothing is created
+	generated, err = service.Bootstrap("other", "password123")
+	if err != nil || generated != "" {
+		t.Fatalf("Unexpected second bootstrap result: %q %v", generated, err)
+	}
+	users, err := service.GetUsers()
+	if err != nil || len(users) != 1 || users[0].Username != "admin" {
+		t.Errorf("Unexpected users: %v %v", users, err)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	log.SetLevel(0)
+	db := connectTestDB(t)
+	service := NewService(time.Hour, db, db, db)
+	if _, err := service.CreateUser("alice", "correct horse", entities.RoleAdmin); err != nil {
+		t.Fatalf("Cant create user: %v", err)
+	}
+
+	testCases := []struct {
+		name          string
+		username      string
+		password      string
+		expectedError error
+	}{
+		{name: "Right password", username: "alice", password: "correct horse"},
+		{name: "Wrong password", username: "alice", password: "wrong horse", expectedError: projectErrors.ErrBadCredentials},
+		{name: "Unknown user", username: "bob", password: "correct horse", expectedError: projectErrors.ErrBadCredentials},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			token, session, err := service.Login(tc.username, tc.password, "127.0.0.1")
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("Expected error %v, got %v", tc.expectedError, err)
+			}
+			if err != nil {
+				return
+			}
+			user, err := service.Authenticate(token)
+			if err != nil {
+				t.Fatalf("Cant authenticate: %v", err)
+			}
+			if user.Username != tc.username || session.UserID != user.ID {
+				t.Errorf("Unexpected user %v of session %v", user, session)
+			}
+			if err := service.Logout(token); err != nil {
+				t.Fatalf("Cant logout: %v", err)
+			}
+			if _, err := service.Authenticate(token); !errors.Is(err, projectErrors.ErrUnauthorized) {
+				t.Errorf("Expected ErrUnauthorized after logout, got %v", err)
+			}
+		})
+	}
+
+	if _, err := service.Authenticate(""); !errors.Is(err, projectErrors.ErrUnauthorized) {
+		t.Errorf("Expected ErrUnauthorized for empty token, got %v", err)
+	}
+	if _, err := service.Authenticate("forged"); !errors.Is(err, projectErrors.ErrUnauthorized) {
+		t.Errorf("Expected ErrUnauthorized for unknown token, got %v", err)
+	}
+}
+
+func TestLoginSessionExpires(t *testing.T) {
+	log.SetLevel(0)
+	db := connectTestDB(t)
+	service := NewService(time.Millisecond, db, db, db)
+	if _, err := service.CreateUser("alice", "correct horse", entities.RoleAdmin); err != nil {
+		t.Fatalf("Cant create user: %v", err)
+	}
+	token, _, err := service.Login("alice", "correct horse", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("Cant login: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, err := service.Authenticate(token); !errors.Is(err, projectErrors.ErrUnauthorized) {
+		t.Errorf("Expected ErrUnauthorized for expired session, got %v", err)
+	}
+}
+
+func TestUsers(t *testing.T) {
+	log.SetLevel(0)
+	db := connectTestDB(t)
+	service := NewService(time.Hour, db, db, db)
+	alice, err := service.CreateUser("alice", "correct horse", entities.RoleAdmin)
+	if err != nil {
+		t.Fatalf("Cant create user: %v", err)
+	}
+
+	testCases := []struct {
+		name          string
+		username      string
+		password      string
+		role          entities.UserRole
+		expectedError error
+	}{
+		{name: "Valid user", username: "bob", password: "battery staple", role: entities.RoleOperator},
+		{name: "Existing username", username: "alice", password: "battery staple", role: entities.RoleOperator, expectedError: projectErrors.ErrUserExists},
+		{name: "Empty username", username: "", password: "battery staple", role: entities.RoleOperator, expectedError: projectErrors.ErrBadName},
+		{name: "Short password", username: "carol", password: "short", role: entities.RoleOperator, expectedError: projectErrors.ErrBadPassword},
+		{name: "Bad role", username: "carol", password: "battery staple", role: "root", expectedError: projectErrors.ErrBadRole},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := service.CreateUser(tc.username, tc.password, tc.role)
+			if !errors.Is(err, tc.expectedError) {
+				t.Errorf("Expected error %v, got %v", tc.expectedError, err)
+			}
+		})
+	}
+
+	token, _, err := service.Login("alice", "correct horse", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("Cant login: %v", err)
+	}
+	if err := service.ChangePassword(alice.ID, "wrong horse", "new password"); !errors.Is(err, projectErrors.ErrBadCredentials) {
+		t.Errorf("Expected ErrBadCredentials, got %v", err)
+	}
+	if err := service.ChangePassword(alice.ID, "correct horse", "new password"); err != nil {
+		t.Fatalf("Cant change password: %v", err)
+	}
+	if _, err := service.Authenticate(token); !errors.Is(err, projectErrors.ErrUnauthorized) {
+		t.Errorf("Session is not closed after password change: %v", err)
+	}
+	if _, _, err := service.Login("alice", "new password", "127.0.0.1"); err != nil {
+		t.Errorf("Cant login with new password: %v", err)
+	}
+
+	users, err := service.GetUsers()
+	if err != nil || len(users) != 2 {
+		t.Fatalf("Unexpected users: %v %v", users, err)
+	}
+	bob := users[1]
+	if err := service.SetUserRole(alice.ID, entities.RoleViewer); !errors.Is(err, projectErrors.ErrLastAdmin) {
+		t.Errorf("Expected ErrLastAdmin, got %v", err)
+	}
+	if err := service.SetUserRole(bob.ID, "root"); !errors.Is(err, projectErrors.ErrBadRole) {
+		t.Errorf("Expected ErrBadRole, got %v", err)
+	}
+	if err := service.SetUserRole(bob.ID, entities.RoleAdmin); err != nil {
+		t.Fatalf("Cant set role: %v", err)
+	}
+	if err := service.SetUserRole(alice.ID, entities.RoleViewer); err != nil {
+		t.Fatalf("Cant demote admin, that is not the last one: %v", err)
+	}
+	if err := service.DeleteUser(bob.ID); !errors.Is(err, projectErrors.ErrLastAdmin) {
+		t.Errorf("Expected ErrLastAdmin, got %v", err)
+	}
+	if err := service.DeleteUser(alice.ID); err != nil {
+		t.Fatalf("Cant delete user: %v", err)
+	}
+	if err := service.DeleteUser(100); !errors.Is(err, projectErrors.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestApiTokens(t *testing.T) {
+	log.SetLevel(0)
+	db := connectTestDB(t)
+	service := NewService(time.Hour, db, db, db)
+	alice, err := service.CreateUser("alice", "correct horse", entities.RoleOperator)
+	if err != nil {
+		t.Fatalf("Cant create user: %v", err)
+	}
+	bob, err := service.CreateUser("bob", "correct horse", entities.RoleOperator)
+	if err != nil {
+		t.Fatalf("Cant create user: %v", err)
+	}
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+
+	testCases := []struct {
+		name          string
+		tokenName     string
+		scopes        []string
+		expiresAt     *time.Time
+		expectedError error
+	}{
+		{name: "Run all", tokenName: "deploy", scopes: []string{entities.ScopeRunAll}},
+		{name: "Run one command with expiry", tokenName: "restart", scopes: []string{"run:command:12", entities.ScopeRead}, expiresAt: &future},
+		{name: "Expired", tokenName: "old", scopes: []string{entities.ScopeRead}, expiresAt: &past, expectedError: projectErrors.ErrBadTokenExpiry},
+		{name: "No scopes", tokenName: "empty", expectedError: projectErrors.ErrBadScope},
+		{name: "Unknown scope", tokenName: "bad", scopes: []string{"root"}, expectedError: projectErrors.ErrBadScope},
+		{name: "Bad command id", tokenName: "bad", scopes: []string{"run:command:abc"}, expectedError: projectErrors.ErrBadScope},
+		{name: "Empty name", tokenName: "", scopes: []string{entities.ScopeRead}, expectedError: projectErrors.ErrBadName},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			token, apiToken, err := service.CreateApiToken(alice.ID, tc.tokenName, tc.scopes, tc.expiresAt)
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("Expected error %v, got %v", tc.expectedError, err)
+			}
+			if err != nil {
+				return
+			}
+			authenticated, err := service.AuthenticateApiToken(token)
+			if err != nil {
+				t.Fatalf("Cant authenticate: %v", err)
+			}
+			if authenticated.ID != apiToken.ID || authenticated.User.ID != alice.ID || authenticated.LastUsedAt == nil {
+				t.Errorf("Unexpected authenticated token %v", authenticated)
+			}
+		})
+	}
+
+	tokens, err := service.GetApiTokens(alice.ID)
+	if err != nil || len(tokens) != 2 {
+		t.Fatalf("Unexpected tokens: %v %v", tokens, err)
+	}
+	if tokens[0].LastUsedAt == nil {
+		t.Error("Last use of token is not saved")
+	}
+	if err := service.RevokeApiToken(bob.ID, tokens[0].ID); !errors.Is(err, projectErrors.ErrNotFound) {
+		t.Errorf("Token of other user is revoked: %v", err)
+	}
+	if err := service.RevokeApiToken(alice.ID, tokens[0].ID); err != nil {
+		t.Fatalf("Cant revoke token: %v", err)
+	}
+	tokens, err = service.GetApiTokens(alice.ID)
+	if err != nil || len(tokens) != 1 {
+		t.Fatalf("Unexpected tokens after revoke: %v %v", tokens, err)
+	}
+	for _, token := range []string{"", "wbcr_forged", "no prefix"} {
+		if _, err := service.AuthenticateApiToken(token); !errors.Is(err, projectErrors.ErrUnauthorized) {
+			t.Errorf("Expected ErrUnauthorized for %q, got %v", token, err)
+		}
+	}
+}
+
+func TestCheckScope(t *testing.T) {
+	service := Service{}
+	token := &entities.ApiToken{Scopes: []string{entities.ScopeRead, "run:command:12", "files:*"}}
+
+	testCases := []struct {
+		name          string
+		token         *entities.ApiToken
+		scope         string
+		expectedError error
+	}{
+		{name: "Login session", token: nil, scope: entities.ScopeAdmin},
+		{name: "Exact scope", token: token, scope: entities.ScopeRead},
+		{name: "One command", token: token, scope: "run:command:12"},
+		{name: "Other command", token: token, scope: "run:command:13", expectedError: projectErrors.ErrForbidden},
+		{name: "Command with same prefix", token: token, scope: "run:command:123", expectedError: projectErrors.ErrForbidden},
+		{name: "Wildcard", token: token, scope: entities.ScopeFilesWrite},
+		{name: "Not granted", token: token, scope: entities.ScopeCommandsWrite, expectedError: projectErrors.ErrForbidden},
+		{name: "Run all", token: &entities.ApiToken{Scopes: []string{entities.ScopeRunAll}}, scope: "run:command:5"},
+		{name: "Everything", token: &entities.ApiToken{Scopes: []string{entities.ScopeAll}}, scope: entities.ScopeAdmin},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := service.CheckScope(tc.token, tc.scope)
+			if !errors.Is(err, tc.expectedError) {
+				t.Errorf("Expected error %v, got %v", tc.expectedError, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	"time"
+)
+
+type UsersRepository interface {
+	AppendUser(user *entities.User) error
+	UpdateUser(user *entities.User) error
+	DeleteUser(id uint) error
+	GetUsers() ([]entities.User, error)
+	GetUser(id uint) (*entities.User, error)
+	GetUserByUsername(username string) (*entities.User, error)
+	CountUsers() (int64, error)
+	CountUsersWithRole(role entities.UserRole) (int64, error)
+}
+
+type LoginSessionsRepository interface {
+	AppendLoginSession(session *entities.LoginSession) error
+	GetLoginSession(tokenHash string) (*entities.LoginSession, error)
+	DeleteLoginSession(tokenHash string) error
+	DeleteUserLoginSessions(userId uint) error
+	DeleteExpiredLoginSessions(now time.Time) error
+}
+
+type ApiTokensRepository interface {
+	AppendApiToken(token *entities.ApiToken) error
+	GetApiToken(tokenHash string) (*entities.ApiToken, error)
+	GetUserApiTokens(userId uint) ([]entities.ApiToken, error)
+	SetApiTokenLastUsed(id uint, lastUsedAt time.Time) error
+	DeleteApiToken(userId, id uint) error
+}
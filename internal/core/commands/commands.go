@@ -2,62 +2,167 @@ package commands
 
 import (
 	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
 	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/utils"
 )
 
 type Service struct {
 	commandsRepository   CommandsRepository
 	defaultCommandRunDir string
+	interpreterChecker   InterpreterChecker
+	access               Access
 }
 
-func NewService(commandsRepository CommandsRepository, defaultCommandRunDir string) *Service {
+// NewService methods take user, that does action, nil user is server itself
+func NewService(commandsRepository CommandsRepository, defaultCommandRunDir string, interpreterChecker InterpreterChecker, access Access) *Service {
 	return &Service{
 		commandsRepository:   commandsRepository,
 		defaultCommandRunDir: defaultCommandRunDir,
+		interpreterChecker:   interpreterChecker,
+		access:               access,
 	}
 }
 
+// checkInterpreter validate interpreter of command and check that it is installed
+func (s Service) checkInterpreter(command *entities.Command) error {
+	interpreter, err := utils.CommandInterpreter(command)
+	if err != nil || interpreter == nil {
+		return err
+	}
+	return s.interpreterChecker.CheckExecutable(interpreter.Argv[0])
+}
+
 func (s Service) DefaultCommand() *entities.Command {
 	return &entities.Command{
 		Dir: s.defaultCommandRunDir,
 	}
 }
 
-func (s Service) AppendCommand(command *entities.Command) error {
+// AppendCommand create command, editor role is needed
+func (s Service) AppendCommand(user *entities.User, command *entities.Command) error {
+	if err := s.access.CheckRole(user, entities.RoleEditor); err != nil {
+		return err
+	}
 	utils.SetDefaultCommandsName(command)
 	if err := utils.CheckName(command.Name); err != nil {
 		return err
 	}
+	if err := utils.CheckParameters(command.Parameters); err != nil {
+		return err
+	}
+	if err := utils.CheckEnv(command.Env); err != nil {
+		return err
+	}
+	if err := utils.CheckEnvNames(command.Secrets); err != nil {
+		return err
+	}
+	if err := s.checkInterpreter(command); err != nil {
+		return err
+	}
+	if err := utils.CheckExecutionMode(command.ExecutionMode); err != nil {
+		return err
+	}
 	return s.commandsRepository.AppendCommand(command)
 }
 
-func (s Service) DeleteCommand(commandId uint) error {
+func (s Service) DeleteCommand(user *entities.User, commandId uint) error {
+	if err := s.access.CheckCommand(user, commandId, entities.AccessEdit); err != nil {
+		return err
+	}
 	return s.commandsRepository.DeleteCommand(commandId)
 }
 
-func (s Service) PatchCommand(commandId uint, newCommand *entities.Command) error {
+func (s Service) PatchCommand(user *entities.User, commandId uint, newCommand *entities.Command) error {
+	if err := s.access.CheckCommand(user, commandId, entities.AccessEdit); err != nil {
+		return err
+	}
 	if newCommand.Name != "" {
 		if err := utils.CheckName(newCommand.Name); err != nil {
 			return err
 		}
 	}
+	if err := utils.CheckParameters(newCommand.Parameters); err != nil {
+		return err
+	}
+	if err := utils.CheckEnv(newCommand.Env); err != nil {
+		return err
+	}
+	if err := utils.CheckEnvNames(newCommand.Secrets); err != nil {
+		return err
+	}
+	// Interpreter and parameters depend on each other, so they are checked on command with patch applied
+	patched, err := s.commandsRepository.GetCommand(commandId)
+	if err != nil {
+		return err
+	}
+	if newCommand.Parameters != nil {
+		patched.Parameters = newCommand.Parameters
+	}
+	if newCommand.Interpreter != nil {
+		patched.Interpreter = newCommand.Interpreter
+		err = s.checkInterpreter(patched)
+	} else {
+		// Installation of stored interpreter is checked only on run, like for imported config
+		_, err = utils.CommandInterpreter(patched)
+	}
+	if err != nil {
+		return err
+	}
+	if err := utils.CheckExecutionMode(newCommand.ExecutionMode); err != nil {
+		return err
+	}
 	return s.commandsRepository.PatchCommand(commandId, newCommand)
 }
 
-func (s Service) PutCommand(commandId uint, newCommand *entities.Command) error {
+func (s Service) PutCommand(user *entities.User, commandId uint, newCommand *entities.Command) error {
+	if err := s.access.CheckCommand(user, commandId, entities.AccessEdit); err != nil {
+		return err
+	}
 	utils.SetDefaultCommandsName(newCommand)
 	if err := utils.CheckName(newCommand.Name); err != nil {
 		return err
 	}
+	if err := utils.CheckParameters(newCommand.Parameters); err != nil {
+		return err
+	}
+	if err := utils.CheckEnv(newCommand.Env); err != nil {
+		return err
+	}
+	if err := utils.CheckEnvNames(newCommand.Secrets); err != nil {
+		return err
+	}
+	if err := s.checkInterpreter(newCommand); err != nil {
+		return err
+	}
+	if err := utils.CheckExecutionMode(newCommand.ExecutionMode); err != nil {
+		return err
+	}
 	return s.commandsRepository.PutCommand(commandId, newCommand)
 }
 
-func (s Service) GetCommandsList() ([]entities.Command, error) {
-	return s.commandsRepository.GetCommands()
+// GetCommandsList return commands, that user can view, with its access level
+func (s Service) GetCommandsList(user *entities.User) ([]entities.Command, error) {
+	commands, err := s.commandsRepository.GetCommands()
+	if err != nil {
+		return nil, err
+	}
+	return s.access.VisibleCommands(user, commands)
 }
 
-func (s Service) GetCommand(commandId uint) (*entities.Command, error) {
-	return s.commandsRepository.GetCommand(commandId)
+// GetCommand return command with access level of user, ErrForbidden if user cant view it
+func (s Service) GetCommand(user *entities.User, commandId uint) (*entities.Command, error) {
+	command, err := s.commandsRepository.GetCommand(commandId)
+	if err != nil {
+		return nil, err
+	}
+	visible, err := s.access.VisibleCommands(user, []entities.Command{*command})
+	if err != nil {
+		return nil, err
+	}
+	if len(visible) == 0 {
+		return nil, projectErrors.ErrForbidden
+	}
+	return &visible[0], nil
 }
 
 func (s Service) CommandExists(commandId uint) (bool, error) {
@@ -1,8 +1,10 @@
 package commands
 
 import (
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/adapters/console/checker"
 	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/adapters/storage/database"
 	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/adapters/storage/filesystem"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/core/access"
 	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/core/userconfig"
 	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/utils"
 	"github.com/gofiber/fiber/v2/log"
@@ -102,7 +104,7 @@ func TestAppendCommand(t *testing.T) {
 			if err != nil {
 				t.Fatalf("Cant set connect filesystem: %v", err)
 			}
-			commandsService := NewService(db, commandRunDir)
+			commandsService := NewService(db, commandRunDir, checker.New("../../../pty"), access.NewService(db, db, db))
 			userConfigService := userconfig.NewService(db, db, filesystemAdapter, utils.DetectDefaultConsole())
 
 			err = userConfigService.SetUserConfig(&tc.initialConfig)
@@ -110,7 +112,7 @@ func TestAppendCommand(t *testing.T) {
 				t.Fatalf("Cant set initial config: %v", err)
 			}
 
-			err = commandsService.AppendCommand(&tc.commandToAdd)
+			err = commandsService.AppendCommand(nil, &tc.commandToAdd)
 			if tc.expectError && err == nil {
 				t.Fatalf("Expected error but got none")
 			}
@@ -124,7 +126,7 @@ func TestAppendCommand(t *testing.T) {
 
 			if !tc.expectError {
 				if !reflect.DeepEqual(resultConfig, tc.expectedConfig) {
-					t.Fatalf("Expected config: %q, got: %q", tc.expectedConfig, resultConfig)
+					t.Fatalf("Expected config: %+v, got: %+v", tc.expectedConfig, resultConfig)
 				}
 			}
 		})
@@ -234,7 +236,7 @@ func TestDeleteCommand(t *testing.T) {
 			if err != nil {
 				t.Fatalf("Cant set connect filesystem: %v", err)
 			}
-			commandsService := NewService(db, commandRunDir)
+			commandsService := NewService(db, commandRunDir, checker.New("../../../pty"), access.NewService(db, db, db))
 			userConfigService := userconfig.NewService(db, db, filesystemAdapter, utils.DetectDefaultConsole())
 
 			err = userConfigService.SetUserConfig(&tc.initialConfig)
@@ -242,7 +244,7 @@ func TestDeleteCommand(t *testing.T) {
 				t.Fatalf("Cant set initial config: %v", err)
 			}
 
-			err = commandsService.DeleteCommand(tc.deleteId)
+			err = commandsService.DeleteCommand(nil, tc.deleteId)
 			if tc.expectError && err == nil {
 				t.Fatalf("Expected error but got none")
 			}
@@ -316,14 +318,14 @@ func TestGetCommandsList(t *testing.T) {
 			if err != nil {
 				t.Fatalf("Cant set connect filesystem: %v", err)
 			}
-			commandsService := NewService(db, commandRunDir)
+			commandsService := NewService(db, commandRunDir, checker.New("../../../pty"), access.NewService(db, db, db))
 			userConfigService := userconfig.NewService(db, db, filesystemAdapter, utils.DetectDefaultConsole())
 
 			err = userConfigService.SetUserConfig(&tc.initialConfig)
 			if err != nil {
 				t.Fatalf("Cant set initial config: %v", err)
 			}
-			result, err := commandsService.GetCommandsList()
+			result, err := commandsService.GetCommandsList(nil)
 			if err != nil {
 				t.Fatalf("Unexpected error: %v", err)
 			}
@@ -417,7 +419,7 @@ func TestGetCommand(t *testing.T) {
 			if err != nil {
 				t.Fatalf("Cant set connect filesystem: %v", err)
 			}
-			commandsService := NewService(db, commandRunDir)
+			commandsService := NewService(db, commandRunDir, checker.New("../../../pty"), access.NewService(db, db, db))
 			userConfigService := userconfig.NewService(db, db, filesystemAdapter, utils.DetectDefaultConsole())
 
 			err = userConfigService.SetUserConfig(&tc.initialConfig)
@@ -425,7 +427,7 @@ func TestGetCommand(t *testing.T) {
 				t.Fatalf("Cant set initial config: %v", err)
 			}
 
-			result, err := commandsService.GetCommand(tc.commandId)
+			result, err := commandsService.GetCommand(nil, tc.commandId)
 			if tc.expectError && err == nil {
 				t.Fatalf("Expected error but got none")
 			}
@@ -547,7 +549,7 @@ func TestPutCommand(t *testing.T) {
 			if err != nil {
 				t.Fatalf("Cant set connect filesystem: %v", err)
 			}
-			commandsService := NewService(db, commandRunDir)
+			commandsService := NewService(db, commandRunDir, checker.New("../../../pty"), access.NewService(db, db, db))
 			userConfigService := userconfig.NewService(db, db, filesystemAdapter, utils.DetectDefaultConsole())
 
 			err = userConfigService.SetUserConfig(&tc.initialConfig)
@@ -555,7 +557,7 @@ func TestPutCommand(t *testing.T) {
 				t.Fatalf("Cant set initial config: %v", err)
 			}
 
-			err = commandsService.PutCommand(tc.commandId, &tc.newCommand)
+			err = commandsService.PutCommand(nil, tc.commandId, &tc.newCommand)
 			if tc.expectError && err == nil {
 				t.Fatalf("Expected error but got none")
 			}
@@ -718,6 +720,123 @@ func TestPatchCommand(t *testing.T) {
 			},
 			expectError: false,
 		},
+		{
+			name: "Set interpreter",
+			initialConfig: entities.UserConfig{
+				UsingConsole: "test",
+				Commands: []entities.Command{
+					{Name: "First", Command: "echo first"},
+				},
+			},
+			commandId:  1,
+			newCommand: entities.Command{Interpreter: []string{console}},
+			expectedConfig: &entities.UserConfig{
+				UsingConsole: console,
+				Commands: []entities.Command{
+					{ID: 1, Name: "First", Command: "echo first", Interpreter: []string{console}},
+				},
+			},
+			expectError: false,
+		},
+		{
+			name: "Unknown interpreter",
+			initialConfig: entities.UserConfig{
+				UsingConsole: "test",
+				Commands: []entities.Command{
+					{Name: "First", Command: "echo first"},
+				},
+			},
+			commandId:  1,
+			newCommand: entities.Command{Interpreter: []string{"unknown"}},
+			expectedConfig: &entities.UserConfig{
+				UsingConsole: console,
+				Commands: []entities.Command{
+					{ID: 1, Name: "First", Command: "echo first"},
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Interpreter not installed",
+			initialConfig: entities.UserConfig{
+				UsingConsole: "test",
+				Commands: []entities.Command{
+					{Name: "First", Command: "echo first"},
+				},
+			},
+			commandId:  1,
+			newCommand: entities.Command{Interpreter: []string{"not-installed-interpreter", "-e", "{{command}}"}},
+			expectedConfig: &entities.UserConfig{
+				UsingConsole: console,
+				Commands: []entities.Command{
+					{ID: 1, Name: "First", Command: "echo first"},
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Custom interpreter without command",
+			initialConfig: entities.UserConfig{
+				UsingConsole: "test",
+				Commands: []entities.Command{
+					{Name: "First", Command: "echo first"},
+				},
+			},
+			commandId:  1,
+			newCommand: entities.Command{Interpreter: []string{console, "-c"}},
+			expectedConfig: &entities.UserConfig{
+				UsingConsole: console,
+				Commands: []entities.Command{
+					{ID: 1, Name: "First", Command: "echo first"},
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Custom interpreter with parameters",
+			initialConfig: entities.UserConfig{
+				UsingConsole: "test",
+				Commands: []entities.Command{
+					{Name: "First", Command: "echo first"},
+				},
+			},
+			commandId: 1,
+			newCommand: entities.Command{
+				Interpreter: []string{console, "-c", "{{command}}"},
+				Parameters:  []entities.CommandParameter{{Name: "name"}},
+			},
+			expectedConfig: &entities.UserConfig{
+				UsingConsole: console,
+				Commands: []entities.Command{
+					{ID: 1, Name: "First", Command: "echo first"},
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "Parameters for stored custom interpreter",
+			initialConfig: entities.UserConfig{
+				UsingConsole: "test",
+				Commands: []entities.Command{
+					{Name: "First", Command: "echo first", Interpreter: []string{console, "-c", "{{command}}"}},
+				},
+			},
+			commandId:   1,
+			newCommand:  entities.Command{Parameters: []entities.CommandParameter{{Name: "name"}}},
+			expectError: true,
+		},
+		{
+			name: "Custom interpreter for stored parameters",
+			initialConfig: entities.UserConfig{
+				UsingConsole: "test",
+				Commands: []entities.Command{
+					{Name: "First", Command: "echo {{name}}", Parameters: []entities.CommandParameter{{Name: "name"}}},
+				},
+			},
+			commandId:   1,
+			newCommand:  entities.Command{Interpreter: []string{console, "-c", "{{command}}"}},
+			expectError: true,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -742,7 +861,7 @@ func TestPatchCommand(t *testing.T) {
 			if err != nil {
 				t.Fatalf("Cant set connect filesystem: %v", err)
 			}
-			commandsService := NewService(db, commandRunDir)
+			commandsService := NewService(db, commandRunDir, checker.New("../../../pty"), access.NewService(db, db, db))
 			userConfigService := userconfig.NewService(db, db, filesystemAdapter, utils.DetectDefaultConsole())
 
 			err = userConfigService.SetUserConfig(&tc.initialConfig)
@@ -750,7 +869,7 @@ func TestPatchCommand(t *testing.T) {
 				t.Fatalf("Cant set initial config: %v", err)
 			}
 
-			err = commandsService.PatchCommand(tc.commandId, &tc.newCommand)
+			err = commandsService.PatchCommand(nil, tc.commandId, &tc.newCommand)
 			if tc.expectError && err == nil {
 				t.Fatalf("Expected error but got none")
 			}
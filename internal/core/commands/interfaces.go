@@ -12,3 +12,13 @@ type CommandsRepository interface {
 	PatchCommand(id uint, new *entities.Command) error
 	CommandExists(id uint) (bool, error)
 }
+
+type InterpreterChecker interface {
+	CheckExecutable(name string) error
+}
+
+type Access interface {
+	CheckRole(user *entities.User, role entities.UserRole) error
+	CheckCommand(user *entities.User, commandId uint, level entities.AccessLevel) error
+	VisibleCommands(user *entities.User, commands []entities.Command) ([]entities.Command, error)
+}
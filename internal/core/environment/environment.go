@@ -0,0 +1,89 @@
+package environment
+
+import (
+	"fmt"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/utils"
+	"github.com/joho/godotenv"
+	"maps"
+	"path/filepath"
+	"slices"
+)
+
+type Service struct {
+	globalEnvFile string
+	envRepository EnvRepository
+}
+
+// NewService globalEnvFile is .env file loaded for every command, empty for none
+func NewService(globalEnvFile string, envRepository EnvRepository) *Service {
+	return &Service{
+		globalEnvFile: globalEnvFile,
+		envRepository: envRepository,
+	}
+}
+
+func (s Service) GetGlobalEnv() (map[string]string, error) {
+	variables, err := s.envRepository.GetEnvVariables()
+	if err != nil {
+		return nil, err
+	}
+	env := make(map[string]string, len(variables))
+	for _, variable := range variables {
+		env[variable.Name] = variable.Value
+	}
+	return env, nil
+}
+
+func (s Service) SetGlobalEnv(env map[string]string) error {
+	if err := utils.CheckEnv(env); err != nil {
+		return err
+	}
+	variables := make([]entities.EnvVariable, 0, len(env))
+	for _, name := range slices.Sorted(maps.Keys(env)) {
+		variables = append(variables, entities.EnvVariable{Name: name, Value: env[name]})
+	}
+	return s.envRepository.SetEnvVariables(variables)
+}
+
+// CommandEnv return variables, that must be added to process environment of command, as NAME=value list.
+// Later sources override earlier: global env file, global variables, command env file, command variables.
+func (s Service) CommandEnv(command *entities.Command, dir string) ([]string, error) {
+	env := make(map[string]string)
+	if s.globalEnvFile != "" {
+		if err := loadEnvFile(env, s.globalEnvFile); err != nil {
+			return nil, err
+		}
+	}
+	globalEnv, err := s.GetGlobalEnv()
+	if err != nil {
+		return nil, err
+	}
+	maps.Copy(env, globalEnv)
+	if command.EnvFile != "" {
+		envFile := command.EnvFile
+		if !filepath.IsAbs(envFile) {
+			envFile = filepath.Join(dir, envFile)
+		}
+		if err := loadEnvFile(env, envFile); err != nil {
+			return nil, err
+		}
+	}
+	maps.Copy(env, command.Env)
+
+	result := make([]string, 0, len(env))
+	for _, name := range slices.Sorted(maps.Keys(env)) {
+		result = append(result, name+"="+env[name])
+	}
+	return result, nil
+}
+
+func loadEnvFile(env map[string]string, path string) error {
+	fileEnv, err := godotenv.Read(path)
+	if err != nil {
+		return fmt.Errorf("%w %s: %w", projectErrors.ErrEnvFile, path, err)
+	}
+	maps.Copy(env, fileEnv)
+	return nil
+}
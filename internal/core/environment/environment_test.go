@@ -0,0 +1,119 @@
+package environment
+
+import (
+	"errors"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/adapters/storage/database"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/testutils"
+	"github.com/gofiber/fiber/v2/log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCommandEnv(t *testing.T) {
+	log.SetLevel(0)
+	testCases := []struct {
+		name          string
+		globalEnvFile string
+		globalEnv     map[string]string
+		command       entities.Command
+		files         map[string]string
+		expected      []string
+		expectedError error
+	}{
+		{
+			name:     "Empty",
+			command:  entities.Command{},
+			expected: []string{},
+		},
+		{
+			name:      "Command overrides global",
+			globalEnv: map[string]string{"A": "global", "B": "global"},
+			command:   entities.Command{Env: map[string]string{"B": "command", "C": "command"}},
+			expected:  []string{"A=global", "B=command", "C=command"},
+		},
+		{
+			name:          "Env files order",
+			globalEnvFile: "global.env",
+			globalEnv:     map[string]string{"B": "global"},
+			command:       entities.Command{EnvFile: "command.env", Env: map[string]string{"D": "command"}},
+			files: map[string]string{
+				"global.env":  "A=global-file\nB=global-file\nC=global-file\n",
+				"command.env": "# comment\nC=command-file\nD=command-file\n",
+			},
+			expected: []string{"A=global-file", "B=global", "C=command-file", "D=command"},
+		},
+		{
+			name:          "Missing env file",
+			command:       entities.Command{EnvFile: "missing.env"},
+			expectedError: projectErrors.ErrEnvFile,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpDir, cleanup := testutils.CreateTempDataFolder(t)
+			defer cleanup()
+			db, err := database.Connect(tmpDir)
+			if err != nil {
+				t.Fatalf("Cant create db: %v", err)
+			}
+			defer func() {
+				if err := db.Close(); err != nil {
+					t.Errorf("Cant close db: %v", err)
+				}
+			}()
+			for name, content := range tc.files {
+				if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0600); err != nil {
+					t.Fatalf("Cant write env file: %v", err)
+				}
+			}
+			globalEnvFile := ""
+			if tc.globalEnvFile != "" {
+				globalEnvFile = filepath.Join(tmpDir, tc.globalEnvFile)
+			}
+			service := NewService(globalEnvFile, db)
+			if err := service.SetGlobalEnv(tc.globalEnv); err != nil {
+				t.Fatalf("Cant set global env: %v", err)
+			}
+
+			env, err := service.CommandEnv(&tc.command, tmpDir)
+			if tc.expectedError != nil {
+				if !errors.Is(err, tc.expectedError) {
+					t.Fatalf("Expected error %v, got %v", tc.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(env, tc.expected) {
+				t.Errorf("Expected %v, got %v", tc.expected, env)
+			}
+		})
+	}
+}
+
+func TestSetGlobalEnv_BadName(t *testing.T) {
+	log.SetLevel(0)
+	tmpDir, cleanup := testutils.CreateTempDataFolder(t)
+	defer cleanup()
+	db, err := database.Connect(tmpDir)
+	if err != nil {
+		t.Fatalf("Cant create db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("Cant close db: %v", err)
+		}
+	}()
+	service := NewService("", db)
+	for _, name := range []string{"", "A=B"} {
+		if err := service.SetGlobalEnv(map[string]string{name: "value"}); !errors.Is(err, projectErrors.ErrBadEnvVariable) {
+			t.Errorf("Expected ErrBadEnvVariable for %q, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+package environment
+
+import (
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+)
+
+type EnvRepository interface {
+	GetEnvVariables() ([]entities.EnvVariable, error)
+	SetEnvVariables(variables []entities.EnvVariable) error
+}
@@ -19,15 +19,19 @@ type Service struct {
 	commandsRepository CommandsRepository
 	filesRepository    FilesRepository
 	filesystem         Filesystem
+	access             Access
 }
 
-func NewService(filesDirPath string, maxFilesSize int64, commandsRepository CommandsRepository, filesRepository FilesRepository, filesystem Filesystem) *Service {
+// NewService methods take user, that does action, nil user is server itself.
+// Files of command are managed with edit access to it, files of all commands only by admin
+func NewService(filesDirPath string, maxFilesSize int64, commandsRepository CommandsRepository, filesRepository FilesRepository, filesystem Filesystem, access Access) *Service {
 	return &Service{
 		filesDirPath:       filesDirPath,
 		maxFileSize:        maxFilesSize,
 		commandsRepository: commandsRepository,
 		filesRepository:    filesRepository,
 		filesystem:         filesystem,
+		access:             access,
 	}
 }
 
@@ -41,32 +45,38 @@ func (s Service) validateFile(data *entities.FileParams) error {
 	return nil
 }
 
-func (s Service) AppendFile(commandID uint, fileBytes []byte, data *entities.FileParams) error {
+func (s Service) AppendFile(user *entities.User, commandID uint, fileBytes []byte, data *entities.FileParams) (*entities.EmbeddedFile, error) {
+	if err := s.access.CheckCommand(user, commandID, entities.AccessEdit); err != nil {
+		return nil, err
+	}
 	exists, err := s.commandsRepository.CommandExists(commandID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if !exists {
-		return projectErrors.ErrFileToBig
+		return nil, projectErrors.ErrFileToBig
 	}
 
 	if err := s.validateFile(data); err != nil {
-		return err
+		return nil, err
 	}
 	embeddedFile := entities.EmbeddedFile{
 		CommandID: commandID,
 		Name:      data.Filename,
 	}
 	if err := s.filesRepository.AppendFile(&embeddedFile); err != nil {
-		return err
+		return nil, err
 	}
 	if err := s.filesystem.SaveFile(embeddedFile.ID, fileBytes); err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	return &embeddedFile, nil
 }
 
-func (s Service) DeleteFile(commandId, fileId uint) error {
+func (s Service) DeleteFile(user *entities.User, commandId, fileId uint) error {
+	if err := s.access.CheckCommand(user, commandId, entities.AccessEdit); err != nil {
+		return err
+	}
 	err := s.filesRepository.DeleteFile(commandId, fileId)
 	if err != nil {
 		return err
@@ -78,7 +88,10 @@ func (s Service) DeleteFile(commandId, fileId uint) error {
 	return nil
 }
 
-func (s Service) PatchFile(commandId, fileId uint, newFile *entities.EmbeddedFile) error {
+func (s Service) PatchFile(user *entities.User, commandId, fileId uint, newFile *entities.EmbeddedFile) error {
+	if err := s.access.CheckCommand(user, commandId, entities.AccessEdit); err != nil {
+		return err
+	}
 	if newFile.Name != "" {
 		if err := utils.CheckName(newFile.Name); err != nil {
 			return err
@@ -91,7 +104,10 @@ func (s Service) PatchFile(commandId, fileId uint, newFile *entities.EmbeddedFil
 	return nil
 }
 
-func (s Service) PutFile(commandId, fileId uint, newFile *entities.EmbeddedFile) error {
+func (s Service) PutFile(user *entities.User, commandId, fileId uint, newFile *entities.EmbeddedFile) error {
+	if err := s.access.CheckCommand(user, commandId, entities.AccessEdit); err != nil {
+		return err
+	}
 	if err := utils.CheckName(newFile.Name); err != nil {
 		return err
 	}
@@ -103,7 +119,10 @@ func (s Service) PutFile(commandId, fileId uint, newFile *entities.EmbeddedFile)
 	return nil
 }
 
-func (s Service) GetFile(commandId, fileId uint) (*entities.EmbeddedFile, error) {
+func (s Service) GetFile(user *entities.User, commandId, fileId uint) (*entities.EmbeddedFile, error) {
+	if err := s.access.CheckCommand(user, commandId, entities.AccessView); err != nil {
+		return nil, err
+	}
 	file, err := s.filesRepository.GetFile(commandId, fileId)
 	if err != nil {
 		return nil, err
@@ -111,7 +130,10 @@ func (s Service) GetFile(commandId, fileId uint) (*entities.EmbeddedFile, error)
 	return file, nil
 }
 
-func (s Service) GetCommandFiles(commandId uint) ([]entities.EmbeddedFile, error) {
+func (s Service) GetCommandFiles(user *entities.User, commandId uint) ([]entities.EmbeddedFile, error) {
+	if err := s.access.CheckCommand(user, commandId, entities.AccessView); err != nil {
+		return nil, err
+	}
 	exists, err := s.commandsRepository.CommandExists(commandId)
 	if err != nil {
 		return nil, fmt.Errorf("cant check command exist: %w", err)
@@ -122,12 +144,15 @@ func (s Service) GetCommandFiles(commandId uint) ([]entities.EmbeddedFile, error
 	return s.filesRepository.GetCommandFiles(commandId)
 }
 
-func (s Service) GetAllFilesList() ([]entities.EmbeddedFile, error) {
+func (s Service) GetAllFilesList(user *entities.User) ([]entities.EmbeddedFile, error) {
+	if err := s.access.CheckRole(user, entities.RoleAdmin); err != nil {
+		return nil, err
+	}
 	return s.filesRepository.GetAllFiles()
 }
 
-func (s Service) DownloadFile(commandId, fileId uint) (*entities.EmbeddedFile, []byte, error) {
-	fileData, err := s.GetFile(commandId, fileId)
+func (s Service) DownloadFile(user *entities.User, commandId, fileId uint) (*entities.EmbeddedFile, []byte, error) {
+	fileData, err := s.GetFile(user, commandId, fileId)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -138,7 +163,10 @@ func (s Service) DownloadFile(commandId, fileId uint) (*entities.EmbeddedFile, [
 	return fileData, data, err
 }
 
-func (s Service) DownloadCommandFilesInArchive(commandId uint) ([]byte, error) {
+func (s Service) DownloadCommandFilesInArchive(user *entities.User, commandId uint) ([]byte, error) {
+	if err := s.access.CheckCommand(user, commandId, entities.AccessView); err != nil {
+		return nil, err
+	}
 	filesDatas, err := s.filesRepository.GetCommandFiles(commandId)
 	if err != nil {
 		return nil, err
@@ -199,7 +227,10 @@ func (s Service) DownloadCommandFilesInArchive(commandId uint) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-func (s Service) DownloadAllFilesInArchive() ([]byte, error) {
+func (s Service) DownloadAllFilesInArchive(user *entities.User) ([]byte, error) {
+	if err := s.access.CheckRole(user, entities.RoleAdmin); err != nil {
+		return nil, err
+	}
 	filesDatas, err := s.filesRepository.GetAllFilesWithCommandInfo()
 	if err != nil {
 		return nil, err
@@ -270,7 +301,10 @@ func (s Service) clearFiles() error {
 	return s.filesystem.ClearFiles()
 }
 
-func (s Service) ImportAllFilesFromZipArchive(data []byte) error {
+func (s Service) ImportAllFilesFromZipArchive(user *entities.User, data []byte) error {
+	if err := s.access.CheckRole(user, entities.RoleAdmin); err != nil {
+		return err
+	}
 	if err := s.clearFiles(); err != nil {
 		return err
 	}
@@ -279,7 +313,7 @@ func (s Service) ImportAllFilesFromZipArchive(data []byte) error {
 		return err
 	}
 	for _, file := range filesToAppend {
-		err = s.AppendFile(file.CommandId, file.Bytes, &entities.FileParams{Filename: file.Params.Filename, Size: file.Params.Size})
+		_, err = s.AppendFile(nil, file.CommandId, file.Bytes, &entities.FileParams{Filename: file.Params.Filename, Size: file.Params.Size})
 		if err != nil {
 			return err
 		}
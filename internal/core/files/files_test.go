@@ -3,6 +3,7 @@ package files
 import (
 	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/adapters/storage/database"
 	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/adapters/storage/filesystem"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/core/access"
 	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/core/userconfig"
 	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/utils"
 	"github.com/gofiber/fiber/v2/log"
@@ -142,7 +143,7 @@ func TestValidateFile(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Cant set connect filesystem: %v", err)
 	}
-	filesService := NewService(filesDir, 1024, db, db, filesystemAdapter)
+	filesService := NewService(filesDir, 1024, db, db, filesystemAdapter, access.NewService(db, db, db))
 
 	testCases := []struct {
 		name        string
@@ -285,7 +286,7 @@ func TestAppendFile(t *testing.T) {
 			if err != nil {
 				t.Fatalf("Cant set connect filesystem: %v", err)
 			}
-			filesService := NewService(filesDir, 1024, db, db, filesystemAdapter)
+			filesService := NewService(filesDir, 1024, db, db, filesystemAdapter, access.NewService(db, db, db))
 			userConfigService := userconfig.NewService(db, db, filesystemAdapter, utils.DetectDefaultConsole())
 
 			err = userConfigService.SetUserConfig(&tc.initialConfig)
@@ -293,7 +294,7 @@ func TestAppendFile(t *testing.T) {
 				t.Fatalf("Cant set initial config: %v", err)
 			}
 
-			err = filesService.AppendFile(tc.commandID, []byte(tc.fileContent), &tc.fileData)
+			appendedFile, err := filesService.AppendFile(nil, tc.commandID, []byte(tc.fileContent), &tc.fileData)
 			if tc.expectError && err == nil {
 				t.Fatalf("Expected error but got none")
 			}
@@ -303,7 +304,7 @@ func TestAppendFile(t *testing.T) {
 
 			if !tc.expectError {
 				// Check that file was added to database
-				files, err := filesService.GetCommandFiles(tc.commandID)
+				files, err := filesService.GetCommandFiles(nil, tc.commandID)
 				if err != nil {
 					t.Fatalf("Cant get command files: %v", err)
 				}
@@ -313,6 +314,9 @@ func TestAppendFile(t *testing.T) {
 				if files[0].Name != tc.fileData.Filename {
 					t.Errorf("Expected filename %s, got %s", tc.fileData.Filename, files[0].Name)
 				}
+				if appendedFile.ID != files[0].ID || appendedFile.CommandID != tc.commandID {
+					t.Errorf("Expected appended file %v, got %v", files[0], appendedFile)
+				}
 			}
 		})
 	}
@@ -375,7 +379,7 @@ func TestDeleteFile(t *testing.T) {
 			if err != nil {
 				t.Fatalf("Cant set connect filesystem: %v", err)
 			}
-			filesService := NewService(filesDir, 1024, db, db, filesystemAdapter)
+			filesService := NewService(filesDir, 1024, db, db, filesystemAdapter, access.NewService(db, db, db))
 			userConfigService := userconfig.NewService(db, db, filesystemAdapter, utils.DetectDefaultConsole())
 
 			err = userConfigService.SetUserConfig(&tc.initialConfig)
@@ -385,13 +389,13 @@ func TestDeleteFile(t *testing.T) {
 
 			// Add a test file first
 			if !tc.expectError {
-				err = filesService.AppendFile(tc.commandID, []byte("test content"), &entities.FileParams{Filename: "test.txt", Size: 12})
+				_, err = filesService.AppendFile(nil, tc.commandID, []byte("test content"), &entities.FileParams{Filename: "test.txt", Size: 12})
 				if err != nil {
 					t.Fatalf("Cant append test file: %v", err)
 				}
 			}
 
-			err = filesService.DeleteFile(tc.commandID, tc.fileID)
+			err = filesService.DeleteFile(nil, tc.commandID, tc.fileID)
 			if tc.expectError && err == nil {
 				t.Fatalf("Expected error but got none")
 			}
@@ -462,7 +466,7 @@ func TestPatchFile(t *testing.T) {
 			if err != nil {
 				t.Fatalf("Cant set connect filesystem: %v", err)
 			}
-			filesService := NewService(filesDir, 1024, db, db, filesystemAdapter)
+			filesService := NewService(filesDir, 1024, db, db, filesystemAdapter, access.NewService(db, db, db))
 			userConfigService := userconfig.NewService(db, db, filesystemAdapter, utils.DetectDefaultConsole())
 
 			err = userConfigService.SetUserConfig(&tc.initialConfig)
@@ -472,13 +476,13 @@ func TestPatchFile(t *testing.T) {
 
 			// Add a test file first
 			if !tc.expectError {
-				err = filesService.AppendFile(tc.commandID, []byte("test content"), &entities.FileParams{Filename: "test.txt", Size: 12})
+				_, err = filesService.AppendFile(nil, tc.commandID, []byte("test content"), &entities.FileParams{Filename: "test.txt", Size: 12})
 				if err != nil {
 					t.Fatalf("Cant append test file: %v", err)
 				}
 			}
 
-			err = filesService.PatchFile(tc.commandID, tc.fileID, &tc.newFile)
+			err = filesService.PatchFile(nil, tc.commandID, tc.fileID, &tc.newFile)
 			if tc.expectError && err == nil {
 				t.Fatalf("Expected error but got none")
 			}
@@ -562,7 +566,7 @@ func TestPutFile(t *testing.T) {
 			if err != nil {
 				t.Fatalf("Cant set connect filesystem: %v", err)
 			}
-			filesService := NewService(filesDir, 1024, db, db, filesystemAdapter)
+			filesService := NewService(filesDir, 1024, db, db, filesystemAdapter, access.NewService(db, db, db))
 			userConfigService := userconfig.NewService(db, db, filesystemAdapter, utils.DetectDefaultConsole())
 
 			err = userConfigService.SetUserConfig(&tc.initialConfig)
@@ -572,13 +576,13 @@ func TestPutFile(t *testing.T) {
 
 			// Add a test file first
 			if !tc.expectError {
-				err = filesService.AppendFile(tc.commandID, []byte("test content"), &entities.FileParams{Filename: "test.txt", Size: 12})
+				_, err = filesService.AppendFile(nil, tc.commandID, []byte("test content"), &entities.FileParams{Filename: "test.txt", Size: 12})
 				if err != nil {
 					t.Fatalf("Cant append test file: %v", err)
 				}
 			}
 
-			err = filesService.PutFile(tc.commandID, tc.fileID, &tc.newFile)
+			err = filesService.PutFile(nil, tc.commandID, tc.fileID, &tc.newFile)
 			if tc.expectError && err == nil {
 				t.Fatalf("Expected error but got none")
 			}
@@ -646,7 +650,7 @@ func TestGetFile(t *testing.T) {
 			if err != nil {
 				t.Fatalf("Cant set connect filesystem: %v", err)
 			}
-			filesService := NewService(filesDir, 1024, db, db, filesystemAdapter)
+			filesService := NewService(filesDir, 1024, db, db, filesystemAdapter, access.NewService(db, db, db))
 			userConfigService := userconfig.NewService(db, db, filesystemAdapter, utils.DetectDefaultConsole())
 
 			err = userConfigService.SetUserConfig(&tc.initialConfig)
@@ -656,13 +660,13 @@ func TestGetFile(t *testing.T) {
 
 			// Add a test file first
 			if !tc.expectError {
-				err = filesService.AppendFile(tc.commandID, []byte("test content"), &entities.FileParams{Filename: "test.txt", Size: 12})
+				_, err = filesService.AppendFile(nil, tc.commandID, []byte("test content"), &entities.FileParams{Filename: "test.txt", Size: 12})
 				if err != nil {
 					t.Fatalf("Cant append test file: %v", err)
 				}
 			}
 
-			_, err = filesService.GetFile(tc.commandID, tc.fileID)
+			_, err = filesService.GetFile(nil, tc.commandID, tc.fileID)
 			if tc.expectError && err == nil {
 				t.Fatalf("Expected error but got none")
 			}
@@ -727,7 +731,7 @@ func TestGetCommandFilesList(t *testing.T) {
 			if err != nil {
 				t.Fatalf("Cant set connect filesystem: %v", err)
 			}
-			filesService := NewService(filesDir, 1024, db, db, filesystemAdapter)
+			filesService := NewService(filesDir, 1024, db, db, filesystemAdapter, access.NewService(db, db, db))
 			userConfigService := userconfig.NewService(db, db, filesystemAdapter, utils.DetectDefaultConsole())
 
 			err = userConfigService.SetUserConfig(&tc.initialConfig)
@@ -735,7 +739,7 @@ func TestGetCommandFilesList(t *testing.T) {
 				t.Fatalf("Cant set initial config: %v", err)
 			}
 
-			_, err = filesService.GetCommandFiles(tc.commandID)
+			_, err = filesService.GetCommandFiles(nil, tc.commandID)
 			if tc.expectError && err == nil {
 				t.Fatalf("Expected error but got none")
 			}
@@ -767,9 +771,9 @@ func TestGetAllFilesList(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Cant set connect filesystem: %v", err)
 	}
-	filesService := NewService(filesDir, 1024, db, db, filesystemAdapter)
+	filesService := NewService(filesDir, 1024, db, db, filesystemAdapter, access.NewService(db, db, db))
 
-	files, err := filesService.GetAllFilesList()
+	files, err := filesService.GetAllFilesList(nil)
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
@@ -838,7 +842,7 @@ func TestDownloadFile(t *testing.T) {
 			if err != nil {
 				t.Fatalf("Cant set connect filesystem: %v", err)
 			}
-			filesService := NewService(filesDir, 1024, db, db, filesystemAdapter)
+			filesService := NewService(filesDir, 1024, db, db, filesystemAdapter, access.NewService(db, db, db))
 			userConfigService := userconfig.NewService(db, db, filesystemAdapter, utils.DetectDefaultConsole())
 
 			err = userConfigService.SetUserConfig(&tc.initialConfig)
@@ -848,13 +852,13 @@ func TestDownloadFile(t *testing.T) {
 
 			// Add a test file first
 			if !tc.expectError {
-				err = filesService.AppendFile(tc.commandID, []byte(tc.fileContent), &entities.FileParams{Filename: "test.txt", Size: uint64(len(tc.fileContent))})
+				_, err = filesService.AppendFile(nil, tc.commandID, []byte(tc.fileContent), &entities.FileParams{Filename: "test.txt", Size: uint64(len(tc.fileContent))})
 				if err != nil {
 					t.Fatalf("Cant append test file: %v", err)
 				}
 			}
 
-			_, data, err := filesService.DownloadFile(tc.commandID, tc.fileID)
+			_, data, err := filesService.DownloadFile(nil, tc.commandID, tc.fileID)
 			if tc.expectError && err == nil {
 				t.Fatalf("Expected error but got none")
 			}
@@ -924,7 +928,7 @@ func TestDownloadCommandFilesInArchive(t *testing.T) {
 			if err != nil {
 				t.Fatalf("Cant set connect filesystem: %v", err)
 			}
-			filesService := NewService(filesDir, 1024, db, db, filesystemAdapter)
+			filesService := NewService(filesDir, 1024, db, db, filesystemAdapter, access.NewService(db, db, db))
 			userConfigService := userconfig.NewService(db, db, filesystemAdapter, utils.DetectDefaultConsole())
 
 			err = userConfigService.SetUserConfig(&tc.initialConfig)
@@ -933,17 +937,17 @@ func TestDownloadCommandFilesInArchive(t *testing.T) {
 			}
 
 			if tc.name == "Download archive for command with files" {
-				err = filesService.AppendFile(tc.commandID, []byte("content1"), &entities.FileParams{Filename: "file1.txt", Size: 8})
+				_, err = filesService.AppendFile(nil, tc.commandID, []byte("content1"), &entities.FileParams{Filename: "file1.txt", Size: 8})
 				if err != nil {
 					t.Fatalf("Cant append test file 1: %v", err)
 				}
-				err = filesService.AppendFile(tc.commandID, []byte("content2"), &entities.FileParams{Filename: "file2.txt", Size: 8})
+				_, err = filesService.AppendFile(nil, tc.commandID, []byte("content2"), &entities.FileParams{Filename: "file2.txt", Size: 8})
 				if err != nil {
 					t.Fatalf("Cant append test file 2: %v", err)
 				}
 			}
 
-			data, err := filesService.DownloadCommandFilesInArchive(tc.commandID)
+			data, err := filesService.DownloadCommandFilesInArchive(nil, tc.commandID)
 			if tc.expectError && err == nil {
 				t.Fatalf("Expected error but got none")
 			}
@@ -980,9 +984,9 @@ func TestDownloadAllFilesInArchive(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Cant set connect filesystem: %v", err)
 	}
-	filesService := NewService(filesDir, 1024, db, db, filesystemAdapter)
+	filesService := NewService(filesDir, 1024, db, db, filesystemAdapter, access.NewService(db, db, db))
 
-	data, err := filesService.DownloadAllFilesInArchive()
+	data, err := filesService.DownloadAllFilesInArchive(nil)
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
@@ -1012,10 +1016,10 @@ func TestImportAllFilesFromArchive(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Cant set connect filesystem: %v", err)
 	}
-	filesService := NewService(filesDir, 1024, db, db, filesystemAdapter)
+	filesService := NewService(filesDir, 1024, db, db, filesystemAdapter, access.NewService(db, db, db))
 
 	var emptyData []byte
-	err = filesService.ImportAllFilesFromZipArchive(emptyData)
+	err = filesService.ImportAllFilesFromZipArchive(nil, emptyData)
 	if err == nil {
 		t.Log("Import with empty data succeeded (unexpected)")
 	}
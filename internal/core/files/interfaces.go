@@ -28,3 +28,8 @@ type Filesystem interface {
 	ClearFiles() error
 	ImportFilesFromZipArchive(data []byte) ([]entities.FileData, error)
 }
+
+type Access interface {
+	CheckRole(user *entities.User, role entities.UserRole) error
+	CheckCommand(user *entities.User, commandId uint, level entities.AccessLevel) error
+}
@@ -5,13 +5,34 @@ import (
 )
 
 type Runner interface {
-	RunCommand(command string, options entities.TerminalOptions) (entities.RunningCommand, error)
+	// RunCommand interpreter is argv template with {{command}}, nil for default console
+	RunCommand(command string, interpreter []string, options entities.TerminalOptions) (entities.RunningCommand, error)
+	// RunPipedCommand run command without pty, stdout and stderr are read separately
+	RunPipedCommand(command string, interpreter []string, options entities.TerminalOptions) (entities.PipedCommand, error)
+	QuoteArgument(argument string) string // quote value to be passed as single argument in console command
 }
 
+// CommandsRepository is called with nil user, access is already checked by runner
 type CommandsRepository interface {
-	GetCommand(id uint) (*entities.Command, error)
+	GetCommand(user *entities.User, id uint) (*entities.Command, error)
 }
 
 type FilesRepository interface {
-	GetCommandFiles(commandId uint) ([]entities.EmbeddedFile, error)
+	GetCommandFiles(user *entities.User, commandId uint) ([]entities.EmbeddedFile, error)
+}
+
+type Environment interface {
+	CommandEnv(command *entities.Command, dir string) ([]string, error)
+}
+
+type Secrets interface {
+	CommandSecrets(command *entities.Command) (map[string]string, error)
+}
+
+type RunsHistory interface {
+	StartRun(command *entities.Command, sessionId string, triggeredBy string, cols, rows uint16) (entities.RunRecorder, error)
+}
+
+type Access interface {
+	CheckCommand(user *entities.User, commandId uint, level entities.AccessLevel) error
 }
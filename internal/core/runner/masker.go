@@ -0,0 +1,68 @@
+package runner
+
+import (
+	"bytes"
+	"slices"
+)
+
+const secretMask = "******"
+
+// outputMasker replaces secret values in command output.
+// End of output, that can be start of secret, is held until next data shows it is not.
+type outputMasker struct {
+	secrets [][]byte // longest first, so secret containing other secret masked whole
+	pending []byte
+}
+
+func newOutputMasker(secrets map[string]string) *outputMasker {
+	masker := &outputMasker{}
+	for _, value := range secrets {
+		if value != "" {
+			masker.secrets = append(masker.secrets, []byte(value))
+		}
+	}
+	if len(masker.secrets) == 0 {
+		return nil
+	}
+	slices.SortFunc(masker.secrets, func(a, b []byte) int {
+		return len(b) - len(a)
+	})
+	return masker
+}
+
+// Mask return part of output, that is safe to show
+func (m *outputMasker) Mask(data []byte) []byte {
+	if m == nil {
+		return data
+	}
+	data = append(m.pending, data...)
+	for _, secret := range m.secrets {
+		data = bytes.ReplaceAll(data, secret, []byte(secretMask))
+	}
+	held := 0
+	for _, secret := range m.secrets {
+		for size := min(len(secret)-1, len(data)); size > held; size-- {
+			if bytes.HasSuffix(data, secret[:size]) {
+				held = size
+				break
+			}
+		}
+	}
+	m.pending = slices.Clone(data[len(data)-held:])
+	return data[:len(data)-held]
+}
+
+// Holds report if part of output is held
+func (m *outputMasker) Holds() bool {
+	return m != nil && len(m.pending) != 0
+}
+
+// Flush return held output, when command output finished or paused
+func (m *outputMasker) Flush() []byte {
+	if m == nil {
+		return nil
+	}
+	data := m.pending
+	m.pending = nil
+	return data
+}
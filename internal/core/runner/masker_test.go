@@ -0,0 +1,64 @@
+package runner
+
+import (
+	"testing"
+)
+
+func TestOutputMasker(t *testing.T) {
+	testCases := []struct {
+		name     string
+		secrets  map[string]string
+		chunks   []string
+		expected string
+	}{
+		{
+			name:     "No secrets",
+			chunks:   []string{"token: ", "abc"},
+			expected: "token: abc",
+		},
+		{
+			name:     "Secret in one chunk",
+			secrets:  map[string]string{"TOKEN": "s3cr3t"},
+			chunks:   []string{"token: s3cr3t\r\n"},
+			expected: "token: ******\r\n",
+		},
+		{
+			name:     "Secret split by chunks",
+			secrets:  map[string]string{"TOKEN": "s3cr3t"},
+			chunks:   []string{"token: s", "3c", "r", "3t", " s3", "cr", "3t"},
+			expected: "token: ****** ******",
+		},
+		{
+			name:     "Prefix of secret at end flushed",
+			secrets:  map[string]string{"TOKEN": "s3cr3t"},
+			chunks:   []string{"s3c"},
+			expected: "s3c",
+		},
+		{
+			name:     "Longer secret masked whole",
+			secrets:  map[string]string{"SHORT": "abc", "LONG": "abcdef"},
+			chunks:   []string{"abcdef abc"},
+			expected: "****** ******",
+		},
+		{
+			name:     "Empty secret ignored",
+			secrets:  map[string]string{"EMPTY": ""},
+			chunks:   []string{"text"},
+			expected: "text",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			masker := newOutputMasker(tc.secrets)
+			result := ""
+			for _, chunk := range tc.chunks {
+				result += string(masker.Mask([]byte(chunk)))
+			}
+			result += string(masker.Flush())
+			if result != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package runner
+
+import (
+	"fmt"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/utils"
+	"regexp"
+)
+
+var placeholderRegexp = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// renderCommand substitute quoted parameter values in place of {{name}} placeholders.
+// Missing values replaced with defaults, placeholders of undeclared parameters kept as is.
+func renderCommand(command *entities.Command, values map[string]string, quote func(string) string) (string, error) {
+	declared := make(map[string]bool, len(command.Parameters))
+	for _, parameter := range command.Parameters {
+		declared[parameter.Name] = true
+	}
+	for name := range values {
+		if !declared[name] {
+			return "", fmt.Errorf("%w: unknown parameter %q", projectErrors.ErrBadParameter, name)
+		}
+	}
+
+	quoted := make(map[string]string, len(command.Parameters))
+	for _, parameter := range command.Parameters {
+		value, ok := values[parameter.Name]
+		if !ok {
+			value = parameter.Default
+		}
+		value, err := utils.CheckParameterValue(parameter, value)
+		if err != nil {
+			return "", err
+		}
+		quoted[parameter.Name] = quote(value)
+	}
+
+	return placeholderRegexp.ReplaceAllStringFunc(command.Command, func(placeholder string) string {
+		if value, ok := quoted[placeholderRegexp.FindStringSubmatch(placeholder)[1]]; ok {
+			return value
+		}
+		return placeholder
+	}), nil
+}
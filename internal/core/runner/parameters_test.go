@@ -0,0 +1,93 @@
+package runner
+
+import (
+	"errors"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"testing"
+)
+
+func TestRenderCommand(t *testing.T) {
+	quote := func(value string) string {
+		return "[" + value + "]"
+	}
+	testCases := []struct {
+		name          string
+		command       entities.Command
+		values        map[string]string
+		expected      string
+		expectedError error
+	}{
+		{
+			name:     "No parameters",
+			command:  entities.Command{Command: "echo {{name}}"},
+			expected: "echo {{name}}",
+		},
+		{
+			name: "Default and value",
+			command: entities.Command{Command: "deploy {{branch}} {{env}}", Parameters: []entities.CommandParameter{
+				{Name: "branch", Default: "main"},
+				{Name: "env", Type: entities.ParameterTypeEnum, Options: []string{"dev", "prod"}, Default: "dev"},
+			}},
+			values:   map[string]string{"env": "prod"},
+			expected: "deploy [main] [prod]",
+		},
+		{
+			name: "Placeholder used twice",
+			command: entities.Command{Command: "{{a}}-{{ a }}", Parameters: []entities.CommandParameter{
+				{Name: "a"},
+			}},
+			values:   map[string]string{"a": "{{a}}"},
+			expected: "[{{a}}]-[{{a}}]",
+		},
+		{
+			name: "Boolean normalized",
+			command: entities.Command{Command: "run --force={{force}}", Parameters: []entities.CommandParameter{
+				{Name: "force", Type: entities.ParameterTypeBoolean, Default: "false"},
+			}},
+			values:   map[string]string{"force": "1"},
+			expected: "run --force=[true]",
+		},
+		{
+			name: "Enum value not in options",
+			command: entities.Command{Command: "{{env}}", Parameters: []entities.CommandParameter{
+				{Name: "env", Type: entities.ParameterTypeEnum, Options: []string{"dev", "prod"}},
+			}},
+			values:        map[string]string{"env": "stage"},
+			expectedError: projectErrors.ErrBadParameter,
+		},
+		{
+			name: "Validation must match fully",
+			command: entities.Command{Command: "ssh {{host}}", Parameters: []entities.CommandParameter{
+				{Name: "host", Validation: `[a-z0-9.-]+`},
+			}},
+			values:        map[string]string{"host": "example.com -oProxyCommand=id"},
+			expectedError: projectErrors.ErrBadParameter,
+		},
+		{
+			name: "Missing number without default",
+			command: entities.Command{Command: "sleep {{seconds}}", Parameters: []entities.CommandParameter{
+				{Name: "seconds", Type: entities.ParameterTypeNumber},
+			}},
+			expectedError: projectErrors.ErrBadParameter,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := renderCommand(&tc.command, tc.values, quote)
+			if tc.expectedError != nil {
+				if !errors.Is(err, tc.expectedError) {
+					t.Fatalf("expected error %v, got %v", tc.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result != tc.expected {
+				t.Fatalf("unexpected result: %q, need %q", result, tc.expected)
+			}
+		})
+	}
+}
@@ -1,33 +1,54 @@
 package runner
 
 import (
-	"bufio"
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
 	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/utils"
 	"github.com/gofiber/fiber/v2/log"
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"time"
 )
 
 type Service struct {
 	defaultCommandRunDir string
 	filesDirPath         string
+	sessionDetachTimeout time.Duration
+	scrollbackSize       int
+	overflowPolicy       entities.OverflowPolicy
+	killPolicy           entities.KillPolicy
 	runner               Runner
 	commands             CommandsRepository
 	files                FilesRepository
+	runs                 RunsHistory
+	environment          Environment
+	secrets              Secrets
+	access               Access
+	sessions             *sessionsStorage
 }
 
-func NewService(defaultCommandRunDir string, filesDirPath string, runner Runner, commandsRepository CommandsRepository, filesRepository FilesRepository) *Service {
+func NewService(defaultCommandRunDir string, filesDirPath string, sessionDetachTimeout time.Duration, scrollbackSize int, overflowPolicy entities.OverflowPolicy, killPolicy entities.KillPolicy, runner Runner, commandsRepository CommandsRepository, filesRepository FilesRepository, runsHistory RunsHistory, environment Environment, secrets Secrets, access Access) *Service {
 	return &Service{
 		defaultCommandRunDir: defaultCommandRunDir,
 		filesDirPath:         filesDirPath,
+		sessionDetachTimeout: sessionDetachTimeout,
+		scrollbackSize:       scrollbackSize,
+		overflowPolicy:       overflowPolicy,
+		killPolicy:           killPolicy,
 		runner:               runner,
 		commands:             commandsRepository,
 		files:                filesRepository,
+		runs:                 runsHistory,
+		environment:          environment,
+		secrets:              secrets,
+		access:               access,
+		sessions:             newSessionsStorage(),
 	}
 }
 
@@ -35,31 +56,32 @@ type deleteCallbackFunction func() error
 
 // prepareFile copy file and return function for delete it
 func (s Service) prepareFile(targetDir string, file entities.EmbeddedFile) (deleteCallbackFunction, error) {
-	targetFileName := filepath.Join(targetDir, file.Name)
-	targetFile, err := os.Create(targetFileName)
+	sourceFile, err := os.Open(filepath.Join(s.filesDirPath, fmt.Sprintf("%d", file.ID)))
 	if err != nil {
 		return nil, err
 	}
-	defer func(targetFile *os.File) {
-		err := targetFile.Close()
+	defer func(sourceFile *os.File) {
+		err := sourceFile.Close()
 		if err != nil {
 			log.Warn(err)
 		}
-	}(targetFile)
+	}(sourceFile)
 
-	sourceFile, err := os.Open(filepath.Join(s.filesDirPath, fmt.Sprintf("%d", file.ID)))
+	targetFileName := filepath.Join(targetDir, file.Name)
+	targetFile, err := os.Create(targetFileName)
 	if err != nil {
 		return nil, err
 	}
-	defer func(sourceFile *os.File) {
-		err := sourceFile.Close()
+	defer func(targetFile *os.File) {
+		err := targetFile.Close()
 		if err != nil {
 			log.Warn(err)
 		}
-	}(sourceFile)
+	}(targetFile)
 
 	_, err = io.Copy(targetFile, sourceFile)
 	if err != nil {
+		_ = os.Remove(targetFileName)
 		return nil, err
 	}
 	return func() error {
@@ -67,25 +89,77 @@ func (s Service) prepareFile(targetDir string, file entities.EmbeddedFile) (dele
 	}, nil
 }
 
-// RunCommand return input chan, output chan and error
-func (s Service) RunCommand(ctx context.Context, commandId uint, options entities.TerminalOptions) (*entities.CommandInputOutput, error) {
-	commandData, err := s.commands.GetCommand(commandId)
+// startSession start command in new session and record it to run history.
+// Idle policy is applied only to interactive session, command without terminal clients never gets input,
+// so piped command without stdin in options gets empty stdin
+func (s Service) startSession(user *entities.User, commandId uint, triggeredBy string, options entities.TerminalOptions, interactive bool) (*session, error) {
+	if err := s.access.CheckCommand(user, commandId, entities.AccessRun); err != nil {
+		return nil, err
+	}
+	commandData, err := s.commands.GetCommand(nil, commandId)
 	if err != nil {
 		return nil, err
 	}
 	if commandData.Command == "" {
 		return nil, projectErrors.ErrEmptyCommand
 	}
+	piped := commandData.ExecutionMode == entities.ExecutionModePipe
+	if options.Stdin != nil && !piped {
+		return nil, projectErrors.ErrStdinNotPiped
+	}
+	if piped && !interactive && options.Stdin == nil {
+		options.Stdin = bytes.NewReader(nil)
+	}
+	interpreter, err := utils.CommandInterpreter(commandData)
+	if err != nil {
+		return nil, err
+	}
+	var interpreterArgv []string
+	quote := s.runner.QuoteArgument
+	if interpreter != nil {
+		interpreterArgv = interpreter.Argv
+		if interpreter.Quote != nil {
+			quote = interpreter.Quote
+		}
+	}
+	// Run history keeps command as it was executed, with substituted parameters
+	commandData.Command, err = renderCommand(commandData, options.Parameters, quote)
+	if err != nil {
+		return nil, err
+	}
 	if commandData.Dir == "" {
 		options.Dir = s.defaultCommandRunDir
 	} else {
 		options.Dir = commandData.Dir
 	}
-	embeddedFiles, err := s.files.GetCommandFiles(commandId)
+	options.Env, err = s.environment.CommandEnv(commandData, options.Dir)
+	if err != nil {
+		return nil, err
+	}
+	secretValues, err := s.secrets.CommandSecrets(commandData)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range secretValues {
+		options.Env = append(options.Env, name+"="+value)
+	}
+	embeddedFiles, err := s.files.GetCommandFiles(nil, commandId)
 	if err != nil {
 		return nil, err
 	}
 	var deleteCallbacks []deleteCallbackFunction
+	started := false
+	// Copied files are deleted at once, if command is not started, otherwise after it finished
+	defer func() {
+		if started {
+			return
+		}
+		for _, f := range deleteCallbacks {
+			if err := f(); err != nil {
+				log.Warn("Error deleting file of not started command: ", err)
+			}
+		}
+	}()
 	for _, file := range embeddedFiles {
 		deleteIt, err := s.prepareFile(options.Dir, file)
 		if err != nil {
@@ -93,79 +167,200 @@ func (s Service) RunCommand(ctx context.Context, commandId uint, options entitie
 		}
 		deleteCallbacks = append(deleteCallbacks, deleteIt)
 	}
-	processingCommand, err := s.runner.RunCommand(commandData.Command, options)
+	var processingCommand entities.RunningCommand
+	if piped {
+		processingCommand, err = s.runner.RunPipedCommand(commandData.Command, interpreterArgv, options)
+	} else {
+		processingCommand, err = s.runner.RunCommand(commandData.Command, interpreterArgv, options)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("error in RunCommand function: %w", err)
 	}
 
-	inputChan := make(chan string)
-	outputChan := make(chan string)
-
-	ctx, cancel := context.WithCancel(ctx)
-
-	// Output goroutine
-	go func() {
-		defer close(outputChan)
-		defer close(inputChan)
-		defer cancel()
-		defer func() {
-			for _, f := range deleteCallbacks {
-				go func() {
-					var err error
-					for try := range 3 {
-						err = f()
-						if err == nil {
-							return
-						}
-						time.Sleep(time.Duration((try+1)*50) * time.Millisecond) // waiting for finishing command executions, for delete its files
-					}
-					log.Warn(err)
-				}()
-
-			}
-		}()
-		scanner := bufio.NewScanner(processingCommand.GetReader())
-		scanner.Split(bufio.ScanRunes)
-		for scanner.Scan() {
-			select {
-			case outputChan <- scanner.Text():
-			case <-ctx.Done():
-				return
-			}
+	killPolicy := s.killPolicy
+	if commandData.KillPolicy != nil {
+		killPolicy = *commandData.KillPolicy
+	}
+	commandSession, err := newSession(commandId, processingCommand, options.Cols, options.Rows, s.scrollbackSize, s.overflowPolicy, s.sessionDetachTimeout, killPolicy)
+	if err != nil {
+		if err := processingCommand.Kill(); err != nil {
+			log.Warn("Error while killing command ", err)
 		}
-		if err := scanner.Err(); err != nil {
-			log.Debug("Error reading command output", err)
+		return nil, fmt.Errorf("error creating session: %w", err)
+	}
+	commandSession.masker = newOutputMasker(secretValues)
+	commandSession.stderrMasker = newOutputMasker(secretValues)
+	commandSession.recorder, err = s.runs.StartRun(commandData, commandSession.id, triggeredBy, options.Cols, options.Rows)
+	if err != nil {
+		if err := processingCommand.Kill(); err != nil {
+			log.Warn("Error while killing command ", err)
 		}
-	}()
+		return nil, fmt.Errorf("error saving run: %w", err)
+	}
+	if commandData.TimeoutMs != 0 {
+		commandSession.setTimeout(time.Duration(commandData.TimeoutMs) * time.Millisecond)
+	}
+	if interactive && commandData.IdlePolicy != nil {
+		commandSession.watchIdleInput(*commandData.IdlePolicy)
+	}
+	s.sessions.add(commandSession)
+	started = true
 
-	// Input goroutine
 	go func() {
-		defer func(processingCommand entities.RunningCommand) {
-			err := processingCommand.Kill()
-			if err != nil {
-				log.Warn("Error while killing command ", err)
-			}
-		}(processingCommand)
-		for {
-			select {
-			case input, ok := <-inputChan:
-				if !ok {
-					return
-				}
-				_, err := processingCommand.GetWriter().Write([]byte(input))
-				if err != nil {
-					log.Warn("Error writing input to command", err)
-					return
-				}
-				if flusher, ok := processingCommand.GetWriter().(interface{ Flush() error }); ok {
-					if err := flusher.Flush(); err != nil {
-						log.Warn("Error flushing input", err)
+		commandSession.readOutput()
+		commandSession.finish()
+		for _, f := range deleteCallbacks {
+			go func() {
+				var err error
+				for try := range 3 {
+					err = f()
+					if err == nil {
+						return
 					}
+					time.Sleep(time.Duration((try+1)*50) * time.Millisecond) // waiting for finishing command executions, for delete its files
 				}
-			case <-ctx.Done():
-				return
-			}
+				log.Warn(err)
+			}()
 		}
+		// Finished session kept for a while, so reconnected client can get the end of output
+		time.AfterFunc(s.sessionDetachTimeout, func() {
+			s.sessions.remove(commandSession.id)
+		})
 	}()
-	return &entities.CommandInputOutput{Input: inputChan, Output: outputChan}, nil
+	return commandSession, nil
+}
+
+// getSession return session, if user has level of access to its command
+func (s Service) getSession(user *entities.User, sessionId string, level entities.AccessLevel) (*session, error) {
+	commandSession, err := s.sessions.get(sessionId)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.access.CheckCommand(user, commandSession.commandId, level); err != nil {
+		return nil, err
+	}
+	return commandSession, nil
+}
+
+// RunCommand start command in new session, record it to run history and attach client to it as controller.
+// Client receives the whole output from the start.
+// Command keeps running after ctx is done, until it finishes or session detach timeout expires.
+func (s Service) RunCommand(ctx context.Context, user *entities.User, commandId uint, triggeredBy string, options entities.TerminalOptions) (*entities.CommandInputOutput, error) {
+	commandSession, err := s.startSession(user, commandId, triggeredBy, options, true)
+	if err != nil {
+		return nil, err
+	}
+	return commandSession.attach(ctx, triggeredBy, entities.ViewerController, false)
+}
+
+// StartCommand start command without client, it runs until finished. Return started run.
+func (s Service) StartCommand(user *entities.User, commandId uint, triggeredBy string, options entities.TerminalOptions) (*entities.Run, error) {
+	commandSession, err := s.startSession(user, commandId, triggeredBy, options, false)
+	if err != nil {
+		return nil, err
+	}
+	return commandSession.recorder.GetRun(), nil
+}
+
+// WaitSession wait until command of session finished and return its exit status
+func (s Service) WaitSession(ctx context.Context, user *entities.User, sessionId string) (*entities.ExitStatus, error) {
+	commandSession, err := s.getSession(user, sessionId, entities.AccessView)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case <-commandSession.exited:
+		exitStatus := commandSession.exitStatus
+		return &exitStatus, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// AttachSession connect viewer to already running session, other viewers keep watching.
+// Viewer first receive snapshot of terminal screen with bounded history, then new output.
+// Controller needs run access to command, spectator needs view access
+func (s Service) AttachSession(ctx context.Context, user *entities.User, sessionId string, name string, role entities.ViewerRole) (*entities.CommandInputOutput, error) {
+	level := entities.AccessRun
+	switch role {
+	case entities.ViewerController:
+	case entities.ViewerSpectator:
+		level = entities.AccessView
+	default:
+		return nil, projectErrors.ErrBadViewerRole
+	}
+	commandSession, err := s.getSession(user, sessionId, level)
+	if err != nil {
+		return nil, err
+	}
+	return commandSession.attach(ctx, name, role, true)
+}
+
+// StreamSession connect read-only spectator to session, who receives raw output since offset
+// and can resume from offset of the last received chunk. Negative offset means from the oldest kept output
+func (s Service) StreamSession(ctx context.Context, user *entities.User, sessionId string, name string, offset int64) (*entities.OutputStream, error) {
+	commandSession, err := s.getSession(user, sessionId, entities.AccessView)
+	if err != nil {
+		return nil, err
+	}
+	return commandSession.stream(ctx, name, offset)
+}
+
+// GetSessions return sessions of running and recently finished commands, that user can view, with their viewers
+func (s Service) GetSessions(user *entities.User) []entities.SessionInfo {
+	sessions := s.sessions.list()
+	res := make([]entities.SessionInfo, 0, len(sessions))
+	for _, commandSession := range sessions {
+		if err := s.access.CheckCommand(user, commandSession.commandId, entities.AccessView); err != nil {
+			if !errors.Is(err, projectErrors.ErrForbidden) {
+				log.Warn("Error checking access to session: ", err)
+			}
+			continue
+		}
+		res = append(res, commandSession.info())
+	}
+	slices.SortFunc(res, func(a, b entities.SessionInfo) int {
+		return a.StartedAt.Compare(b.StartedAt)
+	})
+	return res
+}
+
+// TerminateSession stop command of session by kill policy without waiting for detach timeout.
+// Return immediately, command stopped in background.
+func (s Service) TerminateSession(user *entities.User, sessionId string) error {
+	commandSession, err := s.getSession(user, sessionId, entities.AccessRun)
+	if err != nil {
+		return err
+	}
+	go commandSession.stop(entities.StopReasonTerminated)
+	return nil
+}
+
+// SignalSession send signal to command of session, unlike terminal input it works in raw mode and on Windows.
+// Spectator viewer can't send signals, empty viewerId is for clients without terminal
+func (s Service) SignalSession(user *entities.User, sessionId string, viewerId string, signal entities.Signal) error {
+	commandSession, err := s.getSession(user, sessionId, entities.AccessRun)
+	if err != nil {
+		return err
+	}
+	return commandSession.signal(viewerId, signal)
+}
+
+// ResizeSession change terminal size of command of session, after controller terminal resized
+func (s Service) ResizeSession(user *entities.User, sessionId string, viewerId string, cols, rows uint16) error {
+	commandSession, err := s.getSession(user, sessionId, entities.AccessRun)
+	if err != nil {
+		return err
+	}
+	return commandSession.resize(viewerId, cols, rows)
+}
+
+// GetSessionStats return output buffer state of session, to see if its client lags
+func (s Service) GetSessionStats(user *entities.User, sessionId string) (*entities.SessionStats, error) {
+	commandSession, err := s.getSession(user, sessionId, entities.AccessView)
+	if err != nil {
+		return nil, err
+	}
+	stats := commandSession.stats()
+	return &stats, nil
 }
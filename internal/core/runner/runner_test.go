@@ -1,12 +1,19 @@
 package runner
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/adapters/console/checker"
 	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/adapters/console/runner"
 	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/adapters/storage/filesystem"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/core/access"
 	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/core/commands"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/core/environment"
 	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/core/files"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/core/runs"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/core/secrets"
 	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/utils"
 	"os"
 	"os/exec"
@@ -18,6 +25,7 @@ import (
 
 	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/adapters/storage/database"
 	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
 	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/testutils"
 	"github.com/acarl005/stripansi"
 	"github.com/gofiber/fiber/v2/log"
@@ -51,10 +59,18 @@ func TestRunCommand_Success(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Cant set connect filesystem: %v", err)
 	}
-	commandsService := commands.NewService(db, commandRunDir)
-	filesService := files.NewService(filesDir, 1024, db, db, filesystemAdapter)
+	runLogsAdapter, err := filesystem.ConnectRunLogs(filepath.Join(dataDir, "runs"))
+	if err != nil {
+		t.Fatalf("Cant set connect run logs: %v", err)
+	}
+	accessService := access.NewService(db, db, db)
+	commandsService := commands.NewService(db, commandRunDir, checker.New(ptyDir), accessService)
+	filesService := files.NewService(filesDir, 1024, db, db, filesystemAdapter, accessService)
 	runnerAdapter := runner.New(ptyDir, utils.DetectDefaultConsole())
-	runnerService := NewService(commandRunDir, filesDir, runnerAdapter, commandsService, filesService)
+	runsService := runs.NewService(1024*1024, db, runLogsAdapter)
+	environmentService := environment.NewService("", db)
+	secretsService := secrets.NewService(make([]byte, secrets.KeySize), db)
+	runnerService := NewService(commandRunDir, filesDir, time.Minute, 64*1024, entities.OverflowDrop, entities.KillPolicy{}, runnerAdapter, commandsService, filesService, runsService, environmentService, secretsService, accessService)
 
 	err = db.SetCommands([]entities.Command{{Name: "Echo", Command: "echo hello", Dir: os.TempDir()}})
 	if err != nil {
@@ -63,7 +79,7 @@ func TestRunCommand_Success(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
 
-	command, err := runnerService.RunCommand(ctx, 1, entities.TerminalOptions{Rows: 30, Cols: 120})
+	command, err := runnerService.RunCommand(ctx, nil, 1, "test", entities.TerminalOptions{Rows: 30, Cols: 120})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -72,14 +88,14 @@ func TestRunCommand_Success(t *testing.T) {
 	}
 	result := ""
 	ok := true
-	var dataOut string
+	var dataOut []byte
 	for ok {
 		select {
 		case dataOut, ok = <-command.Output:
 			if !ok {
 				break
 			}
-			result += dataOut
+			result += string(dataOut)
 		case <-time.After(1 * time.Second):
 			t.Fatal("timeout waiting for result")
 			return
@@ -115,10 +131,18 @@ func TestRunCommand_InvalidCommand(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Cant set connect filesystem: %v", err)
 	}
-	commandsService := commands.NewService(db, commandRunDir)
-	filesService := files.NewService(filesDir, 1024, db, db, filesystemAdapter)
+	runLogsAdapter, err := filesystem.ConnectRunLogs(filepath.Join(dataDir, "runs"))
+	if err != nil {
+		t.Fatalf("Cant set connect run logs: %v", err)
+	}
+	accessService := access.NewService(db, db, db)
+	commandsService := commands.NewService(db, commandRunDir, checker.New(ptyDir), accessService)
+	filesService := files.NewService(filesDir, 1024, db, db, filesystemAdapter, accessService)
 	runnerAdapter := runner.New(ptyDir, utils.DetectDefaultConsole())
-	runnerService := NewService(commandRunDir, filesDir, runnerAdapter, commandsService, filesService)
+	runsService := runs.NewService(1024*1024, db, runLogsAdapter)
+	environmentService := environment.NewService("", db)
+	secretsService := secrets.NewService(make([]byte, secrets.KeySize), db)
+	runnerService := NewService(commandRunDir, filesDir, time.Minute, 64*1024, entities.OverflowDrop, entities.KillPolicy{}, runnerAdapter, commandsService, filesService, runsService, environmentService, secretsService, accessService)
 
 	// seed invalid command
 	err = db.SetCommands([]entities.Command{{Name: "Bad", Command: "nonexistentcommand1234", Dir: os.TempDir()}})
@@ -128,20 +152,20 @@ func TestRunCommand_InvalidCommand(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
 
-	command, err := runnerService.RunCommand(ctx, 1, entities.TerminalOptions{Rows: 30, Cols: 120})
+	command, err := runnerService.RunCommand(ctx, nil, 1, "test", entities.TerminalOptions{Rows: 30, Cols: 120})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 	result := ""
 	ok := true
-	var dataOut string
+	var dataOut []byte
 	for ok {
 		select {
 		case dataOut, ok = <-command.Output:
 			if !ok {
 				break
 			}
-			result += dataOut
+			result += string(dataOut)
 		case <-time.After(1 * time.Second):
 			t.Fatal("timeout waiting for result")
 			return
@@ -180,17 +204,25 @@ func TestRunCommand_ContextCancel(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Cant set connect filesystem: %v", err)
 	}
-	commandsService := commands.NewService(db, commandRunDir)
-	filesService := files.NewService(filesDir, 1024, db, db, filesystemAdapter)
+	runLogsAdapter, err := filesystem.ConnectRunLogs(filepath.Join(dataDir, "runs"))
+	if err != nil {
+		t.Fatalf("Cant set connect run logs: %v", err)
+	}
+	accessService := access.NewService(db, db, db)
+	commandsService := commands.NewService(db, commandRunDir, checker.New(ptyDir), accessService)
+	filesService := files.NewService(filesDir, 1024, db, db, filesystemAdapter, accessService)
 	runnerAdapter := runner.New(ptyDir, utils.DetectDefaultConsole())
-	runnerService := NewService(commandRunDir, filesDir, runnerAdapter, commandsService, filesService)
+	runsService := runs.NewService(1024*1024, db, runLogsAdapter)
+	environmentService := environment.NewService("", db)
+	secretsService := secrets.NewService(make([]byte, secrets.KeySize), db)
+	runnerService := NewService(commandRunDir, filesDir, time.Minute, 64*1024, entities.OverflowDrop, entities.KillPolicy{}, runnerAdapter, commandsService, filesService, runsService, environmentService, secretsService, accessService)
 
 	// seed long-running command
 	err = db.SetCommands([]entities.Command{{Name: "Ping", Command: "ping 127.0.0.1", Dir: os.TempDir()}})
 	if err != nil {
 		t.Fatalf("cant set config: %v", err)
 	}
-	command, err := runnerService.RunCommand(ctx, 1, entities.TerminalOptions{Rows: 30, Cols: 120})
+	command, err := runnerService.RunCommand(ctx, nil, 1, "test", entities.TerminalOptions{Rows: 30, Cols: 120})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -245,17 +277,25 @@ func TestRunCommand_PythonInteractive(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Cant set connect filesystem: %v", err)
 	}
-	commandsService := commands.NewService(db, commandRunDir)
-	filesService := files.NewService(filesDir, 1024, db, db, filesystemAdapter)
+	runLogsAdapter, err := filesystem.ConnectRunLogs(filepath.Join(dataDir, "runs"))
+	if err != nil {
+		t.Fatalf("Cant set connect run logs: %v", err)
+	}
+	accessService := access.NewService(db, db, db)
+	commandsService := commands.NewService(db, commandRunDir, checker.New(ptyDir), accessService)
+	filesService := files.NewService(filesDir, 1024, db, db, filesystemAdapter, accessService)
 	runnerAdapter := runner.New(ptyDir, utils.DetectDefaultConsole())
-	runnerService := NewService(commandRunDir, filesDir, runnerAdapter, commandsService, filesService)
+	runsService := runs.NewService(1024*1024, db, runLogsAdapter)
+	environmentService := environment.NewService("", db)
+	secretsService := secrets.NewService(make([]byte, secrets.KeySize), db)
+	runnerService := NewService(commandRunDir, filesDir, time.Minute, 64*1024, entities.OverflowDrop, entities.KillPolicy{}, runnerAdapter, commandsService, filesService, runsService, environmentService, secretsService, accessService)
 	// seed python command
 	err = db.SetCommands([]entities.Command{{Name: "Py", Command: pythonCmd, Dir: os.TempDir()}})
 	if err != nil {
 		t.Fatalf("cant set config: %v", err)
 	}
 
-	command, err := runnerService.RunCommand(ctx, 1, entities.TerminalOptions{Rows: 30, Cols: 120})
+	command, err := runnerService.RunCommand(ctx, nil, 1, "test", entities.TerminalOptions{Rows: 30, Cols: 120})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -267,14 +307,14 @@ func TestRunCommand_PythonInteractive(t *testing.T) {
 	<-time.After(1 * time.Second)
 	result := ""
 	ok := true
-	var dataOut string
+	var dataOut []byte
 	for ok {
 		select {
 		case dataOut, ok = <-command.Output:
 			if !ok {
 				break
 			}
-			result += dataOut
+			result += string(dataOut)
 		case <-time.After(2 * time.Second):
 			log.Debug(normalizeOutput(result))
 			t.Fatal("timeout waiting for result")
@@ -316,10 +356,18 @@ func TestRunCommand_EditFile(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Cant set connect filesystem: %v", err)
 	}
-	commandsService := commands.NewService(db, commandRunDir)
-	filesService := files.NewService(filesDir, 100*1024, db, db, filesystemAdapter)
+	runLogsAdapter, err := filesystem.ConnectRunLogs(filepath.Join(dataDir, "runs"))
+	if err != nil {
+		t.Fatalf("Cant set connect run logs: %v", err)
+	}
+	accessService := access.NewService(db, db, db)
+	commandsService := commands.NewService(db, commandRunDir, checker.New(ptyDir), accessService)
+	filesService := files.NewService(filesDir, 100*1024, db, db, filesystemAdapter, accessService)
 	runnerAdapter := runner.New(ptyDir, utils.DetectDefaultConsole())
-	runnerService := NewService(commandRunDir, filesDir, runnerAdapter, commandsService, filesService)
+	runsService := runs.NewService(1024*1024, db, runLogsAdapter)
+	environmentService := environment.NewService("", db)
+	secretsService := secrets.NewService(make([]byte, secrets.KeySize), db)
+	runnerService := NewService(commandRunDir, filesDir, time.Minute, 64*1024, entities.OverflowDrop, entities.KillPolicy{}, runnerAdapter, commandsService, filesService, runsService, environmentService, secretsService, accessService)
 
 	var commandText string
 	fileName := "embedded_test.txt"
@@ -334,12 +382,12 @@ func TestRunCommand_EditFile(t *testing.T) {
 	}
 	// attach embedded file content
 	fileContent := []byte("Hello from embedded file\n")
-	err = filesService.AppendFile(1, fileContent, &entities.FileParams{Filename: fileName, Size: uint64(len(fileContent))})
+	_, err = filesService.AppendFile(nil, 1, fileContent, &entities.FileParams{Filename: fileName, Size: uint64(len(fileContent))})
 	if err != nil {
 		t.Fatalf("cant append file: %v", err)
 	}
 
-	command, err := runnerService.RunCommand(ctx, 1, entities.TerminalOptions{Rows: 30, Cols: 120})
+	command, err := runnerService.RunCommand(ctx, nil, 1, "test", entities.TerminalOptions{Rows: 30, Cols: 120})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -349,14 +397,14 @@ func TestRunCommand_EditFile(t *testing.T) {
 	// drain output
 	result := ""
 	ok := true
-	var dataOut string
+	var dataOut []byte
 	for ok {
 		select {
 		case dataOut, ok = <-command.Output:
 			if !ok {
 				break
 			}
-			result += dataOut
+			result += string(dataOut)
 		case <-time.After(2 * time.Second):
 			t.Fatal("timeout waiting for result")
 			return
@@ -401,10 +449,18 @@ func TestRunCommand_ExecutionDir(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Cant set connect filesystem: %v", err)
 	}
-	commandsService := commands.NewService(db, commandRunDir)
-	filesService := files.NewService(filesDir, 1024, db, db, filesystemAdapter)
+	runLogsAdapter, err := filesystem.ConnectRunLogs(filepath.Join(dataDir, "runs"))
+	if err != nil {
+		t.Fatalf("Cant set connect run logs: %v", err)
+	}
+	accessService := access.NewService(db, db, db)
+	commandsService := commands.NewService(db, commandRunDir, checker.New(ptyDir), accessService)
+	filesService := files.NewService(filesDir, 1024, db, db, filesystemAdapter, accessService)
 	runnerAdapter := runner.New(ptyDir, utils.DetectDefaultConsole())
-	runnerService := NewService(commandRunDir, filesDir, runnerAdapter, commandsService, filesService)
+	runsService := runs.NewService(1024*1024, db, runLogsAdapter)
+	environmentService := environment.NewService("", db)
+	secretsService := secrets.NewService(make([]byte, secrets.KeySize), db)
+	runnerService := NewService(commandRunDir, filesDir, time.Minute, 64*1024, entities.OverflowDrop, entities.KillPolicy{}, runnerAdapter, commandsService, filesService, runsService, environmentService, secretsService, accessService)
 
 	var commandText string
 	if runtime.GOOS == "windows" {
@@ -417,21 +473,21 @@ func TestRunCommand_ExecutionDir(t *testing.T) {
 		t.Fatalf("cant set config: %v", err)
 	}
 
-	command, err := runnerService.RunCommand(ctx, 1, entities.TerminalOptions{Rows: 30, Cols: 120})
+	command, err := runnerService.RunCommand(ctx, nil, 1, "test", entities.TerminalOptions{Rows: 30, Cols: 120})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 	// drain output
 	result := ""
 	ok := true
-	var dataOut string
+	var dataOut []byte
 	for ok {
 		select {
 		case dataOut, ok = <-command.Output:
 			if !ok {
 				break
 			}
-			result += dataOut
+			result += string(dataOut)
 		case <-time.After(3 * time.Second):
 			t.Fatal("timeout waiting for result")
 			return
@@ -473,23 +529,31 @@ func TestRunCommand_WithFile(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Cant set connect filesystem: %v", err)
 	}
-	commandsService := commands.NewService(db, commandRunDir)
-	filesService := files.NewService(filesDir, 1024, db, db, filesystemAdapter)
+	runLogsAdapter, err := filesystem.ConnectRunLogs(filepath.Join(dataDir, "runs"))
+	if err != nil {
+		t.Fatalf("Cant set connect run logs: %v", err)
+	}
+	accessService := access.NewService(db, db, db)
+	commandsService := commands.NewService(db, commandRunDir, checker.New(ptyDir), accessService)
+	filesService := files.NewService(filesDir, 1024, db, db, filesystemAdapter, accessService)
 	runnerAdapter := runner.New(ptyDir, utils.DetectDefaultConsole())
-	runnerService := NewService(commandRunDir, filesDir, runnerAdapter, commandsService, filesService)
+	runsService := runs.NewService(1024*1024, db, runLogsAdapter)
+	environmentService := environment.NewService("", db)
+	secretsService := secrets.NewService(make([]byte, secrets.KeySize), db)
+	runnerService := NewService(commandRunDir, filesDir, time.Minute, 64*1024, entities.OverflowDrop, entities.KillPolicy{}, runnerAdapter, commandsService, filesService, runsService, environmentService, secretsService, accessService)
 
 	err = db.SetCommands([]entities.Command{{Name: "Test", Command: "more test-file.txt", Dir: os.TempDir()}})
 	if err != nil {
 		t.Fatalf("cant set config: %v", err)
 	}
-	err = filesService.AppendFile(1, []byte("test data"), &entities.FileParams{Filename: "test-file.txt", Size: uint64(len([]byte("test data")))})
+	_, err = filesService.AppendFile(nil, 1, []byte("test data"), &entities.FileParams{Filename: "test-file.txt", Size: uint64(len([]byte("test data")))})
 	if err != nil {
 		return
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
 
-	command, err := runnerService.RunCommand(ctx, 1, entities.TerminalOptions{Rows: 30, Cols: 120})
+	command, err := runnerService.RunCommand(ctx, nil, 1, "test", entities.TerminalOptions{Rows: 30, Cols: 120})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -498,14 +562,14 @@ func TestRunCommand_WithFile(t *testing.T) {
 	}
 	result := ""
 	ok := true
-	var dataOut string
+	var dataOut []byte
 	for ok {
 		select {
 		case dataOut, ok = <-command.Output:
 			if !ok {
 				break
 			}
-			result += dataOut
+			result += string(dataOut)
 		case <-time.After(1 * time.Second):
 			t.Fatal("timeout waiting for result")
 			return
@@ -516,3 +580,1894 @@ func TestRunCommand_WithFile(t *testing.T) {
 		t.Fatalf("unexpected output: %q, need 'test data\\r'", out)
 	}
 }
+
+func TestRunCommand_FilesDeletedOnStartError(t *testing.T) {
+	log.SetLevel(0)
+	tmpDir, cleanup := testutils.CreateTempDataFolder(t)
+	defer cleanup()
+	commandRunDir := filepath.Join(tmpDir, "command_run")
+	_ = os.MkdirAll(commandRunDir, 0750)
+	dataDir := filepath.Join(tmpDir, "data")
+	filesDir := filepath.Join(dataDir, "files123")
+	ptyDir := "../../../pty"
+
+	db, err := database.Connect(dataDir)
+	if err != nil {
+		t.Fatalf("Cant create db: %v", err)
+	}
+	defer func(u database.DB) {
+		err := db.Close()
+		if err != nil {
+			t.Errorf("Error closing db: %v", err)
+		}
+	}(db)
+	filesystemAdapter, err := filesystem.Connect(filesDir)
+	if err != nil {
+		t.Fatalf("Cant set connect filesystem: %v", err)
+	}
+	runLogsAdapter, err := filesystem.ConnectRunLogs(filepath.Join(dataDir, "runs"))
+	if err != nil {
+		t.Fatalf("Cant set connect run logs: %v", err)
+	}
+	accessService := access.NewService(db, db, db)
+	commandsService := commands.NewService(db, commandRunDir, checker.New(ptyDir), accessService)
+	filesService := files.NewService(filesDir, 1024, db, db, filesystemAdapter, accessService)
+	runnerAdapter := runner.New(ptyDir, utils.DetectDefaultConsole())
+	runsService := runs.NewService(1024*1024, db, runLogsAdapter)
+	environmentService := environment.NewService("", db)
+	secretsService := secrets.NewService(make([]byte, secrets.KeySize), db)
+	runnerService := NewService(commandRunDir, filesDir, time.Minute, 64*1024, entities.OverflowDrop, entities.KillPolicy{}, runnerAdapter, commandsService, filesService, runsService, environmentService, secretsService, accessService)
+
+	// Interpreter is checked to be installed only on save, so process fails to start after files are copied
+	err = db.SetCommands([]entities.Command{{Name: "Test", Command: "cat test-file.txt", Dir: commandRunDir, Interpreter: []string{"not-installed-interpreter", "-c", "{{command}}"}}})
+	if err != nil {
+		t.Fatalf("cant set config: %v", err)
+	}
+	if _, err := filesService.AppendFile(nil, 1, []byte("test data"), &entities.FileParams{Filename: "test-file.txt", Size: 9}); err != nil {
+		t.Fatalf("cant append file: %v", err)
+	}
+	if _, err := runnerService.RunCommand(context.Background(), nil, 1, "test", entities.TerminalOptions{Rows: 30, Cols: 120}); err == nil {
+		t.Fatal("expected error for not installed interpreter")
+	}
+	if _, err := os.Stat(filepath.Join(commandRunDir, "test-file.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("file of not started command is not deleted: %v", err)
+	}
+}
+
+func TestAttachSession_Reattach(t *testing.T) {
+	log.SetLevel(0)
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh syntax")
+	}
+	tmpDir, cleanup := testutils.CreateTempDataFolder(t)
+	defer cleanup()
+	commandRunDir := filepath.Join(tmpDir, "command_run")
+	_ = os.MkdirAll(commandRunDir, 0750)
+	dataDir := filepath.Join(tmpDir, "data")
+	filesDir := filepath.Join(dataDir, "files123")
+	ptyDir := "../../../pty"
+
+	db, err := database.Connect(dataDir)
+	if err != nil {
+		t.Fatalf("Cant create db: %v", err)
+	}
+	defer func(u database.DB) {
+		err := db.Close()
+		if err != nil {
+			t.Errorf("Error closing db: %v", err)
+		}
+	}(db)
+	filesystemAdapter, err := filesystem.Connect(filesDir)
+	if err != nil {
+		t.Fatalf("Cant set connect filesystem: %v", err)
+	}
+	runLogsAdapter, err := filesystem.ConnectRunLogs(filepath.Join(dataDir, "runs"))
+	if err != nil {
+		t.Fatalf("Cant set connect run logs: %v", err)
+	}
+	accessService := access.NewService(db, db, db)
+	commandsService := commands.NewService(db, commandRunDir, checker.New(ptyDir), accessService)
+	filesService := files.NewService(filesDir, 1024, db, db, filesystemAdapter, accessService)
+	runnerAdapter := runner.New(ptyDir, utils.DetectDefaultConsole())
+	runsService := runs.NewService(1024*1024, db, runLogsAdapter)
+	environmentService := environment.NewService("", db)
+	secretsService := secrets.NewService(make([]byte, secrets.KeySize), db)
+	runnerService := NewService(commandRunDir, filesDir, time.Minute, 64*1024, entities.OverflowDrop, entities.KillPolicy{}, runnerAdapter, commandsService, filesService, runsService, environmentService, secretsService, accessService)
+
+	err = db.SetCommands([]entities.Command{{Name: "Slow", Command: "echo first; sleep 1; echo second", Dir: os.TempDir()}})
+	if err != nil {
+		t.Fatalf("cant set config: %v", err)
+	}
+	firstCtx, firstCancel := context.WithCancel(context.Background())
+	command, err := runnerService.RunCommand(firstCtx, nil, 1, "test", entities.TerminalOptions{Rows: 30, Cols: 120})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if command.SessionID == "" {
+		t.Fatal("session id is empty")
+	}
+	select {
+	case <-command.Output:
+	case <-time.After(1 * time.Second):
+		t.Fatal("timeout waiting for first output")
+	}
+	// Client disconnected, command must keep running
+	firstCancel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	attached, err := runnerService.AttachSession(ctx, nil, command.SessionID, "test", entities.ViewerController)
+	if err != nil {
+		t.Fatalf("unexpected error while attaching: %v", err)
+	}
+	result := ""
+	ok := true
+	var dataOut []byte
+	for ok {
+		select {
+		case dataOut, ok = <-attached.Output:
+			if !ok {
+				break
+			}
+			result += string(dataOut)
+		case <-time.After(2 * time.Second):
+			t.Fatal("timeout waiting for result")
+			return
+		}
+	}
+	out := normalizeOutput(result)
+	if out != "first\rsecond\r" {
+		t.Fatalf("unexpected output after reattach: %q, need scrollback replay and new output", out)
+	}
+}
+
+func TestAttachSession_Viewers(t *testing.T) {
+	log.SetLevel(0)
+	tmpDir, cleanup := testutils.CreateTempDataFolder(t)
+	defer cleanup()
+	commandRunDir := filepath.Join(tmpDir, "command_run")
+	_ = os.MkdirAll(commandRunDir, 0750)
+	dataDir := filepath.Join(tmpDir, "data")
+	filesDir := filepath.Join(dataDir, "files123")
+	ptyDir := "../../../pty"
+
+	db, err := database.Connect(dataDir)
+	if err != nil {
+		t.Fatalf("Cant create db: %v", err)
+	}
+	defer func(u database.DB) {
+		err := db.Close()
+		if err != nil {
+			t.Errorf("Error closing db: %v", err)
+		}
+	}(db)
+	filesystemAdapter, err := filesystem.Connect(filesDir)
+	if err != nil {
+		t.Fatalf("Cant set connect filesystem: %v", err)
+	}
+	runLogsAdapter, err := filesystem.ConnectRunLogs(filepath.Join(dataDir, "runs"))
+	if err != nil {
+		t.Fatalf("Cant set connect run logs: %v", err)
+	}
+	accessService := access.NewService(db, db, db)
+	commandsService := commands.NewService(db, commandRunDir, checker.New(ptyDir), accessService)
+	filesService := files.NewService(filesDir, 1024, db, db, filesystemAdapter, accessService)
+	runnerAdapter := runner.New(ptyDir, utils.DetectDefaultConsole())
+	runsService := runs.NewService(1024*1024, db, runLogsAdapter)
+	environmentService := environment.NewService("", db)
+	secretsService := secrets.NewService(make([]byte, secrets.KeySize), db)
+	runnerService := NewService(commandRunDir, filesDir, time.Minute, 64*1024, entities.OverflowDrop, entities.KillPolicy{}, runnerAdapter, commandsService, filesService, runsService, environmentService, secretsService, accessService)
+
+	err = db.SetCommands([]entities.Command{{Name: "Slow", Command: "echo first; sleep 1; echo second", Dir: os.TempDir()}})
+	if err != nil {
+		t.Fatalf("cant set config: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	controller, err := runnerService.RunCommand(ctx, nil, 1, "owner", entities.TerminalOptions{Rows: 30, Cols: 120})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := runnerService.AttachSession(ctx, nil, controller.SessionID, "guest", "admin"); !errors.Is(err, projectErrors.ErrBadViewerRole) {
+		t.Fatalf("unexpected error for bad role: %v", err)
+	}
+	spectator, err := runnerService.AttachSession(ctx, nil, controller.SessionID, "guest", entities.ViewerSpectator)
+	if err != nil {
+		t.Fatalf("unexpected error while attaching: %v", err)
+	}
+
+	select {
+	case viewers := <-spectator.Viewers:
+		if len(viewers) != 2 || viewers[0].Name != "owner" || viewers[1].Role != entities.ViewerSpectator {
+			t.Fatalf("unexpected viewers: %+v", viewers)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for viewers")
+	}
+	sessions := runnerService.GetSessions(nil)
+	if len(sessions) != 1 || len(sessions[0].Viewers) != 2 || sessions[0].CommandID != 1 {
+		t.Fatalf("unexpected sessions: %+v", sessions)
+	}
+	if err := runnerService.SignalSession(nil, controller.SessionID, spectator.Viewer.ID, entities.SignalKill); !errors.Is(err, projectErrors.ErrSpectator) {
+		t.Fatalf("spectator can control command: %v", err)
+	}
+	if err := runnerService.ResizeSession(nil, controller.SessionID, spectator.Viewer.ID, 100, 40); !errors.Is(err, projectErrors.ErrSpectator) {
+		t.Fatalf("spectator can resize terminal: %v", err)
+	}
+
+	for _, client := range []*entities.CommandInputOutput{controller, spectator} {
+		result := ""
+		for data := range client.Output {
+			result += string(data)
+		}
+		if out := normalizeOutput(result); out != "first\rsecond\r" {
+			t.Fatalf("unexpected output of %s: %q", client.Viewer.Role, out)
+		}
+	}
+}
+
+func TestAttachSession_NotFound(t *testing.T) {
+	log.SetLevel(0)
+	runnerService := NewService("", "", time.Minute, 64*1024, entities.OverflowDrop, entities.KillPolicy{}, nil, nil, nil, nil, nil, nil, nil)
+	_, err := runnerService.AttachSession(context.Background(), nil, "unknown", "test", entities.ViewerController)
+	if !errors.Is(err, projectErrors.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	err = runnerService.TerminateSession(nil, "unknown")
+	if !errors.Is(err, projectErrors.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestTerminateSession(t *testing.T) {
+	log.SetLevel(0)
+	tmpDir, cleanup := testutils.CreateTempDataFolder(t)
+	defer cleanup()
+	commandRunDir := filepath.Join(tmpDir, "command_run")
+	_ = os.MkdirAll(commandRunDir, 0750)
+	dataDir := filepath.Join(tmpDir, "data")
+	filesDir := filepath.Join(dataDir, "files123")
+	ptyDir := "../../../pty"
+
+	db, err := database.Connect(dataDir)
+	if err != nil {
+		t.Fatalf("Cant create db: %v", err)
+	}
+	defer func(u database.DB) {
+		err := db.Close()
+		if err != nil {
+			t.Errorf("Error closing db: %v", err)
+		}
+	}(db)
+	filesystemAdapter, err := filesystem.Connect(filesDir)
+	if err != nil {
+		t.Fatalf("Cant set connect filesystem: %v", err)
+	}
+	runLogsAdapter, err := filesystem.ConnectRunLogs(filepath.Join(dataDir, "runs"))
+	if err != nil {
+		t.Fatalf("Cant set connect run logs: %v", err)
+	}
+	accessService := access.NewService(db, db, db)
+	commandsService := commands.NewService(db, commandRunDir, checker.New(ptyDir), accessService)
+	filesService := files.NewService(filesDir, 1024, db, db, filesystemAdapter, accessService)
+	runnerAdapter := runner.New(ptyDir, utils.DetectDefaultConsole())
+	runsService := runs.NewService(1024*1024, db, runLogsAdapter)
+	environmentService := environment.NewService("", db)
+	secretsService := secrets.NewService(make([]byte, secrets.KeySize), db)
+	runnerService := NewService(commandRunDir, filesDir, time.Minute, 64*1024, entities.OverflowDrop, entities.KillPolicy{}, runnerAdapter, commandsService, filesService, runsService, environmentService, secretsService, accessService)
+
+	longCommand := "sleep 10"
+	if runtime.GOOS == "windows" {
+		longCommand = "ping -n 10 127.0.0.1"
+	}
+	err = db.SetCommands([]entities.Command{{Name: "Long", Command: longCommand, Dir: os.TempDir()}})
+	if err != nil {
+		t.Fatalf("cant set config: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	command, err := runnerService.RunCommand(ctx, nil, 1, "test", entities.TerminalOptions{Rows: 30, Cols: 120})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := runnerService.TerminateSession(nil, command.SessionID); err != nil {
+		t.Fatalf("unexpected error while terminating: %v", err)
+	}
+	for {
+		select {
+		case _, ok := <-command.Output:
+			if !ok {
+				return
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("timeout waiting for output channel to close after terminate")
+			return
+		}
+	}
+}
+
+func TestRunCommand_RecordsRun(t *testing.T) {
+	log.SetLevel(0)
+	tmpDir, cleanup := testutils.CreateTempDataFolder(t)
+	defer cleanup()
+	commandRunDir := filepath.Join(tmpDir, "command_run")
+	_ = os.MkdirAll(commandRunDir, 0750)
+	dataDir := filepath.Join(tmpDir, "data")
+	filesDir := filepath.Join(dataDir, "files123")
+	ptyDir := "../../../pty"
+
+	db, err := database.Connect(dataDir)
+	if err != nil {
+		t.Fatalf("Cant create db: %v", err)
+	}
+	defer func(u database.DB) {
+		err := db.Close()
+		if err != nil {
+			t.Errorf("Error closing db: %v", err)
+		}
+	}(db)
+	filesystemAdapter, err := filesystem.Connect(filesDir)
+	if err != nil {
+		t.Fatalf("Cant set connect filesystem: %v", err)
+	}
+	runLogsAdapter, err := filesystem.ConnectRunLogs(filepath.Join(dataDir, "runs"))
+	if err != nil {
+		t.Fatalf("Cant set connect run logs: %v", err)
+	}
+	accessService := access.NewService(db, db, db)
+	commandsService := commands.NewService(db, commandRunDir, checker.New(ptyDir), accessService)
+	filesService := files.NewService(filesDir, 1024, db, db, filesystemAdapter, accessService)
+	runnerAdapter := runner.New(ptyDir, utils.DetectDefaultConsole())
+	runsService := runs.NewService(1024*1024, db, runLogsAdapter)
+	environmentService := environment.NewService("", db)
+	secretsService := secrets.NewService(make([]byte, secrets.KeySize), db)
+	runnerService := NewService(commandRunDir, filesDir, time.Minute, 64*1024, entities.OverflowDrop, entities.KillPolicy{}, runnerAdapter, commandsService, filesService, runsService, environmentService, secretsService, accessService)
+
+	err = db.SetCommands([]entities.Command{{Name: "Exit", Command: "echo hello && exit 3", Dir: os.TempDir()}})
+	if err != nil {
+		t.Fatalf("cant set config: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	command, err := runnerService.RunCommand(ctx, nil, 1, "tester", entities.TerminalOptions{Rows: 30, Cols: 120})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for range command.Output {
+	}
+
+	var run entities.Run
+	for try := 0; ; try++ {
+		commandRuns, err := runsService.GetCommandRuns(1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(commandRuns) == 1 && commandRuns[0].FinishedAt != nil {
+			run = commandRuns[0]
+			break
+		}
+		if try == 20 {
+			t.Fatal("timeout waiting for run to be finished in history")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if run.ExitCode == nil || *run.ExitCode != 3 {
+		t.Fatalf("unexpected exit code: %v, need 3", run.ExitCode)
+	}
+	if run.TriggeredBy != "tester" || run.SessionID != command.SessionID {
+		t.Fatalf("unexpected run info: %+v", run)
+	}
+	output, err := runsService.GetRunOutput(run.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if normalizeOutput(string(output)) != "hello\r" {
+		t.Fatalf("unexpected stored output: %q", output)
+	}
+}
+
+func TestRunCommand_ExitStatus(t *testing.T) {
+	log.SetLevel(0)
+	testCases := []struct {
+		name           string
+		command        string
+		terminate      bool
+		expectedCode   int
+		expectedSignal string
+		unixOnly       bool
+	}{
+		{
+			name:         "Success",
+			command:      "echo ok",
+			expectedCode: 0,
+		},
+		{
+			name:         "Exit code",
+			command:      "exit 3",
+			expectedCode: 3,
+		},
+		{
+			name:           "Killed",
+			command:        "sleep 10",
+			terminate:      true,
+			expectedCode:   -1,
+			expectedSignal: "SIGKILL",
+			unixOnly:       true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.unixOnly && runtime.GOOS == "windows" {
+				t.Skip("unix only")
+			}
+			tmpDir, cleanup := testutils.CreateTempDataFolder(t)
+			defer cleanup()
+			commandRunDir := filepath.Join(tmpDir, "command_run")
+			_ = os.MkdirAll(commandRunDir, 0750)
+			dataDir := filepath.Join(tmpDir, "data")
+			filesDir := filepath.Join(dataDir, "files123")
+			ptyDir := "../../../pty"
+
+			db, err := database.Connect(dataDir)
+			if err != nil {
+				t.Fatalf("Cant create db: %v", err)
+			}
+			defer func(u database.DB) {
+				err := db.Close()
+				if err != nil {
+					t.Errorf("Error closing db: %v", err)
+				}
+			}(db)
+			filesystemAdapter, err := filesystem.Connect(filesDir)
+			if err != nil {
+				t.Fatalf("Cant set connect filesystem: %v", err)
+			}
+			runLogsAdapter, err := filesystem.ConnectRunLogs(filepath.Join(dataDir, "runs"))
+			if err != nil {
+				t.Fatalf("Cant set connect run logs: %v", err)
+			}
+			accessService := access.NewService(db, db, db)
+			commandsService := commands.NewService(db, commandRunDir, checker.New(ptyDir), accessService)
+			filesService := files.NewService(filesDir, 1024, db, db, filesystemAdapter, accessService)
+			runnerAdapter := runner.New(ptyDir, utils.DetectDefaultConsole())
+			runsService := runs.NewService(1024*1024, db, runLogsAdapter)
+			environmentService := environment.NewService("", db)
+			secretsService := secrets.NewService(make([]byte, secrets.KeySize), db)
+			runnerService := NewService(commandRunDir, filesDir, time.Minute, 64*1024, entities.OverflowDrop, entities.KillPolicy{}, runnerAdapter, commandsService, filesService, runsService, environmentService, secretsService, accessService)
+
+			err = db.SetCommands([]entities.Command{{Name: "Exit", Command: tc.command, Dir: os.TempDir()}})
+			if err != nil {
+				t.Fatalf("cant set config: %v", err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+			command, err := runnerService.RunCommand(ctx, nil, 1, "test", entities.TerminalOptions{Rows: 30, Cols: 120})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tc.terminate {
+				if err := runnerService.TerminateSession(nil, command.SessionID); err != nil {
+					t.Fatalf("unexpected error while terminating: %v", err)
+				}
+			}
+			for range command.Output {
+			}
+			select {
+			case exitStatus := <-command.Exit:
+				if exitStatus.Code != tc.expectedCode || exitStatus.Signal != tc.expectedSignal {
+					t.Fatalf("unexpected exit status: %+v, need code %d and signal %q", exitStatus, tc.expectedCode, tc.expectedSignal)
+				}
+			default:
+				t.Fatal("exit status not received before output closed")
+			}
+		})
+	}
+}
+
+func TestStartCommand_Headless(t *testing.T) {
+	log.SetLevel(0)
+	tmpDir, cleanup := testutils.CreateTempDataFolder(t)
+	defer cleanup()
+	commandRunDir := filepath.Join(tmpDir, "command_run")
+	_ = os.MkdirAll(commandRunDir, 0750)
+	dataDir := filepath.Join(tmpDir, "data")
+	filesDir := filepath.Join(dataDir, "files123")
+	ptyDir := "../../../pty"
+
+	db, err := database.Connect(dataDir)
+	if err != nil {
+		t.Fatalf("Cant create db: %v", err)
+	}
+	defer func(u database.DB) {
+		err := db.Close()
+		if err != nil {
+			t.Errorf("Error closing db: %v", err)
+		}
+	}(db)
+	filesystemAdapter, err := filesystem.Connect(filesDir)
+	if err != nil {
+		t.Fatalf("Cant set connect filesystem: %v", err)
+	}
+	runLogsAdapter, err := filesystem.ConnectRunLogs(filepath.Join(dataDir, "runs"))
+	if err != nil {
+		t.Fatalf("Cant set connect run logs: %v", err)
+	}
+	accessService := access.NewService(db, db, db)
+	commandsService := commands.NewService(db, commandRunDir, checker.New(ptyDir), accessService)
+	filesService := files.NewService(filesDir, 1024, db, db, filesystemAdapter, accessService)
+	runnerAdapter := runner.New(ptyDir, utils.DetectDefaultConsole())
+	runsService := runs.NewService(1024*1024, db, runLogsAdapter)
+	environmentService := environment.NewService("", db)
+	secretsService := secrets.NewService(make([]byte, secrets.KeySize), db)
+	runnerService := NewService(commandRunDir, filesDir, time.Minute, 64*1024, entities.OverflowDrop, entities.KillPolicy{}, runnerAdapter, commandsService, filesService, runsService, environmentService, secretsService, accessService)
+
+	longCommand := "sleep 10"
+	if runtime.GOOS == "windows" {
+		longCommand = "ping -n 10 127.0.0.1"
+	}
+	err = db.SetCommands([]entities.Command{
+		{Name: "Echo", Command: "echo headless", Dir: os.TempDir()},
+		{Name: "Long", Command: longCommand, Dir: os.TempDir()},
+	})
+	if err != nil {
+		t.Fatalf("cant set config: %v", err)
+	}
+
+	run, err := runnerService.StartCommand(nil, 1, "script", entities.TerminalOptions{Rows: 24, Cols: 80})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if run.ID == 0 || run.SessionID == "" || run.TriggeredBy != "script" {
+		t.Fatalf("unexpected started run: %+v", run)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	exitStatus, err := runnerService.WaitSession(ctx, nil, run.SessionID)
+	if err != nil {
+		t.Fatalf("unexpected error while waiting: %v", err)
+	}
+	if exitStatus.Code != 0 {
+		t.Fatalf("unexpected exit code: %d", exitStatus.Code)
+	}
+	finishedRun, err := runsService.GetRun(run.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if finishedRun.FinishedAt == nil {
+		t.Fatal("run not finished in history after wait")
+	}
+	output, err := runsService.GetRunOutput(run.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if normalizeOutput(string(output)) != "headless\r" {
+		t.Fatalf("unexpected output: %q", output)
+	}
+
+	run, err = runnerService.StartCommand(nil, 2, "script", entities.TerminalOptions{Rows: 24, Cols: 80})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	shortCtx, shortCancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer shortCancel()
+	_, err = runnerService.WaitSession(shortCtx, nil, run.SessionID)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected wait timeout, got %v", err)
+	}
+	if err := runnerService.TerminateSession(nil, run.SessionID); err != nil {
+		t.Fatalf("unexpected error while terminating: %v", err)
+	}
+	_, err = runnerService.WaitSession(ctx, nil, run.SessionID)
+	if err != nil {
+		t.Fatalf("unexpected error while waiting terminated command: %v", err)
+	}
+}
+
+func TestStreamSession(t *testing.T) {
+	log.SetLevel(0)
+	if runtime.GOOS == "windows" {
+		t.Skip("unix only")
+	}
+	tmpDir, cleanup := testutils.CreateTempDataFolder(t)
+	defer cleanup()
+	commandRunDir := filepath.Join(tmpDir, "command_run")
+	_ = os.MkdirAll(commandRunDir, 0750)
+	dataDir := filepath.Join(tmpDir, "data")
+	filesDir := filepath.Join(dataDir, "files123")
+	ptyDir := "../../../pty"
+
+	db, err := database.Connect(dataDir)
+	if err != nil {
+		t.Fatalf("Cant create db: %v", err)
+	}
+	defer func(u database.DB) {
+		err := db.Close()
+		if err != nil {
+			t.Errorf("Error closing db: %v", err)
+		}
+	}(db)
+	filesystemAdapter, err := filesystem.Connect(filesDir)
+	if err != nil {
+		t.Fatalf("Cant set connect filesystem: %v", err)
+	}
+	runLogsAdapter, err := filesystem.ConnectRunLogs(filepath.Join(dataDir, "runs"))
+	if err != nil {
+		t.Fatalf("Cant set connect run logs: %v", err)
+	}
+	accessService := access.NewService(db, db, db)
+	commandsService := commands.NewService(db, commandRunDir, checker.New(ptyDir), accessService)
+	filesService := files.NewService(filesDir, 1024, db, db, filesystemAdapter, accessService)
+	runnerAdapter := runner.New(ptyDir, utils.DetectDefaultConsole())
+	runsService := runs.NewService(1024*1024, db, runLogsAdapter)
+	environmentService := environment.NewService("", db)
+	secretsService := secrets.NewService(make([]byte, secrets.KeySize), db)
+	runnerService := NewService(commandRunDir, filesDir, time.Minute, 64*1024, entities.OverflowDrop, entities.KillPolicy{}, runnerAdapter, commandsService, filesService, runsService, environmentService, secretsService, accessService)
+
+	err = db.SetCommands([]entities.Command{{Name: "Echo", Command: "echo first; sleep 0.3; echo second", Dir: os.TempDir()}})
+	if err != nil {
+		t.Fatalf("cant set config: %v", err)
+	}
+	run, err := runnerService.StartCommand(nil, 1, "script", entities.TerminalOptions{Rows: 24, Cols: 80})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stream, err := runnerService.StreamSession(ctx, nil, run.SessionID, "dashboard", -1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status := <-stream.Status; status.State != entities.SessionRunning {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+	var events []entities.OutputEvent
+	result := ""
+	for event := range stream.Output {
+		events = append(events, event)
+		result += string(event.Data)
+		if event.Offset != int64(len(result)) {
+			t.Fatalf("unexpected offset %d after %q", event.Offset, result)
+		}
+	}
+	if normalizeOutput(result) != "first\rsecond\r" {
+		t.Fatalf("unexpected output: %q", result)
+	}
+	select {
+	case exitStatus := <-stream.Exit:
+		if exitStatus.Code != 0 {
+			t.Fatalf("unexpected exit status: %+v", exitStatus)
+		}
+	default:
+		t.Fatal("exit status not received before output closed")
+	}
+
+	// Resumed stream continues after the first chunk
+	resumed, err := runnerService.StreamSession(ctx, nil, run.SessionID, "dashboard", events[0].Offset)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resumedResult := ""
+	for event := range resumed.Output {
+		resumedResult += string(event.Data)
+	}
+	if string(events[0].Data)+resumedResult != result {
+		t.Fatalf("unexpected resumed output: %q", resumedResult)
+	}
+}
+
+func TestRunCommand_PipeMode(t *testing.T) {
+	log.SetLevel(0)
+	if runtime.GOOS == "windows" {
+		t.Skip("unix only")
+	}
+	tmpDir, cleanup := testutils.CreateTempDataFolder(t)
+	defer cleanup()
+	commandRunDir := filepath.Join(tmpDir, "command_run")
+	_ = os.MkdirAll(commandRunDir, 0750)
+	dataDir := filepath.Join(tmpDir, "data")
+	filesDir := filepath.Join(dataDir, "files123")
+	ptyDir := "../../../pty"
+
+	db, err := database.Connect(dataDir)
+	if err != nil {
+		t.Fatalf("Cant create db: %v", err)
+	}
+	defer func(u database.DB) {
+		err := db.Close()
+		if err != nil {
+			t.Errorf("Error closing db: %v", err)
+		}
+	}(db)
+	filesystemAdapter, err := filesystem.Connect(filesDir)
+	if err != nil {
+		t.Fatalf("Cant set connect filesystem: %v", err)
+	}
+	runLogsAdapter, err := filesystem.ConnectRunLogs(filepath.Join(dataDir, "runs"))
+	if err != nil {
+		t.Fatalf("Cant set connect run logs: %v", err)
+	}
+	accessService := access.NewService(db, db, db)
+	commandsService := commands.NewService(db, commandRunDir, checker.New(ptyDir), accessService)
+	filesService := files.NewService(filesDir, 1024, db, db, filesystemAdapter, accessService)
+	runnerAdapter := runner.New(ptyDir, utils.DetectDefaultConsole())
+	runsService := runs.NewService(1024*1024, db, runLogsAdapter)
+	environmentService := environment.NewService("", db)
+	secretsService := secrets.NewService(make([]byte, secrets.KeySize), db)
+	runnerService := NewService(commandRunDir, filesDir, time.Minute, 64*1024, entities.OverflowDrop, entities.KillPolicy{}, runnerAdapter, commandsService, filesService, runsService, environmentService, secretsService, accessService)
+
+	err = db.SetCommands([]entities.Command{
+		{Name: "Pipe", Command: "cat; sleep 0.1; echo err >&2; sleep 0.1; echo done", Dir: os.TempDir(), ExecutionMode: entities.ExecutionModePipe},
+		{Name: "Terminal", Command: "cat", Dir: os.TempDir()},
+		{Name: "Background", Command: "(sleep 0.3; echo alive > background) > /dev/null 2>&1 &", Dir: tmpDir, ExecutionMode: entities.ExecutionModePipe},
+	})
+	if err != nil {
+		t.Fatalf("cant set config: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t.Run("Headless with stdin", func(t *testing.T) {
+		run, err := runnerService.StartCommand(nil, 1, "script", entities.TerminalOptions{Stdin: strings.NewReader("input\n")})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		stream, err := runnerService.StreamSession(ctx, nil, run.SessionID, "script", -1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var received []string
+		for event := range stream.Output {
+			received = append(received, string(event.Source)+":"+string(event.Data))
+		}
+		expected := []string{"stdout:input\n", "stderr:err\n", "stdout:done\n"}
+		if strings.Join(received, "") != strings.Join(expected, "") {
+			t.Fatalf("unexpected output: %q", received)
+		}
+		if exitStatus, err := runnerService.WaitSession(ctx, nil, run.SessionID); err != nil || exitStatus.Code != 0 {
+			t.Fatalf("unexpected exit: %v, %v", exitStatus, err)
+		}
+		stdout, err := runsService.GetRunSourceOutput(run.ID, entities.OutputStdout)
+		if err != nil || string(stdout) != "input\ndone\n" {
+			t.Fatalf("unexpected stdout: %q, %v", stdout, err)
+		}
+		stderr, err := runsService.GetRunSourceOutput(run.ID, entities.OutputStderr)
+		if err != nil || string(stderr) != "err\n" {
+			t.Fatalf("unexpected stderr: %q, %v", stderr, err)
+		}
+	})
+
+	t.Run("Interactive", func(t *testing.T) {
+		commandIO, err := runnerService.RunCommand(ctx, nil, 1, "user", entities.TerminalOptions{Rows: 24, Cols: 80})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		commandIO.Input <- "typed\r"
+		commandIO.Input <- "\x04"
+		result := ""
+		for data := range commandIO.Output {
+			result += string(data)
+		}
+		// Terminal client gets line feeds with carriage returns and red stderr
+		if result != "typed\r\n\x1b[31merr\r\n\x1b[39mdone\r\n" {
+			t.Fatalf("unexpected output: %q", result)
+		}
+	})
+
+	t.Run("Stdin for terminal command", func(t *testing.T) {
+		_, err := runnerService.StartCommand(nil, 2, "script", entities.TerminalOptions{Stdin: strings.NewReader("input")})
+		if !errors.Is(err, projectErrors.ErrStdinNotPiped) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Processes left in background", func(t *testing.T) {
+		run, err := runnerService.StartCommand(nil, 3, "script", entities.TerminalOptions{Stdin: strings.NewReader("")})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := runnerService.WaitSession(ctx, nil, run.SessionID); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		time.Sleep(500 * time.Millisecond)
+		if _, err := os.Stat(filepath.Join(tmpDir, "background")); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("process left in background is not killed: %v", err)
+		}
+	})
+}
+
+func TestRunCommand_Parameters(t *testing.T) {
+	log.SetLevel(0)
+	if runtime.GOOS == "windows" {
+		t.Skip("cmd echo prints quotes of arguments")
+	}
+	tmpDir, cleanup := testutils.CreateTempDataFolder(t)
+	defer cleanup()
+	commandRunDir := filepath.Join(tmpDir, "command_run")
+	_ = os.MkdirAll(commandRunDir, 0750)
+	dataDir := filepath.Join(tmpDir, "data")
+	filesDir := filepath.Join(dataDir, "files123")
+	ptyDir := "../../../pty"
+
+	db, err := database.Connect(dataDir)
+	if err != nil {
+		t.Fatalf("Cant create db: %v", err)
+	}
+	defer func(u database.DB) {
+		err := db.Close()
+		if err != nil {
+			t.Errorf("Error closing db: %v", err)
+		}
+	}(db)
+	filesystemAdapter, err := filesystem.Connect(filesDir)
+	if err != nil {
+		t.Fatalf("Cant set connect filesystem: %v", err)
+	}
+	runLogsAdapter, err := filesystem.ConnectRunLogs(filepath.Join(dataDir, "runs"))
+	if err != nil {
+		t.Fatalf("Cant set connect run logs: %v", err)
+	}
+	accessService := access.NewService(db, db, db)
+	commandsService := commands.NewService(db, commandRunDir, checker.New(ptyDir), accessService)
+	filesService := files.NewService(filesDir, 1024, db, db, filesystemAdapter, accessService)
+	runnerAdapter := runner.New(ptyDir, utils.DetectDefaultConsole())
+	runsService := runs.NewService(1024*1024, db, runLogsAdapter)
+	environmentService := environment.NewService("", db)
+	secretsService := secrets.NewService(make([]byte, secrets.KeySize), db)
+	runnerService := NewService(commandRunDir, filesDir, time.Minute, 64*1024, entities.OverflowDrop, entities.KillPolicy{}, runnerAdapter, commandsService, filesService, runsService, environmentService, secretsService, accessService)
+
+	err = db.SetCommands([]entities.Command{{
+		Name:    "Greet",
+		Command: "echo {{greeting}} {{ count }} {{undeclared}}",
+		Dir:     os.TempDir(),
+		Parameters: []entities.CommandParameter{
+			{Name: "greeting", Default: "hello"},
+			{Name: "count", Type: entities.ParameterTypeNumber, Default: "1"},
+		},
+	}})
+	if err != nil {
+		t.Fatalf("cant set config: %v", err)
+	}
+
+	testCases := []struct {
+		name           string
+		parameters     map[string]string
+		expectedOutput string
+		expectedError  error
+	}{
+		{
+			name:           "Defaults",
+			expectedOutput: "hello 1 {{undeclared}}\r",
+		},
+		{
+			name:           "Values",
+			parameters:     map[string]string{"greeting": "hi there", "count": "2.5"},
+			expectedOutput: "hi there 2.5 {{undeclared}}\r",
+		},
+		{
+			name:           "Shell injection quoted",
+			parameters:     map[string]string{"greeting": "it's; echo $HOME `id` && exit 7"},
+			expectedOutput: "it's; echo $HOME `id` && exit 7 1 {{undeclared}}\r",
+		},
+		{
+			name:          "Invalid number",
+			parameters:    map[string]string{"count": "many"},
+			expectedError: projectErrors.ErrBadParameter,
+		},
+		{
+			name:          "Unknown parameter",
+			parameters:    map[string]string{"other": "value"},
+			expectedError: projectErrors.ErrBadParameter,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+			command, err := runnerService.RunCommand(ctx, nil, 1, "tester", entities.TerminalOptions{Rows: 30, Cols: 120, Parameters: tc.parameters})
+			if tc.expectedError != nil {
+				if !errors.Is(err, tc.expectedError) {
+					t.Fatalf("expected error %v, got %v", tc.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			result := ""
+			for data := range command.Output {
+				result += string(data)
+			}
+			if out := normalizeOutput(result); out != tc.expectedOutput {
+				t.Fatalf("unexpected output: %q, need %q", out, tc.expectedOutput)
+			}
+		})
+	}
+}
+
+func TestRunCommand_Env(t *testing.T) {
+	log.SetLevel(0)
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh syntax")
+	}
+	t.Setenv("WBCR_INHERITED", "inherited")
+	t.Setenv("SECRETS_KEY", "master key")
+	t.Setenv("ADMIN_PASSWORD", "admin password")
+	tmpDir, cleanup := testutils.CreateTempDataFolder(t)
+	defer cleanup()
+	commandRunDir := filepath.Join(tmpDir, "command_run")
+	_ = os.MkdirAll(commandRunDir, 0750)
+	dataDir := filepath.Join(tmpDir, "data")
+	filesDir := filepath.Join(dataDir, "files123")
+	ptyDir := "../../../pty"
+
+	db, err := database.Connect(dataDir)
+	if err != nil {
+		t.Fatalf("Cant create db: %v", err)
+	}
+	defer func(u database.DB) {
+		err := db.Close()
+		if err != nil {
+			t.Errorf("Error closing db: %v", err)
+		}
+	}(db)
+	filesystemAdapter, err := filesystem.Connect(filesDir)
+	if err != nil {
+		t.Fatalf("Cant set connect filesystem: %v", err)
+	}
+	runLogsAdapter, err := filesystem.ConnectRunLogs(filepath.Join(dataDir, "runs"))
+	if err != nil {
+		t.Fatalf("Cant set connect run logs: %v", err)
+	}
+	accessService := access.NewService(db, db, db)
+	commandsService := commands.NewService(db, commandRunDir, checker.New(ptyDir), accessService)
+	filesService := files.NewService(filesDir, 1024, db, db, filesystemAdapter, accessService)
+	runnerAdapter := runner.New(ptyDir, utils.DetectDefaultConsole())
+	runsService := runs.NewService(1024*1024, db, runLogsAdapter)
+	environmentService := environment.NewService("", db)
+	secretsService := secrets.NewService(make([]byte, secrets.KeySize), db)
+	runnerService := NewService(commandRunDir, filesDir, time.Minute, 64*1024, entities.OverflowDrop, entities.KillPolicy{}, runnerAdapter, commandsService, filesService, runsService, environmentService, secretsService, accessService)
+
+	if err := os.WriteFile(filepath.Join(commandRunDir, "command.env"), []byte("FROM_FILE=file\nWBCR_INHERITED=overridden\n"), 0600); err != nil {
+		t.Fatalf("Cant write env file: %v", err)
+	}
+	if err := environmentService.SetGlobalEnv(map[string]string{"GLOBAL": "global"}); err != nil {
+		t.Fatalf("Cant set global env: %v", err)
+	}
+	err = db.SetCommands([]entities.Command{{
+		Name:    "Env",
+		Command: "echo $GLOBAL $FROM_FILE $OWN $WBCR_INHERITED $PWD; env | grep -c -e SECRETS_KEY -e ADMIN_PASSWORD",
+		Dir:     commandRunDir,
+		Env:     map[string]string{"OWN": "own"},
+		EnvFile: "command.env",
+	}})
+	if err != nil {
+		t.Fatalf("cant set config: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	command, err := runnerService.RunCommand(ctx, nil, 1, "tester", entities.TerminalOptions{Rows: 30, Cols: 120})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	result := ""
+	for data := range command.Output {
+		result += string(data)
+	}
+	// Server config variables, like secrets key, are not inherited
+	expected := fmt.Sprintf("global file own overridden %s\r0\r", commandRunDir)
+	if out := normalizeOutput(result); out != expected {
+		t.Fatalf("unexpected output: %q, need %q", out, expected)
+	}
+}
+
+func TestRunCommand_Secrets(t *testing.T) {
+	log.SetLevel(0)
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh syntax")
+	}
+	tmpDir, cleanup := testutils.CreateTempDataFolder(t)
+	defer cleanup()
+	commandRunDir := filepath.Join(tmpDir, "command_run")
+	_ = os.MkdirAll(commandRunDir, 0750)
+	dataDir := filepath.Join(tmpDir, "data")
+	filesDir := filepath.Join(dataDir, "files123")
+	ptyDir := "../../../pty"
+
+	db, err := database.Connect(dataDir)
+	if err != nil {
+		t.Fatalf("Cant create db: %v", err)
+	}
+	defer func(u database.DB) {
+		err := db.Close()
+		if err != nil {
+			t.Errorf("Error closing db: %v", err)
+		}
+	}(db)
+	filesystemAdapter, err := filesystem.Connect(filesDir)
+	if err != nil {
+		t.Fatalf("Cant set connect filesystem: %v", err)
+	}
+	runLogsAdapter, err := filesystem.ConnectRunLogs(filepath.Join(dataDir, "runs"))
+	if err != nil {
+		t.Fatalf("Cant set connect run logs: %v", err)
+	}
+	accessService := access.NewService(db, db, db)
+	commandsService := commands.NewService(db, commandRunDir, checker.New(ptyDir), accessService)
+	filesService := files.NewService(filesDir, 1024, db, db, filesystemAdapter, accessService)
+	runnerAdapter := runner.New(ptyDir, utils.DetectDefaultConsole())
+	runsService := runs.NewService(1024*1024, db, runLogsAdapter)
+	environmentService := environment.NewService("", db)
+	secretsService := secrets.NewService(make([]byte, secrets.KeySize), db)
+	runnerService := NewService(commandRunDir, filesDir, time.Minute, 64*1024, entities.OverflowDrop, entities.KillPolicy{}, runnerAdapter, commandsService, filesService, runsService, environmentService, secretsService, accessService)
+
+	if err := secretsService.SetSecret("API_TOKEN", "t0ken-value"); err != nil {
+		t.Fatalf("Cant set secret: %v", err)
+	}
+	err = db.SetCommands([]entities.Command{
+		{Name: "Secret", Command: "echo token=$API_TOKEN", Dir: commandRunDir, Secrets: []string{"API_TOKEN"}},
+		{Name: "Missing", Command: "echo $MISSING", Dir: commandRunDir, Secrets: []string{"MISSING"}},
+		{Name: "Prompt", Command: "printf 'Enter t0'; read answer; echo $answer", Dir: commandRunDir, Secrets: []string{"API_TOKEN"}},
+	})
+	if err != nil {
+		t.Fatalf("cant set config: %v", err)
+	}
+
+	_, err = runnerService.RunCommand(context.Background(), nil, 2, "tester", entities.TerminalOptions{Rows: 30, Cols: 120})
+	if !errors.Is(err, projectErrors.ErrSecretNotFound) {
+		t.Fatalf("expected ErrSecretNotFound, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	command, err := runnerService.RunCommand(ctx, nil, 1, "tester", entities.TerminalOptions{Rows: 30, Cols: 120})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	result := ""
+	for data := range command.Output {
+		result += string(data)
+	}
+	if out := normalizeOutput(result); out != "token=******\r" {
+		t.Fatalf("unexpected output: %q", out)
+	}
+	run, err := runsService.GetCommandRuns(1)
+	if err != nil || len(run) != 1 {
+		t.Fatalf("unexpected runs: %v %v", run, err)
+	}
+	output, err := runsService.GetRunOutput(run[0].ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(string(output), "t0ken-value") {
+		t.Fatalf("secret stored in run output: %q", output)
+	}
+
+	// Prompt ends with start of secret, it is shown while command waits for input
+	prompt, err := runnerService.RunCommand(ctx, nil, 3, "tester", entities.TerminalOptions{Rows: 30, Cols: 120})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	result = ""
+	for !strings.Contains(result, "Enter t0") {
+		select {
+		case data := <-prompt.Output:
+			result += string(data)
+		case <-time.After(time.Second):
+			t.Fatalf("prompt is not shown: %q", result)
+		}
+	}
+	prompt.Input <- "t0ken-value\r"
+	for data := range prompt.Output {
+		result += string(data)
+	}
+	if out := normalizeOutput(result); out != "Enter t0******\r******\r" {
+		t.Fatalf("unexpected output: %q", out)
+	}
+}
+
+func TestRunCommand_Interpreter(t *testing.T) {
+	log.SetLevel(0)
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh syntax")
+	}
+	tmpDir, cleanup := testutils.CreateTempDataFolder(t)
+	defer cleanup()
+	commandRunDir := filepath.Join(tmpDir, "command_run")
+	_ = os.MkdirAll(commandRunDir, 0750)
+	dataDir := filepath.Join(tmpDir, "data")
+	filesDir := filepath.Join(dataDir, "files123")
+	ptyDir := "../../../pty"
+
+	db, err := database.Connect(dataDir)
+	if err != nil {
+		t.Fatalf("Cant create db: %v", err)
+	}
+	defer func(u database.DB) {
+		err := db.Close()
+		if err != nil {
+			t.Errorf("Error closing db: %v", err)
+		}
+	}(db)
+	filesystemAdapter, err := filesystem.Connect(filesDir)
+	if err != nil {
+		t.Fatalf("Cant set connect filesystem: %v", err)
+	}
+	runLogsAdapter, err := filesystem.ConnectRunLogs(filepath.Join(dataDir, "runs"))
+	if err != nil {
+		t.Fatalf("Cant set connect run logs: %v", err)
+	}
+	accessService := access.NewService(db, db, db)
+	commandsService := commands.NewService(db, commandRunDir, checker.New(ptyDir), accessService)
+	filesService := files.NewService(filesDir, 1024, db, db, filesystemAdapter, accessService)
+	runnerAdapter := runner.New(ptyDir, utils.DetectDefaultConsole())
+	runsService := runs.NewService(1024*1024, db, runLogsAdapter)
+	environmentService := environment.NewService("", db)
+	secretsService := secrets.NewService(make([]byte, secrets.KeySize), db)
+	runnerService := NewService(commandRunDir, filesDir, time.Minute, 64*1024, entities.OverflowDrop, entities.KillPolicy{}, runnerAdapter, commandsService, filesService, runsService, environmentService, secretsService, accessService)
+
+	testCases := []struct {
+		name           string
+		executable     string
+		command        entities.Command
+		parameters     map[string]string
+		expectedOutput string
+	}{
+		{
+			name:       "Python",
+			executable: "python3",
+			command: entities.Command{
+				Command:     "import sys\nprint({{text}}, len(sys.argv))",
+				Interpreter: []string{"python3"},
+				Parameters:  []entities.CommandParameter{{Name: "text"}},
+			},
+			parameters:     map[string]string{"text": `it's "quoted" \n`},
+			expectedOutput: `it's "quoted" \n 1` + "\r",
+		},
+		{
+			name:       "Node",
+			executable: "node",
+			command: entities.Command{
+				Command:     "console.log([1, 2, 3].map(x => x * {{factor}}).join(','))",
+				Interpreter: []string{"node"},
+				Parameters:  []entities.CommandParameter{{Name: "factor", Type: entities.ParameterTypeNumber}},
+			},
+			parameters:     map[string]string{"factor": "2"},
+			expectedOutput: "2,4,6\r",
+		},
+		{
+			name:       "Custom argv",
+			executable: "sh",
+			command: entities.Command{
+				Command:     "echo $0 $1",
+				Interpreter: []string{"sh", "-c", "{{command}}", "first", "second"},
+			},
+			expectedOutput: "first second\r",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := exec.LookPath(tc.executable); err != nil {
+				t.Skipf("%s is not installed", tc.executable)
+			}
+			tc.command.Name = tc.name
+			tc.command.Dir = commandRunDir
+			commandsList := []entities.Command{tc.command}
+			err = db.SetCommands(commandsList)
+			if err != nil {
+				t.Fatalf("cant set config: %v", err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			command, err := runnerService.RunCommand(ctx, nil, commandsList[0].ID, "tester", entities.TerminalOptions{Rows: 30, Cols: 120, Parameters: tc.parameters})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			result := ""
+			for data := range command.Output {
+				result += string(data)
+			}
+			if out := normalizeOutput(result); out != tc.expectedOutput {
+				t.Fatalf("unexpected output: %q, need %q", out, tc.expectedOutput)
+			}
+		})
+	}
+}
+
+func TestRunCommand_Timeout(t *testing.T) {
+	log.SetLevel(0)
+	if runtime.GOOS == "windows" {
+		t.Skip("unix only")
+	}
+	testCases := []struct {
+		name           string
+		command        string
+		killPolicy     *entities.KillPolicy
+		expectedSignal string
+	}{
+		{
+			name:           "Kill without grace",
+			command:        "sleep 10",
+			expectedSignal: "SIGKILL",
+		},
+		{
+			name:           "Interrupt",
+			command:        "sleep 10",
+			killPolicy:     &entities.KillPolicy{InterruptGraceMs: 2000, TerminateGraceMs: 2000},
+			expectedSignal: "SIGINT",
+		},
+		{
+			name:           "Interrupt ignored",
+			command:        "trap '' INT; sleep 10",
+			killPolicy:     &entities.KillPolicy{InterruptGraceMs: 200, TerminateGraceMs: 2000},
+			expectedSignal: "SIGTERM",
+		},
+		{
+			name:           "Background process killed",
+			command:        "sleep 10 & sleep 10",
+			expectedSignal: "SIGKILL",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpDir, cleanup := testutils.CreateTempDataFolder(t)
+			defer cleanup()
+			commandRunDir := filepath.Join(tmpDir, "command_run")
+			_ = os.MkdirAll(commandRunDir, 0750)
+			dataDir := filepath.Join(tmpDir, "data")
+			filesDir := filepath.Join(dataDir, "files123")
+			ptyDir := "../../../pty"
+
+			db, err := database.Connect(dataDir)
+			if err != nil {
+				t.Fatalf("Cant create db: %v", err)
+			}
+			defer func(u database.DB) {
+				err := db.Close()
+				if err != nil {
+					t.Errorf("Error closing db: %v", err)
+				}
+			}(db)
+			filesystemAdapter, err := filesystem.Connect(filesDir)
+			if err != nil {
+				t.Fatalf("Cant set connect filesystem: %v", err)
+			}
+			runLogsAdapter, err := filesystem.ConnectRunLogs(filepath.Join(dataDir, "runs"))
+			if err != nil {
+				t.Fatalf("Cant set connect run logs: %v", err)
+			}
+			accessService := access.NewService(db, db, db)
+			commandsService := commands.NewService(db, commandRunDir, checker.New(ptyDir), accessService)
+			filesService := files.NewService(filesDir, 1024, db, db, filesystemAdapter, accessService)
+			runnerAdapter := runner.New(ptyDir, utils.DetectDefaultConsole())
+			runsService := runs.NewService(1024*1024, db, runLogsAdapter)
+			environmentService := environment.NewService("", db)
+			secretsService := secrets.NewService(make([]byte, secrets.KeySize), db)
+			runnerService := NewService(commandRunDir, filesDir, time.Minute, 64*1024, entities.OverflowDrop, entities.KillPolicy{}, runnerAdapter, commandsService, filesService, runsService, environmentService, secretsService, accessService)
+
+			err = db.SetCommands([]entities.Command{{Name: "Timeout", Command: tc.command, Dir: os.TempDir(), TimeoutMs: 200, KillPolicy: tc.killPolicy}})
+			if err != nil {
+				t.Fatalf("cant set config: %v", err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			startedAt := time.Now()
+			command, err := runnerService.RunCommand(ctx, nil, 1, "test", entities.TerminalOptions{Rows: 30, Cols: 120})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for range command.Output {
+			}
+			if elapsed := time.Since(startedAt); elapsed > 4*time.Second {
+				t.Fatalf("command stopped too late: %v", elapsed)
+			}
+			select {
+			case exitStatus := <-command.Exit:
+				if exitStatus.Signal != tc.expectedSignal || exitStatus.StopReason != entities.StopReasonTimeout {
+					t.Fatalf("unexpected exit status: %+v, need signal %q and timeout stop reason", exitStatus, tc.expectedSignal)
+				}
+			default:
+				t.Fatal("exit status not received before output closed")
+			}
+
+			commandRuns, err := runsService.GetCommandRuns(1)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(commandRuns) != 1 || commandRuns[0].StopReason != entities.StopReasonTimeout {
+				t.Fatalf("unexpected runs: %+v", commandRuns)
+			}
+		})
+	}
+}
+
+func TestRunCommand_IdlePolicy(t *testing.T) {
+	log.SetLevel(0)
+	if runtime.GOOS == "windows" {
+		t.Skip("unix only")
+	}
+	testCases := []struct {
+		name            string
+		idlePolicy      entities.IdlePolicy
+		inputFor        time.Duration
+		expectedWarning bool
+		minDuration     time.Duration
+	}{
+		{
+			name:            "Warn and terminate",
+			idlePolicy:      entities.IdlePolicy{WarnAfterMs: 200, TerminateAfterMs: 600},
+			expectedWarning: true,
+			minDuration:     600 * time.Millisecond,
+		},
+		{
+			name:        "Terminate without warning",
+			idlePolicy:  entities.IdlePolicy{TerminateAfterMs: 300},
+			minDuration: 300 * time.Millisecond,
+		},
+		{
+			name:            "Input resets idle time",
+			idlePolicy:      entities.IdlePolicy{WarnAfterMs: 200, TerminateAfterMs: 400},
+			inputFor:        time.Second,
+			expectedWarning: true,
+			minDuration:     time.Second,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpDir, cleanup := testutils.CreateTempDataFolder(t)
+			defer cleanup()
+			commandRunDir := filepath.Join(tmpDir, "command_run")
+			_ = os.MkdirAll(commandRunDir, 0750)
+			dataDir := filepath.Join(tmpDir, "data")
+			filesDir := filepath.Join(dataDir, "files123")
+			ptyDir := "../../../pty"
+
+			db, err := database.Connect(dataDir)
+			if err != nil {
+				t.Fatalf("Cant create db: %v", err)
+			}
+			defer func(u database.DB) {
+				err := db.Close()
+				if err != nil {
+					t.Errorf("Error closing db: %v", err)
+				}
+			}(db)
+			filesystemAdapter, err := filesystem.Connect(filesDir)
+			if err != nil {
+				t.Fatalf("Cant set connect filesystem: %v", err)
+			}
+			runLogsAdapter, err := filesystem.ConnectRunLogs(filepath.Join(dataDir, "runs"))
+			if err != nil {
+				t.Fatalf("Cant set connect run logs: %v", err)
+			}
+			accessService := access.NewService(db, db, db)
+			commandsService := commands.NewService(db, commandRunDir, checker.New(ptyDir), accessService)
+			filesService := files.NewService(filesDir, 1024, db, db, filesystemAdapter, accessService)
+			runnerAdapter := runner.New(ptyDir, utils.DetectDefaultConsole())
+			runsService := runs.NewService(1024*1024, db, runLogsAdapter)
+			environmentService := environment.NewService("", db)
+			secretsService := secrets.NewService(make([]byte, secrets.KeySize), db)
+			runnerService := NewService(commandRunDir, filesDir, time.Minute, 64*1024, entities.OverflowDrop, entities.KillPolicy{}, runnerAdapter, commandsService, filesService, runsService, environmentService, secretsService, accessService)
+
+			err = db.SetCommands([]entities.Command{{Name: "Idle", Command: "sleep 10", Dir: os.TempDir(), IdlePolicy: &tc.idlePolicy}})
+			if err != nil {
+				t.Fatalf("cant set config: %v", err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			startedAt := time.Now()
+			command, err := runnerService.RunCommand(ctx, nil, 1, "test", entities.TerminalOptions{Rows: 30, Cols: 120})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			go func() {
+				for time.Since(startedAt) < tc.inputFor {
+					select {
+					case command.Input <- "x":
+					case <-ctx.Done():
+						return
+					}
+					time.Sleep(100 * time.Millisecond)
+				}
+			}()
+			var warnings []string
+			for output := command.Output; output != nil; {
+				select {
+				case _, ok := <-output:
+					if !ok {
+						output = nil
+					}
+				case warning := <-command.Warnings:
+					warnings = append(warnings, warning)
+				}
+			}
+			if elapsed := time.Since(startedAt); elapsed < tc.minDuration || elapsed > 4*time.Second {
+				t.Fatalf("command stopped at unexpected time: %v", elapsed)
+			}
+			if tc.expectedWarning != (len(warnings) != 0) {
+				t.Fatalf("unexpected warnings: %q", warnings)
+			}
+			select {
+			case exitStatus := <-command.Exit:
+				if exitStatus.StopReason != entities.StopReasonIdle {
+					t.Fatalf("unexpected exit status: %+v, need idle stop reason", exitStatus)
+				}
+			default:
+				t.Fatal("exit status not received before output closed")
+			}
+		})
+	}
+}
+
+func TestSignalSession(t *testing.T) {
+	log.SetLevel(0)
+	if runtime.GOOS == "windows" {
+		t.Skip("unix only")
+	}
+	testCases := []struct {
+		name           string
+		signals        []entities.Signal
+		expectedError  error
+		expectedSignal string
+	}{
+		{
+			name:           "Interrupt",
+			signals:        []entities.Signal{entities.SignalInterrupt},
+			expectedSignal: "SIGINT",
+		},
+		{
+			name:           "Hangup",
+			signals:        []entities.Signal{entities.SignalHangup},
+			expectedSignal: "SIGHUP",
+		},
+		{
+			name:           "Terminate suspended",
+			signals:        []entities.Signal{entities.SignalSuspend, entities.SignalTerminate},
+			expectedSignal: "SIGTERM",
+		},
+		{
+			name:          "Unsupported",
+			signals:       []entities.Signal{"bad"},
+			expectedError: projectErrors.ErrUnsupportedSignal,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpDir, cleanup := testutils.CreateTempDataFolder(t)
+			defer cleanup()
+			commandRunDir := filepath.Join(tmpDir, "command_run")
+			_ = os.MkdirAll(commandRunDir, 0750)
+			dataDir := filepath.Join(tmpDir, "data")
+			filesDir := filepath.Join(dataDir, "files123")
+			ptyDir := "../../../pty"
+
+			db, err := database.Connect(dataDir)
+			if err != nil {
+				t.Fatalf("Cant create db: %v", err)
+			}
+			defer func(u database.DB) {
+				err := db.Close()
+				if err != nil {
+					t.Errorf("Error closing db: %v", err)
+				}
+			}(db)
+			filesystemAdapter, err := filesystem.Connect(filesDir)
+			if err != nil {
+				t.Fatalf("Cant set connect filesystem: %v", err)
+			}
+			runLogsAdapter, err := filesystem.ConnectRunLogs(filepath.Join(dataDir, "runs"))
+			if err != nil {
+				t.Fatalf("Cant set connect run logs: %v", err)
+			}
+			accessService := access.NewService(db, db, db)
+			commandsService := commands.NewService(db, commandRunDir, checker.New(ptyDir), accessService)
+			filesService := files.NewService(filesDir, 1024, db, db, filesystemAdapter, accessService)
+			runnerAdapter := runner.New(ptyDir, utils.DetectDefaultConsole())
+			runsService := runs.NewService(1024*1024, db, runLogsAdapter)
+			environmentService := environment.NewService("", db)
+			secretsService := secrets.NewService(make([]byte, secrets.KeySize), db)
+			runnerService := NewService(commandRunDir, filesDir, time.Minute, 64*1024, entities.OverflowDrop, entities.KillPolicy{}, runnerAdapter, commandsService, filesService, runsService, environmentService, secretsService, accessService)
+
+			err = db.SetCommands([]entities.Command{{Name: "Signal", Command: "sleep 10", Dir: os.TempDir()}})
+			if err != nil {
+				t.Fatalf("cant set config: %v", err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+			command, err := runnerService.RunCommand(ctx, nil, 1, "test", entities.TerminalOptions{Rows: 30, Cols: 120})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, signal := range tc.signals {
+				err = runnerService.SignalSession(nil, command.SessionID, "", signal)
+			}
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("unexpected error: %v, need %v", err, tc.expectedError)
+			}
+			if tc.expectedError != nil {
+				if err := runnerService.TerminateSession(nil, command.SessionID); err != nil {
+					t.Fatalf("unexpected error while terminating: %v", err)
+				}
+			}
+			for range command.Output {
+			}
+			exitStatus := <-command.Exit
+			if tc.expectedSignal != "" && exitStatus.Signal != tc.expectedSignal {
+				t.Fatalf("unexpected exit status: %+v, need signal %q", exitStatus, tc.expectedSignal)
+			}
+			if err := runnerService.SignalSession(nil, command.SessionID, "", entities.SignalInterrupt); !errors.Is(err, projectErrors.ErrSessionFinished) {
+				t.Fatalf("unexpected error for finished session: %v", err)
+			}
+		})
+	}
+}
+
+func TestResizeSession(t *testing.T) {
+	log.SetLevel(0)
+	if runtime.GOOS == "windows" {
+		t.Skip("unix only")
+	}
+	tmpDir, cleanup := testutils.CreateTempDataFolder(t)
+	defer cleanup()
+	commandRunDir := filepath.Join(tmpDir, "command_run")
+	_ = os.MkdirAll(commandRunDir, 0750)
+	dataDir := filepath.Join(tmpDir, "data")
+	filesDir := filepath.Join(dataDir, "files123")
+	ptyDir := "../../../pty"
+
+	db, err := database.Connect(dataDir)
+	if err != nil {
+		t.Fatalf("Cant create db: %v", err)
+	}
+	defer func(u database.DB) {
+		err := db.Close()
+		if err != nil {
+			t.Errorf("Error closing db: %v", err)
+		}
+	}(db)
+	filesystemAdapter, err := filesystem.Connect(filesDir)
+	if err != nil {
+		t.Fatalf("Cant set connect filesystem: %v", err)
+	}
+	runLogsAdapter, err := filesystem.ConnectRunLogs(filepath.Join(dataDir, "runs"))
+	if err != nil {
+		t.Fatalf("Cant set connect run logs: %v", err)
+	}
+	accessService := access.NewService(db, db, db)
+	commandsService := commands.NewService(db, commandRunDir, checker.New(ptyDir), accessService)
+	filesService := files.NewService(filesDir, 1024, db, db, filesystemAdapter, accessService)
+	runnerAdapter := runner.New(ptyDir, utils.DetectDefaultConsole())
+	runsService := runs.NewService(1024*1024, db, runLogsAdapter)
+	environmentService := environment.NewService("", db)
+	secretsService := secrets.NewService(make([]byte, secrets.KeySize), db)
+	runnerService := NewService(commandRunDir, filesDir, time.Minute, 64*1024, entities.OverflowDrop, entities.KillPolicy{}, runnerAdapter, commandsService, filesService, runsService, environmentService, secretsService, accessService)
+
+	err = db.SetCommands([]entities.Command{{Name: "Size", Command: "stty size; sleep 0.5; stty size", Dir: os.TempDir()}})
+	if err != nil {
+		t.Fatalf("cant set config: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	command, err := runnerService.RunCommand(ctx, nil, 1, "test", entities.TerminalOptions{Rows: 30, Cols: 120})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var output string
+	resized := false
+	for out := range command.Output {
+		output += string(out)
+		// Resize after the first size printed
+		if !resized && strings.Contains(output, "\n") {
+			if err := runnerService.ResizeSession(nil, command.SessionID, "", 0, 40); !errors.Is(err, projectErrors.ErrBadTerminalSize) {
+				t.Fatalf("unexpected error for bad size: %v", err)
+			}
+			if err := runnerService.ResizeSession(nil, command.SessionID, "", 100, 40); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			resized = true
+		}
+	}
+	if normalizeOutput(output) != "30 120\r40 100\r" {
+		t.Fatalf("unexpected output: %q", output)
+	}
+	if err := runnerService.ResizeSession(nil, command.SessionID, "", 100, 40); !errors.Is(err, projectErrors.ErrSessionFinished) {
+		t.Fatalf("unexpected error for finished session: %v", err)
+	}
+}
+
+func TestRunCommand_BinaryOutput(t *testing.T) {
+	log.SetLevel(0)
+	if runtime.GOOS == "windows" {
+		t.Skip("unix only")
+	}
+	tmpDir, cleanup := testutils.CreateTempDataFolder(t)
+	defer cleanup()
+	commandRunDir := filepath.Join(tmpDir, "command_run")
+	_ = os.MkdirAll(commandRunDir, 0750)
+	dataDir := filepath.Join(tmpDir, "data")
+	filesDir := filepath.Join(dataDir, "files123")
+	ptyDir := "../../../pty"
+
+	db, err := database.Connect(dataDir)
+	if err != nil {
+		t.Fatalf("Cant create db: %v", err)
+	}
+	defer func(u database.DB) {
+		err := db.Close()
+		if err != nil {
+			t.Errorf("Error closing db: %v", err)
+		}
+	}(db)
+	filesystemAdapter, err := filesystem.Connect(filesDir)
+	if err != nil {
+		t.Fatalf("Cant set connect filesystem: %v", err)
+	}
+	runLogsAdapter, err := filesystem.ConnectRunLogs(filepath.Join(dataDir, "runs"))
+	if err != nil {
+		t.Fatalf("Cant set connect run logs: %v", err)
+	}
+	accessService := access.NewService(db, db, db)
+	commandsService := commands.NewService(db, commandRunDir, checker.New(ptyDir), accessService)
+	filesService := files.NewService(filesDir, 1024, db, db, filesystemAdapter, accessService)
+	runnerAdapter := runner.New(ptyDir, utils.DetectDefaultConsole())
+	runsService := runs.NewService(1024*1024, db, runLogsAdapter)
+	environmentService := environment.NewService("", db)
+	secretsService := secrets.NewService(make([]byte, secrets.KeySize), db)
+	runnerService := NewService(commandRunDir, filesDir, time.Minute, 64*1024, entities.OverflowDrop, entities.KillPolicy{}, runnerAdapter, commandsService, filesService, runsService, environmentService, secretsService, accessService)
+
+	// Invalid UTF-8 and character split between bytes must come as is
+	err = db.SetCommands([]entities.Command{{Name: "Binary", Command: `printf '\377\376'; printf '\320'; sleep 0.1; printf '\226'`, Dir: os.TempDir()}})
+	if err != nil {
+		t.Fatalf("cant set config: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	command, err := runnerService.RunCommand(ctx, nil, 1, "test", entities.TerminalOptions{Rows: 30, Cols: 120})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var output []byte
+	for data := range command.Output {
+		output = append(output, data...)
+	}
+	if expected := []byte{0xff, 0xfe, 0xd0, 0x96}; !bytes.Equal(output, expected) {
+		t.Fatalf("unexpected output: %v, need %v", output, expected)
+	}
+}
+
+func TestAttachSession_Snapshot(t *testing.T) {
+	log.SetLevel(0)
+	if runtime.GOOS == "windows" {
+		t.Skip("unix only")
+	}
+	tmpDir, cleanup := testutils.CreateTempDataFolder(t)
+	defer cleanup()
+	commandRunDir := filepath.Join(tmpDir, "command_run")
+	_ = os.MkdirAll(commandRunDir, 0750)
+	dataDir := filepath.Join(tmpDir, "data")
+	filesDir := filepath.Join(dataDir, "files123")
+	ptyDir := "../../../pty"
+
+	db, err := database.Connect(dataDir)
+	if err != nil {
+		t.Fatalf("Cant create db: %v", err)
+	}
+	defer func(u database.DB) {
+		err := db.Close()
+		if err != nil {
+			t.Errorf("Error closing db: %v", err)
+		}
+	}(db)
+	filesystemAdapter, err := filesystem.Connect(filesDir)
+	if err != nil {
+		t.Fatalf("Cant set connect filesystem: %v", err)
+	}
+	runLogsAdapter, err := filesystem.ConnectRunLogs(filepath.Join(dataDir, "runs"))
+	if err != nil {
+		t.Fatalf("Cant set connect run logs: %v", err)
+	}
+	accessService := access.NewService(db, db, db)
+	commandsService := commands.NewService(db, commandRunDir, checker.New(ptyDir), accessService)
+	filesService := files.NewService(filesDir, 1024, db, db, filesystemAdapter, accessService)
+	runnerAdapter := runner.New(ptyDir, utils.DetectDefaultConsole())
+	runsService := runs.NewService(1024*1024, db, runLogsAdapter)
+	environmentService := environment.NewService("", db)
+	secretsService := secrets.NewService(make([]byte, secrets.KeySize), db)
+	runnerService := NewService(commandRunDir, filesDir, time.Minute, 64*1024, entities.OverflowDrop, entities.KillPolicy{}, runnerAdapter, commandsService, filesService, runsService, environmentService, secretsService, accessService)
+
+	// Full screen program redraws progress in place, raw replay would show every frame
+	command := `printf 'shell$ \033[?1049h'; for i in 1 2 3; do printf '\033[5;10Hprogress %s' $i; done; printf '\033[1;1Hready\n'; sleep 1`
+	err = db.SetCommands([]entities.Command{{Name: "Full screen", Command: command, Dir: os.TempDir()}})
+	if err != nil {
+		t.Fatalf("cant set config: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	controller, err := runnerService.RunCommand(ctx, nil, 1, "owner", entities.TerminalOptions{Rows: 30, Cols: 120})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var output []byte
+	for !bytes.Contains(output, []byte("ready")) {
+		select {
+		case data := <-controller.Output:
+			output = append(output, data...)
+		case <-ctx.Done():
+			t.Fatalf("timeout waiting for output, got %q", output)
+		}
+	}
+
+	spectator, err := runnerService.AttachSession(ctx, nil, controller.SessionID, "guest", entities.ViewerSpectator)
+	if err != nil {
+		t.Fatalf("unexpected error while attaching: %v", err)
+	}
+	var snapshot []byte
+	select {
+	case snapshot = <-spectator.Output:
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for snapshot")
+	}
+	if bytes.Contains(snapshot, []byte("progress 1")) {
+		t.Fatalf("snapshot contains overwritten output: %q", snapshot)
+	}
+	restored := newScreen(120, 30)
+	restored.Write(snapshot)
+	lines := linesText(restored, restored.lines)
+	if !restored.altScreen || lines[0] != "ready" || lines[4] != "         progress 3" {
+		t.Fatalf("unexpected screen from snapshot: %q", lines[:5])
+	}
+	if mainLines := linesText(restored, restored.mainLines); mainLines[0] != "shell$" {
+		t.Fatalf("unexpected main screen from snapshot: %q", mainLines[0])
+	}
+}
+
+// BenchmarkRunCommand_Output measure throughput of output pipeline from pty to client
+func TestStartCommand_Access(t *testing.T) {
+	log.SetLevel(0)
+	tmpDir, cleanup := testutils.CreateTempDataFolder(t)
+	defer cleanup()
+	commandRunDir := filepath.Join(tmpDir, "command_run")
+	_ = os.MkdirAll(commandRunDir, 0750)
+	dataDir := filepath.Join(tmpDir, "data")
+	filesDir := filepath.Join(dataDir, "files123")
+	ptyDir := "../../../pty"
+
+	db, err := database.Connect(dataDir)
+	if err != nil {
+		t.Fatalf("Cant create db: %v", err)
+	}
+	defer func(u database.DB) {
+		err := db.Close()
+		if err != nil {
+			t.Errorf("Error closing db: %v", err)
+		}
+	}(db)
+	filesystemAdapter, err := filesystem.Connect(filesDir)
+	if err != nil {
+		t.Fatalf("Cant set connect filesystem: %v", err)
+	}
+	runLogsAdapter, err := filesystem.ConnectRunLogs(filepath.Join(dataDir, "runs"))
+	if err != nil {
+		t.Fatalf("Cant set connect run logs: %v", err)
+	}
+	accessService := access.NewService(db, db, db)
+	commandsService := commands.NewService(db, commandRunDir, checker.New(ptyDir), accessService)
+	filesService := files.NewService(filesDir, 1024, db, db, filesystemAdapter, accessService)
+	runnerAdapter := runner.New(ptyDir, utils.DetectDefaultConsole())
+	runsService := runs.NewService(1024*1024, db, runLogsAdapter)
+	environmentService := environment.NewService("", db)
+	secretsService := secrets.NewService(make([]byte, secrets.KeySize), db)
+	runnerService := NewService(commandRunDir, filesDir, time.Minute, 64*1024, entities.OverflowDrop, entities.KillPolicy{}, runnerAdapter, commandsService, filesService, runsService, environmentService, secretsService, accessService)
+
+	longCommand := "sleep 10"
+	if runtime.GOOS == "windows" {
+		longCommand = "ping -n 10 127.0.0.1"
+	}
+	err = db.SetCommands([]entities.Command{
+		{Name: "Long", Command: longCommand, Dir: os.TempDir()},
+	})
+	if err != nil {
+		t.Fatalf("cant set config: %v", err)
+	}
+	operator := &entities.User{Username: "operator", Role: entities.RoleOperator}
+	viewer := &entities.User{Username: "viewer", Role: entities.RoleViewer}
+	stranger := &entities.User{Username: "stranger", Role: entities.RoleOperator}
+	for _, user := range []*entities.User{operator, viewer, stranger} {
+		if err := db.AppendUser(user); err != nil {
+			t.Fatalf("Cant append user: %v", err)
+		}
+	}
+	if err := accessService.SetCommandGrant(nil, &entities.CommandGrant{CommandID: 1, UserID: stranger.ID, Level: entities.AccessNone}); err != nil {
+		t.Fatalf("Cant set grant: %v", err)
+	}
+
+	if _, err := runnerService.StartCommand(viewer, 1, "viewer", entities.TerminalOptions{Rows: 24, Cols: 80}); !errors.Is(err, projectErrors.ErrForbidden) {
+		t.Fatalf("expected ErrForbidden for viewer, got %v", err)
+	}
+	run, err := runnerService.StartCommand(operator, 1, "operator", entities.TerminalOptions{Rows: 24, Cols: 80})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(runnerService.GetSessions(viewer)) != 1 {
+		t.Error("viewer does not see session of command")
+	}
+	if len(runnerService.GetSessions(stranger)) != 0 {
+		t.Error("session of hidden command is visible")
+	}
+	if _, err := runnerService.GetSessionStats(stranger, run.SessionID); !errors.Is(err, projectErrors.ErrForbidden) {
+		t.Errorf("expected ErrForbidden for stats, got %v", err)
+	}
+	if err := runnerService.ResizeSession(viewer, run.SessionID, "", 100, 40); !errors.Is(err, projectErrors.ErrForbidden) {
+		t.Errorf("expected ErrForbidden for resize, got %v", err)
+	}
+	if err := runnerService.TerminateSession(viewer, run.SessionID); !errors.Is(err, projectErrors.ErrForbidden) {
+		t.Errorf("expected ErrForbidden for terminate, got %v", err)
+	}
+	if err := runnerService.TerminateSession(operator, run.SessionID); err != nil {
+		t.Fatalf("unexpected error while terminating: %v", err)
+	}
+}
+
+func BenchmarkRunCommand_Output(b *testing.B) {
+	log.SetLevel(4)
+	if runtime.GOOS == "windows" {
+		b.Skip("unix only")
+	}
+	const outputSize = 100 * 1024 * 1024
+	tmpDir := b.TempDir()
+	commandRunDir := filepath.Join(tmpDir, "command_run")
+	_ = os.MkdirAll(commandRunDir, 0750)
+	dataDir := filepath.Join(tmpDir, "data")
+	filesDir := filepath.Join(dataDir, "files123")
+	ptyDir := "../../../pty"
+
+	db, err := database.Connect(dataDir)
+	if err != nil {
+		b.Fatalf("Cant create db: %v", err)
+	}
+	defer func(u database.DB) {
+		err := db.Close()
+		if err != nil {
+			b.Errorf("Error closing db: %v", err)
+		}
+	}(db)
+	filesystemAdapter, err := filesystem.Connect(filesDir)
+	if err != nil {
+		b.Fatalf("Cant set connect filesystem: %v", err)
+	}
+	runLogsAdapter, err := filesystem.ConnectRunLogs(filepath.Join(dataDir, "runs"))
+	if err != nil {
+		b.Fatalf("Cant set connect run logs: %v", err)
+	}
+	accessService := access.NewService(db, db, db)
+	commandsService := commands.NewService(db, commandRunDir, checker.New(ptyDir), accessService)
+	filesService := files.NewService(filesDir, 1024, db, db, filesystemAdapter, accessService)
+	runnerAdapter := runner.New(ptyDir, utils.DetectDefaultConsole())
+	runsService := runs.NewService(1024*1024, db, runLogsAdapter)
+	environmentService := environment.NewService("", db)
+	secretsService := secrets.NewService(make([]byte, secrets.KeySize), db)
+	runnerService := NewService(commandRunDir, filesDir, time.Minute, 256*1024, entities.OverflowDrop, entities.KillPolicy{}, runnerAdapter, commandsService, filesService, runsService, environmentService, secretsService, accessService)
+
+	err = db.SetCommands([]entities.Command{{Name: "Yes", Command: fmt.Sprintf("yes | head -c %d", outputSize), Dir: os.TempDir()}})
+	if err != nil {
+		b.Fatalf("cant set config: %v", err)
+	}
+	b.SetBytes(outputSize)
+	b.ResetTimer()
+	for range b.N {
+		command, err := runnerService.RunCommand(context.Background(), nil, 1, "bench", entities.TerminalOptions{Rows: 30, Cols: 120})
+		if err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+		received := 0
+		for data := range command.Output {
+			received += len(data)
+		}
+		// Terminal translates \n to \r\n
+		if received < outputSize {
+			b.Fatalf("received %d bytes, need at least %d", received, outputSize)
+		}
+	}
+}
@@ -0,0 +1,1240 @@
+package runner
+
+import (
+	"bytes"
+	"github.com/mattn/go-runewidth"
+	"slices"
+	"strconv"
+	"unicode/utf8"
+)
+
+// screenHistoryLines is count of lines scrolled off the screen, that kept for snapshot, the same as xterm.js scrollback
+const screenHistoryLines = 1000
+
+const (
+	colorDefault  uint32 = 0
+	colorIndexed  uint32 = 1 << 24
+	colorRGB      uint32 = 2 << 24
+	colorKindMask uint32 = 0xff << 24
+)
+
+type cellFlags uint16
+
+const (
+	cellBold cellFlags = 1 << iota
+	cellDim
+	cellItalic
+	cellUnderline
+	cellBlink
+	cellInverse
+	cellHidden
+	cellStrike
+)
+
+// cellAttr is graphic rendition of cell, colors are colorDefault, colorIndexed|index or colorRGB|rgb
+type cellAttr struct {
+	fg, bg uint32
+	flags  cellFlags
+}
+
+// cell has no pointers, so scrolling and erasing don't add work for garbage collector
+type cell struct {
+	r    rune   // 0 for erased cell
+	comb uint16 // 1 + index of combining characters drawn over r in screen clusters, 0 for none
+	wide uint8  // cellWideHead or cellWideTail for halves of wide character
+	attr cellAttr
+}
+
+const (
+	cellWideHead = 1
+	cellWideTail = 2
+)
+
+// blank is true for erased cell without background, zero cell is blank
+func (c cell) blank() bool {
+	return c == cell{}
+}
+
+type screenLine struct {
+	cells   []cell
+	wrapped bool // line continues on the next line after auto wrap
+}
+
+// screenHistory keeps lines already rendered for snapshot, they don't change after scrolled off the screen.
+// All lines are in one array, so saving line to history rarely allocates
+type screenHistory struct {
+	data  []byte
+	lines []historyLine
+}
+
+type historyLine struct {
+	start, end int // rendered line in data
+	wrapped    bool
+}
+
+type screenCursor struct {
+	x, y        int
+	attr        cellAttr
+	pendingWrap bool // cursor is after the last column, next character goes to the next line
+	originMode  bool
+	lineDrawing bool // G0 charset is DEC special graphics
+}
+
+type parserState uint8
+
+const (
+	stateGround parserState = iota
+	stateEscape
+	stateCharset
+	stateCSI
+	stateOSC
+	stateString // DCS, SOS, PM and APC strings, that are skipped
+	stateStringEscape
+)
+
+// replayedModes are DEC private modes, that don't change screen, but must be restored in snapshot:
+// cursor keys, reverse video, mouse tracking and encodings, focus events, bracketed paste
+var replayedModes = []int{1, 5, 9, 1000, 1002, 1003, 1004, 1005, 1006, 1015, 2004}
+
+// decGraphics maps DEC special graphics charset to unicode line drawing characters
+var decGraphics = map[byte]rune{
+	'`': '◆', 'a': '▒', 'f': '°', 'g': '±', 'j': '┘', 'k': '┐', 'l': '┌', 'm': '└', 'n': '┼', 'o': '⎺',
+	'p': '⎻', 'q': '─', 'r': '⎼', 's': '⎽', 't': '├', 'u': '┤', 'v': '┴', 'w': '┬', 'x': '│', 'y': '≤',
+	'z': '≥', '{': 'π', '|': '≠', '}': '£', '~': '·',
+}
+
+// screen is VT100/xterm terminal emulator, that tracks screen grid, cursor, modes and bounded history of session.
+// It doesn't answer terminal queries, viewers terminals do it.
+// Snapshot renders this state as output, that brings reset terminal to the same state,
+// so viewer joined late doesn't need the whole output log.
+type screen struct {
+	cols, rows   int
+	lines        []screenLine  // active buffer
+	mainLines    []screenLine  // primary buffer, while alternate buffer is active
+	history      screenHistory // lines scrolled off the top of primary buffer
+	altScreen    bool
+	cur          screenCursor
+	saved        [2]screenCursor // saved cursor of primary and alternate buffer
+	top, bottom  int             // scroll region
+	autoWrap     bool
+	insertMode   bool
+	cursorHidden bool
+	cursorStyle  int
+	modes        map[int]bool // enabled replayedModes
+	tabStops     []bool
+	title        string
+	lastChar     rune
+	clusters     []string          // distinct sequences of combining characters
+	clusterIds   map[string]uint16 // index of clusters
+
+	state   parserState
+	params  []int
+	private byte // private marker of CSI, like '?'
+	inter   []byte
+	osc     []byte
+	utf8    []byte // incomplete UTF-8 sequence
+}
+
+// newScreen create screen of terminal size, zero size means default 80x24
+func newScreen(cols, rows int) *screen {
+	if cols <= 0 {
+		cols = 80
+	}
+	if rows <= 0 {
+		rows = 24
+	}
+	s := &screen{}
+	s.reset(cols, rows)
+	return s
+}
+
+// reset terminal to initial state, like RIS
+func (s *screen) reset(cols, rows int) {
+	*s = screen{cols: cols, rows: rows, autoWrap: true, modes: make(map[int]bool)}
+	s.lines = s.blankLines(rows)
+	s.bottom = rows - 1
+	s.resetTabStops()
+}
+
+func (s *screen) resetTabStops() {
+	s.tabStops = make([]bool, s.cols)
+	for x := 8; x < s.cols; x += 8 {
+		s.tabStops[x] = true
+	}
+}
+
+// erasedCell is cell cleared with current background, like xterm does
+func (s *screen) erasedCell() cell {
+	return cell{attr: cellAttr{bg: s.cur.attr.bg}}
+}
+
+func (s *screen) blankLine() screenLine {
+	line := screenLine{cells: make([]cell, s.cols)}
+	s.clearLine(&line)
+	return line
+}
+
+func (s *screen) clearLine(line *screenLine) {
+	if erased := s.erasedCell(); erased.blank() {
+		clear(line.cells)
+	} else {
+		for i := range line.cells {
+			line.cells[i] = erased
+		}
+	}
+	line.wrapped = false
+}
+
+func (s *screen) blankLines(n int) []screenLine {
+	lines := make([]screenLine, n)
+	for i := range lines {
+		lines[i] = s.blankLine()
+	}
+	return lines
+}
+
+// Write update screen by terminal output, sequences can be split between writes
+func (s *screen) Write(p []byte) {
+	for _, b := range p {
+		s.feed(b)
+	}
+}
+
+func (s *screen) feed(b byte) {
+	switch s.state {
+	case stateOSC:
+		switch b {
+		case 0x07:
+			s.dispatchOSC()
+			s.state = stateGround
+		case 0x1b:
+			s.dispatchOSC()
+			s.state = stateStringEscape
+		default:
+			if len(s.osc) < 4096 {
+				s.osc = append(s.osc, b)
+			}
+		}
+		return
+	case stateString:
+		switch b {
+		case 0x07:
+			s.state = stateGround
+		case 0x1b:
+			s.state = stateStringEscape
+		}
+		return
+	case stateStringEscape:
+		// ESC \ finishes string, any other ESC starts new sequence
+		s.state = stateGround
+		if b != '\\' {
+			s.startEscape()
+			s.feed(b)
+		}
+		return
+	}
+
+	if b < 0x20 || b == 0x7f {
+		s.control(b)
+		return
+	}
+	switch s.state {
+	case stateGround:
+		s.ground(b)
+	case stateEscape:
+		s.escape(b)
+	case stateCharset:
+		if len(s.inter) != 0 && s.inter[0] == '(' {
+			s.cur.lineDrawing = b == '0'
+		}
+		s.state = stateGround
+	case stateCSI:
+		s.csi(b)
+	}
+}
+
+func (s *screen) startEscape() {
+	s.state = stateEscape
+	s.inter = s.inter[:0]
+}
+
+// control execute C0 control character, they work inside escape sequences too
+func (s *screen) control(b byte) {
+	switch b {
+	case 0x1b:
+		s.startEscape()
+		s.utf8 = s.utf8[:0]
+	case 0x18, 0x1a:
+		s.state = stateGround
+	case '\b':
+		s.cur.pendingWrap = false
+		if s.cur.x > 0 {
+			s.cur.x--
+		}
+	case '\t':
+		s.tab(1)
+	case '\n', 0x0b, 0x0c:
+		s.lineFeed()
+	case '\r':
+		s.cur.x = 0
+		s.cur.pendingWrap = false
+	}
+}
+
+func (s *screen) ground(b byte) {
+	if b < 0x80 && len(s.utf8) == 0 {
+		s.put(rune(b))
+		return
+	}
+	if utf8.RuneStart(b) {
+		if len(s.utf8) != 0 {
+			s.put(utf8.RuneError)
+		}
+		s.utf8 = s.utf8[:0]
+	}
+	s.utf8 = append(s.utf8, b)
+	if !utf8.FullRune(s.utf8) {
+		return
+	}
+	r, _ := utf8.DecodeRune(s.utf8)
+	s.utf8 = s.utf8[:0]
+	s.put(r)
+}
+
+func (s *screen) escape(b byte) {
+	if b >= 0x20 && b <= 0x2f {
+		s.inter = append(s.inter, b)
+		if b == '(' || b == ')' || b == '*' || b == '+' {
+			s.state = stateCharset
+		}
+		return
+	}
+	s.state = stateGround
+	if len(s.inter) != 0 {
+		// ESC # 8 and other rare sequences with intermediates
+		return
+	}
+	switch b {
+	case '[':
+		s.state = stateCSI
+		s.params = s.params[:0]
+		s.private = 0
+	case ']':
+		s.state = stateOSC
+		s.osc = s.osc[:0]
+	case 'P', 'X', '^', '_':
+		s.state = stateString
+	case '7':
+		s.saveCursor()
+	case '8':
+		s.restoreCursor()
+	case 'D':
+		s.lineFeed()
+	case 'E':
+		s.cur.x = 0
+		s.lineFeed()
+	case 'M':
+		s.reverseIndex()
+	case 'H':
+		s.tabStops[s.cur.x] = true
+	case 'c':
+		s.reset(s.cols, s.rows)
+	case '=':
+		s.modes[66] = true
+	case '>':
+		delete(s.modes, 66)
+	}
+}
+
+func (s *screen) csi(b byte) {
+	switch {
+	case b >= '0' && b <= '9':
+		if len(s.params) == 0 {
+			s.params = append(s.params, 0)
+		}
+		last := &s.params[len(s.params)-1]
+		*last = min(*last*10+int(b-'0'), 65535)
+	case b == ';' || b == ':':
+		if len(s.params) == 0 {
+			s.params = append(s.params, 0)
+		}
+		if len(s.params) < 32 {
+			s.params = append(s.params, 0)
+		}
+	case b >= '<' && b <= '?':
+		s.private = b
+	case b >= 0x20 && b <= 0x2f:
+		s.inter = append(s.inter, b)
+	case b >= 0x40 && b <= 0x7e:
+		s.state = stateGround
+		s.dispatchCSI(b)
+	}
+}
+
+// param return CSI parameter i, or def if it is missing or zero
+func (s *screen) param(i, def int) int {
+	if i >= len(s.params) || s.params[i] == 0 {
+		return def
+	}
+	return s.params[i]
+}
+
+func (s *screen) dispatchCSI(final byte) {
+	if s.private != 0 {
+		if s.private == '?' && len(s.inter) == 0 && (final == 'h' || final == 'l') {
+			for _, mode := range s.params {
+				s.setPrivateMode(mode, final == 'h')
+			}
+		}
+		return
+	}
+	if len(s.inter) != 0 {
+		switch {
+		case s.inter[0] == ' ' && final == 'q':
+			s.cursorStyle = s.param(0, 0)
+		case s.inter[0] == '!' && final == 'p':
+			s.softReset()
+		}
+		return
+	}
+	switch final {
+	case '@':
+		s.insertChars(s.param(0, 1))
+	case 'A':
+		s.moveCursor(s.cur.x, s.cur.y-s.param(0, 1))
+	case 'B', 'e':
+		s.moveCursor(s.cur.x, s.cur.y+s.param(0, 1))
+	case 'C', 'a':
+		s.moveCursor(s.cur.x+s.param(0, 1), s.cur.y)
+	case 'D':
+		s.moveCursor(s.cur.x-s.param(0, 1), s.cur.y)
+	case 'E':
+		s.moveCursor(0, s.cur.y+s.param(0, 1))
+	case 'F':
+		s.moveCursor(0, s.cur.y-s.param(0, 1))
+	case 'G', '`':
+		s.moveCursor(s.param(0, 1)-1, s.cur.y)
+	case 'H', 'f':
+		s.setCursor(s.param(1, 1)-1, s.param(0, 1)-1)
+	case 'd':
+		s.setCursor(s.cur.x, s.param(0, 1)-1)
+	case 'I':
+		s.tab(s.param(0, 1))
+	case 'Z':
+		s.backTab(s.param(0, 1))
+	case 'J':
+		s.eraseDisplay(s.param(0, 0))
+	case 'K':
+		s.eraseLine(s.param(0, 0))
+	case 'L':
+		s.insertLines(s.param(0, 1))
+	case 'M':
+		s.deleteLines(s.param(0, 1))
+	case 'P':
+		s.deleteChars(s.param(0, 1))
+	case 'X':
+		s.eraseChars(s.param(0, 1))
+	case 'S':
+		s.scrollUp(s.top, s.param(0, 1), true)
+	case 'T':
+		s.scrollDown(s.top, s.param(0, 1))
+	case 'b':
+		if s.lastChar != 0 {
+			for range min(s.param(0, 1), s.cols*s.rows) {
+				s.put(s.lastChar)
+			}
+		}
+	case 'g':
+		switch s.param(0, 0) {
+		case 0:
+			s.tabStops[s.cur.x] = false
+		case 3:
+			clear(s.tabStops)
+		}
+	case 'h', 'l':
+		for _, mode := range s.params {
+			if mode == 4 {
+				s.insertMode = final == 'h'
+			}
+		}
+	case 'm':
+		s.setGraphics()
+	case 'r':
+		top, bottom := s.param(0, 1)-1, s.param(1, s.rows)-1
+		if top < bottom && bottom < s.rows {
+			s.top, s.bottom = top, bottom
+			s.setCursor(0, 0)
+		}
+	case 's':
+		s.saveCursor()
+	case 'u':
+		s.restoreCursor()
+	}
+}
+
+func (s *screen) setPrivateMode(mode int, enabled bool) {
+	switch mode {
+	case 6:
+		s.cur.originMode = enabled
+		s.setCursor(0, 0)
+	case 7:
+		s.autoWrap = enabled
+		if !enabled {
+			s.cur.pendingWrap = false
+		}
+	case 25:
+		s.cursorHidden = !enabled
+	case 47, 1047:
+		s.switchScreen(enabled)
+	case 1048:
+		if enabled {
+			s.saveCursor()
+		} else {
+			s.restoreCursor()
+		}
+	case 1049:
+		if enabled {
+			s.saveCursor()
+			s.switchScreen(true)
+		} else {
+			s.switchScreen(false)
+			s.restoreCursor()
+		}
+	default:
+		if slices.Contains(replayedModes, mode) {
+			if enabled {
+				s.modes[mode] = true
+			} else {
+				delete(s.modes, mode)
+			}
+		}
+	}
+}
+
+// switchScreen switch to cleared alternate buffer or back to primary buffer
+func (s *screen) switchScreen(alt bool) {
+	if alt == s.altScreen {
+		return
+	}
+	s.altScreen = alt
+	if alt {
+		s.mainLines = s.lines
+		s.lines = s.blankLines(s.rows)
+	} else {
+		s.lines = s.mainLines
+		s.mainLines = nil
+	}
+	s.cur.pendingWrap = false
+}
+
+func (s *screen) bufferIndex() int {
+	if s.altScreen {
+		return 1
+	}
+	return 0
+}
+
+func (s *screen) saveCursor() {
+	s.saved[s.bufferIndex()] = s.cur
+}
+
+func (s *screen) restoreCursor() {
+	s.cur = s.saved[s.bufferIndex()]
+	s.cur.x = min(s.cur.x, s.cols-1)
+	s.cur.y = min(s.cur.y, s.rows-1)
+}
+
+// softReset is DECSTR, it resets modes and rendition, but keeps screen content
+func (s *screen) softReset() {
+	s.cur.attr = cellAttr{}
+	s.cur.originMode = false
+	s.cur.lineDrawing = false
+	s.cur.pendingWrap = false
+	s.top, s.bottom = 0, s.rows-1
+	s.autoWrap = true
+	s.insertMode = false
+	s.cursorHidden = false
+	s.saved[s.bufferIndex()] = screenCursor{}
+	delete(s.modes, 1)
+	delete(s.modes, 66)
+}
+
+func (s *screen) dispatchOSC() {
+	code, text, ok := bytes.Cut(s.osc, []byte{';'})
+	if !ok {
+		return
+	}
+	switch string(code) {
+	case "0", "2":
+		s.title = string(text)
+	}
+}
+
+func (s *screen) setGraphics() {
+	if len(s.params) == 0 {
+		s.cur.attr = cellAttr{}
+		return
+	}
+	for i := 0; i < len(s.params); i++ {
+		p := s.params[i]
+		switch {
+		case p == 0:
+			s.cur.attr = cellAttr{}
+		case p == 1:
+			s.cur.attr.flags |= cellBold
+		case p == 2:
+			s.cur.attr.flags |= cellDim
+		case p == 3:
+			s.cur.attr.flags |= cellItalic
+		case p == 4 || p == 21:
+			s.cur.attr.flags |= cellUnderline
+		case p == 5 || p == 6:
+			s.cur.attr.flags |= cellBlink
+		case p == 7:
+			s.cur.attr.flags |= cellInverse
+		case p == 8:
+			s.cur.attr.flags |= cellHidden
+		case p == 9:
+			s.cur.attr.flags |= cellStrike
+		case p == 22:
+			s.cur.attr.flags &^= cellBold | cellDim
+		case p == 23:
+			s.cur.attr.flags &^= cellItalic
+		case p == 24:
+			s.cur.attr.flags &^= cellUnderline
+		case p == 25:
+			s.cur.attr.flags &^= cellBlink
+		case p == 27:
+			s.cur.attr.flags &^= cellInverse
+		case p == 28:
+			s.cur.attr.flags &^= cellHidden
+		case p == 29:
+			s.cur.attr.flags &^= cellStrike
+		case p >= 30 && p <= 37:
+			s.cur.attr.fg = colorIndexed | uint32(p-30)
+		case p == 38 || p == 48:
+			var color uint32
+			color, i = s.extendedColor(i)
+			if p == 38 {
+				s.cur.attr.fg = color
+			} else {
+				s.cur.attr.bg = color
+			}
+		case p == 39:
+			s.cur.attr.fg = colorDefault
+		case p >= 40 && p <= 47:
+			s.cur.attr.bg = colorIndexed | uint32(p-40)
+		case p == 49:
+			s.cur.attr.bg = colorDefault
+		case p >= 90 && p <= 97:
+			s.cur.attr.fg = colorIndexed | uint32(p-90+8)
+		case p >= 100 && p <= 107:
+			s.cur.attr.bg = colorIndexed | uint32(p-100+8)
+		}
+	}
+}
+
+// extendedColor parse 5;index or 2;r;g;b after SGR 38 or 48 at i, return color and index of its last parameter
+func (s *screen) extendedColor(i int) (uint32, int) {
+	switch s.param(i+1, 0) {
+	case 5:
+		if i+2 < len(s.params) {
+			return colorIndexed | uint32(s.params[i+2]&0xff), i + 2
+		}
+	case 2:
+		if i+4 < len(s.params) {
+			r, g, b := uint32(s.params[i+2]&0xff), uint32(s.params[i+3]&0xff), uint32(s.params[i+4]&0xff)
+			return colorRGB | r<<16 | g<<8 | b, i + 4
+		}
+	}
+	return colorDefault, len(s.params)
+}
+
+// put draw character at cursor and move cursor
+func (s *screen) put(r rune) {
+	if s.cur.lineDrawing && r < 0x80 {
+		if mapped, ok := decGraphics[byte(r)]; ok {
+			r = mapped
+		}
+	}
+	width := 1
+	if r >= 0x80 {
+		width = runewidth.RuneWidth(r)
+	}
+	if width == 0 {
+		s.combine(r)
+		return
+	}
+	if s.cur.pendingWrap && s.autoWrap {
+		s.lines[s.cur.y].wrapped = true
+		s.cur.x = 0
+		s.lineFeed()
+	}
+	s.cur.pendingWrap = false
+	if width == 2 && s.cur.x == s.cols-1 {
+		if !s.autoWrap || s.cols < 2 {
+			return
+		}
+		s.lines[s.cur.y].cells[s.cur.x] = s.erasedCell()
+		s.lines[s.cur.y].wrapped = true
+		s.cur.x = 0
+		s.lineFeed()
+	}
+	line := s.lines[s.cur.y].cells
+	if s.insertMode {
+		s.insertChars(width)
+	}
+	if line[s.cur.x].wide != 0 {
+		s.clearWide(line, s.cur.x)
+	}
+	if width == 2 {
+		s.clearWide(line, s.cur.x+1)
+	}
+	line[s.cur.x] = cell{r: r, attr: s.cur.attr}
+	if width == 2 {
+		line[s.cur.x].wide = cellWideHead
+		line[s.cur.x+1] = cell{wide: cellWideTail, attr: s.cur.attr}
+	}
+	s.lastChar = r
+	s.cur.x += width
+	if s.cur.x >= s.cols {
+		s.cur.x = s.cols - 1
+		s.cur.pendingWrap = s.autoWrap
+	}
+}
+
+// combine add zero width character to the last drawn cell
+func (s *screen) combine(r rune) {
+	x := s.cur.x
+	if !s.cur.pendingWrap {
+		x--
+	}
+	line := s.lines[s.cur.y].cells
+	if x > 0 && line[x].wide == cellWideTail {
+		x--
+	}
+	if x < 0 || line[x].r == 0 {
+		return
+	}
+	comb := s.cluster(line[x].comb) + string(r)
+	if len(comb) > 32 {
+		return
+	}
+	id, ok := s.clusterIds[comb]
+	if !ok {
+		if len(s.clusters) == 0xffff {
+			return
+		}
+		if s.clusterIds == nil {
+			s.clusterIds = make(map[string]uint16)
+		}
+		s.clusters = append(s.clusters, comb)
+		id = uint16(len(s.clusters))
+		s.clusterIds[comb] = id
+	}
+	line[x].comb = id
+}
+
+// cluster return combining characters by cell comb
+func (s *screen) cluster(comb uint16) string {
+	if comb == 0 {
+		return ""
+	}
+	return s.clusters[comb-1]
+}
+
+// clearWide erase the other half of wide character, when one of its halves at x is overwritten
+func (s *screen) clearWide(line []cell, x int) {
+	if x < 0 || x >= len(line) {
+		return
+	}
+	switch {
+	case line[x].wide == cellWideTail && x > 0:
+		line[x-1] = s.erasedCell()
+	case line[x].wide == cellWideHead && x+1 < len(line):
+		line[x+1] = s.erasedCell()
+	}
+}
+
+func (s *screen) lineFeed() {
+	s.cur.pendingWrap = false
+	switch {
+	case s.cur.y == s.bottom:
+		s.scrollUp(s.top, 1, true)
+	case s.cur.y < s.rows-1:
+		s.cur.y++
+	}
+}
+
+func (s *screen) reverseIndex() {
+	s.cur.pendingWrap = false
+	switch {
+	case s.cur.y == s.top:
+		s.scrollDown(s.top, 1)
+	case s.cur.y > 0:
+		s.cur.y--
+	}
+}
+
+// scrollUp move lines from start to the bottom of scroll region up by n lines.
+// If keepHistory, lines scrolled off the top of primary screen are saved to history
+func (s *screen) scrollUp(start, n int, keepHistory bool) {
+	n = min(n, s.bottom-start+1)
+	if keepHistory && start == 0 && !s.altScreen {
+		for _, line := range s.lines[:n] {
+			s.pushHistory(line)
+		}
+	}
+	// Cells of lines scrolled off are reused for new lines
+	region := s.lines[start : s.bottom+1]
+	if n == 1 {
+		first := region[0]
+		copy(region, region[1:])
+		region[len(region)-1] = first
+	} else {
+		slices.Reverse(region[:n])
+		slices.Reverse(region[n:])
+		slices.Reverse(region)
+	}
+	for i := len(region) - n; i < len(region); i++ {
+		s.clearLine(&region[i])
+	}
+}
+
+// scrollDown move lines from start to the bottom of scroll region down by n lines
+func (s *screen) scrollDown(start, n int) {
+	n = min(n, s.bottom-start+1)
+	region := s.lines[start : s.bottom+1]
+	slices.Reverse(region[:len(region)-n])
+	slices.Reverse(region[len(region)-n:])
+	slices.Reverse(region)
+	for i := range n {
+		s.clearLine(&region[i])
+	}
+}
+
+// pushHistory render line to history, each line starts and ends with default graphic rendition
+func (s *screen) pushHistory(line screenLine) {
+	h := &s.history
+	if len(h.lines) >= 2*screenHistoryLines {
+		// Dropping old lines by halves keeps pushing constant time
+		kept := h.lines[len(h.lines)-screenHistoryLines:]
+		base := kept[0].start
+		h.data = slices.Clone(h.data[base:])
+		h.lines = slices.Clone(kept)
+		for i := range h.lines {
+			h.lines[i].start -= base
+			h.lines[i].end -= base
+		}
+	}
+	r := snapshotRenderer{out: h.data, clusters: s.clusters}
+	r.line(line.cells)
+	r.attr(cellAttr{})
+	h.lines = append(h.lines, historyLine{start: len(h.data), end: len(r.out), wrapped: line.wrapped})
+	h.data = r.out
+}
+
+// historyLines return the last screenHistoryLines lines of history
+func (s *screen) historyLines() []historyLine {
+	return s.history.lines[max(len(s.history.lines)-screenHistoryLines, 0):]
+}
+
+func (s *screen) tab(n int) {
+	s.cur.pendingWrap = false
+	for ; n > 0 && s.cur.x < s.cols-1; n-- {
+		s.cur.x++
+		for s.cur.x < s.cols-1 && !s.tabStops[s.cur.x] {
+			s.cur.x++
+		}
+	}
+}
+
+func (s *screen) backTab(n int) {
+	s.cur.pendingWrap = false
+	for ; n > 0 && s.cur.x > 0; n-- {
+		s.cur.x--
+		for s.cur.x > 0 && !s.tabStops[s.cur.x] {
+			s.cur.x--
+		}
+	}
+}
+
+// moveCursor move cursor relatively, it stays inside scroll region if it was inside it
+func (s *screen) moveCursor(x, y int) {
+	top, bottom := 0, s.rows-1
+	if s.cur.y >= s.top && s.cur.y <= s.bottom {
+		top, bottom = s.top, s.bottom
+	}
+	s.cur.x = min(max(x, 0), s.cols-1)
+	s.cur.y = min(max(y, top), bottom)
+	s.cur.pendingWrap = false
+}
+
+// setCursor move cursor to absolute position, that is relative to scroll region in origin mode
+func (s *screen) setCursor(x, y int) {
+	top, bottom := 0, s.rows-1
+	if s.cur.originMode {
+		top, bottom = s.top, s.bottom
+		y += s.top
+	}
+	s.cur.x = min(max(x, 0), s.cols-1)
+	s.cur.y = min(max(y, top), bottom)
+	s.cur.pendingWrap = false
+}
+
+func (s *screen) eraseCells(line []cell, from, to int) {
+	s.clearWide(line, from)
+	s.clearWide(line, to-1)
+	erased := s.erasedCell()
+	for x := from; x < to; x++ {
+		line[x] = erased
+	}
+}
+
+func (s *screen) eraseDisplay(mode int) {
+	switch mode {
+	case 0:
+		s.eraseLine(0)
+		for y := s.cur.y + 1; y < s.rows; y++ {
+			s.clearLine(&s.lines[y])
+		}
+	case 1:
+		s.eraseLine(1)
+		for y := 0; y < s.cur.y; y++ {
+			s.clearLine(&s.lines[y])
+		}
+	case 2:
+		for y := range s.lines {
+			s.clearLine(&s.lines[y])
+		}
+	case 3:
+		s.history = screenHistory{}
+	}
+}
+
+func (s *screen) eraseLine(mode int) {
+	line := &s.lines[s.cur.y]
+	switch mode {
+	case 0:
+		s.eraseCells(line.cells, s.cur.x, s.cols)
+		line.wrapped = false
+	case 1:
+		s.eraseCells(line.cells, 0, s.cur.x+1)
+	case 2:
+		s.eraseCells(line.cells, 0, s.cols)
+		line.wrapped = false
+	}
+	s.cur.pendingWrap = false
+}
+
+func (s *screen) eraseChars(n int) {
+	s.eraseCells(s.lines[s.cur.y].cells, s.cur.x, min(s.cur.x+n, s.cols))
+	s.cur.pendingWrap = false
+}
+
+func (s *screen) insertChars(n int) {
+	line := s.lines[s.cur.y].cells
+	n = min(n, s.cols-s.cur.x)
+	s.clearWide(line, s.cur.x)
+	s.clearWide(line, s.cols-n)
+	copy(line[s.cur.x+n:], line[s.cur.x:])
+	s.eraseCells(line, s.cur.x, s.cur.x+n)
+	s.cur.pendingWrap = false
+}
+
+func (s *screen) deleteChars(n int) {
+	line := s.lines[s.cur.y].cells
+	n = min(n, s.cols-s.cur.x)
+	s.clearWide(line, s.cur.x)
+	s.clearWide(line, s.cur.x+n)
+	copy(line[s.cur.x:], line[s.cur.x+n:])
+	s.eraseCells(line, s.cols-n, s.cols)
+	s.cur.pendingWrap = false
+}
+
+func (s *screen) insertLines(n int) {
+	if s.cur.y < s.top || s.cur.y > s.bottom {
+		return
+	}
+	s.scrollDown(s.cur.y, n)
+	s.cur.x = 0
+	s.cur.pendingWrap = false
+}
+
+func (s *screen) deleteLines(n int) {
+	if s.cur.y < s.top || s.cur.y > s.bottom {
+		return
+	}
+	// Deleted lines are not scrolled off the screen, so they don't go to history
+	s.scrollUp(s.cur.y, n, false)
+	s.cur.x = 0
+	s.cur.pendingWrap = false
+}
+
+// Resize change screen size without reflow. When screen gets lower, empty lines below cursor
+// are removed first, then top lines are scrolled to history
+func (s *screen) Resize(cols, rows int) {
+	cols, rows = max(cols, 1), max(rows, 1)
+	if cols == s.cols && rows == s.rows {
+		return
+	}
+	s.lines = s.resizeLines(s.lines, cols, rows, &s.cur, !s.altScreen)
+	if s.altScreen {
+		s.mainLines = s.resizeLines(s.mainLines, cols, rows, &s.saved[0], true)
+	}
+	s.cols, s.rows = cols, rows
+	s.top, s.bottom = 0, rows-1
+	for i := range s.saved {
+		s.saved[i].x = min(s.saved[i].x, cols-1)
+		s.saved[i].y = min(s.saved[i].y, rows-1)
+	}
+	s.cur.pendingWrap = false
+	tabStops := s.tabStops
+	s.resetTabStops()
+	copy(s.tabStops, tabStops)
+}
+
+func (s *screen) resizeLines(lines []screenLine, cols, rows int, cur *screenCursor, keepHistory bool) []screenLine {
+	for len(lines) > rows && len(lines)-1 > cur.y && slices.IndexFunc(lines[len(lines)-1].cells, func(c cell) bool { return !c.blank() }) == -1 {
+		lines = lines[:len(lines)-1]
+	}
+	if extra := len(lines) - rows; extra > 0 {
+		if keepHistory {
+			for _, line := range lines[:extra] {
+				s.pushHistory(line)
+			}
+		}
+		lines = lines[extra:]
+		cur.y = max(cur.y-extra, 0)
+	}
+	res := make([]screenLine, rows)
+	for y := range res {
+		cells := make([]cell, cols)
+		if y < len(lines) {
+			copy(cells, lines[y].cells)
+			if cols < len(lines[y].cells) {
+				// Wide character cut in half
+				if cells[cols-1].wide == cellWideHead {
+					cells[cols-1] = cell{}
+				}
+			} else {
+				res[y].wrapped = lines[y].wrapped
+			}
+		}
+		res[y].cells = cells
+	}
+	cur.x = min(cur.x, cols-1)
+	cur.y = min(cur.y, rows-1)
+	return res
+}
+
+// Snapshot render screen state as terminal output: reset, history, screen content, cursor and modes.
+// Output is meant for terminal of the same size
+func (s *screen) Snapshot() []byte {
+	r := snapshotRenderer{clusters: s.clusters}
+	r.writeString("\x1bc")
+	if s.title != "" {
+		r.writeString("\x1b]2;" + s.title + "\x07")
+	}
+	mainLines, mainCursor := s.lines, s.cur
+	if s.altScreen {
+		mainLines, mainCursor = s.mainLines, s.saved[0]
+	}
+	history := s.historyLines()
+	for _, line := range history {
+		r.out = append(r.out, s.history.data[line.start:line.end]...)
+		if !line.wrapped {
+			r.newLine()
+		}
+	}
+	// Without history only used lines are rendered, with it all lines are needed to scroll history off the screen
+	last := mainCursor.y
+	if len(history) != 0 {
+		last = len(mainLines) - 1
+	}
+	for y, line := range mainLines {
+		if slices.IndexFunc(line.cells, func(c cell) bool { return !c.blank() }) != -1 {
+			last = max(last, y)
+		}
+	}
+	for y, line := range mainLines[:last+1] {
+		r.line(line.cells)
+		if y != last {
+			r.newLine()
+		}
+	}
+	if s.altScreen {
+		r.cursor(mainCursor)
+		r.writeString("\x1b[?1049h")
+		for y, line := range s.lines {
+			r.writeString("\x1b[" + strconv.Itoa(y+1) + "H")
+			r.line(line.cells)
+		}
+	}
+	if saved := s.saved[s.bufferIndex()]; saved != (screenCursor{}) {
+		r.cursor(saved)
+		r.writeString("\x1b7")
+	}
+	r.tabStops(s.tabStops)
+	if s.top != 0 || s.bottom != s.rows-1 {
+		r.writeString("\x1b[" + strconv.Itoa(s.top+1) + ";" + strconv.Itoa(s.bottom+1) + "r")
+	}
+	for _, mode := range replayedModes {
+		if s.modes[mode] {
+			r.writeString("\x1b[?" + strconv.Itoa(mode) + "h")
+		}
+	}
+	if s.modes[66] {
+		r.writeString("\x1b=")
+	}
+	if s.insertMode {
+		r.writeString("\x1b[4h")
+	}
+	if s.cursorStyle != 0 {
+		r.writeString("\x1b[" + strconv.Itoa(s.cursorStyle) + " q")
+	}
+	if s.cursorHidden {
+		r.writeString("\x1b[?25l")
+	}
+	if s.cur.originMode {
+		r.writeString("\x1b[?6h")
+	}
+	x, y := s.cur.x, s.cur.y
+	if s.cur.originMode {
+		y -= s.top
+	}
+	line := s.lines[s.cur.y].cells
+	if s.cur.pendingWrap && line[x].wide == cellWideTail && x > 0 {
+		x--
+	}
+	r.writeString("\x1b[" + strconv.Itoa(y+1) + ";" + strconv.Itoa(x+1) + "H")
+	if s.cur.pendingWrap {
+		// Pending wrap is restored by drawing the last character of line again
+		r.cell(line[x])
+	}
+	if !s.autoWrap {
+		r.writeString("\x1b[?7l")
+	}
+	r.attr(s.cur.attr)
+	if s.cur.lineDrawing {
+		r.writeString("\x1b(0")
+	}
+	return r.out
+}
+
+// snapshotRenderer writes cells with minimal graphic rendition changes
+type snapshotRenderer struct {
+	out      []byte
+	pen      cellAttr
+	clusters []string
+}
+
+func (r *snapshotRenderer) writeString(s string) {
+	r.out = append(r.out, s...)
+}
+
+func (r *snapshotRenderer) writeByte(b byte) {
+	r.out = append(r.out, b)
+}
+
+func (r *snapshotRenderer) writeRune(c rune) {
+	r.out = utf8.AppendRune(r.out, c)
+}
+
+func (r *snapshotRenderer) line(cells []cell) {
+	end := len(cells)
+	for end > 0 && cells[end-1].blank() {
+		end--
+	}
+	for _, c := range cells[:end] {
+		if c.wide != cellWideTail {
+			r.cell(c)
+		}
+	}
+}
+
+func (r *snapshotRenderer) cell(c cell) {
+	r.attr(c.attr)
+	switch {
+	case c.r == 0:
+		r.writeByte(' ')
+		return
+	case c.r < utf8.RuneSelf:
+		r.writeByte(byte(c.r))
+	default:
+		r.writeRune(c.r)
+	}
+	if c.comb != 0 {
+		r.writeString(r.clusters[c.comb-1])
+	}
+}
+
+// newLine reset rendition before line feed, otherwise new line is filled with current background
+func (r *snapshotRenderer) newLine() {
+	r.attr(cellAttr{})
+	r.writeString("\r\n")
+}
+
+func (r *snapshotRenderer) cursor(cur screenCursor) {
+	r.writeString("\x1b[" + strconv.Itoa(cur.y+1) + ";" + strconv.Itoa(cur.x+1) + "H")
+	r.attr(cur.attr)
+}
+
+func (r *snapshotRenderer) tabStops(stops []bool) {
+	defaultStops := true
+	for x, stop := range stops {
+		if stop != (x%8 == 0 && x != 0) {
+			defaultStops = false
+			break
+		}
+	}
+	if defaultStops {
+		return
+	}
+	r.writeString("\x1b[3g")
+	for x, stop := range stops {
+		if stop {
+			r.writeString("\x1b[1;" + strconv.Itoa(x+1) + "H\x1bH")
+		}
+	}
+}
+
+func (r *snapshotRenderer) attr(attr cellAttr) {
+	if attr == r.pen {
+		return
+	}
+	r.pen = attr
+	r.writeString("\x1b[0")
+	flags := []struct {
+		flag cellFlags
+		code string
+	}{
+		{cellBold, "1"}, {cellDim, "2"}, {cellItalic, "3"}, {cellUnderline, "4"},
+		{cellBlink, "5"}, {cellInverse, "7"}, {cellHidden, "8"}, {cellStrike, "9"},
+	}
+	for _, f := range flags {
+		if attr.flags&f.flag != 0 {
+			r.writeString(";" + f.code)
+		}
+	}
+	r.color(attr.fg, "3", "9", "38")
+	r.color(attr.bg, "4", "10", "48")
+	r.writeByte('m')
+}
+
+func (r *snapshotRenderer) color(color uint32, normal, bright, extended string) {
+	value := color &^ colorKindMask
+	switch color & colorKindMask {
+	case colorIndexed:
+		switch {
+		case value < 8:
+			r.writeString(";" + normal + strconv.Itoa(int(value)))
+		case value < 16:
+			r.writeString(";" + bright + strconv.Itoa(int(value-8)))
+		default:
+			r.writeString(";" + extended + ";5;" + strconv.Itoa(int(value)))
+		}
+	case colorRGB:
+		r.writeString(";" + extended + ";2;" + strconv.Itoa(int(value>>16)) + ";" + strconv.Itoa(int(value>>8&0xff)) + ";" + strconv.Itoa(int(value&0xff)))
+	}
+}
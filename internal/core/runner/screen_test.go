@@ -0,0 +1,320 @@
+package runner
+
+import (
+	"fmt"
+	"github.com/acarl005/stripansi"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// linesText return text of screen lines without trailing spaces
+func linesText(s *screen, lines []screenLine) []string {
+	res := make([]string, 0, len(lines))
+	for _, line := range lines {
+		var text strings.Builder
+		for _, c := range line.cells {
+			switch {
+			case c.wide == cellWideTail:
+			case c.r == 0:
+				text.WriteByte(' ')
+			default:
+				text.WriteRune(c.r)
+				text.WriteString(s.cluster(c.comb))
+			}
+		}
+		res = append(res, strings.TrimRight(text.String(), " "))
+	}
+	return res
+}
+
+// historyText return text of history lines, wrapped lines are joined
+func historyText(s *screen) []string {
+	res := make([]string, 0)
+	text := ""
+	for _, line := range s.historyLines() {
+		text += stripansi.Strip(string(s.history.data[line.start:line.end]))
+		if !line.wrapped {
+			res = append(res, text)
+			text = ""
+		}
+	}
+	return res
+}
+
+func TestScreen(t *testing.T) {
+	testCases := []struct {
+		name            string
+		cols, rows      int
+		input           string
+		expectedLines   []string
+		expectedHistory []string
+		expectedX       int
+		expectedY       int
+	}{
+		{
+			name:          "Plain text",
+			cols:          10,
+			rows:          3,
+			input:         "hello\r\nworld",
+			expectedLines: []string{"hello", "world", ""},
+			expectedX:     5,
+			expectedY:     1,
+		},
+		{
+			name:          "Auto wrap",
+			cols:          4,
+			rows:          3,
+			input:         "abcdef",
+			expectedLines: []string{"abcd", "ef", ""},
+			expectedX:     2,
+			expectedY:     1,
+		},
+		{
+			name:          "Pending wrap",
+			cols:          4,
+			rows:          3,
+			input:         "abcd\r\n",
+			expectedLines: []string{"abcd", "", ""},
+			expectedX:     0,
+			expectedY:     1,
+		},
+		{
+			name:            "Scroll to history",
+			cols:            5,
+			rows:            2,
+			input:           "one\r\ntwo\r\nthree",
+			expectedLines:   []string{"two", "three"},
+			expectedHistory: []string{"one"},
+			expectedX:       4,
+			expectedY:       1,
+		},
+		{
+			name:          "Cursor addressing",
+			cols:          10,
+			rows:          3,
+			input:         "hello\x1b[1;1HJ\x1b[3;4Hx",
+			expectedLines: []string{"Jello", "", "   x"},
+			expectedX:     4,
+			expectedY:     2,
+		},
+		{
+			name:          "Erase",
+			cols:          10,
+			rows:          3,
+			input:         "hello\r\nworld\x1b[3G\x1b[K\x1b[1;2H\x1b[1K",
+			expectedLines: []string{"  llo", "wo", ""},
+			expectedX:     1,
+			expectedY:     0,
+		},
+		{
+			name:          "Erase display",
+			cols:          10,
+			rows:          3,
+			input:         "hello\r\nworld\x1b[2J",
+			expectedLines: []string{"", "", ""},
+			expectedX:     5,
+			expectedY:     1,
+		},
+		{
+			name:          "Alternate screen",
+			cols:          10,
+			rows:          3,
+			input:         "main\x1b[?1049h\x1b[Halt\x1b[?1049l",
+			expectedLines: []string{"main", "", ""},
+			expectedX:     4,
+			expectedY:     0,
+		},
+		{
+			name:            "Scroll region",
+			cols:            10,
+			rows:            4,
+			input:           "top\r\n\x1b[2;3r\x1b[2;1Ha\r\nb\r\nc\x1b[r\x1b[4;1Hbottom",
+			expectedLines:   []string{"top", "b", "c", "bottom"},
+			expectedHistory: nil,
+			expectedX:       6,
+			expectedY:       3,
+		},
+		{
+			name:          "Insert and delete",
+			cols:          10,
+			rows:          3,
+			input:         "abcdef\x1b[1;3H\x1b[2@XY\x1b[1;1H\x1b[P\r\n1\r\n2\x1b[1;1H\x1b[L",
+			expectedLines: []string{"", "bXYcdef", "1"},
+			expectedX:     0,
+			expectedY:     0,
+		},
+		{
+			name:          "Wide characters",
+			cols:          5,
+			rows:          3,
+			input:         "a世界\x1b[1;3Hb",
+			expectedLines: []string{"a b界"},
+			expectedX:     3,
+			expectedY:     0,
+		},
+		{
+			name:          "Combining characters",
+			cols:          10,
+			rows:          3,
+			input:         "e\u0301!",
+			expectedLines: []string{"e\u0301!", "", ""},
+			expectedX:     2,
+			expectedY:     0,
+		},
+		{
+			name:          "Line drawing and repeat",
+			cols:          10,
+			rows:          3,
+			input:         "\x1b(0lqk\x1b(Ba\x1b[2b",
+			expectedLines: []string{"┌─┐aaa", "", ""},
+			expectedX:     6,
+			expectedY:     0,
+		},
+		{
+			name:          "Tabs and backspace",
+			cols:          20,
+			rows:          3,
+			input:         "a\tb\x08c\x1b]2;title\x07",
+			expectedLines: []string{"a       c", "", ""},
+			expectedX:     9,
+			expectedY:     0,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			whole := newScreen(tc.cols, tc.rows)
+			whole.Write([]byte(tc.input))
+			// Sequences split between writes must give the same result
+			byByte := newScreen(tc.cols, tc.rows)
+			for i := range len(tc.input) {
+				byByte.Write([]byte{tc.input[i]})
+			}
+			for _, s := range []*screen{whole, byByte} {
+				lines := linesText(s, s.lines)
+				if !slices.Equal(lines[:len(tc.expectedLines)], tc.expectedLines) {
+					t.Errorf("unexpected lines: %q", lines)
+				}
+				if history := historyText(s); !slices.Equal(history, tc.expectedHistory) {
+					t.Errorf("unexpected history: %q", history)
+				}
+				if s.cur.x != tc.expectedX || s.cur.y != tc.expectedY {
+					t.Errorf("unexpected cursor: %d, %d", s.cur.x, s.cur.y)
+				}
+			}
+		})
+	}
+}
+
+type comparableState struct {
+	screen
+	history []string
+}
+
+// comparableScreen return screen state without parser state and details, that snapshot doesn't restore
+func comparableScreen(s *screen) comparableState {
+	res := *s
+	res.state, res.params, res.private, res.inter, res.osc, res.utf8, res.lastChar = 0, nil, 0, nil, nil, nil, 0
+	normalize := func(lines []screenLine) []screenLine {
+		if lines == nil {
+			return nil
+		}
+		res := make([]screenLine, 0, len(lines))
+		for _, line := range lines {
+			cells := slices.Clone(line.cells)
+			for i, c := range cells {
+				// Erased cell with background is rendered as space
+				if c.r == ' ' {
+					cells[i].r = 0
+				}
+			}
+			res = append(res, screenLine{cells: cells})
+		}
+		return res
+	}
+	res.lines = normalize(s.lines)
+	res.mainLines = normalize(s.mainLines)
+	res.history = screenHistory{}
+	var history []string
+	for _, line := range s.historyLines() {
+		history = append(history, fmt.Sprintf("%q %t", s.history.data[line.start:line.end], line.wrapped))
+	}
+	return comparableState{screen: res, history: history}
+}
+
+func TestScreen_Snapshot(t *testing.T) {
+	testCases := []struct {
+		name  string
+		input string
+	}{
+		{
+			name:  "Empty",
+			input: "",
+		},
+		{
+			name:  "Colors",
+			input: "\x1b[1;31mred\x1b[0m \x1b[38;5;200;48;2;1;2;3mcolors\x1b[4;97m\r\nunderline\x1b[44m\x1b[K",
+		},
+		{
+			name:  "History",
+			input: strings.Repeat("line of output, that is long enough to wrap\r\n", 30) + "\x1b[7mlast",
+		},
+		{
+			name:  "Alternate screen",
+			input: "shell$ \x1b[s\x1b[?1049h\x1b[2;5H\x1b[32meditor\x1b[3;1Hstatus\x1b7\x1b[5;5H",
+		},
+		{
+			name:  "Scroll region and origin mode",
+			input: "header\x1b[3;8r\x1b[?6h\x1b[2;3Hinside\x1b[?25l\x1b[?2004h\x1b[?1h\x1b]0;title\x07",
+		},
+		{
+			name:  "Pending wrap",
+			input: "\x1b[1;40H\x1b[35mab",
+		},
+		{
+			name:  "Pending wrap after wide character",
+			input: "\x1b[1;39H世",
+		},
+		{
+			name:  "Modes and charset",
+			input: "\x1b[?7l\x1b[4h\x1b[5 q\x1b=\x1b[3g\x1b[1;5H\x1bH\x1b[1;1H\x1b(0",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			source := newScreen(40, 10)
+			source.Write([]byte(tc.input))
+			snapshot := source.Snapshot()
+
+			restored := newScreen(40, 10)
+			restored.Write([]byte("garbage\x1b[?1049h\x1b[31m"))
+			restored.Write(snapshot)
+			if expected, actual := comparableScreen(source), comparableScreen(restored); !reflect.DeepEqual(expected, actual) {
+				t.Fatalf("restored screen differs\nexpected: %+v\nactual:   %+v\nsnapshot: %q", expected, actual, snapshot)
+			}
+		})
+	}
+}
+
+func TestScreen_Resize(t *testing.T) {
+	s := newScreen(10, 4)
+	s.Write([]byte("one\r\ntwo\r\nthree世"))
+	s.Resize(6, 2)
+	if lines := linesText(s, s.lines); !slices.Equal(lines, []string{"two", "three"}) {
+		t.Fatalf("unexpected lines after resize: %q", lines)
+	}
+	if history := historyText(s); !slices.Equal(history, []string{"one"}) {
+		t.Fatalf("unexpected history after resize: %q", history)
+	}
+	if s.cur.x != 5 || s.cur.y != 1 {
+		t.Fatalf("unexpected cursor after resize: %d, %d", s.cur.x, s.cur.y)
+	}
+	s.Resize(20, 5)
+	s.Write([]byte("\x1b[5;20Hx"))
+	if lines := linesText(s, s.lines); !slices.Equal(lines, []string{"two", "three", "", "", strings.Repeat(" ", 19) + "x"}) {
+		t.Fatalf("unexpected lines after resize: %q", lines)
+	}
+}
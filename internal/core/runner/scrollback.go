@@ -0,0 +1,69 @@
+package runner
+
+// scrollback keeps the last limit bytes of session output in ring buffer, or all output if limit <= 0.
+// Data is addressed by absolute offsets, so every client can track its own read position.
+type scrollback struct {
+	data  []byte // ring of limit bytes, allocated on first write
+	start int64  // absolute offset of the oldest kept byte
+	end   int64  // absolute offset after the last written byte
+	limit int
+}
+
+func newScrollback(limit int) *scrollback {
+	return &scrollback{limit: limit}
+}
+
+func (b *scrollback) Write(p []byte) {
+	if b.limit <= 0 {
+		b.data = append(b.data, p...)
+		b.end += int64(len(p))
+		return
+	}
+	if b.data == nil {
+		b.data = make([]byte, b.limit)
+	}
+	if len(p) > b.limit {
+		b.end += int64(len(p) - b.limit)
+		p = p[len(p)-b.limit:]
+	}
+	for len(p) > 0 {
+		n := copy(b.data[b.end%int64(b.limit):], p)
+		p = p[n:]
+		b.end += int64(n)
+	}
+	b.start = max(b.start, b.end-int64(b.limit))
+}
+
+// Start return offset of the oldest byte still kept
+func (b *scrollback) Start() int64 {
+	return b.start
+}
+
+// End return offset after the last written byte
+func (b *scrollback) End() int64 {
+	return b.end
+}
+
+// Limit return max count of kept bytes, <= 0 for unlimited
+func (b *scrollback) Limit() int {
+	return b.limit
+}
+
+// ReadFrom return copy of data since offset and offset for the next read.
+// If offset already dropped out of buffer, data returned from the oldest kept byte.
+func (b *scrollback) ReadFrom(offset int64) ([]byte, int64) {
+	if offset < b.start {
+		offset = b.start
+	}
+	if offset >= b.end {
+		return nil, b.end
+	}
+	res := make([]byte, b.end-offset)
+	if b.limit <= 0 {
+		copy(res, b.data[offset:])
+		return res, b.end
+	}
+	n := copy(res, b.data[offset%int64(b.limit):])
+	copy(res[n:], b.data)
+	return res, b.end
+}
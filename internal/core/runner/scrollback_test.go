@@ -0,0 +1,80 @@
+package runner
+
+import (
+	"testing"
+)
+
+func TestScrollback(t *testing.T) {
+	testCases := []struct {
+		name         string
+		limit        int
+		writes       []string
+		readFrom     int64
+		expectedData string
+		expectedNext int64
+	}{
+		{
+			name:         "Read everything",
+			limit:        10,
+			writes:       []string{"hello", " world"},
+			readFrom:     0,
+			expectedData: "ello world",
+			expectedNext: 11,
+		},
+		{
+			name:         "Read from middle",
+			limit:        100,
+			writes:       []string{"hello", " world"},
+			readFrom:     5,
+			expectedData: " world",
+			expectedNext: 11,
+		},
+		{
+			name:         "Nothing new",
+			limit:        100,
+			writes:       []string{"hello"},
+			readFrom:     5,
+			expectedData: "",
+			expectedNext: 5,
+		},
+		{
+			name:         "Wrap around",
+			limit:        8,
+			writes:       []string{"hello", " world", "!"},
+			readFrom:     4,
+			expectedData: "o world!",
+			expectedNext: 12,
+		},
+		{
+			name:         "Write bigger than limit",
+			limit:        4,
+			writes:       []string{"ab", "hello world"},
+			readFrom:     0,
+			expectedData: "orld",
+			expectedNext: 13,
+		},
+		{
+			name:         "Unlimited",
+			limit:        0,
+			writes:       []string{"hello", " world"},
+			readFrom:     0,
+			expectedData: "hello world",
+			expectedNext: 11,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := newScrollback(tc.limit)
+			for _, w := range tc.writes {
+				buf.Write([]byte(w))
+			}
+			data, next := buf.ReadFrom(tc.readFrom)
+			if string(data) != tc.expectedData {
+				t.Errorf("unexpected data: %q, need %q", data, tc.expectedData)
+			}
+			if next != tc.expectedNext {
+				t.Errorf("unexpected next offset: %d, need %d", next, tc.expectedNext)
+			}
+		})
+	}
+}
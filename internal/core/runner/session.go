@@ -0,0 +1,911 @@
+package runner
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/utils"
+	"github.com/gofiber/fiber/v2/log"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	exitGracePeriod     = time.Second
+	maskerFlushDelay    = 200 * time.Millisecond
+	outputBufferSize    = 32 * 1024
+	droppedOutputMarker = "\r\n\x1b[1;33m[%d bytes of output skipped, client is too slow]\x1b[0m\r\n"
+	idleWarning         = "No input for %s, command will be terminated in %s"
+	idleWarningNoLimit  = "No input for %s"
+)
+
+// outputBufferPool keeps read buffers of command output, shared by all sessions
+var outputBufferPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, outputBufferSize)
+		return &buf
+	},
+}
+
+type sessionClient struct {
+	viewer   entities.Viewer
+	notify   chan struct{}
+	presence chan struct{} // notified when viewers joined or left
+	status   chan struct{} // notified when command is stopping or finished
+	warnings chan string
+	cancel   context.CancelFunc
+	offset   int64 // offset of output, that is already sent to client
+}
+
+// session is a running command, that lives independent of connected clients
+type session struct {
+	id             string
+	commandId      uint
+	process        entities.RunningCommand
+	recorder       entities.RunRecorder
+	masker         *outputMasker // nil if command uses no secrets
+	stderrMasker   *outputMasker // masker of stderr of piped command
+	detachTimeout  time.Duration
+	killPolicy     entities.KillPolicy
+	overflowPolicy entities.OverflowPolicy
+
+	mu            sync.Mutex
+	output        *scrollback
+	piped         bool                  // output comes from stdout and stderr pipes instead of terminal
+	sources       []entities.OutputSpan // where kept output of piped command switches between stdout and stderr
+	screen        *screen               // terminal state, that late viewers receive instead of the whole output
+	outputSpace   *sync.Cond            // broadcast when client read output or disconnected, with OverflowBlock
+	finished      bool
+	clients       []*sessionClient // in join order
+	detachTimer   *time.Timer
+	timeoutTimer  *time.Timer
+	idlePolicy    entities.IdlePolicy
+	idleTimer     *time.Timer
+	lastInputAt   time.Time
+	idleWarned    bool   // warning sent since the last input
+	stopReason    string // first reason command was stopped by server, empty if not stopped
+	droppedOutput int64
+	pausedFor     time.Duration
+
+	outputMu   sync.Mutex // keeps order of output in log and scrollback, when stdout and stderr are written together
+	writeMu    sync.Mutex
+	done       chan struct{} // closed when output finished
+	exited     chan struct{} // closed when exitStatus is set
+	exitStatus entities.ExitStatus
+}
+
+// newRandomId generate id of session or viewer
+func newRandomId() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func newSession(commandId uint, process entities.RunningCommand, cols, rows uint16, scrollbackSize int, overflowPolicy entities.OverflowPolicy, detachTimeout time.Duration, killPolicy entities.KillPolicy) (*session, error) {
+	id, err := newRandomId()
+	if err != nil {
+		return nil, err
+	}
+	s := &session{
+		id:             id,
+		commandId:      commandId,
+		process:        process,
+		detachTimeout:  detachTimeout,
+		killPolicy:     killPolicy,
+		overflowPolicy: overflowPolicy,
+		output:         newScrollback(scrollbackSize),
+		screen:         newScreen(int(cols), int(rows)),
+		done:           make(chan struct{}),
+		exited:         make(chan struct{}),
+	}
+	_, s.piped = process.(entities.PipedCommand)
+	s.outputSpace = sync.NewCond(&s.mu)
+	return s, nil
+}
+
+// readOutput copy command output to scrollback and run log until command finished.
+// Output is read by chunks as is, without decoding. Stdout and stderr of piped command are read in parallel
+func (s *session) readOutput() {
+	defer close(s.done)
+	go func() {
+		// Exited command has no output to wait space for
+		<-s.process.Done()
+		s.mu.Lock()
+		s.outputSpace.Broadcast()
+		s.mu.Unlock()
+	}()
+	if piped, ok := s.process.(entities.PipedCommand); ok {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.readSource(piped.GetErrorReader(), entities.OutputStderr, s.stderrMasker)
+		}()
+		s.readSource(piped.GetReader(), entities.OutputStdout, s.masker)
+		wg.Wait()
+	} else {
+		s.readSource(s.process.GetReader(), "", s.masker)
+	}
+	s.mu.Lock()
+	s.finished = true
+	if s.detachTimer != nil {
+		s.detachTimer.Stop()
+	}
+	s.notifyClients()
+	s.notifyStatus()
+	s.mu.Unlock()
+}
+
+// readSource read one output of command until it closed, empty source is for terminal
+func (s *session) readSource(reader io.Reader, source entities.OutputSource, masker *outputMasker) {
+	buf := outputBufferPool.Get().(*[]byte)
+	defer outputBufferPool.Put(buf)
+	readBuf := *buf
+	if limit := s.output.Limit(); limit > 0 && limit < len(readBuf) {
+		// Chunk bigger than buffer would overwrite unsent output even for client without lag
+		readBuf = readBuf[:limit]
+	}
+	// Output held by masker is shown, when command prints nothing after it for maskerFlushDelay, like prompt waiting for input.
+	// Flush is ordered with read output by mutex, generation drops flush scheduled before next output
+	var flushMu sync.Mutex
+	flushGeneration := 0
+	for {
+		n, err := reader.Read(readBuf)
+		if n > 0 {
+			s.waitOutputSpace(n)
+			flushMu.Lock()
+			flushGeneration++
+			// writeOutput copies data, so buffer can be reused
+			s.writeOutput(masker.Mask(readBuf[:n]), source)
+			if masker.Holds() {
+				generation := flushGeneration
+				time.AfterFunc(maskerFlushDelay, func() {
+					flushMu.Lock()
+					defer flushMu.Unlock()
+					if generation == flushGeneration {
+						s.writeOutput(masker.Flush(), source)
+					}
+				})
+			}
+			flushMu.Unlock()
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Debug("Error reading command output ", err)
+			}
+			break
+		}
+	}
+	flushMu.Lock()
+	flushGeneration++
+	s.writeOutput(masker.Flush(), source)
+	flushMu.Unlock()
+}
+
+func (s *session) writeOutput(data []byte, source entities.OutputSource) {
+	if len(data) == 0 {
+		return
+	}
+	s.outputMu.Lock()
+	defer s.outputMu.Unlock()
+	if source == "" {
+		_, _ = s.recorder.Write(data)
+	} else {
+		s.recorder.WriteSource(source, data)
+	}
+	s.mu.Lock()
+	if source != "" {
+		s.addSource(source)
+	}
+	s.output.Write(data)
+	if source == "" {
+		s.screen.Write(data)
+	} else {
+		s.screen.Write(utils.PipedOutputToTerminal(data, source))
+	}
+	s.notifyClients()
+	s.mu.Unlock()
+}
+
+// addSource mark that output since the end comes from source and forget sources of output, that dropped out of buffer.
+// Must be called with s.mu locked
+func (s *session) addSource(source entities.OutputSource) {
+	if len(s.sources) == 0 || s.sources[len(s.sources)-1].Source != source {
+		s.sources = append(s.sources, entities.OutputSpan{Offset: s.output.End(), Source: source})
+	}
+	start := s.output.Start()
+	dropped := 0
+	for dropped+1 < len(s.sources) && s.sources[dropped+1].Offset <= start {
+		dropped++
+	}
+	s.sources = slices.Delete(s.sources, 0, dropped)
+}
+
+// controllersLag return unsent output of the slowest controller, spectators never pause command.
+// Must be called with s.mu locked
+func (s *session) controllersLag() int64 {
+	var lag int64
+	for _, client := range s.clients {
+		if client.viewer.Role == entities.ViewerController {
+			lag = max(lag, s.output.End()-client.offset)
+		}
+	}
+	return lag
+}
+
+// waitOutputSpace pause reading output with OverflowBlock, while size bytes of new output
+// would push data, that connected controller has not received yet, out of buffer.
+// Command is blocked by terminal flow control, when its output is not read
+func (s *session) waitOutputSpace(size int) {
+	limit := int64(s.output.Limit())
+	if s.overflowPolicy != entities.OverflowBlock || limit <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var pausedAt time.Time
+	for {
+		lag := s.controllersLag()
+		if lag == 0 || lag+int64(size) <= limit {
+			break
+		}
+		select {
+		case <-s.process.Done():
+			return
+		default:
+		}
+		if pausedAt.IsZero() {
+			pausedAt = time.Now()
+		}
+		s.outputSpace.Wait()
+	}
+	if !pausedAt.IsZero() {
+		s.pausedFor += time.Since(pausedAt)
+	}
+}
+
+// notifyClients must be called with s.mu locked
+func (s *session) notifyClients() {
+	for _, client := range s.clients {
+		select {
+		case client.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// notifyPresence must be called with s.mu locked
+func (s *session) notifyPresence() {
+	for _, client := range s.clients {
+		select {
+		case client.presence <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// viewers return connected viewers in join order, must be called with s.mu locked
+func (s *session) viewers() []entities.Viewer {
+	viewers := make([]entities.Viewer, 0, len(s.clients))
+	for _, client := range s.clients {
+		viewers = append(viewers, client.viewer)
+	}
+	return viewers
+}
+
+// newClient create client of session, it is canceled with returned context
+func newClient(ctx context.Context, name string, role entities.ViewerRole) (*sessionClient, context.Context, error) {
+	viewerId, err := newRandomId()
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return &sessionClient{
+		viewer:   entities.Viewer{ID: viewerId, Name: name, Role: role, JoinedAt: time.Now()},
+		notify:   make(chan struct{}, 1),
+		presence: make(chan struct{}, 1),
+		status:   make(chan struct{}, 1),
+		warnings: make(chan string, 1),
+		cancel:   cancel,
+	}, ctx, nil
+}
+
+// addClient must be called with s.mu locked, client.offset must be set before unlock
+func (s *session) addClient(client *sessionClient) {
+	if s.detachTimer != nil {
+		s.detachTimer.Stop()
+		s.detachTimer = nil
+	}
+	s.clients = append(s.clients, client)
+	s.outputSpace.Broadcast()
+	s.notifyPresence()
+}
+
+// attach connect new viewer to session, other viewers keep watching.
+// Client receive snapshot of terminal screen or, without snapshot, all kept scrollback first, then new output.
+func (s *session) attach(ctx context.Context, name string, role entities.ViewerRole, snapshot bool) (*entities.CommandInputOutput, error) {
+	client, ctx, err := newClient(ctx, name, role)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.addClient(client)
+	var screenSnapshot []byte
+	offset := s.output.Start()
+	if snapshot {
+		screenSnapshot = s.screen.Snapshot()
+		offset = s.output.End()
+	}
+	client.offset = offset
+	s.mu.Unlock()
+
+	inputChan := make(chan string)
+	outputChan := make(chan []byte)
+	exitChan := make(chan entities.ExitStatus, 1)
+	viewersChan := make(chan []entities.Viewer)
+
+	go func() {
+		<-ctx.Done()
+		s.detach(client)
+	}()
+	go func() {
+		defer close(outputChan)
+		s.pumpOutput(ctx, client, screenSnapshot, offset, func(event entities.OutputEvent) bool {
+			data := event.Data
+			if event.Source != "" {
+				data = utils.PipedOutputToTerminal(data, event.Source)
+			}
+			select {
+			case outputChan <- data:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}, exitChan)
+	}()
+	go s.pumpInput(ctx, client, inputChan)
+	go s.pumpViewers(ctx, client, viewersChan)
+
+	return &entities.CommandInputOutput{
+		SessionID: s.id,
+		Viewer:    client.viewer,
+		Input:     inputChan,
+		Output:    outputChan,
+		Exit:      exitChan,
+		Viewers:   viewersChan,
+		Warnings:  client.warnings,
+	}, nil
+}
+
+// stream connect read-only spectator, that receives raw output since offset, each chunk with offset to resume from.
+// Negative offset means from the oldest kept byte, offset after the end is moved to the end
+func (s *session) stream(ctx context.Context, name string, offset int64) (*entities.OutputStream, error) {
+	client, ctx, err := newClient(ctx, name, entities.ViewerSpectator)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.addClient(client)
+	if offset < 0 {
+		offset = s.output.Start()
+	}
+	offset = min(offset, s.output.End())
+	client.offset = offset
+	s.mu.Unlock()
+
+	outputChan := make(chan entities.OutputEvent)
+	exitChan := make(chan entities.ExitStatus, 1)
+	statusChan := make(chan entities.SessionStatus)
+
+	go func() {
+		<-ctx.Done()
+		s.detach(client)
+	}()
+	go func() {
+		defer close(outputChan)
+		s.pumpOutput(ctx, client, nil, offset, func(event entities.OutputEvent) bool {
+			select {
+			case outputChan <- event:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}, exitChan)
+	}()
+	go s.pumpStatus(ctx, client, statusChan)
+
+	return &entities.OutputStream{
+		SessionID: s.id,
+		Viewer:    client.viewer,
+		Output:    outputChan,
+		Status:    statusChan,
+		Exit:      exitChan,
+	}, nil
+}
+
+// detach disconnect client, and if nobody connected, stop command after detach timeout
+func (s *session) detach(client *sessionClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	index := slices.Index(s.clients, client)
+	if index == -1 {
+		return
+	}
+	s.clients = slices.Delete(s.clients, index, index+1)
+	s.outputSpace.Broadcast()
+	s.notifyPresence()
+	if s.finished || len(s.clients) != 0 {
+		return
+	}
+	s.detachTimer = time.AfterFunc(s.detachTimeout, func() {
+		s.mu.Lock()
+		abandoned := len(s.clients) == 0
+		s.mu.Unlock()
+		if abandoned {
+			log.Debug("Session abandoned, stopping command ", s.id)
+			s.stop(entities.StopReasonAbandoned)
+		}
+	})
+}
+
+// pumpOutput send screen snapshot, if any, and all output since offset as one chunk, so output produced while client is busy is merged.
+// If client lagged and its output was overwritten, it receives marker with count of skipped bytes.
+// Output of piped command is sent as chunk for each source, marker and snapshot are sent before them without source.
+// send gets chunk with offset after it, it returns false if client is gone
+func (s *session) pumpOutput(ctx context.Context, client *sessionClient, screenSnapshot []byte, offset int64, send func(event entities.OutputEvent) bool, exit chan<- entities.ExitStatus) {
+	for {
+		s.mu.Lock()
+		var skipped int64
+		if start := s.output.Start(); offset < start {
+			skipped = start - offset
+			s.droppedOutput += skipped
+		}
+		data, next := s.output.ReadFrom(offset)
+		// Offset after snapshot and marker, they are not part of output
+		dataOffset := next
+		var events []entities.OutputEvent
+		if s.piped {
+			dataOffset = next - int64(len(data))
+			events = utils.SplitOutputBySource(data, dataOffset, s.sources)
+			data = nil
+		}
+		finished := s.finished
+		s.mu.Unlock()
+		if skipped != 0 {
+			data = append([]byte(fmt.Sprintf(droppedOutputMarker, skipped)), data...)
+		}
+		if screenSnapshot != nil {
+			data = append(screenSnapshot, data...)
+			screenSnapshot = nil
+		}
+		if len(data) != 0 {
+			events = append([]entities.OutputEvent{{Data: data, Offset: dataOffset, Skipped: skipped}}, events...)
+		}
+		if len(events) != 0 {
+			offset = next
+			for _, event := range events {
+				if !send(event) {
+					return
+				}
+			}
+			s.mu.Lock()
+			client.offset = offset
+			s.outputSpace.Broadcast()
+			s.mu.Unlock()
+			continue
+		}
+		if finished {
+			select {
+			case <-s.exited:
+				exit <- s.exitStatus
+			case <-ctx.Done():
+			}
+			return
+		}
+		select {
+		case <-client.notify:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// pumpViewers send viewers list to client on every join and leave
+func (s *session) pumpViewers(ctx context.Context, client *sessionClient, viewers chan<- []entities.Viewer) {
+	for {
+		select {
+		case <-client.presence:
+		case <-ctx.Done():
+			return
+		}
+		s.mu.Lock()
+		list := s.viewers()
+		s.mu.Unlock()
+		select {
+		case viewers <- list:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// pumpStatus send status of session to client on start and every change
+func (s *session) pumpStatus(ctx context.Context, client *sessionClient, status chan<- entities.SessionStatus) {
+	for {
+		s.mu.Lock()
+		current := entities.SessionStatus{State: entities.SessionRunning, StopReason: s.stopReason}
+		if s.finished {
+			current.State = entities.SessionFinished
+		} else if s.stopReason != "" {
+			current.State = entities.SessionStopping
+		}
+		s.mu.Unlock()
+		select {
+		case status <- current:
+		case <-ctx.Done():
+			return
+		}
+		if current.State == entities.SessionFinished {
+			return
+		}
+		select {
+		case <-client.status:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// notifyStatus must be called with s.mu locked
+func (s *session) notifyStatus() {
+	for _, client := range s.clients {
+		select {
+		case client.status <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// pumpInput pass client input to command, input of spectators is dropped
+func (s *session) pumpInput(ctx context.Context, client *sessionClient, input <-chan string) {
+	for {
+		select {
+		case data := <-input:
+			if client.viewer.Role != entities.ViewerController {
+				continue
+			}
+			if err := s.write(data); err != nil {
+				log.Warn("Error writing input to command", err)
+				return
+			}
+			s.touchInput()
+		case <-s.done:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *session) write(data string) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	writer := s.process.GetWriter()
+	eof := false
+	if s.piped {
+		// Pipe has no line discipline of terminal: Enter is turned to line feed and Ctrl+D closes stdin here
+		data, _, eof = strings.Cut(data, "\x04")
+		data = strings.ReplaceAll(data, "\r", "\n")
+	}
+	if _, err := writer.Write([]byte(data)); err != nil {
+		return err
+	}
+	if closer, ok := writer.(io.Closer); ok && eof {
+		return closer.Close()
+	}
+	if flusher, ok := writer.(interface{ Flush() error }); ok {
+		if err := flusher.Flush(); err != nil {
+			log.Warn("Error flushing input", err)
+		}
+	}
+	return nil
+}
+
+// finish wait for command exit and save result to run history.
+// Called after output closed, so command that keeps running without output is killed after exitGracePeriod.
+func (s *session) finish() {
+	select {
+	case <-s.process.Done():
+	case <-time.After(exitGracePeriod):
+		s.terminate()
+		<-s.process.Done()
+	}
+	// Kill after exit releases console resources, processes left in background are killed by runner before command is reaped
+	s.terminate()
+	s.mu.Lock()
+	if s.timeoutTimer != nil {
+		s.timeoutTimer.Stop()
+	}
+	if s.idleTimer != nil {
+		s.idleTimer.Stop()
+	}
+	stopReason := s.stopReason
+	s.mu.Unlock()
+	s.exitStatus = s.process.ExitStatus()
+	s.exitStatus.StopReason = stopReason
+	if err := s.recorder.Finish(s.exitStatus); err != nil {
+		log.Warn("Error saving run result: ", err)
+	}
+	close(s.exited)
+}
+
+func (s *session) info() entities.SessionInfo {
+	run := s.recorder.GetRun()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return entities.SessionInfo{
+		SessionID: s.id,
+		CommandID: s.commandId,
+		RunID:     run.ID,
+		StartedAt: run.StartedAt,
+		Finished:  s.finished,
+		Viewers:   s.viewers(),
+	}
+}
+
+// stats return current state of output buffer
+func (s *session) stats() entities.SessionStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := entities.SessionStats{
+		SessionID:      s.id,
+		OverflowPolicy: s.overflowPolicy,
+		BufferLimit:    s.output.Limit(),
+		Buffered:       s.output.End() - s.output.Start(),
+		TotalOutput:    s.output.End(),
+		DroppedOutput:  s.droppedOutput,
+		PausedMs:       s.pausedFor.Milliseconds(),
+		Finished:       s.finished,
+	}
+	for _, client := range s.clients {
+		stats.ClientConnected = true
+		stats.ClientLag = max(stats.ClientLag, s.output.End()-client.offset)
+	}
+	return stats
+}
+
+// checkController return error, if viewer can't control command. Empty viewer id is not a viewer, like API client
+func (s *session) checkController(viewerId string) error {
+	if viewerId == "" {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, client := range s.clients {
+		if client.viewer.ID == viewerId {
+			if client.viewer.Role != entities.ViewerController {
+				return projectErrors.ErrSpectator
+			}
+			return nil
+		}
+	}
+	return projectErrors.ErrNotFound
+}
+
+// signal send signal to command, if it is still running
+func (s *session) signal(viewerId string, signal entities.Signal) error {
+	if err := s.checkController(viewerId); err != nil {
+		return err
+	}
+	select {
+	case <-s.process.Done():
+		return projectErrors.ErrSessionFinished
+	default:
+	}
+	if err := s.process.Signal(signal); err != nil {
+		return err
+	}
+	s.touchInput()
+	return nil
+}
+
+// resize change terminal size of command, if it is still running
+func (s *session) resize(viewerId string, cols, rows uint16) error {
+	if err := s.checkController(viewerId); err != nil {
+		return err
+	}
+	if cols == 0 || rows == 0 {
+		return projectErrors.ErrBadTerminalSize
+	}
+	select {
+	case <-s.process.Done():
+		return projectErrors.ErrSessionFinished
+	default:
+	}
+	if err := s.process.Resize(cols, rows); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.screen.Resize(int(cols), int(rows))
+	s.mu.Unlock()
+	s.recorder.Resize(cols, rows)
+	return nil
+}
+
+// watchIdleInput warn viewers and stop command by idle policy, when controllers send no input
+func (s *session) watchIdleInput(policy entities.IdlePolicy) {
+	if policy.WarnAfterMs == 0 && policy.TerminateAfterMs == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.idlePolicy = policy
+	s.lastInputAt = time.Now()
+	s.scheduleIdleCheck()
+}
+
+// touchInput reset idle time after input from controller
+func (s *session) touchInput() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastInputAt = time.Now()
+	s.idleWarned = false
+}
+
+// scheduleIdleCheck start timer for the next idle policy step. Input does not reset timer,
+// check reschedules itself by the time of the last input. Must be called with s.mu locked
+func (s *session) scheduleIdleCheck() {
+	warnAfter := time.Duration(s.idlePolicy.WarnAfterMs) * time.Millisecond
+	terminateAfter := time.Duration(s.idlePolicy.TerminateAfterMs) * time.Millisecond
+	idle := time.Since(s.lastInputAt)
+	var next time.Duration
+	if warnAfter != 0 && !s.idleWarned && (terminateAfter == 0 || warnAfter < terminateAfter) {
+		next = warnAfter
+	} else if terminateAfter != 0 {
+		next = terminateAfter
+	} else {
+		// Warned and nothing left to do until next input, check again after the same time
+		next = idle + warnAfter
+	}
+	s.idleTimer = time.AfterFunc(max(next-idle, 0), s.checkIdle)
+}
+
+func (s *session) checkIdle() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.finished {
+		return
+	}
+	warnAfter := time.Duration(s.idlePolicy.WarnAfterMs) * time.Millisecond
+	terminateAfter := time.Duration(s.idlePolicy.TerminateAfterMs) * time.Millisecond
+	idle := time.Since(s.lastInputAt)
+	if terminateAfter != 0 && idle >= terminateAfter {
+		log.Debug("Session idle, stopping command ", s.id)
+		go s.stop(entities.StopReasonIdle)
+		return
+	}
+	if warnAfter != 0 && !s.idleWarned && idle >= warnAfter {
+		s.idleWarned = true
+		warning := fmt.Sprintf(idleWarningNoLimit, warnAfter)
+		if terminateAfter != 0 {
+			warning = fmt.Sprintf(idleWarning, warnAfter, terminateAfter-warnAfter)
+		}
+		for _, client := range s.clients {
+			select {
+			case client.warnings <- warning:
+			default:
+			}
+		}
+	}
+	s.scheduleIdleCheck()
+}
+
+// setTimeout stop command by kill policy after timeout
+func (s *session) setTimeout(timeout time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.timeoutTimer = time.AfterFunc(timeout, func() {
+		log.Debug("Session timed out, stopping command ", s.id)
+		s.stop(entities.StopReasonTimeout)
+	})
+}
+
+// stop send signals of kill policy to command one by one until it exited, SIGKILL sent last.
+// Blocks until command killed, only first call does something.
+func (s *session) stop(reason string) {
+	s.mu.Lock()
+	if s.stopReason != "" {
+		s.mu.Unlock()
+		return
+	}
+	s.stopReason = reason
+	s.notifyStatus()
+	s.mu.Unlock()
+
+	steps := []struct {
+		signal entities.Signal
+		grace  time.Duration
+	}{
+		{entities.SignalInterrupt, time.Duration(s.killPolicy.InterruptGraceMs) * time.Millisecond},
+		{entities.SignalTerminate, time.Duration(s.killPolicy.TerminateGraceMs) * time.Millisecond},
+	}
+	for _, step := range steps {
+		if step.grace == 0 {
+			continue
+		}
+		select {
+		case <-s.process.Done():
+			return
+		default:
+		}
+		if err := s.process.Signal(step.signal); err != nil {
+			log.Warn("Error sending signal to command ", step.signal, err)
+			continue
+		}
+		select {
+		case <-s.process.Done():
+			return
+		case <-time.After(step.grace):
+		}
+	}
+	s.terminate()
+}
+
+func (s *session) terminate() {
+	err := s.process.Kill()
+	if err != nil {
+		log.Warn("Error while killing command ", err)
+	}
+}
+
+type sessionsStorage struct {
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+func newSessionsStorage() *sessionsStorage {
+	return &sessionsStorage{sessions: make(map[string]*session)}
+}
+
+func (st *sessionsStorage) add(s *session) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.sessions[s.id] = s
+}
+
+func (st *sessionsStorage) get(id string) (*session, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	s, ok := st.sessions[id]
+	if !ok {
+		return nil, projectErrors.ErrNotFound
+	}
+	return s, nil
+}
+
+func (st *sessionsStorage) remove(id string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	delete(st.sessions, id)
+}
+
+func (st *sessionsStorage) list() []*session {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	res := make([]*session, 0, len(st.sessions))
+	for _, s := range st.sessions {
+		res = append(res, s)
+	}
+	return res
+}
@@ -0,0 +1,163 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	"github.com/gofiber/fiber/v2/log"
+	"io"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// fakeCommand is command, that prints prepared output and exits
+type fakeCommand struct {
+	output io.Reader
+	done   chan struct{}
+}
+
+func (c *fakeCommand) GetReader() io.Reader {
+	return &closingReader{reader: c.output, done: c.done}
+}
+
+func (c *fakeCommand) GetWriter() io.Writer                { return io.Discard }
+func (c *fakeCommand) Done() <-chan struct{}               { return c.done }
+func (c *fakeCommand) ExitStatus() entities.ExitStatus     { return entities.ExitStatus{} }
+func (c *fakeCommand) Signal(signal entities.Signal) error { return nil }
+func (c *fakeCommand) Resize(cols, rows uint16) error      { return nil }
+func (c *fakeCommand) Kill() error                         { return nil }
+
+// closingReader close done after output read, like exited command
+type closingReader struct {
+	reader io.Reader
+	done   chan struct{}
+}
+
+func (r *closingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if err == io.EOF {
+		select {
+		case <-r.done:
+		default:
+			close(r.done)
+		}
+	}
+	return n, err
+}
+
+type discardRecorder struct {
+	run entities.Run
+}
+
+func (r *discardRecorder) Write(p []byte) (int, error)               { return len(p), nil }
+func (r *discardRecorder) WriteSource(entities.OutputSource, []byte) {}
+func (r *discardRecorder) GetRun() *entities.Run                     { return &r.run }
+func (r *discardRecorder) Resize(cols, rows uint16)                  {}
+func (r *discardRecorder) Finish(status entities.ExitStatus) error   { return nil }
+
+var droppedMarkerRegexp = regexp.MustCompile(`\r\n\x1b\[1;33m\[\d+ bytes of output skipped, client is too slow\]\x1b\[0m\r\n`)
+
+func TestSession_Overflow(t *testing.T) {
+	log.SetLevel(0)
+	const bufferLimit = 1024
+	const outputSize = 64 * 1024
+	testCases := []struct {
+		name           string
+		overflowPolicy entities.OverflowPolicy
+		expectDropped  bool
+	}{
+		{
+			name:           "Drop",
+			overflowPolicy: entities.OverflowDrop,
+			expectDropped:  true,
+		},
+		{
+			name:           "Block",
+			overflowPolicy: entities.OverflowBlock,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			output := bytes.Repeat([]byte("0123456789abcdef"), outputSize/16)
+			process := &fakeCommand{output: bytes.NewReader(output), done: make(chan struct{})}
+			commandSession, err := newSession(1, process, 80, 24, bufferLimit, tc.overflowPolicy, time.Minute, entities.KillPolicy{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			commandSession.recorder = &discardRecorder{}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			client, err := commandSession.attach(ctx, "test", entities.ViewerController, false)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			go func() {
+				commandSession.readOutput()
+				commandSession.finish()
+			}()
+
+			// Client does not read for a while
+			time.Sleep(100 * time.Millisecond)
+			stats := commandSession.stats()
+			if tc.overflowPolicy == entities.OverflowBlock && stats.TotalOutput > bufferLimit {
+				t.Fatalf("output read while client lags: %+v", stats)
+			}
+			if tc.overflowPolicy == entities.OverflowDrop && stats.TotalOutput != outputSize {
+				t.Fatalf("output not read while client lags: %+v", stats)
+			}
+
+			var received []byte
+			for data := range client.Output {
+				received = append(received, data...)
+			}
+			stats = commandSession.stats()
+			if tc.expectDropped {
+				// Client may receive a few chunks before it lags, the rest is dropped
+				payload := droppedMarkerRegexp.ReplaceAll(received, nil)
+				if stats.DroppedOutput == 0 || stats.DroppedOutput+int64(len(payload)) != outputSize || len(payload) == len(received) {
+					t.Fatalf("dropped output not reported: %+v, received %d bytes", stats, len(received))
+				}
+				if !bytes.HasSuffix(received, output[outputSize-bufferLimit:]) {
+					t.Fatal("last output not received")
+				}
+			} else {
+				if stats.DroppedOutput != 0 || stats.PausedMs == 0 {
+					t.Fatalf("unexpected stats: %+v", stats)
+				}
+				if !bytes.Equal(received, output) {
+					t.Fatalf("output corrupted, received %d bytes", len(received))
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkSession_Output measure throughput of output pipeline without pty: read, scrollback, screen emulation and client pump
+func BenchmarkSession_Output(b *testing.B) {
+	log.SetLevel(4)
+	const outputSize = 100 * 1024 * 1024
+	// Lines as pty gives them, with CR LF
+	line := []byte("2006-01-02 15:04:05 [info] line of output\r\n")
+	output := bytes.Repeat(line, outputSize/len(line))
+	b.SetBytes(int64(len(output)))
+	b.ResetTimer()
+	for range b.N {
+		process := &fakeCommand{output: bytes.NewReader(output), done: make(chan struct{})}
+		commandSession, err := newSession(1, process, 80, 24, 256*1024, entities.OverflowDrop, time.Minute, entities.KillPolicy{})
+		if err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+		commandSession.recorder = &discardRecorder{}
+		client, err := commandSession.attach(context.Background(), "bench", entities.ViewerController, false)
+		if err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+		go func() {
+			commandSession.readOutput()
+			commandSession.finish()
+		}()
+		for range client.Output {
+		}
+	}
+}
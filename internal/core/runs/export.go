@@ -0,0 +1,355 @@
+package runs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"github.com/acarl005/stripansi"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// escapeSequenceRegexp matches CSI, OSC and other escape sequences, the same way as stripansi does
+var escapeSequenceRegexp = regexp.MustCompile("[\u001B\u009B][[\\]()#;?]*(?:(?:(?:[a-zA-Z\\d]*(?:;[a-zA-Z\\d]*)*)?\u0007)|(?:(?:\\d{1,4}(?:;\\d{0,4})*)?[\\dA-PRZcf-ntqry=><~]))")
+
+// ansiColors are xterm default colors of 16 basic colors, bright ones are last
+var ansiColors = [16]string{
+	"#000000", "#cd0000", "#00cd00", "#cdcd00", "#0000ee", "#cd00cd", "#00cdcd", "#e5e5e5",
+	"#7f7f7f", "#ff0000", "#00ff00", "#ffff00", "#5c5cff", "#ff00ff", "#00ffff", "#ffffff",
+}
+
+const htmlExportTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%s</title>
+<style>body { background: #1e1e1e; color: #e5e5e5; } pre { font-family: monospace; white-space: pre-wrap; }</style>
+</head>
+<body>
+<pre>%s</pre>
+</body>
+</html>
+`
+
+type jsonLine struct {
+	Time    time.Time `json:"time"`
+	Elapsed float64   `json:"elapsed"` // seconds since start of run
+	Line    string    `json:"line"`
+}
+
+// ExportRunOutput return output of run converted to format: plain text, raw output with ANSI sequences,
+// HTML page with colours or JSON lines with time of every line. JSON lines need recording of run, older runs have no one
+func (s Service) ExportRunOutput(runId uint, format entities.ExportFormat) ([]byte, error) {
+	run, err := s.runsRepository.GetRun(runId)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case entities.ExportJSONLines:
+		recording, err := s.runLogs.GetRunRecording(runId)
+		if err != nil {
+			return nil, err
+		}
+		_, events, err := parseRecording(recording)
+		if err != nil {
+			return nil, fmt.Errorf("cant read run recording: %w", err)
+		}
+		return exportJSONLines(run, events)
+	case entities.ExportText, entities.ExportANSI, entities.ExportHTML:
+	default:
+		return nil, projectErrors.ErrBadExportFormat
+	}
+	output, err := s.runLogs.GetRunLog(runId)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case entities.ExportText:
+		return exportText(output), nil
+	case entities.ExportHTML:
+		return exportHTML(run, output), nil
+	}
+	return output, nil
+}
+
+// plainLine return text of line as terminal shows it: carriage return starts line again,
+// so only the last of progress bar updates is left
+func plainLine(line string) string {
+	line = strings.TrimSuffix(line, "\r")
+	if i := strings.LastIndexByte(line, '\r'); i != -1 {
+		line = line[i+1:]
+	}
+	return line
+}
+
+func exportText(output []byte) []byte {
+	lines := strings.Split(stripansi.Strip(string(output)), "\n")
+	for i, line := range lines {
+		lines[i] = plainLine(line)
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+func exportJSONLines(run *entities.Run, events []castEvent) ([]byte, error) {
+	var res bytes.Buffer
+	encoder := json.NewEncoder(&res)
+	encoder.SetEscapeHTML(false)
+	var line strings.Builder
+	var lineStarted float64
+	writeLine := func() error {
+		return encoder.Encode(jsonLine{
+			Time:    run.StartedAt.Add(time.Duration(lineStarted * float64(time.Second))),
+			Elapsed: lineStarted,
+			Line:    plainLine(stripansi.Strip(line.String())),
+		})
+	}
+	for _, event := range events {
+		if event.Type != castEventOutput {
+			continue
+		}
+		if line.Len() == 0 {
+			lineStarted = event.Time
+		}
+		data := event.Data
+		for {
+			before, after, found := strings.Cut(data, "\n")
+			line.WriteString(before)
+			if !found {
+				break
+			}
+			if err := writeLine(); err != nil {
+				return nil, err
+			}
+			line.Reset()
+			lineStarted = event.Time
+			data = after
+		}
+	}
+	if line.Len() != 0 {
+		if err := writeLine(); err != nil {
+			return nil, err
+		}
+	}
+	return res.Bytes(), nil
+}
+
+// textStyle is SGR state of terminal, that is kept in HTML export
+type textStyle struct {
+	fg, bg                            string // css color, empty for default
+	bold, dim, italic, underline      bool
+	inverse, strikethrough, concealed bool
+}
+
+func (st textStyle) css() string {
+	fg, bg := st.fg, st.bg
+	if st.inverse {
+		fg, bg = bg, fg
+		if fg == "" {
+			fg = "#1e1e1e"
+		}
+		if bg == "" {
+			bg = "#e5e5e5"
+		}
+	}
+	var res []string
+	if fg != "" {
+		res = append(res, "color:"+fg)
+	}
+	if bg != "" {
+		res = append(res, "background-color:"+bg)
+	}
+	if st.bold {
+		res = append(res, "font-weight:bold")
+	}
+	if st.dim {
+		res = append(res, "opacity:0.7")
+	}
+	if st.italic {
+		res = append(res, "font-style:italic")
+	}
+	if st.underline && st.strikethrough {
+		res = append(res, "text-decoration:underline line-through")
+	} else if st.underline {
+		res = append(res, "text-decoration:underline")
+	} else if st.strikethrough {
+		res = append(res, "text-decoration:line-through")
+	}
+	if st.concealed {
+		res = append(res, "visibility:hidden")
+	}
+	return strings.Join(res, ";")
+}
+
+// color256 return css color of xterm 256 colors palette
+func color256(n int) string {
+	switch {
+	case n < 16:
+		return ansiColors[n]
+	case n < 232:
+		n -= 16
+		level := func(v int) int {
+			if v == 0 {
+				return 0
+			}
+			return 55 + v*40
+		}
+		return fmt.Sprintf("#%02x%02x%02x", level(n/36), level(n/6%6), level(n%6))
+	default:
+		gray := 8 + (n-232)*10
+		return fmt.Sprintf("#%02x%02x%02x", gray, gray, gray)
+	}
+}
+
+// extendedColor parse 38/48 color after its code: 5;n or 2;r;g;b. Return color and count of used params
+func extendedColor(params []int) (string, int) {
+	if len(params) >= 2 && params[0] == 5 && params[1] >= 0 && params[1] < 256 {
+		return color256(params[1]), 2
+	}
+	if len(params) >= 4 && params[0] == 2 {
+		return fmt.Sprintf("#%02x%02x%02x", uint8(params[1]), uint8(params[2]), uint8(params[3])), 4
+	}
+	return "", len(params)
+}
+
+// apply change style by SGR parameters
+func (st textStyle) apply(params []int) textStyle {
+	if len(params) == 0 {
+		return textStyle{}
+	}
+	for i := 0; i < len(params); i++ {
+		switch p := params[i]; {
+		case p == 0:
+			st = textStyle{}
+		case p == 1:
+			st.bold = true
+		case p == 2:
+			st.dim = true
+		case p == 3:
+			st.italic = true
+		case p == 4:
+			st.underline = true
+		case p == 7:
+			st.inverse = true
+		case p == 8:
+			st.concealed = true
+		case p == 9:
+			st.strikethrough = true
+		case p == 22:
+			st.bold, st.dim = false, false
+		case p == 23:
+			st.italic = false
+		case p == 24:
+			st.underline = false
+		case p == 27:
+			st.inverse = false
+		case p == 28:
+			st.concealed = false
+		case p == 29:
+			st.strikethrough = false
+		case p >= 30 && p <= 37:
+			st.fg = ansiColors[p-30]
+		case p == 38:
+			color, used := extendedColor(params[i+1:])
+			st.fg = color
+			i += used
+		case p == 39:
+			st.fg = ""
+		case p >= 40 && p <= 47:
+			st.bg = ansiColors[p-40]
+		case p == 48:
+			color, used := extendedColor(params[i+1:])
+			st.bg = color
+			i += used
+		case p == 49:
+			st.bg = ""
+		case p >= 90 && p <= 97:
+			st.fg = ansiColors[p-90+8]
+		case p >= 100 && p <= 107:
+			st.bg = ansiColors[p-100+8]
+		}
+	}
+	return st
+}
+
+// sgrParams return parameters of SGR sequence, ok is false for other sequences
+func sgrParams(sequence string) ([]int, bool) {
+	if !strings.HasPrefix(sequence, "\x1b[") || !strings.HasSuffix(sequence, "m") {
+		return nil, false
+	}
+	body := sequence[2 : len(sequence)-1]
+	if body == "" {
+		return nil, true
+	}
+	var params []int
+	for _, field := range strings.Split(strings.ReplaceAll(body, ":", ";"), ";") {
+		// Empty parameter is 0
+		n := 0
+		if field != "" {
+			var err error
+			if n, err = strconv.Atoi(field); err != nil {
+				return nil, false
+			}
+		}
+		params = append(params, n)
+	}
+	return params, true
+}
+
+type styledText struct {
+	text  string
+	style textStyle
+}
+
+// exportHTML render output as HTML page, colours and text attributes of SGR sequences are kept, other sequences are dropped
+func exportHTML(run *entities.Run, output []byte) []byte {
+	var res strings.Builder
+	var style textStyle
+	for lineIndex, line := range strings.Split(string(output), "\n") {
+		if lineIndex != 0 {
+			res.WriteByte('\n')
+		}
+		line = strings.TrimSuffix(line, "\r")
+		var parts []styledText
+		for line != "" {
+			loc := escapeSequenceRegexp.FindStringIndex(line)
+			text := line
+			if loc != nil {
+				text = line[:loc[0]]
+			}
+			// Carriage return inside line starts it again, like in terminal
+			for {
+				before, after, found := strings.Cut(text, "\r")
+				if !found {
+					if before != "" {
+						parts = append(parts, styledText{text: before, style: style})
+					}
+					break
+				}
+				parts = nil
+				text = after
+			}
+			if loc == nil {
+				break
+			}
+			if params, ok := sgrParams(line[loc[0]:loc[1]]); ok {
+				style = style.apply(params)
+			}
+			line = line[loc[1]:]
+		}
+		for _, part := range parts {
+			css := part.style.css()
+			if css == "" {
+				res.WriteString(html.EscapeString(part.text))
+				continue
+			}
+			_, _ = fmt.Fprintf(&res, `<span style="%s">%s</span>`, css, html.EscapeString(part.text))
+		}
+	}
+	title := fmt.Sprintf("Run #%d: %s", run.ID, run.Command)
+	return []byte(fmt.Sprintf(htmlExportTemplate, html.EscapeString(title), res.String()))
+}
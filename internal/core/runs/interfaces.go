@@ -0,0 +1,22 @@
+package runs
+
+import (
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	"io"
+)
+
+type RunsRepository interface {
+	AppendRun(run *entities.Run) error
+	UpdateRun(run *entities.Run) error
+	GetRun(id uint) (*entities.Run, error)
+	GetCommandRuns(commandId uint) ([]entities.Run, error)
+}
+
+type RunLogs interface {
+	CreateRunLog(runId uint) (io.WriteCloser, error)
+	GetRunLog(runId uint) ([]byte, error)
+	CreateRunRecording(runId uint) (io.WriteCloser, error)
+	GetRunRecording(runId uint) ([]byte, error)
+	CreateRunSources(runId uint) (io.WriteCloser, error) // where output switches between stdout and stderr, only for piped commands
+	GetRunSources(runId uint) ([]byte, error)
+}
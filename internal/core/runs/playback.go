@@ -0,0 +1,84 @@
+package runs
+
+import (
+	"context"
+	"fmt"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"time"
+)
+
+const maxPlaybackSpeed = 100
+
+// playbackIdleLimit is the longest pause of recording, that is replayed. Longer pauses are cut to it
+const playbackIdleLimit = 2 * time.Second
+
+func validPlaybackSpeed(speed float64) bool {
+	return speed > 0 && speed <= maxPlaybackSpeed
+}
+
+// PlayRun replay recorded output of run with its original timing multiplied by speed, until ctx is done.
+// Terminal resizes are not replayed, client terminal keeps its own size
+func (s Service) PlayRun(ctx context.Context, runId uint, speed float64) (*entities.RunPlayback, error) {
+	if !validPlaybackSpeed(speed) {
+		return nil, projectErrors.ErrBadPlaybackSpeed
+	}
+	run, err := s.runsRepository.GetRun(runId)
+	if err != nil {
+		return nil, err
+	}
+	recording, err := s.runLogs.GetRunRecording(runId)
+	if err != nil {
+		return nil, err
+	}
+	_, events, err := parseRecording(recording)
+	if err != nil {
+		return nil, fmt.Errorf("cant read run recording: %w", err)
+	}
+
+	output := make(chan []byte)
+	speedChan := make(chan float64)
+	go func() {
+		defer close(output)
+		var last float64
+		for _, event := range events {
+			if event.Type != castEventOutput {
+				continue
+			}
+			// Pause left to wait in recording time, speed can change while waiting
+			pause := min(time.Duration((event.Time-last)*float64(time.Second)), playbackIdleLimit)
+			last = event.Time
+			for pause > 0 {
+				waitStarted := time.Now()
+				timer := time.NewTimer(time.Duration(float64(pause) / speed))
+				select {
+				case <-timer.C:
+					pause = 0
+				case newSpeed := <-speedChan:
+					timer.Stop()
+					if validPlaybackSpeed(newSpeed) {
+						pause -= time.Duration(float64(time.Since(waitStarted)) * speed)
+						speed = newSpeed
+					}
+				case <-ctx.Done():
+					timer.Stop()
+					return
+				}
+			}
+			for sent := false; !sent; {
+				select {
+				case output <- []byte(event.Data):
+					sent = true
+				case newSpeed := <-speedChan:
+					if validPlaybackSpeed(newSpeed) {
+						speed = newSpeed
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return &entities.RunPlayback{Run: run, Output: output, Speed: speedChan, Exit: runExitStatus(run)}, nil
+}
@@ -0,0 +1,121 @@
+package runs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/utils"
+	"io"
+	"math"
+	"time"
+)
+
+// Recording is written in asciicast v2 format: header line, then line for every event
+// https://docs.asciinema.org/manual/asciicast/v2/
+
+const (
+	castVersion     = 2
+	castEventOutput = "o"
+	castEventResize = "r"
+)
+
+var errBadRecording = errors.New("bad asciicast recording")
+
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     uint16            `json:"width"`
+	Height    uint16            `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Command   string            `json:"command,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+type castEvent struct {
+	Time float64 // seconds since start of recording
+	Type string
+	Data string
+}
+
+// castWriter writes output as asciicast events with time since start
+type castWriter struct {
+	w          io.WriteCloser
+	startedAt  time.Time
+	incomplete []byte // start of UTF-8 character split between writes, json strings can't hold it
+}
+
+func newCastWriter(w io.WriteCloser, header castHeader, startedAt time.Time) (*castWriter, error) {
+	data, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(append(data, '\n')); err != nil {
+		return nil, err
+	}
+	return &castWriter{w: w, startedAt: startedAt}, nil
+}
+
+func (c *castWriter) writeEvent(eventType string, data string) error {
+	// Microseconds are enough, longer numbers only bloat the file
+	elapsed := math.Round(time.Since(c.startedAt).Seconds()*1e6) / 1e6
+	line, err := json.Marshal([]any{elapsed, eventType, data})
+	if err != nil {
+		return err
+	}
+	_, err = c.w.Write(append(line, '\n'))
+	return err
+}
+
+// Output write output event, invalid UTF-8 replaced with U+FFFD
+func (c *castWriter) Output(p []byte) error {
+	data := append(c.incomplete, p...)
+	data, c.incomplete = utils.SplitIncompleteRune(data)
+	c.incomplete = bytes.Clone(c.incomplete)
+	if len(data) == 0 {
+		return nil
+	}
+	return c.writeEvent(castEventOutput, string(data))
+}
+
+func (c *castWriter) Resize(cols, rows uint16) error {
+	return c.writeEvent(castEventResize, fmt.Sprintf("%dx%d", cols, rows))
+}
+
+// Close write held bytes and close file
+func (c *castWriter) Close() error {
+	var err error
+	if len(c.incomplete) != 0 {
+		err = c.writeEvent(castEventOutput, string(c.incomplete))
+		c.incomplete = nil
+	}
+	return errors.Join(err, c.w.Close())
+}
+
+// parseRecording read header and events of recording.
+// Recording of running or crashed command can end with cut line, it is skipped
+func parseRecording(data []byte) (*castHeader, []castEvent, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	if !scanner.Scan() {
+		return nil, nil, errBadRecording
+	}
+	header := &castHeader{}
+	if err := json.Unmarshal(scanner.Bytes(), header); err != nil || header.Version != castVersion {
+		return nil, nil, errBadRecording
+	}
+	var events []castEvent
+	for scanner.Scan() {
+		var fields []json.RawMessage
+		if err := json.Unmarshal(scanner.Bytes(), &fields); err != nil || len(fields) != 3 {
+			continue
+		}
+		var event castEvent
+		if json.Unmarshal(fields[0], &event.Time) != nil || json.Unmarshal(fields[1], &event.Type) != nil || json.Unmarshal(fields[2], &event.Data) != nil {
+			continue
+		}
+		events = append(events, event)
+	}
+	return header, events, scanner.Err()
+}
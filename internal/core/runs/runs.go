@@ -0,0 +1,299 @@
+package runs
+
+import (
+	"errors"
+	"fmt"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/utils"
+	"github.com/gofiber/fiber/v2/log"
+	"io"
+	"sync"
+	"time"
+)
+
+type Service struct {
+	maxOutputSize  int64
+	runsRepository RunsRepository
+	runLogs        RunLogs
+}
+
+func NewService(maxOutputSize int64, runsRepository RunsRepository, runLogs RunLogs) *Service {
+	return &Service{
+		maxOutputSize:  maxOutputSize,
+		runsRepository: runsRepository,
+		runLogs:        runLogs,
+	}
+}
+
+// recorder writes run output to log and asciicast recording (first maxOutputSize bytes) and saves run result on finish
+type recorder struct {
+	mu             sync.Mutex
+	run            *entities.Run
+	log            io.WriteCloser
+	cast           *castWriter
+	sources        *sourcesWriter // nil if command is not piped
+	maxOutputSize  int64
+	runsRepository RunsRepository
+}
+
+// StartRun save new run to history and return recorder for its output.
+// Terminal size is saved to recording, zero size means default 80x24
+func (s Service) StartRun(command *entities.Command, sessionId string, triggeredBy string, cols, rows uint16) (entities.RunRecorder, error) {
+	run := &entities.Run{
+		CommandID:     command.ID,
+		Command:       command.Command,
+		SessionID:     sessionId,
+		TriggeredBy:   triggeredBy,
+		StartedAt:     time.Now(),
+		ExecutionMode: command.ExecutionMode,
+	}
+	if err := s.runsRepository.AppendRun(run); err != nil {
+		return nil, err
+	}
+	runLog, err := s.runLogs.CreateRunLog(run.ID)
+	if err != nil {
+		return nil, fmt.Errorf("cant create run log: %w", err)
+	}
+	recordingFile, err := s.runLogs.CreateRunRecording(run.ID)
+	if err != nil {
+		_ = runLog.Close()
+		return nil, fmt.Errorf("cant create run recording: %w", err)
+	}
+	if cols == 0 {
+		cols = 80
+	}
+	if rows == 0 {
+		rows = 24
+	}
+	cast, err := newCastWriter(recordingFile, castHeader{
+		Version:   castVersion,
+		Width:     cols,
+		Height:    rows,
+		Timestamp: run.StartedAt.Unix(),
+		Command:   command.Command,
+		Title:     command.Name,
+		Env:       map[string]string{"TERM": "xterm-256color"},
+	}, run.StartedAt)
+	if err != nil {
+		_ = runLog.Close()
+		_ = recordingFile.Close()
+		return nil, fmt.Errorf("cant write run recording: %w", err)
+	}
+	res := &recorder{
+		run:            run,
+		log:            runLog,
+		cast:           cast,
+		maxOutputSize:  s.maxOutputSize,
+		runsRepository: s.runsRepository,
+	}
+	if command.ExecutionMode == entities.ExecutionModePipe {
+		sourcesFile, err := s.runLogs.CreateRunSources(run.ID)
+		if err != nil {
+			_ = runLog.Close()
+			_ = cast.Close()
+			return nil, fmt.Errorf("cant create run sources: %w", err)
+		}
+		res.sources = &sourcesWriter{w: sourcesFile}
+	}
+	return res, nil
+}
+
+func (s Service) GetRun(runId uint) (*entities.Run, error) {
+	return s.runsRepository.GetRun(runId)
+}
+
+func (s Service) GetCommandRuns(commandId uint) ([]entities.Run, error) {
+	return s.runsRepository.GetCommandRuns(commandId)
+}
+
+func (s Service) GetRunOutput(runId uint) ([]byte, error) {
+	if _, err := s.runsRepository.GetRun(runId); err != nil {
+		return nil, err
+	}
+	return s.runLogs.GetRunLog(runId)
+}
+
+// GetRunRecording return output of run in asciicast v2 format, ErrNotFound for runs recorded before recordings were added
+func (s Service) GetRunRecording(runId uint) ([]byte, error) {
+	if _, err := s.runsRepository.GetRun(runId); err != nil {
+		return nil, err
+	}
+	return s.runLogs.GetRunRecording(runId)
+}
+
+// runSpans return where output of piped run switches between stdout and stderr
+func (s Service) runSpans(runId uint) ([]entities.OutputSpan, error) {
+	data, err := s.runLogs.GetRunSources(runId)
+	if errors.Is(err, projectErrors.ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return parseSources(data), nil
+}
+
+// GetRunSourceOutput return only stdout or only stderr of run of piped command
+func (s Service) GetRunSourceOutput(runId uint, source entities.OutputSource) ([]byte, error) {
+	run, err := s.runsRepository.GetRun(runId)
+	if err != nil {
+		return nil, err
+	}
+	if run.ExecutionMode != entities.ExecutionModePipe {
+		return nil, projectErrors.ErrNotPiped
+	}
+	output, err := s.runLogs.GetRunLog(runId)
+	if err != nil {
+		return nil, err
+	}
+	spans, err := s.runSpans(runId)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]byte, 0)
+	for _, chunk := range utils.SplitOutputBySource(output, 0, spans) {
+		if chunk.Source == source {
+			res = append(res, chunk.Data...)
+		}
+	}
+	return res, nil
+}
+
+// runExitStatus return exit status saved in run, nil if run is not finished
+func runExitStatus(run *entities.Run) *entities.ExitStatus {
+	if run.FinishedAt == nil || run.ExitCode == nil {
+		return nil
+	}
+	return &entities.ExitStatus{
+		Code:       *run.ExitCode,
+		Signal:     run.ExitSignal,
+		DurationMs: run.FinishedAt.Sub(run.StartedAt).Milliseconds(),
+		StopReason: run.StopReason,
+	}
+}
+
+// StreamRunOutput return saved output of run since offset as already finished stream, for runs without live session.
+// Saved output can be truncated, run that was not finished (server stopped while it was running) has no exit status
+func (s Service) StreamRunOutput(runId uint, offset int64) (*entities.OutputStream, error) {
+	run, err := s.runsRepository.GetRun(runId)
+	if err != nil {
+		return nil, err
+	}
+	output, err := s.runLogs.GetRunLog(runId)
+	if errors.Is(err, projectErrors.ErrNotFound) {
+		output = nil
+	} else if err != nil {
+		return nil, err
+	}
+	offset = min(max(offset, 0), int64(len(output)))
+
+	events := []entities.OutputEvent{{Data: output[offset:], Offset: int64(len(output))}}
+	if run.ExecutionMode == entities.ExecutionModePipe {
+		spans, err := s.runSpans(runId)
+		if err != nil {
+			return nil, err
+		}
+		events = utils.SplitOutputBySource(output[offset:], offset, spans)
+	}
+	outputChan := make(chan entities.OutputEvent, len(events))
+	for _, event := range events {
+		if len(event.Data) != 0 {
+			outputChan <- event
+		}
+	}
+	close(outputChan)
+	statusChan := make(chan entities.SessionStatus, 1)
+	statusChan <- entities.SessionStatus{State: entities.SessionFinished, StopReason: run.StopReason}
+	exitChan := make(chan entities.ExitStatus, 1)
+	if exitStatus := runExitStatus(run); exitStatus != nil {
+		exitChan <- *exitStatus
+	}
+	return &entities.OutputStream{
+		SessionID: run.SessionID,
+		Output:    outputChan,
+		Status:    statusChan,
+		Exit:      exitChan,
+	}, nil
+}
+
+// GetRun return snapshot of recorded run
+func (r *recorder) GetRun() *entities.Run {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	run := *r.run
+	return &run
+}
+
+// Write never fails, so broken log does not break command output
+func (r *recorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.write(p, "")
+	return len(p), nil
+}
+
+// WriteSource write output of piped command, recording gets it as terminal would show it
+func (r *recorder) WriteSource(source entities.OutputSource, p []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.write(p, source)
+}
+
+// write must be called with r.mu locked, empty source is for terminal output
+func (r *recorder) write(p []byte, source entities.OutputSource) {
+	data := p
+	if r.maxOutputSize > 0 && r.run.OutputSize+int64(len(data)) > r.maxOutputSize {
+		data = data[:max(r.maxOutputSize-r.run.OutputSize, 0)]
+		r.run.OutputTruncated = true
+	}
+	if len(data) == 0 {
+		return
+	}
+	if r.sources != nil && source != "" {
+		if err := r.sources.Write(r.run.OutputSize, source); err != nil {
+			log.Warn("Error writing run sources: ", err)
+		}
+	}
+	n, err := r.log.Write(data)
+	r.run.OutputSize += int64(n)
+	if err != nil {
+		log.Warn("Error writing run log: ", err)
+	}
+	if source != "" {
+		data = utils.PipedOutputToTerminal(data, source)
+	}
+	if err := r.cast.Output(data); err != nil {
+		log.Warn("Error writing run recording: ", err)
+	}
+}
+
+// Resize record change of terminal size
+func (r *recorder) Resize(cols, rows uint16) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.cast.Resize(cols, rows); err != nil {
+		log.Warn("Error writing run recording: ", err)
+	}
+}
+
+func (r *recorder) Finish(status entities.ExitStatus) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.log.Close(); err != nil {
+		log.Warn("Error closing run log: ", err)
+	}
+	if err := r.cast.Close(); err != nil {
+		log.Warn("Error closing run recording: ", err)
+	}
+	if r.sources != nil {
+		if err := r.sources.Close(); err != nil {
+			log.Warn("Error closing run sources: ", err)
+		}
+	}
+	finishedAt := time.Now()
+	r.run.FinishedAt = &finishedAt
+	r.run.ExitCode = &status.Code
+	r.run.ExitSignal = status.Signal
+	r.run.StopReason = status.StopReason
+	return r.runsRepository.UpdateRun(r.run)
+}
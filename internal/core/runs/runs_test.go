@@ -0,0 +1,503 @@
+package runs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/adapters/storage/database"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/adapters/storage/filesystem"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/testutils"
+	"github.com/gofiber/fiber/v2/log"
+	"math"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordRun(t *testing.T) {
+	log.SetLevel(0)
+	testCases := []struct {
+		name              string
+		maxOutputSize     int64
+		writes            []string
+		exitCode          int
+		expectedOutput    string
+		expectedTruncated bool
+	}{
+		{
+			name:           "Full output",
+			maxOutputSize:  100,
+			writes:         []string{"hello", " world"},
+			exitCode:       0,
+			expectedOutput: "hello world",
+		},
+		{
+			name:              "Truncated output",
+			maxOutputSize:     7,
+			writes:            []string{"hello", " world"},
+			exitCode:          2,
+			expectedOutput:    "hello w",
+			expectedTruncated: true,
+		},
+		{
+			name:           "Split UTF-8 character",
+			maxOutputSize:  100,
+			writes:         []string{"пр", "\xd0", "\xb8вет"},
+			exitCode:       0,
+			expectedOutput: "привет",
+		},
+		{
+			name:           "Unlimited output",
+			maxOutputSize:  -1,
+			writes:         []string{"hello", " world"},
+			exitCode:       1,
+			expectedOutput: "hello world",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpDir, cleanup := testutils.CreateTempDataFolder(t)
+			defer cleanup()
+			dataDir := filepath.Join(tmpDir, "data")
+
+			db, err := database.Connect(dataDir)
+			if err != nil {
+				t.Fatalf("Cant create db: %v", err)
+			}
+			defer func(u database.DB) {
+				err := db.Close()
+				if err != nil {
+					t.Errorf("Error closing db: %v", err)
+				}
+			}(db)
+			runLogsAdapter, err := filesystem.ConnectRunLogs(filepath.Join(dataDir, "runs"))
+			if err != nil {
+				t.Fatalf("Cant set connect run logs: %v", err)
+			}
+			runsService := NewService(tc.maxOutputSize, db, runLogsAdapter)
+
+			recorder, err := runsService.StartRun(&entities.Command{ID: 5, Command: "echo hello world"}, "session", "tester", 100, 30)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			for _, w := range tc.writes {
+				n, err := recorder.Write([]byte(w))
+				if err != nil || n != len(w) {
+					t.Fatalf("Unexpected write result: %d, %v", n, err)
+				}
+			}
+			if err := recorder.Finish(entities.ExitStatus{Code: tc.exitCode}); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			runs, err := runsService.GetCommandRuns(5)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(runs) != 1 {
+				t.Fatalf("Expected 1 run, got %d", len(runs))
+			}
+			run := runs[0]
+			if run.ExitCode == nil || *run.ExitCode != tc.exitCode {
+				t.Errorf("Unexpected exit code: %v, need %d", run.ExitCode, tc.exitCode)
+			}
+			if run.FinishedAt == nil {
+				t.Errorf("Finish time not saved")
+			}
+			if run.TriggeredBy != "tester" || run.SessionID != "session" || run.Command != "echo hello world" {
+				t.Errorf("Unexpected run info: %+v", run)
+			}
+			if run.OutputTruncated != tc.expectedTruncated {
+				t.Errorf("Unexpected truncated flag: %v", run.OutputTruncated)
+			}
+			output, err := runsService.GetRunOutput(run.ID)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if string(output) != tc.expectedOutput {
+				t.Errorf("Unexpected output: %q, need %q", output, tc.expectedOutput)
+			}
+
+			recording, err := runsService.GetRunRecording(run.ID)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			header, events, err := parseRecording(recording)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if header.Width != 100 || header.Height != 30 || header.Command != "echo hello world" {
+				t.Errorf("Unexpected recording header: %+v", header)
+			}
+			recordedOutput := ""
+			for _, event := range events {
+				recordedOutput += event.Data
+			}
+			if recordedOutput != tc.expectedOutput {
+				t.Errorf("Unexpected recorded output: %q, need %q", recordedOutput, tc.expectedOutput)
+			}
+		})
+	}
+}
+
+func TestGetRunOutput_NotFound(t *testing.T) {
+	log.SetLevel(0)
+	tmpDir, cleanup := testutils.CreateTempDataFolder(t)
+	defer cleanup()
+	dataDir := filepath.Join(tmpDir, "data")
+
+	db, err := database.Connect(dataDir)
+	if err != nil {
+		t.Fatalf("Cant create db: %v", err)
+	}
+	defer func(u database.DB) {
+		err := db.Close()
+		if err != nil {
+			t.Errorf("Error closing db: %v", err)
+		}
+	}(db)
+	runLogsAdapter, err := filesystem.ConnectRunLogs(filepath.Join(dataDir, "runs"))
+	if err != nil {
+		t.Fatalf("Cant set connect run logs: %v", err)
+	}
+	runsService := NewService(1024, db, runLogsAdapter)
+	_, err = runsService.GetRunOutput(1)
+	if !errors.Is(err, projectErrors.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestPlayRun(t *testing.T) {
+	log.SetLevel(0)
+	tmpDir, cleanup := testutils.CreateTempDataFolder(t)
+	defer cleanup()
+	dataDir := filepath.Join(tmpDir, "data")
+
+	db, err := database.Connect(dataDir)
+	if err != nil {
+		t.Fatalf("Cant create db: %v", err)
+	}
+	defer func(u database.DB) {
+		err := db.Close()
+		if err != nil {
+			t.Errorf("Error closing db: %v", err)
+		}
+	}(db)
+	runLogsAdapter, err := filesystem.ConnectRunLogs(filepath.Join(dataDir, "runs"))
+	if err != nil {
+		t.Fatalf("Cant set connect run logs: %v", err)
+	}
+	runsService := NewService(1024, db, runLogsAdapter)
+
+	recorder, err := runsService.StartRun(&entities.Command{ID: 5, Command: "echo hello world"}, "session", "tester", 80, 24)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, _ = recorder.Write([]byte("first"))
+	time.Sleep(200 * time.Millisecond)
+	recorder.Resize(100, 30)
+	_, _ = recorder.Write([]byte("second"))
+	if err := recorder.Finish(entities.ExitStatus{Code: 3}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	runId := recorder.GetRun().ID
+
+	_, err = runsService.PlayRun(context.Background(), runId, 0)
+	if !errors.Is(err, projectErrors.ErrBadPlaybackSpeed) {
+		t.Errorf("Expected ErrBadPlaybackSpeed, got %v", err)
+	}
+
+	for _, speed := range []float64{1, 10} {
+		started := time.Now()
+		playback, err := runsService.PlayRun(context.Background(), runId, speed)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		var chunks []string
+		for data := range playback.Output {
+			chunks = append(chunks, string(data))
+		}
+		elapsed := time.Since(started)
+		if len(chunks) != 2 || chunks[0] != "first" || chunks[1] != "second" {
+			t.Errorf("Unexpected played output: %q", chunks)
+		}
+		if expected := time.Duration(float64(200*time.Millisecond) / speed); elapsed < expected || elapsed > expected+time.Second {
+			t.Errorf("Unexpected playback duration with speed %v: %v", speed, elapsed)
+		}
+		if playback.Exit == nil || playback.Exit.Code != 3 {
+			t.Errorf("Unexpected exit status: %+v", playback.Exit)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	playback, err := runsService.PlayRun(ctx, runId, 1)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	<-playback.Output
+	cancel()
+	for range playback.Output {
+	}
+}
+
+func TestStreamRunOutput(t *testing.T) {
+	log.SetLevel(0)
+	tmpDir, cleanup := testutils.CreateTempDataFolder(t)
+	defer cleanup()
+	dataDir := filepath.Join(tmpDir, "data")
+
+	db, err := database.Connect(dataDir)
+	if err != nil {
+		t.Fatalf("Cant create db: %v", err)
+	}
+	defer func(u database.DB) {
+		err := db.Close()
+		if err != nil {
+			t.Errorf("Error closing db: %v", err)
+		}
+	}(db)
+	runLogsAdapter, err := filesystem.ConnectRunLogs(filepath.Join(dataDir, "runs"))
+	if err != nil {
+		t.Fatalf("Cant set connect run logs: %v", err)
+	}
+	runsService := NewService(1024, db, runLogsAdapter)
+
+	recorder, err := runsService.StartRun(&entities.Command{ID: 5, Command: "echo hello world"}, "session", "tester", 80, 24)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, _ = recorder.Write([]byte("hello world"))
+	if err := recorder.Finish(entities.ExitStatus{Code: 1, StopReason: entities.StopReasonTimeout}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	runId := recorder.GetRun().ID
+
+	testCases := []struct {
+		name           string
+		offset         int64
+		expectedOutput string
+	}{
+		{
+			name:           "From start",
+			offset:         -1,
+			expectedOutput: "hello world",
+		},
+		{
+			name:           "Resumed",
+			offset:         6,
+			expectedOutput: "world",
+		},
+		{
+			name:           "After end",
+			offset:         100,
+			expectedOutput: "",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			stream, err := runsService.StreamRunOutput(runId, tc.offset)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			output := ""
+			for event := range stream.Output {
+				output += string(event.Data)
+				if event.Offset != 11 {
+					t.Errorf("Unexpected offset: %d", event.Offset)
+				}
+			}
+			if output != tc.expectedOutput {
+				t.Errorf("Unexpected output: %q, need %q", output, tc.expectedOutput)
+			}
+			if status := <-stream.Status; status.State != entities.SessionFinished || status.StopReason != entities.StopReasonTimeout {
+				t.Errorf("Unexpected status: %+v", status)
+			}
+			if exitStatus := <-stream.Exit; exitStatus.Code != 1 {
+				t.Errorf("Unexpected exit status: %+v", exitStatus)
+			}
+		})
+	}
+}
+
+func TestRecordRun_Piped(t *testing.T) {
+	log.SetLevel(0)
+	tmpDir, cleanup := testutils.CreateTempDataFolder(t)
+	defer cleanup()
+	dataDir := filepath.Join(tmpDir, "data")
+
+	db, err := database.Connect(dataDir)
+	if err != nil {
+		t.Fatalf("Cant create db: %v", err)
+	}
+	defer func(u database.DB) {
+		err := db.Close()
+		if err != nil {
+			t.Errorf("Error closing db: %v", err)
+		}
+	}(db)
+	runLogsAdapter, err := filesystem.ConnectRunLogs(filepath.Join(dataDir, "runs"))
+	if err != nil {
+		t.Fatalf("Cant set connect run logs: %v", err)
+	}
+	runsService := NewService(1024, db, runLogsAdapter)
+
+	recorder, err := runsService.StartRun(&entities.Command{ID: 5, Command: "make", ExecutionMode: entities.ExecutionModePipe}, "session", "tester", 80, 24)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	recorder.WriteSource(entities.OutputStdout, []byte("building\n"))
+	recorder.WriteSource(entities.OutputStderr, []byte("warning\n"))
+	recorder.WriteSource(entities.OutputStderr, []byte("error\n"))
+	recorder.WriteSource(entities.OutputStdout, []byte("failed\n"))
+	if err := recorder.Finish(entities.ExitStatus{Code: 2}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	runId := recorder.GetRun().ID
+
+	output, err := runsService.GetRunOutput(runId)
+	if err != nil || string(output) != "building\nwarning\nerror\nfailed\n" {
+		t.Fatalf("Unexpected output: %q, %v", output, err)
+	}
+	stdout, err := runsService.GetRunSourceOutput(runId, entities.OutputStdout)
+	if err != nil || string(stdout) != "building\nfailed\n" {
+		t.Fatalf("Unexpected stdout: %q, %v", stdout, err)
+	}
+	stderr, err := runsService.GetRunSourceOutput(runId, entities.OutputStderr)
+	if err != nil || string(stderr) != "warning\nerror\n" {
+		t.Fatalf("Unexpected stderr: %q, %v", stderr, err)
+	}
+	recording, err := runsService.GetRunRecording(runId)
+	if err != nil || !strings.Contains(string(recording), `"\u001b[31mwarning\r\n\u001b[39m"`) {
+		t.Fatalf("Unexpected recording: %s, %v", recording, err)
+	}
+
+	// Resumed stream keeps sources
+	stream, err := runsService.StreamRunOutput(runId, 12)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var events []string
+	for event := range stream.Output {
+		events = append(events, fmt.Sprintf("%s:%q:%d", event.Source, event.Data, event.Offset))
+	}
+	expected := []string{`stderr:"ning\nerror\n":23`, `stdout:"failed\n":30`}
+	if !slices.Equal(events, expected) {
+		t.Fatalf("Unexpected events: %v", events)
+	}
+}
+
+func TestExportRunOutput(t *testing.T) {
+	log.SetLevel(0)
+	tmpDir, cleanup := testutils.CreateTempDataFolder(t)
+	defer cleanup()
+	dataDir := filepath.Join(tmpDir, "data")
+
+	db, err := database.Connect(dataDir)
+	if err != nil {
+		t.Fatalf("Cant create db: %v", err)
+	}
+	defer func(u database.DB) {
+		err := db.Close()
+		if err != nil {
+			t.Errorf("Error closing db: %v", err)
+		}
+	}(db)
+	runLogsAdapter, err := filesystem.ConnectRunLogs(filepath.Join(dataDir, "runs"))
+	if err != nil {
+		t.Fatalf("Cant set connect run logs: %v", err)
+	}
+	runsService := NewService(1024, db, runLogsAdapter)
+
+	recorder, err := runsService.StartRun(&entities.Command{ID: 5, Command: "build"}, "session", "tester", 80, 24)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, _ = recorder.Write([]byte("\x1b[1;31mred\x1b[0m <b>\r\nprogress 10%"))
+	time.Sleep(50 * time.Millisecond)
+	_, _ = recorder.Write([]byte("\rprogress 100%\r\n\x1b[38;5;46m\x1b[?25ldone"))
+	if err := recorder.Finish(entities.ExitStatus{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	runId := recorder.GetRun().ID
+
+	testCases := []struct {
+		name           string
+		format         entities.ExportFormat
+		expectedOutput string
+		expectedErr    error
+	}{
+		{
+			name:           "Plain text",
+			format:         entities.ExportText,
+			expectedOutput: "red <b>\nprogress 100%\ndone",
+		},
+		{
+			name:           "Raw ANSI",
+			format:         entities.ExportANSI,
+			expectedOutput: "\x1b[1;31mred\x1b[0m <b>\r\nprogress 10%\rprogress 100%\r\n\x1b[38;5;46m\x1b[?25ldone",
+		},
+		{
+			name:           "HTML",
+			format:         entities.ExportHTML,
+			expectedOutput: `<pre><span style="color:#cd0000;font-weight:bold">red</span> &lt;b&gt;` + "\nprogress 100%\n" + `<span style="color:#00ff00">done</span></pre>`,
+		},
+		{
+			name:   "JSON lines",
+			format: entities.ExportJSONLines,
+			expectedOutput: `{"line":"red <b>","elapsed":0}` + "\n" +
+				`{"line":"progress 100%","elapsed":0}` + "\n" +
+				`{"line":"done","elapsed":0.05}` + "\n",
+		},
+		{
+			name:        "Unknown format",
+			format:      "pdf",
+			expectedErr: projectErrors.ErrBadExportFormat,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := runsService.ExportRunOutput(runId, tc.format)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("Unexpected error: %v, need %v", err, tc.expectedErr)
+			}
+			if err != nil {
+				return
+			}
+			switch tc.format {
+			case entities.ExportHTML:
+				if !strings.Contains(string(data), tc.expectedOutput) {
+					t.Fatalf("Unexpected output: %s", data)
+				}
+			case entities.ExportJSONLines:
+				// Times are floored to 0.05 second, so they do not depend on speed of test
+				var lines []string
+				for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+					var record struct {
+						Time    time.Time `json:"time"`
+						Elapsed float64   `json:"elapsed"`
+						Line    string    `json:"line"`
+					}
+					if err := json.Unmarshal([]byte(line), &record); err != nil {
+						t.Fatalf("Bad json line %q: %v", line, err)
+					}
+					if record.Time.IsZero() {
+						t.Fatalf("No time in line %q", line)
+					}
+					lines = append(lines, fmt.Sprintf(`{"line":%q,"elapsed":%g}`, record.Line, math.Floor(record.Elapsed*20)/20))
+				}
+				if output := strings.Join(lines, "\n") + "\n"; output != tc.expectedOutput {
+					t.Fatalf("Unexpected output: %s", output)
+				}
+			default:
+				if string(data) != tc.expectedOutput {
+					t.Fatalf("Unexpected output: %q", data)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package runs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	"io"
+)
+
+// Sources of piped command output are saved as json line for every switch between stdout and stderr
+
+// sourcesWriter writes span, when source of output changes
+type sourcesWriter struct {
+	w      io.WriteCloser
+	source entities.OutputSource
+}
+
+func (w *sourcesWriter) Write(offset int64, source entities.OutputSource) error {
+	if source == w.source {
+		return nil
+	}
+	w.source = source
+	line, err := json.Marshal(entities.OutputSpan{Offset: offset, Source: source})
+	if err != nil {
+		return err
+	}
+	_, err = w.w.Write(append(line, '\n'))
+	return err
+}
+
+func (w *sourcesWriter) Close() error {
+	return w.w.Close()
+}
+
+// parseSources read spans of output, cut line of crashed server is skipped
+func parseSources(data []byte) []entities.OutputSpan {
+	var spans []entities.OutputSpan
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var span entities.OutputSpan
+		if err := json.Unmarshal(scanner.Bytes(), &span); err != nil {
+			continue
+		}
+		spans = append(spans, span)
+	}
+	return spans
+}
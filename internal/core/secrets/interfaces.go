@@ -0,0 +1,12 @@
+package secrets
+
+import (
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+)
+
+type SecretsRepository interface {
+	GetSecrets() ([]entities.Secret, error)
+	GetSecret(name string) (*entities.Secret, error)
+	SetSecret(secret *entities.Secret) error
+	DeleteSecret(name string) error
+}
@@ -0,0 +1,113 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/utils"
+)
+
+// KeySize is size of AES-256 key, that encrypts secrets
+const KeySize = 32
+
+type Service struct {
+	key               []byte
+	secretsRepository SecretsRepository
+}
+
+// ParseKey decode base64 secrets key
+func ParseKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != KeySize {
+		return nil, projectErrors.ErrBadSecretsKey
+	}
+	return key, nil
+}
+
+// NewService key must be KeySize bytes, see ParseKey
+func NewService(key []byte, secretsRepository SecretsRepository) *Service {
+	return &Service{
+		key:               key,
+		secretsRepository: secretsRepository,
+	}
+}
+
+func (s Service) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(s.key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", projectErrors.ErrBadSecretsKey, err)
+	}
+	return cipher.NewGCM(block)
+}
+
+func (s Service) encrypt(name string, value string) ([]byte, error) {
+	aead, err := s.aead()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(value)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	// Name is authenticated, so encrypted value cant be moved to other secret
+	return aead.Seal(nonce, nonce, []byte(value), []byte(name)), nil
+}
+
+func (s Service) decrypt(secret *entities.Secret) (string, error) {
+	aead, err := s.aead()
+	if err != nil {
+		return "", err
+	}
+	if len(secret.Value) < aead.NonceSize() {
+		return "", fmt.Errorf("secret %q is corrupted", secret.Name)
+	}
+	nonce, ciphertext := secret.Value[:aead.NonceSize()], secret.Value[aead.NonceSize():]
+	value, err := aead.Open(nil, nonce, ciphertext, []byte(secret.Name))
+	if err != nil {
+		return "", fmt.Errorf("cant decrypt secret %q, secrets key changed? %w", secret.Name, err)
+	}
+	return string(value), nil
+}
+
+// GetSecrets return secrets without values
+func (s Service) GetSecrets() ([]entities.Secret, error) {
+	return s.secretsRepository.GetSecrets()
+}
+
+func (s Service) SetSecret(name string, value string) error {
+	if err := utils.CheckEnvName(name); err != nil {
+		return err
+	}
+	encrypted, err := s.encrypt(name, value)
+	if err != nil {
+		return err
+	}
+	return s.secretsRepository.SetSecret(&entities.Secret{Name: name, Value: encrypted})
+}
+
+func (s Service) DeleteSecret(name string) error {
+	return s.secretsRepository.DeleteSecret(name)
+}
+
+// CommandSecrets return decrypted values of secrets used by command
+func (s Service) CommandSecrets(command *entities.Command) (map[string]string, error) {
+	values := make(map[string]string, len(command.Secrets))
+	for _, name := range command.Secrets {
+		secret, err := s.secretsRepository.GetSecret(name)
+		if errors.Is(err, projectErrors.ErrNotFound) {
+			return nil, fmt.Errorf("%w: %q", projectErrors.ErrSecretNotFound, name)
+		} else if err != nil {
+			return nil, err
+		}
+		values[name], err = s.decrypt(secret)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,119 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/adapters/storage/database"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/testutils"
+	"github.com/gofiber/fiber/v2/log"
+	"reflect"
+	"testing"
+)
+
+func TestParseKey(t *testing.T) {
+	testCases := []struct {
+		name        string
+		encoded     string
+		expectError bool
+	}{
+		{name: "Valid key", encoded: base64.StdEncoding.EncodeToString(make([]byte, KeySize))},
+		{name: "Short key", encoded: base64.StdEncoding.EncodeToString(make([]byte, 16)), expectError: true},
+		{name: "Not base64", encoded: "not base64!", expectError: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseKey(tc.encoded)
+			if tc.expectError != errors.Is(err, projectErrors.ErrBadSecretsKey) {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestCommandSecrets(t *testing.T) {
+	log.SetLevel(0)
+	tmpDir, cleanup := testutils.CreateTempDataFolder(t)
+	defer cleanup()
+	db, err := database.Connect(tmpDir)
+	if err != nil {
+		t.Fatalf("Cant create db: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("Cant close db: %v", err)
+		}
+	}()
+	key := bytes.Repeat([]byte{1}, KeySize)
+	service := NewService(key, db)
+	if err := service.SetSecret("TOKEN", "s3cr3t"); err != nil {
+		t.Fatalf("Cant set secret: %v", err)
+	}
+	if err := service.SetSecret("OTHER", "other"); err != nil {
+		t.Fatalf("Cant set secret: %v", err)
+	}
+	if err := service.SetSecret("BAD=NAME", "value"); !errors.Is(err, projectErrors.ErrBadEnvVariable) {
+		t.Fatalf("Expected ErrBadEnvVariable, got %v", err)
+	}
+
+	stored, err := db.GetSecret("TOKEN")
+	if err != nil {
+		t.Fatalf("Cant get secret: %v", err)
+	}
+	if bytes.Contains(stored.Value, []byte("s3cr3t")) {
+		t.Fatal("Secret stored in plaintext")
+	}
+
+	testCases := []struct {
+		name          string
+		service       *Service
+		secrets       []string
+		expected      map[string]string
+		expectedError error
+	}{
+		{
+			name:     "Decrypt",
+			service:  service,
+			secrets:  []string{"TOKEN"},
+			expected: map[string]string{"TOKEN": "s3cr3t"},
+		},
+		{
+			name:     "No secrets",
+			service:  service,
+			expected: map[string]string{},
+		},
+		{
+			name:          "Missing secret",
+			service:       service,
+			secrets:       []string{"TOKEN", "MISSING"},
+			expectedError: projectErrors.ErrSecretNotFound,
+		},
+		{
+			name:    "Wrong key",
+			service: NewService(bytes.Repeat([]byte{2}, KeySize), db),
+			secrets: []string{"OTHER"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			values, err := tc.service.CommandSecrets(&entities.Command{Secrets: tc.secrets})
+			if tc.expectedError != nil && !errors.Is(err, tc.expectedError) {
+				t.Fatalf("Expected error %v, got %v", tc.expectedError, err)
+			}
+			if tc.expected == nil {
+				if err == nil {
+					t.Fatalf("Expected error, got %v", values)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(values, tc.expected) {
+				t.Errorf("Expected %v, got %v", tc.expected, values)
+			}
+		})
+	}
+}
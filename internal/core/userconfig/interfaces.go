@@ -8,9 +8,12 @@ type CommandsRepository interface {
 }
 
 type FilesRepository interface {
+	GetAllFiles() ([]entities.EmbeddedFile, error)
+	DeleteFile(commandId, id uint) error
 	DeleteAllFiles() error
 }
 
 type Filesystem interface {
+	DeleteFile(fileId uint) error
 	ClearFiles() error
 }
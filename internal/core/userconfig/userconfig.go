@@ -1,8 +1,12 @@
 package userconfig
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
 	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/utils"
+	"os"
 )
 
 type Service struct {
@@ -46,8 +50,83 @@ func (s Service) clearFiles() error {
 	return s.filesystem.ClearFiles()
 }
 
+// clearRemovedFiles delete files of commands, that are not in commands anymore
+func (s Service) clearRemovedFiles(commands []entities.Command) error {
+	kept := make(map[uint]bool, len(commands))
+	for _, command := range commands {
+		kept[command.ID] = true
+	}
+	files, err := s.filesRepository.GetAllFiles()
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if kept[file.CommandID] {
+			continue
+		}
+		if err := s.filesRepository.DeleteFile(file.CommandID, file.ID); err != nil {
+			return err
+		}
+		if err := s.filesystem.DeleteFile(file.ID); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// keepStoredCommandIds give commands without id the id of stored command with the same name,
+// so grants and files of such commands are not lost on every load
+func (s Service) keepStoredCommandIds(commands []entities.Command) error {
+	stored, err := s.commandsRepository.GetCommands()
+	if err != nil {
+		return err
+	}
+	used := make(map[uint]bool, len(commands))
+	for _, command := range commands {
+		used[command.ID] = true
+	}
+	for i := range commands {
+		if commands[i].ID != 0 || commands[i].Name == "" {
+			continue
+		}
+		for _, storedCommand := range stored {
+			if storedCommand.Name == commands[i].Name && !used[storedCommand.ID] {
+				commands[i].ID = storedCommand.ID
+				used[storedCommand.ID] = true
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func checkCommands(commands []entities.Command) error {
+	utils.SetDefaultCommandsNames(commands)
+	for _, command := range commands {
+		if err := utils.CheckParameters(command.Parameters); err != nil {
+			return err
+		}
+		if err := utils.CheckEnv(command.Env); err != nil {
+			return err
+		}
+		if err := utils.CheckEnvNames(command.Secrets); err != nil {
+			return err
+		}
+		// Imported config can come from other machine, so installation of interpreter is checked only on run
+		if _, err := utils.CommandInterpreter(&command); err != nil {
+			return err
+		}
+		if err := utils.CheckExecutionMode(command.ExecutionMode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (s Service) SetUserConfig(newConfig *entities.UserConfig) error {
-	utils.SetDefaultCommandsNames(newConfig.Commands)
+	if err := checkCommands(newConfig.Commands); err != nil {
+		return err
+	}
 	err := s.commandsRepository.SetCommands(newConfig.Commands)
 	if err != nil {
 		return err
@@ -58,3 +137,27 @@ func (s Service) SetUserConfig(newConfig *entities.UserConfig) error {
 	}
 	return nil
 }
+
+// LoadUserConfigFile replace commands with commands from json config file, exported from ui or /json-config.
+// Unlike SetUserConfig only files of removed commands are deleted, so it can be done on every start and files of commands with the same id stay.
+// Commands without id keep id of stored command with the same name
+func (s Service) LoadUserConfigFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cant read config file: %w", err)
+	}
+	newConfig := s.CreateDefaultUserConfig()
+	if err := json.Unmarshal(data, newConfig); err != nil {
+		return fmt.Errorf("cant parse config file: %w", err)
+	}
+	if err := s.keepStoredCommandIds(newConfig.Commands); err != nil {
+		return err
+	}
+	if err := checkCommands(newConfig.Commands); err != nil {
+		return err
+	}
+	if err := s.commandsRepository.SetCommands(newConfig.Commands); err != nil {
+		return err
+	}
+	return s.clearRemovedFiles(newConfig.Commands)
+}
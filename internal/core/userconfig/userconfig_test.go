@@ -7,6 +7,7 @@ import (
 	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
 	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/testutils"
 	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/utils"
+	"os"
 	"path/filepath"
 	"reflect"
 	"testing"
@@ -198,3 +199,117 @@ func TestUpdateUserConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadUserConfigFile(t *testing.T) {
+	console := utils.DetectDefaultConsole()
+	testCases := []struct {
+		name           string
+		storedCommands []entities.Command
+		fileContent    string
+		expectedConfig entities.UserConfig
+		expectError    bool
+	}{
+		{
+			name:        "Load commands",
+			fileContent: `{"usingConsole": "sh", "commands": [{"id": 5, "name": "Restart", "command": "echo restart"}, {"id": 7, "name": "Second", "command": "echo second"}]}`,
+			expectedConfig: entities.UserConfig{
+				UsingConsole: console,
+				Commands: []entities.Command{
+					{ID: 5, Name: "Restart", Command: "echo restart"},
+					{ID: 7, Name: "Second", Command: "echo second"},
+				},
+			},
+		},
+		{
+			name:           "Commands without id keep stored ids by name",
+			storedCommands: []entities.Command{{ID: 5, Name: "Restart", Command: "echo old"}, {ID: 9, Name: "Removed", Command: "echo removed"}},
+			fileContent:    `{"commands": [{"name": "Second", "command": "echo second", "id": 7}, {"name": "Restart", "command": "echo restart"}]}`,
+			expectedConfig: entities.UserConfig{
+				UsingConsole: console,
+				Commands: []entities.Command{
+					{ID: 5, Name: "Restart", Command: "echo restart"},
+					{ID: 7, Name: "Second", Command: "echo second"},
+				},
+			},
+		},
+		{
+			name:        "Bad json",
+			fileContent: `{"commands": [`,
+			expectError: true,
+		},
+		{
+			name:        "Bad execution mode",
+			fileContent: `{"commands": [{"name": "Bad", "command": "echo bad", "executionMode": "magic"}]}`,
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpDir, cleanup := testutils.CreateTempDataFolder(t)
+			defer cleanup()
+			dataDir := filepath.Join(tmpDir, "data")
+			filesDir := filepath.Join(dataDir, "files123")
+
+			db, err := database.Connect(dataDir)
+			if err != nil {
+				t.Fatalf("Cant create db: %v", err)
+			}
+			defer func(u database.DB) {
+				err := db.Close()
+				if err != nil {
+					t.Errorf("Error closing db: %v", err)
+				}
+			}(db)
+			filesystemAdapter, err := filesystem.Connect(filesDir)
+			if err != nil {
+				t.Fatalf("Cant set connect filesystem: %v", err)
+			}
+			userConfigService := NewService(db, db, filesystemAdapter, console)
+			if err := db.SetCommands(tc.storedCommands); err != nil {
+				t.Fatalf("Cant set stored commands: %v", err)
+			}
+			for _, file := range []*entities.EmbeddedFile{{CommandID: 5, Name: "kept.txt"}, {CommandID: 9, Name: "removed.txt"}} {
+				if err := db.AppendFile(file); err != nil {
+					t.Fatalf("Cant append file: %v", err)
+				}
+				if err := filesystemAdapter.SaveFile(file.ID, []byte(file.Name)); err != nil {
+					t.Fatalf("Cant save file: %v", err)
+				}
+			}
+
+			configPath := filepath.Join(tmpDir, "frozen.json")
+			if err := os.WriteFile(configPath, []byte(tc.fileContent), 0600); err != nil {
+				t.Fatalf("Cant write config file: %v", err)
+			}
+			err = userConfigService.LoadUserConfigFile(configPath)
+			if tc.expectError {
+				if err == nil {
+					t.Fatalf("Expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			resultConfig, err := userConfigService.GetUserConfig()
+			if err != nil {
+				t.Fatalf("Cant get result config: %v", err)
+			}
+			if !reflect.DeepEqual(*resultConfig, tc.expectedConfig) {
+				t.Fatalf("Expected config: %v, got: %v", tc.expectedConfig, *resultConfig)
+			}
+			files, err := db.GetCommandFiles(5)
+			if err != nil || len(files) != 1 {
+				t.Errorf("Files are not kept: %v %v", files, err)
+			}
+			files, err = db.GetCommandFiles(9)
+			if err != nil || len(files) != 0 {
+				t.Errorf("Files of removed command are not deleted: %v %v", files, err)
+			}
+			if _, err := filesystemAdapter.GetFileData(2); err == nil {
+				t.Errorf("Data of file of removed command is not deleted")
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package entities
 
 import (
 	"io"
+	"time"
 )
 
 type TerminalOptions struct {
@@ -9,6 +10,9 @@ type TerminalOptions struct {
 	Rows uint16   `json:"rows"`
 	Env  []string `json:"-"`
 	Dir  string   `json:"-"`
+
+	Parameters map[string]string `json:"parameters"` // values of command parameters by name
+	Stdin      io.Reader         `json:"-"`          // piped to command in pipe mode, nil to keep stdin open for input of clients
 }
 
 type EmbeddedFile struct {
@@ -22,11 +26,219 @@ type UserConfig struct {
 	Commands     []Command `json:"commands"`
 }
 
+const (
+	ParameterTypeString  = "string"
+	ParameterTypeEnum    = "enum"
+	ParameterTypeNumber  = "number"
+	ParameterTypeBoolean = "boolean"
+)
+
+const (
+	ExecutionModeTerminal = "terminal" // default, command runs in pty, stderr merged into stdout
+	ExecutionModePipe     = "pipe"     // command runs with plain pipes, stdout and stderr are separate
+)
+
+// CommandParameter is a value, that asked before run and substituted in place of {{name}} in command
+type CommandParameter struct {
+	Name       string   `json:"name"`
+	Type       string   `json:"type"` // string (default), enum, number or boolean
+	Default    string   `json:"default"`
+	Options    []string `json:"options,omitempty"`    // allowed values of enum
+	Validation string   `json:"validation,omitempty"` // regexp, value must fully match it
+}
+
 type Command struct {
-	ID      uint   `json:"id" gorm:"->;<-:create;primaryKey"`
-	Name    string `json:"name"`
-	Command string `json:"command"`
-	Dir     string `json:"executionDir"`
+	ID            uint               `json:"id" gorm:"->;<-:create;primaryKey"`
+	Name          string             `json:"name"`
+	Command       string             `json:"command"`
+	Dir           string             `json:"executionDir"`
+	Parameters    []CommandParameter `json:"parameters,omitempty" gorm:"serializer:json"`
+	Env           map[string]string  `json:"env,omitempty" gorm:"serializer:json"`
+	EnvFile       string             `json:"envFile,omitempty"`                            // .env file, relative path resolved from execution dir
+	Secrets       []string           `json:"secrets,omitempty" gorm:"serializer:json"`     // names of secrets, set as environment variables
+	Interpreter   []string           `json:"interpreter,omitempty" gorm:"serializer:json"` // preset name, like ["python3"], or argv template with {{command}}, empty for default console
+	TimeoutMs     uint               `json:"timeoutMs,omitempty"`                          // command stopped by kill policy after timeout, 0 for no timeout
+	KillPolicy    *KillPolicy        `json:"killPolicy,omitempty" gorm:"serializer:json"`  // nil for default policy
+	IdlePolicy    *IdlePolicy        `json:"idlePolicy,omitempty" gorm:"serializer:json"`  // nil for no idle input timeout
+	ExecutionMode string             `json:"executionMode,omitempty"`                      // terminal (default) or pipe
+	Access        AccessLevel        `json:"access,omitempty" gorm:"-"`                    // access level of user, that requested command
+}
+
+// KillPolicy is how command is stopped: signals sent to its process group one by one,
+// next signal sent if command still runs after grace period. Zero grace period skips signal, SIGKILL sent last.
+type KillPolicy struct {
+	InterruptGraceMs uint `json:"interruptGraceMs"` // wait after SIGINT
+	TerminateGraceMs uint `json:"terminateGraceMs"` // wait after SIGTERM
+}
+
+// IdlePolicy is how interactive command is stopped, when its terminal clients send no input.
+// Warning is sent only if it comes before termination, zero disables step. Commands started without terminal are not affected
+type IdlePolicy struct {
+	WarnAfterMs      uint `json:"warnAfterMs"`      // warn viewers after no input for this time
+	TerminateAfterMs uint `json:"terminateAfterMs"` // stop command by kill policy after no input for this time
+}
+
+type Signal string
+
+const (
+	SignalInterrupt Signal = "interrupt"
+	SignalTerminate Signal = "terminate"
+	SignalKill      Signal = "kill"
+	SignalHangup    Signal = "hangup"
+	SignalSuspend   Signal = "suspend"  // unix only
+	SignalContinue  Signal = "continue" // unix only
+)
+
+// Reasons of stopping command by server
+const (
+	StopReasonTimeout    = "timeout"
+	StopReasonTerminated = "terminated" // by client
+	StopReasonAbandoned  = "abandoned"  // no clients connected for session detach timeout
+	StopReasonIdle       = "idle"       // no input from clients for idle policy timeout
+)
+
+// EnvVariable is global environment variable, that set for every command
+type EnvVariable struct {
+	Name  string `json:"name" gorm:"primaryKey"`
+	Value string `json:"value"`
+}
+
+// Secret is encrypted value, that set as environment variable of commands. Value never leaves server.
+type Secret struct {
+	Name      string    `json:"name" gorm:"primaryKey"`
+	Value     []byte    `json:"-"` // nonce followed by AES-GCM ciphertext
+	UpdatedAt time.Time `json:"updated-at"`
+}
+
+// UserRole is what user can do with all commands, grants change it for single commands
+type UserRole string
+
+const (
+	RoleAdmin    UserRole = "admin"    // everything, users, environment, secrets and config import too
+	RoleEditor   UserRole = "editor"   // create, edit and run commands, manage their files
+	RoleOperator UserRole = "operator" // run commands
+	RoleViewer   UserRole = "viewer"   // see commands, runs and watch sessions
+)
+
+// AccessLevel is what user can do with one command, every level includes the lower ones
+type AccessLevel string
+
+const (
+	AccessNone AccessLevel = "none" // command is hidden
+	AccessView AccessLevel = "view" // see command, its files, runs and watch its sessions
+	AccessRun  AccessLevel = "run"  // run command, control and signal its sessions
+	AccessEdit AccessLevel = "edit" // change and delete command, upload its files
+)
+
+// User is local account, that can log in to web ui and api.
+// Services take nil user for calls of server itself, that have full access
+type User struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	Username     string    `json:"username" gorm:"uniqueIndex"`
+	PasswordHash []byte    `json:"-"` // bcrypt hash
+	Role         UserRole  `json:"role" gorm:"default:admin"`
+	CreatedAt    time.Time `json:"created-at"`
+}
+
+// CommandGrant set access level of user to command instead of level given by user role
+type CommandGrant struct {
+	CommandID uint        `json:"command-id" gorm:"primaryKey"`
+	UserID    uint        `json:"user-id" gorm:"primaryKey;index"`
+	Level     AccessLevel `json:"level"`
+}
+
+// ApiToken is personal token of user for scripts. It acts as its user, limited by scopes. Database has only hash of token
+type ApiToken struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	TokenHash  string     `json:"-" gorm:"uniqueIndex"` // hex of sha256 of token
+	UserID     uint       `json:"user-id" gorm:"index"`
+	User       User       `json:"-" gorm:"foreignKey:UserID"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes" gorm:"serializer:json"`
+	CreatedAt  time.Time  `json:"created-at"`
+	ExpiresAt  *time.Time `json:"expires-at,omitempty"` // nil for token without expiry
+	LastUsedAt *time.Time `json:"last-used-at,omitempty"`
+}
+
+// Scopes of api tokens. Scope ending with * allows every scope with the same prefix
+const (
+	ScopeAll              = "*"
+	ScopeRead             = "read"           // see commands, files, runs, sessions, env and secret names
+	ScopeRunAll           = "run:*"          // run any command, control and signal its sessions
+	ScopeRunCommandPrefix = "run:command:"   // run one command, like run:command:12
+	ScopeCommandsWrite    = "commands:write" // create, change and delete commands
+	ScopeFilesWrite       = "files:write"    // upload, change and delete files
+	ScopeAdmin            = "admin"          // users, grants, env, secrets and config import
+)
+
+// AuditAction is kind of recorded action
+type AuditAction string
+
+const (
+	AuditCommandCreate AuditAction = "command.create"
+	AuditCommandUpdate AuditAction = "command.update"
+	AuditCommandDelete AuditAction = "command.delete"
+	AuditFileCreate    AuditAction = "file.create"
+	AuditFileUpdate    AuditAction = "file.update"
+	AuditFileDelete    AuditAction = "file.delete"
+	AuditConfigImport  AuditAction = "config.import" // json config of all commands is replaced or patched
+	AuditFilesImport   AuditAction = "files.import"  // zip archive of files is imported
+	AuditLogin         AuditAction = "login"
+	AuditLoginFailed   AuditAction = "login.failed"
+	AuditRunStart      AuditAction = "run.start"
+	AuditRunStop       AuditAction = "run.stop"   // user terminated run or sent stopping signal to it
+	AuditRunSignal     AuditAction = "run.signal" // user sent signal, that does not stop run, like suspend
+	AuditRunFinish     AuditAction = "run.finish" // command exited
+)
+
+// AuditChange is old and new value of changed field, nil if field is added or removed
+type AuditChange struct {
+	Old any `json:"old,omitempty"`
+	New any `json:"new,omitempty"`
+}
+
+// AuditEntry is record of audit log. Every entry has hash of its fields and hash of previous entry,
+// so changed, inserted or deleted entries break the chain
+type AuditEntry struct {
+	ID       uint                   `json:"id" gorm:"primaryKey"`
+	Time     time.Time              `json:"time" gorm:"index"`
+	UserID   *uint                  `json:"user-id,omitempty"` // nil for actions of server and failed logins
+	Actor    string                 `json:"actor" gorm:"index"`
+	IP       string                 `json:"ip"`
+	Action   AuditAction            `json:"action" gorm:"index"`
+	Target   string                 `json:"target" gorm:"index"` // like command:12, command:12/file:3 or run:5
+	Diff     map[string]AuditChange `json:"diff,omitempty" gorm:"serializer:json"`
+	PrevHash string                 `json:"prev-hash"`
+	Hash     string                 `json:"hash"` // hex of sha256
+}
+
+// AuditFilter selects audit entries, zero fields are not checked. Target matches by prefix
+type AuditFilter struct {
+	Actor  string
+	Action AuditAction
+	Target string
+	Since  *time.Time
+	Until  *time.Time
+	Limit  int
+	Offset int
+}
+
+// AuditVerification is result of audit log chain check
+type AuditVerification struct {
+	Valid    bool   `json:"valid"`
+	Checked  int    `json:"checked"`
+	BrokenID uint   `json:"broken-id,omitempty"` // first entry, that does not match its hash or previous entry
+	LastHash string `json:"last-hash"`           // saved outside, it shows that last entries were not deleted
+}
+
+// LoginSession is session of logged in user. Token itself is kept only in cookie of client, database has its hash
+type LoginSession struct {
+	TokenHash string `gorm:"primaryKey"` // hex of sha256 of token
+	UserID    uint   `gorm:"index"`
+	User      User   `gorm:"foreignKey:UserID"`
+	IP        string // address of client, that logged in
+	CreatedAt time.Time
+	ExpiresAt time.Time `gorm:"index"`
 }
 
 type EmbeddedFileWithCommandInfo struct {
@@ -34,16 +246,166 @@ type EmbeddedFileWithCommandInfo struct {
 	Command Command `json:"command" gorm:"foreignKey:CommandID;references:ID;belongsTo:Command"`
 }
 
+type Run struct {
+	ID              uint       `json:"id" gorm:"primaryKey"`
+	CommandID       uint       `json:"command-id" gorm:"index"`
+	Command         string     `json:"command"`
+	SessionID       string     `json:"session-id"`
+	TriggeredBy     string     `json:"triggered-by"`
+	StartedAt       time.Time  `json:"started-at"`
+	FinishedAt      *time.Time `json:"finished-at"`
+	ExitCode        *int       `json:"exit-code"`
+	ExitSignal      string     `json:"exit-signal,omitempty"`
+	OutputSize      int64      `json:"output-size"`
+	OutputTruncated bool       `json:"output-truncated"`
+	StopReason      string     `json:"stop-reason,omitempty"`
+	ExecutionMode   string     `json:"execution-mode,omitempty"`
+}
+
+type ExitStatus struct {
+	Code       int    `json:"code"`
+	Signal     string `json:"signal,omitempty"` // name of signal which killed command, like SIGKILL
+	DurationMs int64  `json:"duration-ms"`
+	StopReason string `json:"stop-reason,omitempty"` // set if command was stopped by server, like timeout
+}
+
+type RunRecorder interface {
+	io.Writer
+	WriteSource(source OutputSource, p []byte) // output of piped command, tagged with its source
+	GetRun() *Run                              // snapshot, safe to use while recording
+	Resize(cols, rows uint16)
+	Finish(status ExitStatus) error
+}
+
+// ExportFormat is format of run output download
+type ExportFormat string
+
+const (
+	ExportText      ExportFormat = "text"  // plain text without terminal sequences
+	ExportANSI      ExportFormat = "ansi"  // raw output with terminal sequences
+	ExportHTML      ExportFormat = "html"  // HTML page with colours
+	ExportJSONLines ExportFormat = "jsonl" // json line with time for every line of output
+)
+
+// RunPlayback is recorded output of run, replayed with its original timing
+type RunPlayback struct {
+	Run    *Run
+	Output <-chan []byte  // output chunks as they were recorded, closed after the last one
+	Speed  chan<- float64 // change playback speed, invalid values are ignored
+	Exit   *ExitStatus    // nil if run is not finished
+}
+
+type ViewerRole string
+
+const (
+	ViewerController ViewerRole = "controller" // can type, send signals and resize terminal
+	ViewerSpectator  ViewerRole = "spectator"  // read-only
+)
+
+// Viewer is client connected to running command
+type Viewer struct {
+	ID       string     `json:"id"`
+	Name     string     `json:"name"`
+	Role     ViewerRole `json:"role"`
+	JoinedAt time.Time  `json:"joined-at"`
+}
+
+type SessionInfo struct {
+	SessionID string    `json:"session-id"`
+	CommandID uint      `json:"command-id"`
+	RunID     uint      `json:"run-id"`
+	StartedAt time.Time `json:"started-at"`
+	Finished  bool      `json:"finished"`
+	Viewers   []Viewer  `json:"viewers"`
+}
+
 type CommandInputOutput struct {
-	Input  chan<- string
-	Output <-chan string
+	SessionID string
+	Viewer    Viewer // this client
+	Input     chan<- string
+	Output    <-chan []byte     // raw output chunks, can split UTF-8 characters
+	Exit      <-chan ExitStatus // receive exit status before Output closed, if command finished
+	Viewers   <-chan []Viewer   // all connected viewers, sent on join and every change
+	Warnings  <-chan string     // server warnings for viewer, like idle input timeout
+}
+
+// OutputStream is read-only viewer of session, that receives output with offsets to resume from
+type OutputStream struct {
+	SessionID string
+	Viewer    Viewer
+	Output    <-chan OutputEvent
+	Status    <-chan SessionStatus // sent on start and every change
+	Exit      <-chan ExitStatus    // receive exit status before Output closed, if command finished
+}
+
+type OutputEvent struct {
+	Data    []byte       // raw output, can split UTF-8 characters
+	Offset  int64        // offset after Data in the whole output of session
+	Skipped int64        // bytes before Data, that dropped out of buffer, Data starts with marker then
+	Source  OutputSource // empty for terminal output and markers
+}
+
+type OutputSource string
+
+const (
+	OutputStdout OutputSource = "stdout"
+	OutputStderr OutputSource = "stderr"
+)
+
+// OutputSpan marks offset, from which output of piped command comes from source
+type OutputSpan struct {
+	Offset int64        `json:"offset"`
+	Source OutputSource `json:"source"`
+}
+
+type SessionState string
+
+const (
+	SessionRunning  SessionState = "running"
+	SessionStopping SessionState = "stopping" // server is stopping command by kill policy
+	SessionFinished SessionState = "finished" // no more output
+)
+
+type SessionStatus struct {
+	State      SessionState `json:"state"`
+	StopReason string       `json:"stop-reason,omitempty"`
+}
+
+// OverflowPolicy is what session does, when client lags so much, that output buffer overflows
+type OverflowPolicy string
+
+const (
+	OverflowDrop  OverflowPolicy = "drop"  // client skips overwritten output and receives marker instead
+	OverflowBlock OverflowPolicy = "block" // reading output paused, so command is blocked by terminal flow control
+)
+
+type SessionStats struct {
+	SessionID       string         `json:"session-id"`
+	OverflowPolicy  OverflowPolicy `json:"overflow-policy"`
+	BufferLimit     int            `json:"buffer-limit"` // in bytes, <= 0 for unlimited
+	Buffered        int64          `json:"buffered"`     // bytes kept in buffer now
+	TotalOutput     int64          `json:"total-output"` // bytes
+	ClientConnected bool           `json:"client-connected"`
+	ClientLag       int64          `json:"client-lag"`     // bytes not yet sent to connected client
+	DroppedOutput   int64          `json:"dropped-output"` // bytes skipped by lagging clients
+	PausedMs        int64          `json:"paused-ms"`      // total time reading output was paused for client
+	Finished        bool           `json:"finished"`
 }
 
 type RunningCommand interface {
 	GetReader() io.Reader
 	GetWriter() io.Writer
-	Done() <-chan error
-	Kill() error
+	Done() <-chan struct{}
+	ExitStatus() ExitStatus // valid after Done closed
+	Signal(signal Signal) error
+	Resize(cols, rows uint16) error
+	Kill() error // kill command with all its child processes
+}
+
+// PipedCommand is command running without terminal, GetReader returns its stdout
+type PipedCommand interface {
+	RunningCommand
+	GetErrorReader() io.Reader // stderr
 }
 
 type FileParams struct {
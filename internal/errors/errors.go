@@ -9,3 +9,32 @@ var ErrNotFound = errors.New("error not found")
 var ErrBadName = errors.New("bad object name")
 var ErrFileToBig = errors.New("file size too mach")
 var ErrEmptyCommand = errors.New("cant run empty command")
+var ErrBadParameter = errors.New("bad command parameter")
+var ErrBadEnvVariable = errors.New("bad environment variable name")
+var ErrEnvFile = errors.New("cant load env file")
+var ErrBadSecretsKey = errors.New("secrets key must be base64 of 32 bytes")
+var ErrSecretNotFound = errors.New("secret not found")
+var ErrBadInterpreter = errors.New("bad command interpreter")
+var ErrInterpreterNotFound = errors.New("interpreter is not installed")
+var ErrUnsupportedSignal = errors.New("signal is not supported")
+var ErrSessionFinished = errors.New("command already finished")
+var ErrBadTerminalSize = errors.New("terminal size must be positive")
+var ErrSpectator = errors.New("spectator can not control command")
+var ErrBadViewerRole = errors.New("viewer role must be controller or spectator")
+var ErrBadPlaybackSpeed = errors.New("playback speed must be positive and not more than 100")
+var ErrBadExecutionMode = errors.New("execution mode must be terminal or pipe")
+var ErrStdinNotPiped = errors.New("stdin can be passed only to command in pipe mode")
+var ErrNotPiped = errors.New("run output is not split to stdout and stderr")
+var ErrBadExportFormat = errors.New("export format must be text, ansi, html or jsonl")
+var ErrBadCredentials = errors.New("wrong username or password")
+var ErrUnauthorized = errors.New("login session is missing or expired")
+var ErrBadPassword = errors.New("password must be from 8 to 72 bytes")
+var ErrUserExists = errors.New("user with this username already exists")
+var ErrLastAdmin = errors.New("cant delete or demote the last admin")
+var ErrForbidden = errors.New("user has no access to this action")
+var ErrBadRole = errors.New("role must be admin, editor, operator or viewer")
+var ErrBadAccessLevel = errors.New("access level must be none, view, run or edit")
+var ErrConfigFrozen = errors.New("config is frozen, commands and files can not be changed")
+var ErrBadScope = errors.New("scope must be *, read, run:*, run:command:<id>, commands:write, files:write or admin")
+var ErrBadTokenExpiry = errors.New("token expiry must be in the future")
+var ErrBadAuditFilter = errors.New("bad audit filter, time must be RFC3339 and limit from 1 to 1000")
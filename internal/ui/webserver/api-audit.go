@@ -0,0 +1,132 @@
+package webserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"time"
+)
+
+func commandTarget(commandId uint) string {
+	return fmt.Sprintf("command:%d", commandId)
+}
+
+func fileTarget(commandId, fileId uint) string {
+	return fmt.Sprintf("command:%d/file:%d", commandId, fileId)
+}
+
+func runTarget(commandId, runId uint) string {
+	return fmt.Sprintf("command:%d/run:%d", commandId, runId)
+}
+
+// recordAuditOf save action to audit log with diff of old and new value of object.
+// Errors are only logged, action is already done at this moment
+func (s *Server) recordAuditOf(user *entities.User, ip string, action entities.AuditAction, target string, old, new any) {
+	entry := &entities.AuditEntry{IP: ip, Action: action, Target: target}
+	if user != nil {
+		entry.UserID = &user.ID
+		entry.Actor = user.Username
+	}
+	if err := s.audit.Record(entry, old, new); err != nil {
+		log.Warn("Error recording audit entry: ", err)
+	}
+}
+
+// recordAudit save action of user of request to audit log
+func (s *Server) recordAudit(c *fiber.Ctx, action entities.AuditAction, target string, old, new any) {
+	s.recordAuditOf(currentUser(c), c.IP(), action, target, old, new)
+}
+
+// recordRun save start of run and its exit status, when it finishes
+func (s *Server) recordRun(user *entities.User, ip string, run *entities.Run) {
+	target := runTarget(run.CommandID, run.ID)
+	s.recordAuditOf(user, ip, entities.AuditRunStart, target, nil, run)
+	go func() {
+		status, err := s.runner.WaitSession(context.Background(), nil, run.SessionID)
+		if err != nil {
+			log.Warn("Error waiting run for audit: ", err)
+			return
+		}
+		s.recordAuditOf(user, ip, entities.AuditRunFinish, target, nil, status)
+	}()
+}
+
+// sessionRun return run of session
+func (s *Server) sessionRun(sessionId string) (*entities.Run, error) {
+	for _, session := range s.runner.GetSessions(nil) {
+		if session.SessionID == sessionId {
+			return s.runs.GetRun(session.RunID)
+		}
+	}
+	return nil, projectErrors.ErrNotFound
+}
+
+// signalAction return audit action of signal, only signals, that stop command, are recorded as stop
+func signalAction(signal entities.Signal) entities.AuditAction {
+	switch signal {
+	case entities.SignalInterrupt, entities.SignalTerminate, entities.SignalKill, entities.SignalHangup:
+		return entities.AuditRunStop
+	}
+	return entities.AuditRunSignal
+}
+
+// recordSessionRun save action of user with run of session, like terminating it or signal sent to it
+func (s *Server) recordSessionRun(user *entities.User, ip string, sessionId string, action entities.AuditAction, new map[string]any) {
+	run, err := s.sessionRun(sessionId)
+	if err != nil {
+		log.Warn("Error getting run for audit: ", err)
+		return
+	}
+	s.recordAuditOf(user, ip, action, runTarget(run.CommandID, run.ID), nil, new)
+}
+
+// getAudit return audit entries, newest first. Query has filters actor, action, target (with nested targets),
+// since and until in RFC3339, limit and offset
+func (s *Server) getAudit() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		filter := entities.AuditFilter{
+			Actor:  c.Query("actor"),
+			Action: entities.AuditAction(c.Query("action")),
+			Target: c.Query("target"),
+			Limit:  c.QueryInt("limit"),
+			Offset: c.QueryInt("offset"),
+		}
+		for _, param := range []struct {
+			name  string
+			value **time.Time
+		}{{"since", &filter.Since}, {"until", &filter.Until}} {
+			if c.Query(param.name) == "" {
+				continue
+			}
+			parsed, err := time.Parse(time.RFC3339, c.Query(param.name))
+			if err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, projectErrors.ErrBadAuditFilter.Error())
+			}
+			*param.value = &parsed
+		}
+		entries, err := s.audit.GetEntries(filter)
+		if errors.Is(err, projectErrors.ErrBadAuditFilter) {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		} else if err != nil {
+			log.Warn("Error getting audit entries: ", err)
+			return fiber.ErrInternalServerError
+		}
+		return c.JSON(entries)
+	}
+}
+
+// getAuditVerification check chain of audit entries, broken chain means that database was edited
+func (s *Server) getAuditVerification() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		verification, err := s.audit.Verify()
+		if err != nil {
+			log.Warn("Error verifying audit log: ", err)
+			return fiber.ErrInternalServerError
+		}
+		return c.JSON(verification)
+	}
+}
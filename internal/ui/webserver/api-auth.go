@@ -0,0 +1,241 @@
+package webserver
+
+import (
+	"errors"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"strings"
+	"time"
+)
+
+const loginCookieName = "session"
+
+// userLocalsKey is key of logged in user in locals of request
+const userLocalsKey = "user"
+
+// apiTokenLocalsKey is key of api token in locals of request, it is not set for login sessions
+const apiTokenLocalsKey = "api-token"
+
+type loginRequestStruct struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type userRequestStruct struct {
+	Username string            `json:"username"`
+	Password string            `json:"password"`
+	Role     entities.UserRole `json:"role"`
+}
+
+type userRoleRequestStruct struct {
+	Role entities.UserRole `json:"role"`
+}
+
+type changePasswordRequestStruct struct {
+	OldPassword string `json:"old-password"`
+	NewPassword string `json:"new-password"`
+}
+
+// currentUser return user, that was logged in by requireLogin
+func currentUser(c *fiber.Ctx) *entities.User {
+	user, _ := c.Locals(userLocalsKey).(*entities.User)
+	return user
+}
+
+func (s *Server) setLoginCookie(c *fiber.Ctx, token string, expires time.Time) {
+	c.Cookie(&fiber.Cookie{
+		Name:     loginCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		Secure:   s.secureCookie || c.Secure(),
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteStrictMode,
+	})
+}
+
+// requireLogin pass only requests with cookie of login session or with api token in Authorization header,
+// user is put to locals
+func (s *Server) requireLogin() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if token, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer "); ok {
+			apiToken, err := s.auth.AuthenticateApiToken(strings.TrimSpace(token))
+			if errors.Is(err, projectErrors.ErrUnauthorized) {
+				return fiber.ErrUnauthorized
+			} else if err != nil {
+				log.Warn("Error checking api token: ", err)
+				return fiber.ErrInternalServerError
+			}
+			c.Locals(userLocalsKey, &apiToken.User)
+			c.Locals(apiTokenLocalsKey, apiToken)
+			return c.Next()
+		}
+		user, err := s.auth.Authenticate(c.Cookies(loginCookieName))
+		if errors.Is(err, projectErrors.ErrUnauthorized) {
+			return fiber.ErrUnauthorized
+		} else if err != nil {
+			log.Warn("Error checking login session: ", err)
+			return fiber.ErrInternalServerError
+		}
+		c.Locals(userLocalsKey, user)
+		return c.Next()
+	}
+}
+
+// requireRole pass only requests of users with role or higher, it must be used after requireLogin
+func (s *Server) requireRole(role entities.UserRole) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := s.access.CheckRole(currentUser(c), role); err != nil {
+			return fiber.ErrForbidden
+		}
+		return c.Next()
+	}
+}
+
+func (s *Server) login() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var request loginRequestStruct
+		if err := c.BodyParser(&request); err != nil {
+			return fiber.ErrBadRequest
+		}
+		token, session, err := s.auth.Login(request.Username, request.Password, c.IP())
+		if errors.Is(err, projectErrors.ErrBadCredentials) {
+			log.Info("Failed login of ", request.Username, " from ", c.IP())
+			failedLogin := &entities.AuditEntry{Actor: request.Username, IP: c.IP(), Action: entities.AuditLoginFailed}
+			if err := s.audit.Record(failedLogin, nil, nil); err != nil {
+				log.Warn("Error recording audit entry: ", err)
+			}
+			return fiber.NewError(fiber.StatusUnauthorized, err.Error())
+		} else if err != nil {
+			log.Warn("Error logging in: ", err)
+			return fiber.ErrInternalServerError
+		}
+		s.setLoginCookie(c, token, session.ExpiresAt)
+		s.recordAuditOf(&session.User, c.IP(), entities.AuditLogin, "", nil, nil)
+		return c.JSON(session.User)
+	}
+}
+
+func (s *Server) logout() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if token := c.Cookies(loginCookieName); token != "" {
+			if err := s.auth.Logout(token); err != nil {
+				log.Warn("Error logging out: ", err)
+				return fiber.ErrInternalServerError
+			}
+		}
+		s.setLoginCookie(c, "", time.Unix(0, 0))
+		return nil
+	}
+}
+
+func (s *Server) getMe() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.JSON(currentUser(c))
+	}
+}
+
+// changePassword change password of current user, it is logged out everywhere and logged in again here
+func (s *Server) changePassword() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var request changePasswordRequestStruct
+		if err := c.BodyParser(&request); err != nil {
+			return fiber.ErrBadRequest
+		}
+		user := currentUser(c)
+		err := s.auth.ChangePassword(user.ID, request.OldPassword, request.NewPassword)
+		if errors.Is(err, projectErrors.ErrBadCredentials) {
+			return fiber.NewError(fiber.StatusForbidden, "wrong old password")
+		} else if errors.Is(err, projectErrors.ErrBadPassword) {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		} else if err != nil {
+			log.Warn("Error changing password: ", err)
+			return fiber.ErrInternalServerError
+		}
+		token, session, err := s.auth.Login(user.Username, request.NewPassword, c.IP())
+		if err != nil {
+			log.Warn("Error logging in after password change: ", err)
+			return fiber.ErrInternalServerError
+		}
+		s.setLoginCookie(c, token, session.ExpiresAt)
+		return nil
+	}
+}
+
+func (s *Server) getUsers() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		users, err := s.auth.GetUsers()
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		return c.JSON(users)
+	}
+}
+
+func (s *Server) postUser() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		request := userRequestStruct{Role: entities.RoleViewer}
+		if err := c.BodyParser(&request); err != nil {
+			return fiber.ErrBadRequest
+		}
+		user, err := s.auth.CreateUser(request.Username, request.Password, request.Role)
+		if errors.Is(err, projectErrors.ErrBadName) {
+			return fiber.NewError(fiber.StatusBadRequest, "bad username")
+		} else if errors.Is(err, projectErrors.ErrBadPassword) || errors.Is(err, projectErrors.ErrBadRole) {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		} else if errors.Is(err, projectErrors.ErrUserExists) {
+			return fiber.NewError(fiber.StatusConflict, err.Error())
+		} else if err != nil {
+			log.Warn("Error creating user: ", err)
+			return fiber.ErrInternalServerError
+		}
+		return c.Status(fiber.StatusCreated).JSON(user)
+	}
+}
+
+// patchUser change role of user
+func (s *Server) patchUser() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userId, err := c.ParamsInt("user_id")
+		if err != nil || userId < 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid user id")
+		}
+		var request userRoleRequestStruct
+		if err := c.BodyParser(&request); err != nil {
+			return fiber.ErrBadRequest
+		}
+		err = s.auth.SetUserRole(uint(userId), request.Role)
+		if errors.Is(err, projectErrors.ErrNotFound) {
+			return fiber.ErrNotFound
+		} else if errors.Is(err, projectErrors.ErrBadRole) {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		} else if errors.Is(err, projectErrors.ErrLastAdmin) {
+			return fiber.NewError(fiber.StatusConflict, err.Error())
+		} else if err != nil {
+			log.Warn("Error changing role of user: ", err)
+			return fiber.ErrInternalServerError
+		}
+		return nil
+	}
+}
+
+func (s *Server) deleteUser() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userId, err := c.ParamsInt("user_id")
+		if err != nil || userId < 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid user id")
+		}
+		err = s.auth.DeleteUser(uint(userId))
+		if errors.Is(err, projectErrors.ErrNotFound) {
+			return fiber.ErrNotFound
+		} else if errors.Is(err, projectErrors.ErrLastAdmin) {
+			return fiber.NewError(fiber.StatusConflict, err.Error())
+		} else if err != nil {
+			log.Warn("Error deleting user: ", err)
+			return fiber.ErrInternalServerError
+		}
+		return nil
+	}
+}
@@ -15,16 +15,26 @@ func (s *Server) postCommand() fiber.Handler {
 		if err != nil {
 			return fiber.ErrBadRequest
 		}
-		if err := s.commands.AppendCommand(command); err != nil {
+		err = s.commands.AppendCommand(currentUser(c), command)
+		if errors.Is(err, projectErrors.ErrForbidden) {
+			return fiber.ErrForbidden
+		} else if errors.Is(err, projectErrors.ErrBadParameter) {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		} else if errors.Is(err, projectErrors.ErrBadEnvVariable) {
+			return fiber.NewError(fiber.StatusBadRequest, "bad environment variable name")
+		} else if errors.Is(err, projectErrors.ErrBadInterpreter) || errors.Is(err, projectErrors.ErrInterpreterNotFound) || errors.Is(err, projectErrors.ErrBadExecutionMode) {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		} else if err != nil {
 			return fiber.ErrInternalServerError
 		}
+		s.recordAudit(c, entities.AuditCommandCreate, commandTarget(command.ID), nil, command)
 		return nil
 	}
 }
 
 func (s *Server) getCommands() fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		commands, err := s.commands.GetCommandsList()
+		commands, err := s.commands.GetCommandsList(currentUser(c))
 		if err != nil {
 			return fiber.ErrInternalServerError
 		}
@@ -38,9 +48,11 @@ func (s *Server) getCommand() fiber.Handler {
 		if err != nil || id < 0 {
 			return fiber.NewError(fiber.StatusBadRequest, "invalid command id")
 		}
-		command, err := s.commands.GetCommand(uint(id))
+		command, err := s.commands.GetCommand(currentUser(c), uint(id))
 		if errors.Is(err, projectErrors.ErrNotFound) {
 			return fiber.ErrNotFound
+		} else if errors.Is(err, projectErrors.ErrForbidden) {
+			return fiber.ErrForbidden
 		} else if err != nil {
 			return fiber.ErrInternalServerError
 		}
@@ -59,15 +71,25 @@ func (s *Server) patchCommand() fiber.Handler {
 		if err != nil {
 			return fiber.ErrBadRequest
 		}
-		err = s.commands.PatchCommand(uint(id), &command)
+		oldCommand, _ := s.commands.GetCommand(nil, uint(id))
+		err = s.commands.PatchCommand(currentUser(c), uint(id), &command)
 		if errors.Is(err, projectErrors.ErrNotFound) {
 			return fiber.ErrNotFound
+		} else if errors.Is(err, projectErrors.ErrForbidden) {
+			return fiber.ErrForbidden
 		} else if errors.Is(err, projectErrors.ErrBadName) {
 			return fiber.NewError(fiber.StatusBadRequest, "bad command name")
+		} else if errors.Is(err, projectErrors.ErrBadParameter) {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		} else if errors.Is(err, projectErrors.ErrBadEnvVariable) {
+			return fiber.NewError(fiber.StatusBadRequest, "bad environment variable name")
+		} else if errors.Is(err, projectErrors.ErrBadInterpreter) || errors.Is(err, projectErrors.ErrInterpreterNotFound) || errors.Is(err, projectErrors.ErrBadExecutionMode) {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
 		} else if err != nil {
 			log.Debug(err)
 			return fiber.ErrInternalServerError
 		}
+		s.recordCommandUpdate(c, uint(id), oldCommand)
 		return nil
 	}
 }
@@ -83,14 +105,24 @@ func (s *Server) putCommand() fiber.Handler {
 		if err != nil {
 			return fiber.ErrBadRequest
 		}
-		err = s.commands.PutCommand(uint(id), command)
+		oldCommand, _ := s.commands.GetCommand(nil, uint(id))
+		err = s.commands.PutCommand(currentUser(c), uint(id), command)
 		if errors.Is(err, projectErrors.ErrNotFound) {
 			return fiber.ErrNotFound
+		} else if errors.Is(err, projectErrors.ErrForbidden) {
+			return fiber.ErrForbidden
 		} else if errors.Is(err, projectErrors.ErrBadName) {
 			return fiber.NewError(fiber.StatusBadRequest, "bad command name")
+		} else if errors.Is(err, projectErrors.ErrBadParameter) {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		} else if errors.Is(err, projectErrors.ErrBadEnvVariable) {
+			return fiber.NewError(fiber.StatusBadRequest, "bad environment variable name")
+		} else if errors.Is(err, projectErrors.ErrBadInterpreter) || errors.Is(err, projectErrors.ErrInterpreterNotFound) || errors.Is(err, projectErrors.ErrBadExecutionMode) {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
 		} else if err != nil {
 			return fiber.ErrInternalServerError
 		}
+		s.recordCommandUpdate(c, uint(id), oldCommand)
 		return nil
 	}
 }
@@ -101,10 +133,26 @@ func (s *Server) deleteCommand() fiber.Handler {
 		if err != nil || id < 0 {
 			return fiber.NewError(fiber.StatusBadRequest, "invalid command id")
 		}
-		err = s.commands.DeleteCommand(uint(id))
+		oldCommand, _ := s.commands.GetCommand(nil, uint(id))
+		err = s.commands.DeleteCommand(currentUser(c), uint(id))
 		if errors.Is(err, projectErrors.ErrNotFound) {
 			return fiber.ErrNotFound
+		} else if errors.Is(err, projectErrors.ErrForbidden) {
+			return fiber.ErrForbidden
+		} else if err != nil {
+			return fiber.ErrInternalServerError
 		}
+		s.recordAudit(c, entities.AuditCommandDelete, commandTarget(uint(id)), oldCommand, nil)
 		return nil
 	}
 }
+
+// recordCommandUpdate save changed fields of command to audit log, old command is read before update
+func (s *Server) recordCommandUpdate(c *fiber.Ctx, commandId uint, oldCommand *entities.Command) {
+	newCommand, err := s.commands.GetCommand(nil, commandId)
+	if err != nil {
+		log.Warn("Error getting command for audit: ", err)
+		return
+	}
+	s.recordAudit(c, entities.AuditCommandUpdate, commandTarget(commandId), oldCommand, newCommand)
+}
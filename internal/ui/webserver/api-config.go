@@ -1,9 +1,23 @@
 package webserver
 
 import (
+	"errors"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
 )
 
+// rejectFrozen forbid changing of commands and files, when config is frozen. Runs are still allowed
+func (s *Server) rejectFrozen() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if s.frozen {
+			return fiber.NewError(fiber.StatusForbidden, projectErrors.ErrConfigFrozen.Error())
+		}
+		return c.Next()
+	}
+}
+
 func (s *Server) getJsonConfig() fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		conf, err := s.userconfig.GetUserConfig()
@@ -22,9 +36,23 @@ func (s *Server) editJsonConfig() fiber.Handler {
 		if err != nil {
 			return fiber.ErrBadRequest
 		}
-		if err := s.userconfig.SetUserConfig(conf); err != nil {
+		oldConf, _ := s.userconfig.GetUserConfig()
+		err = s.userconfig.SetUserConfig(conf)
+		if errors.Is(err, projectErrors.ErrBadParameter) {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		} else if errors.Is(err, projectErrors.ErrBadEnvVariable) {
+			return fiber.NewError(fiber.StatusBadRequest, "bad environment variable name")
+		} else if errors.Is(err, projectErrors.ErrBadInterpreter) || errors.Is(err, projectErrors.ErrBadExecutionMode) {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		} else if err != nil {
 			return fiber.ErrInternalServerError
 		}
+		newConf, err := s.userconfig.GetUserConfig()
+		if err != nil {
+			log.Warn("Error getting config for audit: ", err)
+			return nil
+		}
+		s.recordAudit(c, entities.AuditConfigImport, "", oldConf, newConf)
 		return nil
 	}
 }
@@ -34,3 +62,9 @@ func (s *Server) consoleUsing() fiber.Handler {
 		return c.Send([]byte(s.usingConsole))
 	}
 }
+
+func (s *Server) configFrozen() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.JSON(s.frozen)
+	}
+}
@@ -0,0 +1,34 @@
+package webserver
+
+import (
+	"errors"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"github.com/gofiber/fiber/v2"
+)
+
+// getEnv return global environment variables, that set for every command
+func (s *Server) getEnv() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		env, err := s.environment.GetGlobalEnv()
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		return c.JSON(env)
+	}
+}
+
+func (s *Server) putEnv() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		env := make(map[string]string)
+		if err := c.BodyParser(&env); err != nil {
+			return fiber.ErrBadRequest
+		}
+		err := s.environment.SetGlobalEnv(env)
+		if errors.Is(err, projectErrors.ErrBadEnvVariable) {
+			return fiber.NewError(fiber.StatusBadRequest, "bad environment variable name")
+		} else if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		return nil
+	}
+}
@@ -26,6 +26,7 @@ func (s *Server) postFiles() fiber.Handler {
 			return err
 		}
 		files := form.File["files"]
+		user := currentUser(c)
 		group, ctx := errgroup.WithContext(ctx)
 		doBrake := false
 		for _, file := range files {
@@ -48,10 +49,14 @@ func (s *Server) postFiles() fiber.Handler {
 							log.Warn(err)
 						}
 					}(src)
-					if err := s.files.AppendFile(uint(commandId), fileBytes, &entities.FileParams{Filename: file.Filename, Size: uint64(file.Size)}); err != nil {
+					embeddedFile, err := s.files.AppendFile(user, uint(commandId), fileBytes, &entities.FileParams{Filename: file.Filename, Size: uint64(file.Size)})
+					if err != nil {
 						if errors.Is(err, projectErrors.ErrNotFound) {
 							return fiber.ErrNotFound
 						}
+						if errors.Is(err, projectErrors.ErrForbidden) {
+							return fiber.ErrForbidden
+						}
 						if errors.Is(err, projectErrors.ErrFileToBig) {
 							return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("too big file (max %d) bytes", s.maxFileSize))
 						}
@@ -61,6 +66,7 @@ func (s *Server) postFiles() fiber.Handler {
 						log.Error(err)
 						return fiber.ErrInternalServerError
 					}
+					s.recordAuditOf(user, c.IP(), entities.AuditFileCreate, fileTarget(uint(commandId), embeddedFile.ID), nil, map[string]any{"filename": file.Filename, "size": file.Size})
 					return nil
 				})
 			}
@@ -78,9 +84,11 @@ func (s *Server) getCommandFilesList() fiber.Handler {
 		if err != nil || commandId < 0 {
 			return fiber.NewError(fiber.StatusBadRequest, "invalid command id")
 		}
-		commands, err := s.files.GetCommandFiles(uint(commandId))
+		commands, err := s.files.GetCommandFiles(currentUser(c), uint(commandId))
 		if errors.Is(err, projectErrors.ErrNotFound) {
 			return fiber.ErrNotFound
+		} else if errors.Is(err, projectErrors.ErrForbidden) {
+			return fiber.ErrForbidden
 		} else if err != nil {
 			return fiber.ErrInternalServerError
 		}
@@ -98,9 +106,11 @@ func (s *Server) getFile() fiber.Handler {
 		if err != nil || fileId < 0 {
 			return fiber.NewError(fiber.StatusBadRequest, "invalid file id")
 		}
-		commands, err := s.files.GetFile(uint(commandId), uint(fileId))
+		commands, err := s.files.GetFile(currentUser(c), uint(commandId), uint(fileId))
 		if errors.Is(err, projectErrors.ErrNotFound) {
 			return fiber.ErrNotFound
+		} else if errors.Is(err, projectErrors.ErrForbidden) {
+			return fiber.ErrForbidden
 		} else if err != nil {
 			return fiber.ErrInternalServerError
 		}
@@ -123,14 +133,18 @@ func (s *Server) putFile() fiber.Handler {
 		if err != nil {
 			return err
 		}
-		err = s.files.PutFile(uint(commandId), uint(fileId), &file)
+		oldFile, _ := s.files.GetFile(nil, uint(commandId), uint(fileId))
+		err = s.files.PutFile(currentUser(c), uint(commandId), uint(fileId), &file)
 		if errors.Is(err, projectErrors.ErrNotFound) {
 			return fiber.ErrNotFound
+		} else if errors.Is(err, projectErrors.ErrForbidden) {
+			return fiber.ErrForbidden
 		} else if errors.Is(err, projectErrors.ErrBadName) {
 			return fiber.NewError(fiber.StatusBadRequest, "bad file name")
 		} else if err != nil {
 			return fiber.ErrInternalServerError
 		}
+		s.recordFileUpdate(c, uint(commandId), uint(fileId), oldFile)
 		return nil
 	}
 }
@@ -150,14 +164,18 @@ func (s *Server) patchFile() fiber.Handler {
 		if err != nil {
 			return err
 		}
-		err = s.files.PatchFile(uint(commandId), uint(fileId), &file)
+		oldFile, _ := s.files.GetFile(nil, uint(commandId), uint(fileId))
+		err = s.files.PatchFile(currentUser(c), uint(commandId), uint(fileId), &file)
 		if errors.Is(err, projectErrors.ErrNotFound) {
 			return fiber.ErrNotFound
+		} else if errors.Is(err, projectErrors.ErrForbidden) {
+			return fiber.ErrForbidden
 		} else if errors.Is(err, projectErrors.ErrBadName) {
 			return fiber.NewError(fiber.StatusBadRequest, "bad file name")
 		} else if err != nil {
 			return fiber.ErrInternalServerError
 		}
+		s.recordFileUpdate(c, uint(commandId), uint(fileId), oldFile)
 		return nil
 	}
 }
@@ -172,12 +190,16 @@ func (s *Server) deleteFile() fiber.Handler {
 		if err != nil || fileId < 0 {
 			return fiber.NewError(fiber.StatusBadRequest, "invalid file id")
 		}
-		err = s.files.DeleteFile(uint(commandId), uint(fileId))
+		oldFile, _ := s.files.GetFile(nil, uint(commandId), uint(fileId))
+		err = s.files.DeleteFile(currentUser(c), uint(commandId), uint(fileId))
 		if errors.Is(err, projectErrors.ErrNotFound) {
 			return fiber.ErrNotFound
+		} else if errors.Is(err, projectErrors.ErrForbidden) {
+			return fiber.ErrForbidden
 		} else if err != nil {
 			return fiber.ErrInternalServerError
 		}
+		s.recordAudit(c, entities.AuditFileDelete, fileTarget(uint(commandId), uint(fileId)), oldFile, nil)
 		return nil
 	}
 }
@@ -192,8 +214,12 @@ func (s *Server) downloadFile() fiber.Handler {
 		if err != nil || fileId < 0 {
 			return fiber.NewError(fiber.StatusBadRequest, "invalid file id")
 		}
-		fileData, file, err := s.files.DownloadFile(uint(commandId), uint(fileId))
-		if err != nil {
+		fileData, file, err := s.files.DownloadFile(currentUser(c), uint(commandId), uint(fileId))
+		if errors.Is(err, projectErrors.ErrNotFound) {
+			return fiber.ErrNotFound
+		} else if errors.Is(err, projectErrors.ErrForbidden) {
+			return fiber.ErrForbidden
+		} else if err != nil {
 			return fiber.ErrInternalServerError
 		}
 		extension := strings.Split(fileData.Name, ".")[0]
@@ -209,8 +235,10 @@ func (s *Server) downloadFile() fiber.Handler {
 
 func (s *Server) downloadAllFiles() fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		archive, err := s.files.DownloadAllFilesInArchive()
-		if err != nil {
+		archive, err := s.files.DownloadAllFilesInArchive(currentUser(c))
+		if errors.Is(err, projectErrors.ErrForbidden) {
+			return fiber.ErrForbidden
+		} else if err != nil {
 			log.Error(err)
 			return fiber.ErrInternalServerError
 		}
@@ -225,8 +253,10 @@ func (s *Server) downloadCommandFiles() fiber.Handler {
 		if err != nil || commandId < 0 {
 			return fiber.NewError(fiber.StatusBadRequest, "invalid command id")
 		}
-		archive, err := s.files.DownloadCommandFilesInArchive(uint(commandId))
-		if err != nil {
+		archive, err := s.files.DownloadCommandFilesInArchive(currentUser(c), uint(commandId))
+		if errors.Is(err, projectErrors.ErrForbidden) {
+			return fiber.ErrForbidden
+		} else if err != nil {
 			log.Error(err)
 			return fiber.ErrInternalServerError
 		}
@@ -262,10 +292,23 @@ func (s *Server) importFiles() fiber.Handler {
 		if err != nil {
 			return fiber.ErrInternalServerError
 		}
-		err = s.files.ImportAllFilesFromZipArchive(bytes)
-		if err != nil {
+		err = s.files.ImportAllFilesFromZipArchive(currentUser(c), bytes)
+		if errors.Is(err, projectErrors.ErrForbidden) {
+			return fiber.ErrForbidden
+		} else if err != nil {
 			return err
 		}
+		s.recordAudit(c, entities.AuditFilesImport, "", nil, map[string]any{"archive": files[0].Filename, "size": len(bytes)})
 		return nil
 	}
 }
+
+// recordFileUpdate save changed fields of file to audit log, old file is read before update
+func (s *Server) recordFileUpdate(c *fiber.Ctx, commandId, fileId uint, oldFile *entities.EmbeddedFile) {
+	newFile, err := s.files.GetFile(nil, commandId, fileId)
+	if err != nil {
+		log.Warn("Error getting file for audit: ", err)
+		return
+	}
+	s.recordAudit(c, entities.AuditFileUpdate, fileTarget(commandId, fileId), oldFile, newFile)
+}
@@ -0,0 +1,82 @@
+package webserver
+
+import (
+	"errors"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+)
+
+type grantRequestStruct struct {
+	Level entities.AccessLevel `json:"level"`
+}
+
+func (s *Server) getCommandGrants() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		commandId, err := c.ParamsInt("command_id")
+		if err != nil || commandId < 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid command id")
+		}
+		grants, err := s.access.GetCommandGrants(currentUser(c), uint(commandId))
+		if errors.Is(err, projectErrors.ErrForbidden) {
+			return fiber.ErrForbidden
+		} else if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		return c.JSON(grants)
+	}
+}
+
+// putCommandGrant set access level of user to command, it replaces level given by role of user
+func (s *Server) putCommandGrant() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		commandId, err := c.ParamsInt("command_id")
+		if err != nil || commandId < 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid command id")
+		}
+		userId, err := c.ParamsInt("user_id")
+		if err != nil || userId < 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid user id")
+		}
+		var request grantRequestStruct
+		if err := c.BodyParser(&request); err != nil {
+			return fiber.ErrBadRequest
+		}
+		grant := &entities.CommandGrant{CommandID: uint(commandId), UserID: uint(userId), Level: request.Level}
+		err = s.access.SetCommandGrant(currentUser(c), grant)
+		if errors.Is(err, projectErrors.ErrForbidden) {
+			return fiber.ErrForbidden
+		} else if errors.Is(err, projectErrors.ErrNotFound) {
+			return fiber.ErrNotFound
+		} else if errors.Is(err, projectErrors.ErrBadAccessLevel) {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		} else if err != nil {
+			log.Warn("Error setting grant: ", err)
+			return fiber.ErrInternalServerError
+		}
+		return nil
+	}
+}
+
+func (s *Server) deleteCommandGrant() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		commandId, err := c.ParamsInt("command_id")
+		if err != nil || commandId < 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid command id")
+		}
+		userId, err := c.ParamsInt("user_id")
+		if err != nil || userId < 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid user id")
+		}
+		err = s.access.DeleteCommandGrant(currentUser(c), uint(commandId), uint(userId))
+		if errors.Is(err, projectErrors.ErrForbidden) {
+			return fiber.ErrForbidden
+		} else if errors.Is(err, projectErrors.ErrNotFound) {
+			return fiber.ErrNotFound
+		} else if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		return nil
+	}
+}
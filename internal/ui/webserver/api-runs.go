@@ -0,0 +1,312 @@
+package webserver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"strings"
+	"time"
+)
+
+type runRequestStruct struct {
+	Options entities.TerminalOptions `json:"options"`
+	Stdin   *string                  `json:"stdin,omitempty"` // only for command in pipe mode
+}
+
+type signalRequestStruct struct {
+	Signal entities.Signal `json:"signal"`
+}
+
+type runResponseStruct struct {
+	Run    *entities.Run        `json:"run"`
+	Exit   *entities.ExitStatus `json:"exit,omitempty"`
+	Output *string              `json:"output,omitempty"`
+	Stdout *string              `json:"stdout,omitempty"` // only for command in pipe mode
+	Stderr *string              `json:"stderr,omitempty"`
+}
+
+// postCommandRun start command without terminal client.
+// Json body has terminal options and parameters, other body is piped to stdin of command in pipe mode.
+// With ?wait=true waits up to ?timeout= for command to finish and returns its exit status and output,
+// otherwise (or on timeout) returns 202 with run to poll.
+func (s *Server) postCommandRun() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		commandId, err := c.ParamsInt("command_id")
+		if err != nil || commandId < 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid command id")
+		}
+		timeout, err := time.ParseDuration(c.Query("timeout", "30s"))
+		if err != nil || timeout <= 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid timeout")
+		}
+		request := runRequestStruct{Options: entities.TerminalOptions{Cols: 80, Rows: 24}}
+		if len(c.Body()) != 0 && c.Is("json") {
+			if err := c.BodyParser(&request); err != nil {
+				return fiber.ErrBadRequest
+			}
+			if request.Stdin != nil {
+				request.Options.Stdin = strings.NewReader(*request.Stdin)
+			}
+		} else if len(c.Body()) != 0 {
+			// Body is reused by fasthttp after handler returned, command can read stdin later
+			request.Options.Stdin = bytes.NewReader(bytes.Clone(c.Body()))
+		}
+
+		user := currentUser(c)
+		run, err := s.runner.StartCommand(user, uint(commandId), c.IP(), request.Options)
+		if errors.Is(err, projectErrors.ErrNotFound) {
+			return fiber.ErrNotFound
+		} else if errors.Is(err, projectErrors.ErrForbidden) {
+			return fiber.ErrForbidden
+		} else if errors.Is(err, projectErrors.ErrEmptyCommand) {
+			return fiber.NewError(fiber.StatusBadRequest, "empty command")
+		} else if errors.Is(err, projectErrors.ErrBadParameter) || errors.Is(err, projectErrors.ErrBadInterpreter) ||
+			errors.Is(err, projectErrors.ErrStdinNotPiped) {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		} else if errors.Is(err, projectErrors.ErrEnvFile) || errors.Is(err, projectErrors.ErrSecretNotFound) {
+			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+		} else if err != nil {
+			log.Warn("Error while stating command: ", err)
+			return fiber.ErrInternalServerError
+		}
+		s.recordRun(user, c.IP(), run)
+		if !c.QueryBool("wait") {
+			return c.Status(fiber.StatusAccepted).JSON(runResponseStruct{Run: run})
+		}
+
+		ctx, cancel := context.WithTimeout(c.Context(), timeout)
+		defer cancel()
+		exitStatus, err := s.runner.WaitSession(ctx, user, run.SessionID)
+		if errors.Is(err, context.DeadlineExceeded) {
+			return c.Status(fiber.StatusAccepted).JSON(runResponseStruct{Run: run})
+		} else if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		run, err = s.runs.GetRun(run.ID)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		output, err := s.runs.GetRunOutput(run.ID)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		outputString := string(output)
+		response := runResponseStruct{Run: run, Exit: exitStatus, Output: &outputString}
+		if run.ExecutionMode == entities.ExecutionModePipe {
+			stdout, err := s.runs.GetRunSourceOutput(run.ID, entities.OutputStdout)
+			if err != nil {
+				return fiber.ErrInternalServerError
+			}
+			stderr, err := s.runs.GetRunSourceOutput(run.ID, entities.OutputStderr)
+			if err != nil {
+				return fiber.ErrInternalServerError
+			}
+			stdoutString, stderrString := string(stdout), string(stderr)
+			response.Stdout, response.Stderr = &stdoutString, &stderrString
+		}
+		return c.JSON(response)
+	}
+}
+
+// getRunWithAccess return run, if user has level of access to its command, otherwise fiber error
+func (s *Server) getRunWithAccess(user *entities.User, runId uint, level entities.AccessLevel) (*entities.Run, error) {
+	run, err := s.runs.GetRun(runId)
+	if errors.Is(err, projectErrors.ErrNotFound) {
+		return nil, fiber.ErrNotFound
+	} else if err != nil {
+		return nil, fiber.ErrInternalServerError
+	}
+	err = s.access.CheckCommand(user, run.CommandID, level)
+	if errors.Is(err, projectErrors.ErrForbidden) {
+		return nil, fiber.ErrForbidden
+	} else if err != nil {
+		return nil, fiber.ErrInternalServerError
+	}
+	return run, nil
+}
+
+func (s *Server) getRun() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		runId, err := c.ParamsInt("run_id")
+		if err != nil || runId < 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid run id")
+		}
+		run, err := s.getRunWithAccess(currentUser(c), uint(runId), entities.AccessView)
+		if err != nil {
+			return err
+		}
+		return c.JSON(run)
+	}
+}
+
+func (s *Server) getCommandRuns() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		commandId, err := c.ParamsInt("command_id")
+		if err != nil || commandId < 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid command id")
+		}
+		err = s.access.CheckCommand(currentUser(c), uint(commandId), entities.AccessView)
+		if errors.Is(err, projectErrors.ErrForbidden) {
+			return fiber.ErrForbidden
+		} else if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		runs, err := s.runs.GetCommandRuns(uint(commandId))
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		return c.JSON(runs)
+	}
+}
+
+func (s *Server) getRunOutput() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		runId, err := c.ParamsInt("run_id")
+		if err != nil || runId < 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid run id")
+		}
+		if _, err := s.getRunWithAccess(currentUser(c), uint(runId), entities.AccessView); err != nil {
+			return err
+		}
+		var output []byte
+		switch source := entities.OutputSource(c.Query("source")); source {
+		case "":
+			output, err = s.runs.GetRunOutput(uint(runId))
+		case entities.OutputStdout, entities.OutputStderr:
+			output, err = s.runs.GetRunSourceOutput(uint(runId), source)
+		default:
+			return fiber.NewError(fiber.StatusBadRequest, "source must be stdout or stderr")
+		}
+		if errors.Is(err, projectErrors.ErrNotFound) {
+			return fiber.ErrNotFound
+		} else if errors.Is(err, projectErrors.ErrNotPiped) {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		} else if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		c.Type("txt", "utf-8")
+		return c.Send(output)
+	}
+}
+
+// getRunRecording send output of run in asciicast v2 format, it can be played by asciinema player
+func (s *Server) getRunRecording() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		runId, err := c.ParamsInt("run_id")
+		if err != nil || runId < 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid run id")
+		}
+		if _, err := s.getRunWithAccess(currentUser(c), uint(runId), entities.AccessView); err != nil {
+			return err
+		}
+		recording, err := s.runs.GetRunRecording(uint(runId))
+		if errors.Is(err, projectErrors.ErrNotFound) {
+			return fiber.ErrNotFound
+		} else if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		c.Set(fiber.HeaderContentType, "application/x-asciicast")
+		c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="run-%d.cast"`, runId))
+		return c.Send(recording)
+	}
+}
+
+// exportFiles is content type and file extension of export formats
+var exportFiles = map[entities.ExportFormat]struct {
+	contentType string
+	extension   string
+}{
+	entities.ExportText:      {"text/plain; charset=utf-8", "txt"},
+	entities.ExportANSI:      {"text/plain; charset=utf-8", "log"},
+	entities.ExportHTML:      {"text/html; charset=utf-8", "html"},
+	entities.ExportJSONLines: {"application/x-ndjson", "jsonl"},
+}
+
+// getRunExport send output of run for download as ?format=text (default), ansi, html or jsonl
+func (s *Server) getRunExport() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		runId, err := c.ParamsInt("run_id")
+		if err != nil || runId < 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid run id")
+		}
+		format := entities.ExportFormat(c.Query("format", string(entities.ExportText)))
+		file, ok := exportFiles[format]
+		if !ok {
+			return fiber.NewError(fiber.StatusBadRequest, projectErrors.ErrBadExportFormat.Error())
+		}
+		if _, err := s.getRunWithAccess(currentUser(c), uint(runId), entities.AccessView); err != nil {
+			return err
+		}
+		data, err := s.runs.ExportRunOutput(uint(runId), format)
+		if errors.Is(err, projectErrors.ErrNotFound) {
+			return fiber.ErrNotFound
+		} else if errors.Is(err, projectErrors.ErrBadExportFormat) {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		} else if err != nil {
+			log.Warn("Error exporting run output: ", err)
+			return fiber.ErrInternalServerError
+		}
+		c.Set(fiber.HeaderContentType, file.contentType)
+		c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="run-%d.%s"`, runId, file.extension))
+		return c.Send(data)
+	}
+}
+
+// postRunSignal send signal to command of running run, for runs started without terminal
+func (s *Server) postRunSignal() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		runId, err := c.ParamsInt("run_id")
+		if err != nil || runId < 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid run id")
+		}
+		request := signalRequestStruct{}
+		if err := c.BodyParser(&request); err != nil {
+			return fiber.ErrBadRequest
+		}
+		run, err := s.getRunWithAccess(currentUser(c), uint(runId), entities.AccessRun)
+		if err != nil {
+			return err
+		}
+		if run.FinishedAt != nil {
+			return fiber.NewError(fiber.StatusConflict, projectErrors.ErrSessionFinished.Error())
+		}
+		err = s.runner.SignalSession(currentUser(c), run.SessionID, "", request.Signal)
+		if errors.Is(err, projectErrors.ErrForbidden) {
+			return fiber.ErrForbidden
+		} else if errors.Is(err, projectErrors.ErrUnsupportedSignal) {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		} else if errors.Is(err, projectErrors.ErrSessionFinished) || errors.Is(err, projectErrors.ErrNotFound) {
+			return fiber.NewError(fiber.StatusConflict, projectErrors.ErrSessionFinished.Error())
+		} else if err != nil {
+			log.Warn("Error sending signal: ", err)
+			return fiber.ErrInternalServerError
+		}
+		s.recordAudit(c, signalAction(request.Signal), runTarget(run.CommandID, run.ID), nil, map[string]any{"signal": request.Signal})
+		return nil
+	}
+}
+
+func (s *Server) getSessions() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.JSON(s.runner.GetSessions(currentUser(c)))
+	}
+}
+
+func (s *Server) getSessionStats() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		stats, err := s.runner.GetSessionStats(currentUser(c), c.Params("session_id"))
+		if errors.Is(err, projectErrors.ErrNotFound) {
+			return fiber.ErrNotFound
+		} else if errors.Is(err, projectErrors.ErrForbidden) {
+			return fiber.ErrForbidden
+		} else if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		return c.JSON(stats)
+	}
+}
@@ -0,0 +1,52 @@
+package webserver
+
+import (
+	"errors"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+)
+
+type secretRequestStruct struct {
+	Value string `json:"value"`
+}
+
+// getSecrets return names of secrets, values are never returned
+func (s *Server) getSecrets() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		secrets, err := s.secrets.GetSecrets()
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		return c.JSON(secrets)
+	}
+}
+
+func (s *Server) putSecret() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var request secretRequestStruct
+		if err := c.BodyParser(&request); err != nil {
+			return fiber.ErrBadRequest
+		}
+		err := s.secrets.SetSecret(c.Params("name"), request.Value)
+		if errors.Is(err, projectErrors.ErrBadEnvVariable) {
+			return fiber.NewError(fiber.StatusBadRequest, "bad secret name")
+		} else if err != nil {
+			log.Warn("Error saving secret: ", err)
+			return fiber.ErrInternalServerError
+		}
+		return nil
+	}
+}
+
+func (s *Server) deleteSecret() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		err := s.secrets.DeleteSecret(c.Params("name"))
+		if errors.Is(err, projectErrors.ErrNotFound) {
+			return fiber.ErrNotFound
+		} else if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		return nil
+	}
+}
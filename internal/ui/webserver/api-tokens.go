@@ -0,0 +1,159 @@
+package webserver
+
+import (
+	"errors"
+	"fmt"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"time"
+)
+
+type apiTokenRequestStruct struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires-at"`
+}
+
+type apiTokenResponseStruct struct {
+	Token    string             `json:"token"` // shown only once, only hash of it is saved
+	ApiToken *entities.ApiToken `json:"api-token"`
+}
+
+// currentApiToken return api token of request, nil for login sessions
+func currentApiToken(c *fiber.Ctx) *entities.ApiToken {
+	apiToken, _ := c.Locals(apiTokenLocalsKey).(*entities.ApiToken)
+	return apiToken
+}
+
+func runCommandScope(commandId uint) string {
+	return fmt.Sprintf("%s%d", entities.ScopeRunCommandPrefix, commandId)
+}
+
+// requireScope pass only requests with login session or with api token with scope, it must be used after requireLogin
+func (s *Server) requireScope(scope string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := s.auth.CheckScope(currentApiToken(c), scope); err != nil {
+			return fiber.NewError(fiber.StatusForbidden, fmt.Sprintf("api token has no scope %s", scope))
+		}
+		return c.Next()
+	}
+}
+
+// requireRunScope is requireScope for run of command from command_id param
+func (s *Server) requireRunScope() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		commandId, err := c.ParamsInt("command_id")
+		if err != nil || commandId < 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid command id")
+		}
+		scope := runCommandScope(uint(commandId))
+		if err := s.auth.CheckScope(currentApiToken(c), scope); err != nil {
+			return fiber.NewError(fiber.StatusForbidden, fmt.Sprintf("api token has no scope %s", scope))
+		}
+		return c.Next()
+	}
+}
+
+// requireRunIdScope is requireScope for run of command of run from run_id param
+func (s *Server) requireRunIdScope() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		apiToken := currentApiToken(c)
+		if apiToken == nil {
+			return c.Next()
+		}
+		runId, err := c.ParamsInt("run_id")
+		if err != nil || runId < 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid run id")
+		}
+		run, err := s.runs.GetRun(uint(runId))
+		if errors.Is(err, projectErrors.ErrNotFound) {
+			return fiber.ErrNotFound
+		} else if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		scope := runCommandScope(run.CommandID)
+		if err := s.auth.CheckScope(apiToken, scope); err != nil {
+			return fiber.NewError(fiber.StatusForbidden, fmt.Sprintf("api token has no scope %s", scope))
+		}
+		return c.Next()
+	}
+}
+
+// requireSessionScope pass requests to session from session_id param,
+// controller needs run scope of command of session and spectator needs read scope
+func (s *Server) requireSessionScope() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		apiToken := currentApiToken(c)
+		if apiToken == nil {
+			return c.Next()
+		}
+		scope := entities.ScopeRead
+		if c.Query("role", string(entities.ViewerController)) != string(entities.ViewerSpectator) {
+			// Unknown session must not fall back to read scope, controller always needs run scope
+			scope = ""
+			sessionId := c.Params("session_id")
+			for _, session := range s.runner.GetSessions(currentUser(c)) {
+				if session.SessionID == sessionId {
+					scope = runCommandScope(session.CommandID)
+					break
+				}
+			}
+			if scope == "" {
+				return fiber.NewError(fiber.StatusNotFound, "session not found")
+			}
+		}
+		if err := s.auth.CheckScope(apiToken, scope); err != nil {
+			return fiber.NewError(fiber.StatusForbidden, fmt.Sprintf("api token has no scope %s", scope))
+		}
+		return c.Next()
+	}
+}
+
+func (s *Server) getApiTokens() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tokens, err := s.auth.GetApiTokens(currentUser(c).ID)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		return c.JSON(tokens)
+	}
+}
+
+// postApiToken create api token of current user, token is returned only in this response
+func (s *Server) postApiToken() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var request apiTokenRequestStruct
+		if err := c.BodyParser(&request); err != nil {
+			return fiber.ErrBadRequest
+		}
+		token, apiToken, err := s.auth.CreateApiToken(currentUser(c).ID, request.Name, request.Scopes, request.ExpiresAt)
+		if errors.Is(err, projectErrors.ErrBadName) {
+			return fiber.NewError(fiber.StatusBadRequest, "bad token name")
+		} else if errors.Is(err, projectErrors.ErrBadScope) || errors.Is(err, projectErrors.ErrBadTokenExpiry) {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		} else if err != nil {
+			log.Warn("Error creating api token: ", err)
+			return fiber.ErrInternalServerError
+		}
+		return c.Status(fiber.StatusCreated).JSON(apiTokenResponseStruct{token, apiToken})
+	}
+}
+
+func (s *Server) deleteApiToken() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tokenId, err := c.ParamsInt("token_id")
+		if err != nil || tokenId < 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid token id")
+		}
+		err = s.auth.RevokeApiToken(currentUser(c).ID, uint(tokenId))
+		if errors.Is(err, projectErrors.ErrNotFound) {
+			return fiber.ErrNotFound
+		} else if err != nil {
+			log.Warn("Error revoking api token: ", err)
+			return fiber.ErrInternalServerError
+		}
+		return nil
+	}
+}
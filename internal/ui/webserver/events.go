@@ -0,0 +1,163 @@
+package webserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"strconv"
+	"time"
+)
+
+// eventsKeepaliveInterval is how often comment is sent to quiet stream, client disconnect is noticed only on write
+const eventsKeepaliveInterval = 15 * time.Second
+
+type outputEventStruct struct {
+	Data    string                `json:"data"`
+	Skipped int64                 `json:"skipped,omitempty"`
+	Source  entities.OutputSource `json:"source,omitempty"` // stdout or stderr for command in pipe mode
+}
+
+// writeEvent write server-sent event, data is marshalled to json, so it is one line. Empty id is not written
+func writeEvent(w *bufio.Writer, event string, id string, data any) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		_, _ = fmt.Fprintf(w, "id: %s\n", id)
+	}
+	_, _ = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, encoded)
+	return w.Flush()
+}
+
+// getRunEvents stream output of run as server-sent events: output chunks with offset as event id, status changes and exit status.
+// Reconnected client sends Last-Event-ID and continues from that offset, while it is kept in session buffer.
+// Run without live session is streamed from its log
+func (s *Server) getRunEvents() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		runId, err := c.ParamsInt("run_id")
+		if err != nil || runId < 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid run id")
+		}
+		offset := int64(-1)
+		if lastEventId := c.Get("Last-Event-ID"); lastEventId != "" {
+			offset, err = strconv.ParseInt(lastEventId, 10, 64)
+			if err != nil || offset < 0 {
+				return fiber.NewError(fiber.StatusBadRequest, "invalid Last-Event-ID")
+			}
+		}
+		user := currentUser(c)
+		run, err := s.getRunWithAccess(user, uint(runId), entities.AccessView)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		stream, err := s.runner.StreamSession(ctx, user, run.SessionID, c.IP(), offset)
+		if errors.Is(err, projectErrors.ErrNotFound) {
+			stream, err = s.runs.StreamRunOutput(run.ID, offset)
+		}
+		if err != nil {
+			cancel()
+			log.Warn("Error while streaming run: ", err)
+			return fiber.ErrInternalServerError
+		}
+
+		c.Set(fiber.HeaderContentType, "text/event-stream")
+		c.Set(fiber.HeaderCacheControl, "no-cache")
+		c.Set(fiber.HeaderConnection, "keep-alive")
+		c.Set("X-Accel-Buffering", "no")
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			// Client disconnect is noticed only on write, so stream keeps connected client until then
+			defer cancel()
+			keepalive := time.NewTicker(eventsKeepaliveInterval)
+			defer keepalive.Stop()
+			var pending []byte // start of UTF-8 character, split between chunks
+			var pendingSource entities.OutputSource
+			var offset int64
+			// Current state goes before buffered output
+			lastStatus := <-stream.Status
+			if lastStatus.State != entities.SessionFinished {
+				if err := writeEvent(w, "status", "", lastStatus); err != nil {
+					return
+				}
+			}
+			for {
+				select {
+				case event, ok := <-stream.Output:
+					if !ok {
+						s.finishRunEvents(w, stream, pending, pendingSource, offset, lastStatus)
+						return
+					}
+					if event.Skipped != 0 {
+						pending = nil
+					}
+					if len(pending) != 0 && event.Source != pendingSource {
+						// Character can't continue in other source, held bytes are sent as is
+						if err := writeEvent(w, "output", strconv.FormatInt(offset, 10), outputEventStruct{Data: string(pending), Source: pendingSource}); err != nil {
+							return
+						}
+						pending = nil
+					}
+					data, rest := utils.SplitIncompleteRune(append(pending, event.Data...))
+					pending, pendingSource = bytes.Clone(rest), event.Source
+					offset = event.Offset
+					if len(data) == 0 {
+						continue
+					}
+					id := strconv.FormatInt(event.Offset-int64(len(rest)), 10)
+					if err := writeEvent(w, "output", id, outputEventStruct{Data: string(data), Skipped: event.Skipped, Source: event.Source}); err != nil {
+						return
+					}
+				case status := <-stream.Status:
+					lastStatus = status
+					// Finished status is sent after the rest of output
+					if status.State == entities.SessionFinished {
+						continue
+					}
+					if err := writeEvent(w, "status", "", status); err != nil {
+						return
+					}
+				case <-keepalive.C:
+					_, _ = w.WriteString(": keepalive\n\n")
+					if err := w.Flush(); err != nil {
+						return
+					}
+				}
+			}
+		})
+		return nil
+	}
+}
+
+// finishRunEvents write the rest of output, finished status and exit status, if command finished
+func (s *Server) finishRunEvents(w *bufio.Writer, stream *entities.OutputStream, pending []byte, pendingSource entities.OutputSource, offset int64, lastStatus entities.SessionStatus) {
+	if len(pending) != 0 {
+		if err := writeEvent(w, "output", strconv.FormatInt(offset, 10), outputEventStruct{Data: string(pending), Source: pendingSource}); err != nil {
+			return
+		}
+	}
+	select {
+	case lastStatus = <-stream.Status:
+	default:
+	}
+	lastStatus.State = entities.SessionFinished
+	if err := writeEvent(w, "status", "", lastStatus); err != nil {
+		return
+	}
+	select {
+	case exitStatus := <-stream.Exit:
+		if err := writeEvent(w, "exit", "", exitStatus); err != nil {
+			log.Debug("Error writing exit event: ", err)
+		}
+	default:
+	}
+}
@@ -3,35 +3,45 @@ package webserver
 import (
 	"context"
 	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	"time"
 )
 
 type Runner interface {
-	RunCommand(ctx context.Context, commandId uint, options entities.TerminalOptions) (*entities.CommandInputOutput, error)
+	RunCommand(ctx context.Context, user *entities.User, commandId uint, triggeredBy string, options entities.TerminalOptions) (*entities.CommandInputOutput, error)
+	AttachSession(ctx context.Context, user *entities.User, sessionId string, name string, role entities.ViewerRole) (*entities.CommandInputOutput, error)
+	StreamSession(ctx context.Context, user *entities.User, sessionId string, name string, offset int64) (*entities.OutputStream, error)
+	GetSessions(user *entities.User) []entities.SessionInfo
+	TerminateSession(user *entities.User, sessionId string) error
+	SignalSession(user *entities.User, sessionId string, viewerId string, signal entities.Signal) error
+	ResizeSession(user *entities.User, sessionId string, viewerId string, cols, rows uint16) error
+	GetSessionStats(user *entities.User, sessionId string) (*entities.SessionStats, error)
+	StartCommand(user *entities.User, commandId uint, triggeredBy string, options entities.TerminalOptions) (*entities.Run, error)
+	WaitSession(ctx context.Context, user *entities.User, sessionId string) (*entities.ExitStatus, error)
 }
 
 type Commands interface {
 	DefaultCommand() *entities.Command
-	AppendCommand(command *entities.Command) error
-	DeleteCommand(commandId uint) error
-	PatchCommand(commandId uint, newCommand *entities.Command) error
-	PutCommand(commandId uint, newCommand *entities.Command) error
-	GetCommandsList() ([]entities.Command, error)
-	GetCommand(commandId uint) (*entities.Command, error)
+	AppendCommand(user *entities.User, command *entities.Command) error
+	DeleteCommand(user *entities.User, commandId uint) error
+	PatchCommand(user *entities.User, commandId uint, newCommand *entities.Command) error
+	PutCommand(user *entities.User, commandId uint, newCommand *entities.Command) error
+	GetCommandsList(user *entities.User) ([]entities.Command, error)
+	GetCommand(user *entities.User, commandId uint) (*entities.Command, error)
 	CommandExists(commandId uint) (bool, error)
 }
 
 type Files interface {
-	AppendFile(commandID uint, fileBytes []byte, data *entities.FileParams) error
-	DeleteFile(commandId, fileId uint) error
-	PatchFile(commandId, fileId uint, newFile *entities.EmbeddedFile) error
-	PutFile(commandId, fileId uint, newFile *entities.EmbeddedFile) error
-	GetFile(commandId, fileId uint) (*entities.EmbeddedFile, error)
-	GetCommandFiles(commandId uint) ([]entities.EmbeddedFile, error)
-	GetAllFilesList() ([]entities.EmbeddedFile, error)
-	DownloadFile(commandId, fileId uint) (*entities.EmbeddedFile, []byte, error)
-	DownloadCommandFilesInArchive(commandId uint) ([]byte, error)
-	DownloadAllFilesInArchive() ([]byte, error)
-	ImportAllFilesFromZipArchive(data []byte) error
+	AppendFile(user *entities.User, commandID uint, fileBytes []byte, data *entities.FileParams) (*entities.EmbeddedFile, error)
+	DeleteFile(user *entities.User, commandId, fileId uint) error
+	PatchFile(user *entities.User, commandId, fileId uint, newFile *entities.EmbeddedFile) error
+	PutFile(user *entities.User, commandId, fileId uint, newFile *entities.EmbeddedFile) error
+	GetFile(user *entities.User, commandId, fileId uint) (*entities.EmbeddedFile, error)
+	GetCommandFiles(user *entities.User, commandId uint) ([]entities.EmbeddedFile, error)
+	GetAllFilesList(user *entities.User) ([]entities.EmbeddedFile, error)
+	DownloadFile(user *entities.User, commandId, fileId uint) (*entities.EmbeddedFile, []byte, error)
+	DownloadCommandFilesInArchive(user *entities.User, commandId uint) ([]byte, error)
+	DownloadAllFilesInArchive(user *entities.User) ([]byte, error)
+	ImportAllFilesFromZipArchive(user *entities.User, data []byte) error
 }
 
 type UserConfig interface {
@@ -39,3 +49,55 @@ type UserConfig interface {
 	GetUserConfig() (*entities.UserConfig, error)
 	SetUserConfig(newConfig *entities.UserConfig) error
 }
+
+type Runs interface {
+	GetRun(runId uint) (*entities.Run, error)
+	GetCommandRuns(commandId uint) ([]entities.Run, error)
+	GetRunOutput(runId uint) ([]byte, error)
+	GetRunSourceOutput(runId uint, source entities.OutputSource) ([]byte, error)
+	GetRunRecording(runId uint) ([]byte, error)
+	ExportRunOutput(runId uint, format entities.ExportFormat) ([]byte, error)
+	StreamRunOutput(runId uint, offset int64) (*entities.OutputStream, error)
+	PlayRun(ctx context.Context, runId uint, speed float64) (*entities.RunPlayback, error)
+}
+
+type Environment interface {
+	GetGlobalEnv() (map[string]string, error)
+	SetGlobalEnv(env map[string]string) error
+}
+
+type Secrets interface {
+	GetSecrets() ([]entities.Secret, error)
+	SetSecret(name string, value string) error
+	DeleteSecret(name string) error
+}
+
+type Auth interface {
+	Login(username string, password string, ip string) (string, *entities.LoginSession, error)
+	Logout(token string) error
+	Authenticate(token string) (*entities.User, error)
+	CreateUser(username string, password string, role entities.UserRole) (*entities.User, error)
+	GetUsers() ([]entities.User, error)
+	DeleteUser(userId uint) error
+	SetUserRole(userId uint, role entities.UserRole) error
+	ChangePassword(userId uint, oldPassword string, newPassword string) error
+	CreateApiToken(userId uint, name string, scopes []string, expiresAt *time.Time) (string, *entities.ApiToken, error)
+	GetApiTokens(userId uint) ([]entities.ApiToken, error)
+	RevokeApiToken(userId uint, tokenId uint) error
+	AuthenticateApiToken(token string) (*entities.ApiToken, error)
+	CheckScope(apiToken *entities.ApiToken, scope string) error
+}
+
+type Access interface {
+	CheckRole(user *entities.User, role entities.UserRole) error
+	CheckCommand(user *entities.User, commandId uint, level entities.AccessLevel) error
+	GetCommandGrants(user *entities.User, commandId uint) ([]entities.CommandGrant, error)
+	SetCommandGrant(user *entities.User, grant *entities.CommandGrant) error
+	DeleteCommandGrant(user *entities.User, commandId, userId uint) error
+}
+
+type Audit interface {
+	Record(entry *entities.AuditEntry, old, new any) error
+	GetEntries(filter entities.AuditFilter) ([]entities.AuditEntry, error)
+	Verify() (*entities.AuditVerification, error)
+}
@@ -2,6 +2,7 @@ package webserver
 
 import (
 	"fmt"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
 	"github.com/gofiber/fiber/v2/middleware/cache"
 	"path/filepath"
 	"strings"
@@ -14,19 +15,28 @@ import (
 )
 
 type Server struct {
-	rootDir                string
-	port                   int
-	usingConsole           string
-	maxFileSize            int64
-	websocketWriteInterval time.Duration
-	commands               Commands
-	files                  Files
-	userconfig             UserConfig
-	runner                 Runner
-	fiberApp               *fiber.App
+	rootDir      string
+	port         int
+	usingConsole string
+	maxFileSize  int64
+	pingInterval time.Duration // websocket ping interval, 0 for no pings
+	pingTimeout  time.Duration // connection closed, if nothing received for ping interval and timeout
+	secureCookie bool          // login cookie is sent only over https
+	frozen       bool          // commands and files can not be changed
+	commands     Commands
+	files        Files
+	userconfig   UserConfig
+	runner       Runner
+	runs         Runs
+	environment  Environment
+	secrets      Secrets
+	auth         Auth
+	access       Access
+	audit        Audit
+	fiberApp     *fiber.App
 }
 
-func New(rootDir string, port int, usingConsole string, maxFileSize int64, websocketWriteInterval time.Duration, commandsService Commands, filesService Files, userconfigService UserConfig, runner Runner) *Server {
+func New(rootDir string, port int, usingConsole string, maxFileSize int64, pingInterval time.Duration, pingTimeout time.Duration, secureCookie bool, frozen bool, commandsService Commands, filesService Files, userconfigService UserConfig, runner Runner, runsService Runs, environmentService Environment, secretsService Secrets, authService Auth, accessService Access, auditService Audit) *Server {
 	fiberApp := fiber.New()
 	fiberApp.Use(recover.New())
 	fiberApp.Use(logger.New())
@@ -35,11 +45,20 @@ func New(rootDir string, port int, usingConsole string, maxFileSize int64, webso
 		port,
 		usingConsole,
 		maxFileSize,
-		websocketWriteInterval,
+		pingInterval,
+		pingTimeout,
+		secureCookie,
+		frozen,
 		commandsService,
 		filesService,
 		userconfigService,
 		runner,
+		runsService,
+		environmentService,
+		secretsService,
+		authService,
+		accessService,
+		auditService,
 		fiberApp,
 	}
 	s.bindEndpoints()
@@ -56,37 +75,81 @@ func (s *Server) bindEndpoints() {
 			CacheControl: true,
 		}))
 	web.Get("/", s.getIndex())
+	web.Get("/login", s.getLogin())
 	web.Static("/static", filepath.Join(s.rootDir, "/web/static"))
 
 	api := s.fiberApp.Group("/api")
 	v1 := api.Group("/v1")
 
-	v1.Post("/commands", s.postCommand())
-	v1.Get("/commands", s.getCommands())
-	v1.Get("/commands/:command_id<min(0)>", s.getCommand())
-	v1.Patch("/commands/:command_id<min(0)>", s.patchCommand())
-	v1.Put("/commands/:command_id<min(0)>", s.putCommand())
-	v1.Delete("/commands/:command_id<min(0)>", s.deleteCommand())
+	v1.Post("/auth/login", s.login())
+	v1.Post("/auth/logout", s.logout())
+	// Every route below, websockets too, needs logged in user or api token with scope of route
+	v1.Use(s.requireLogin())
+	v1.Get("/auth/me", s.requireScope(entities.ScopeRead), s.getMe())
+	v1.Put("/auth/password", s.requireScope(entities.ScopeAll), s.changePassword())
+	v1.Get("/users", s.requireScope(entities.ScopeAdmin), s.requireRole(entities.RoleAdmin), s.getUsers())
+	v1.Post("/users", s.requireScope(entities.ScopeAdmin), s.requireRole(entities.RoleAdmin), s.postUser())
+	v1.Patch("/users/:user_id<min(0)>", s.requireScope(entities.ScopeAdmin), s.requireRole(entities.RoleAdmin), s.patchUser())
+	v1.Delete("/users/:user_id<min(0)>", s.requireScope(entities.ScopeAdmin), s.requireRole(entities.RoleAdmin), s.deleteUser())
 
-	v1.Get("/commands/:command_id/files", s.getCommandFilesList())
-	v1.Post("/commands/:command_id<min(0)>/files", s.postFiles())
+	// Tokens can not manage tokens, unless they have every scope
+	v1.Get("/tokens", s.requireScope(entities.ScopeAll), s.getApiTokens())
+	v1.Post("/tokens", s.requireScope(entities.ScopeAll), s.postApiToken())
+	v1.Delete("/tokens/:token_id<min(0)>", s.requireScope(entities.ScopeAll), s.deleteApiToken())
 
-	v1.Get("/commands/:command_id<min(0)>/files/:file_id<min(0)>", s.getFile())
-	v1.Put("/commands/:command_id<min(0)>/files/:file_id<min(0)>", s.putFile())
-	v1.Patch("/commands/:command_id<min(0)>/files/:file_id<min(0)>", s.patchFile())
-	v1.Delete("/commands/:command_id<min(0)>/files/:file_id<min(0)>", s.deleteFile())
-	v1.Get("/commands/:command_id<min(0)>/files/:file_id<min(0)>/download", s.downloadFile())
-	v1.Get("/commands/:command_id<min(0)>/files/download", s.downloadCommandFiles())
+	v1.Post("/commands", s.requireScope(entities.ScopeCommandsWrite), s.rejectFrozen(), s.postCommand())
+	v1.Get("/commands", s.requireScope(entities.ScopeRead), s.getCommands())
+	v1.Get("/commands/:command_id<min(0)>", s.requireScope(entities.ScopeRead), s.getCommand())
+	v1.Patch("/commands/:command_id<min(0)>", s.requireScope(entities.ScopeCommandsWrite), s.rejectFrozen(), s.patchCommand())
+	v1.Put("/commands/:command_id<min(0)>", s.requireScope(entities.ScopeCommandsWrite), s.rejectFrozen(), s.putCommand())
+	v1.Delete("/commands/:command_id<min(0)>", s.requireScope(entities.ScopeCommandsWrite), s.rejectFrozen(), s.deleteCommand())
 
-	v1.Get("/json-config", s.getJsonConfig())
-	v1.Post("/json-config", s.editJsonConfig())
-	v1.Put("/json-config", s.editJsonConfig())
-	v1.Patch("/json-config", s.editJsonConfig())
+	v1.Get("/commands/:command_id<min(0)>/grants", s.requireScope(entities.ScopeRead), s.getCommandGrants())
+	v1.Put("/commands/:command_id<min(0)>/grants/:user_id<min(0)>", s.requireScope(entities.ScopeAdmin), s.putCommandGrant())
+	v1.Delete("/commands/:command_id<min(0)>/grants/:user_id<min(0)>", s.requireScope(entities.ScopeAdmin), s.deleteCommandGrant())
 
-	v1.Get("/files/download", s.downloadAllFiles())
-	v1.Post("/files/upload", s.importFiles())
+	v1.Get("/commands/:command_id/files", s.requireScope(entities.ScopeRead), s.getCommandFilesList())
+	v1.Post("/commands/:command_id<min(0)>/files", s.requireScope(entities.ScopeFilesWrite), s.rejectFrozen(), s.postFiles())
 
-	v1.Get("/console-using", s.consoleUsing())
+	v1.Get("/commands/:command_id<min(0)>/files/:file_id<min(0)>", s.requireScope(entities.ScopeRead), s.getFile())
+	v1.Put("/commands/:command_id<min(0)>/files/:file_id<min(0)>", s.requireScope(entities.ScopeFilesWrite), s.rejectFrozen(), s.putFile())
+	v1.Patch("/commands/:command_id<min(0)>/files/:file_id<min(0)>", s.requireScope(entities.ScopeFilesWrite), s.rejectFrozen(), s.patchFile())
+	v1.Delete("/commands/:command_id<min(0)>/files/:file_id<min(0)>", s.requireScope(entities.ScopeFilesWrite), s.rejectFrozen(), s.deleteFile())
+	v1.Get("/commands/:command_id<min(0)>/files/:file_id<min(0)>/download", s.requireScope(entities.ScopeRead), s.downloadFile())
+	v1.Get("/commands/:command_id<min(0)>/files/download", s.requireScope(entities.ScopeRead), s.downloadCommandFiles())
+
+	v1.Post("/commands/:command_id<min(0)>/run", s.requireRunScope(), s.postCommandRun())
+	v1.Get("/commands/:command_id<min(0)>/runs", s.requireScope(entities.ScopeRead), s.getCommandRuns())
+	v1.Get("/runs/:run_id<min(0)>", s.requireScope(entities.ScopeRead), s.getRun())
+	v1.Get("/runs/:run_id<min(0)>/output", s.requireScope(entities.ScopeRead), s.getRunOutput())
+	v1.Get("/runs/:run_id<min(0)>/recording", s.requireScope(entities.ScopeRead), s.getRunRecording())
+	v1.Get("/runs/:run_id<min(0)>/export", s.requireScope(entities.ScopeRead), s.getRunExport())
+	v1.Get("/runs/:run_id<min(0)>/events", s.requireScope(entities.ScopeRead), s.getRunEvents())
+	v1.Post("/runs/:run_id<min(0)>/signal", s.requireRunIdScope(), s.postRunSignal())
+	v1.Get("/sessions", s.requireScope(entities.ScopeRead), s.getSessions())
+	v1.Get("/sessions/:session_id/stats", s.requireScope(entities.ScopeRead), s.getSessionStats())
+
+	// Global env, secrets and whole config affect every command, so they are not covered by grants
+	v1.Get("/env", s.requireScope(entities.ScopeRead), s.requireRole(entities.RoleEditor), s.getEnv())
+	v1.Put("/env", s.requireScope(entities.ScopeAdmin), s.requireRole(entities.RoleAdmin), s.putEnv())
+
+	v1.Get("/secrets", s.requireScope(entities.ScopeRead), s.requireRole(entities.RoleEditor), s.getSecrets())
+	v1.Put("/secrets/:name", s.requireScope(entities.ScopeAdmin), s.requireRole(entities.RoleAdmin), s.putSecret())
+	v1.Delete("/secrets/:name", s.requireScope(entities.ScopeAdmin), s.requireRole(entities.RoleAdmin), s.deleteSecret())
+
+	v1.Get("/json-config", s.requireScope(entities.ScopeRead), s.requireRole(entities.RoleAdmin), s.getJsonConfig())
+	v1.Post("/json-config", s.requireScope(entities.ScopeAdmin), s.requireRole(entities.RoleAdmin), s.rejectFrozen(), s.editJsonConfig())
+	v1.Put("/json-config", s.requireScope(entities.ScopeAdmin), s.requireRole(entities.RoleAdmin), s.rejectFrozen(), s.editJsonConfig())
+	v1.Patch("/json-config", s.requireScope(entities.ScopeAdmin), s.requireRole(entities.RoleAdmin), s.rejectFrozen(), s.editJsonConfig())
+
+	v1.Get("/audit", s.requireScope(entities.ScopeAdmin), s.requireRole(entities.RoleAdmin), s.getAudit())
+	v1.Get("/audit/verify", s.requireScope(entities.ScopeAdmin), s.requireRole(entities.RoleAdmin), s.getAuditVerification())
+
+	v1.Get("/files/download", s.requireScope(entities.ScopeRead), s.downloadAllFiles())
+	v1.Post("/files/upload", s.requireScope(entities.ScopeFilesWrite), s.rejectFrozen(), s.importFiles())
+
+	v1.Get("/console-using", s.requireScope(entities.ScopeRead), s.consoleUsing())
+	v1.Get("/config-frozen", s.requireScope(entities.ScopeRead), s.configFrozen())
 
 	websockets := v1.Group("/ws", func(c *fiber.Ctx) error {
 		if !websocket.IsWebSocketUpgrade(c) {
@@ -94,7 +157,9 @@ func (s *Server) bindEndpoints() {
 		}
 		return c.Next()
 	})
-	websockets.Get("/commands/:command_id<min(0)>", s.runCommandWebsocket())
+	websockets.Get("/commands/:command_id<min(0)>", s.requireRunScope(), s.runCommandWebsocket())
+	websockets.Get("/sessions/:session_id", s.requireSessionScope(), s.reattachSessionWebsocket())
+	websockets.Get("/runs/:run_id<min(0)>/playback", s.requireScope(entities.ScopeRead), s.playRunWebsocket())
 }
 
 func (s *Server) Run() error {
@@ -11,3 +11,9 @@ func (s *Server) getIndex() fiber.Handler {
 		return c.SendFile(filepath.Join(s.rootDir, "/web/templates/index.html"))
 	}
 }
+
+func (s *Server) getLogin() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.SendFile(filepath.Join(s.rootDir, "/web/templates/login.html"))
+	}
+}
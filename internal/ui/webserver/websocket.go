@@ -10,7 +10,9 @@ import (
 	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/log"
+	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -19,11 +21,31 @@ type inputMessageStruct struct {
 	MessageType string                   `json:"message-type"`
 	Data        string                   `json:"data"`
 	Options     entities.TerminalOptions `json:"options"`
+	Signal      entities.Signal          `json:"signal"`
+	Speed       float64                  `json:"speed"`
 }
 
 type outMessageStruct struct {
-	MessageType string `json:"message-type"`
-	Data        string `json:"data"`
+	MessageType string               `json:"message-type"`
+	Data        string               `json:"data"`
+	Exit        *entities.ExitStatus `json:"exit,omitempty"`
+	Viewer      *entities.Viewer     `json:"viewer,omitempty"`
+	Viewers     []entities.Viewer    `json:"viewers,omitempty"`
+}
+
+// websocketUser return user, that was logged in by requireLogin before upgrade
+func websocketUser(c *websocket.Conn) *entities.User {
+	user, _ := c.Locals(userLocalsKey).(*entities.User)
+	return user
+}
+
+// formatCloseMessage format close message with text cut to fit in control frame
+func formatCloseMessage(closeCode int, text string) []byte {
+	const maxCloseTextSize = 123
+	if len(text) > maxCloseTextSize {
+		text = strings.ToValidUTF8(text[:maxCloseTextSize], "")
+	}
+	return websocket.FormatCloseMessage(closeCode, text)
 }
 
 func (s *Server) runCommandWebsocket() fiber.Handler {
@@ -76,8 +98,15 @@ func (s *Server) runCommandWebsocket() fiber.Handler {
 		ctx, cancel := context.WithCancel(context.Background())
 		defer cancel()
 
-		runningCommand, err := s.runner.RunCommand(ctx, uint(commandId), inputData.Options)
+		runningCommand, err := s.runner.RunCommand(ctx, websocketUser(c), uint(commandId), c.IP(), inputData.Options)
 		if err != nil {
+			if errors.Is(err, projectErrors.ErrForbidden) {
+				data := websocket.FormatCloseMessage(4003, "forbidden")
+				if err = c.WriteMessage(websocket.CloseMessage, data); err != nil {
+					log.Warn("Error writing close message: ", err)
+				}
+				return
+			}
 			if errors.Is(err, projectErrors.ErrEmptyCommand) {
 				data := websocket.FormatCloseMessage(1002, "empty command")
 				if err = c.WriteMessage(websocket.CloseMessage, data); err != nil {
@@ -85,6 +114,20 @@ func (s *Server) runCommandWebsocket() fiber.Handler {
 				}
 				return
 			}
+			if errors.Is(err, projectErrors.ErrBadParameter) || errors.Is(err, projectErrors.ErrBadInterpreter) {
+				data := formatCloseMessage(1003, err.Error())
+				if err = c.WriteMessage(websocket.CloseMessage, data); err != nil {
+					log.Warn("Error writing close message: ", err)
+				}
+				return
+			}
+			if errors.Is(err, projectErrors.ErrEnvFile) || errors.Is(err, projectErrors.ErrSecretNotFound) {
+				data := formatCloseMessage(1011, err.Error())
+				if err = c.WriteMessage(websocket.CloseMessage, data); err != nil {
+					log.Warn("Error writing close message: ", err)
+				}
+				return
+			}
 			log.Warn("Error while stating command: ", err)
 			data := websocket.FormatCloseMessage(1011, "unexpected error while stating command")
 			if err = c.WriteMessage(websocket.CloseMessage, data); err != nil {
@@ -92,102 +135,409 @@ func (s *Server) runCommandWebsocket() fiber.Handler {
 			}
 			return
 		}
+		if run, err := s.sessionRun(runningCommand.SessionID); err == nil {
+			s.recordRun(websocketUser(c), c.IP(), run)
+		} else {
+			log.Warn("Error getting run for audit: ", err)
+		}
 
-		outMutex := &sync.Mutex{}
-		websocketWriteMutex := &sync.Mutex{}
-		outBuffer := ""
-		outBufferEOF := false
+		s.streamTerminal(c, ctx, cancel, runningCommand)
+	})
+}
 
-		// Get output
-		go func() {
-			for out := range runningCommand.Output {
-				outMutex.Lock()
-				outBuffer += out
-				outMutex.Unlock()
+func (s *Server) reattachSessionWebsocket() fiber.Handler {
+	return websocket.New(func(c *websocket.Conn) {
+		defer func() {
+			_ = c.Close()
+		}()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		role := entities.ViewerRole(c.Query("role", string(entities.ViewerController)))
+		runningCommand, err := s.runner.AttachSession(ctx, websocketUser(c), c.Params("session_id"), c.IP(), role)
+		if err != nil {
+			if errors.Is(err, projectErrors.ErrNotFound) {
+				data := websocket.FormatCloseMessage(4004, "session not found")
+				if err = c.WriteMessage(websocket.CloseMessage, data); err != nil {
+					log.Warn("Error writing close message: ", err)
+				}
+				return
+			} else if errors.Is(err, projectErrors.ErrForbidden) {
+				data := websocket.FormatCloseMessage(4003, "forbidden")
+				if err = c.WriteMessage(websocket.CloseMessage, data); err != nil {
+					log.Warn("Error writing close message: ", err)
+				}
+				return
+			} else if errors.Is(err, projectErrors.ErrBadViewerRole) {
+				data := formatCloseMessage(1003, err.Error())
+				if err = c.WriteMessage(websocket.CloseMessage, data); err != nil {
+					log.Warn("Error writing close message: ", err)
+				}
+				return
+			}
+			log.Warn("Error while attaching to session: ", err)
+			data := websocket.FormatCloseMessage(1011, "unexpected error while attaching to session")
+			if err = c.WriteMessage(websocket.CloseMessage, data); err != nil {
+				log.Warn("Error writing close message: ", err)
 			}
-			outBufferEOF = true
+			return
+		}
+		s.streamTerminal(c, ctx, cancel, runningCommand)
+	})
+}
+
+// playRunWebsocket replay recorded output of run with speed from ?speed= (1 by default),
+// speed can be changed by playback-speed message. Client input is not accepted
+func (s *Server) playRunWebsocket() fiber.Handler {
+	return websocket.New(func(c *websocket.Conn) {
+		defer func() {
+			_ = c.Close()
 		}()
+		runId, err := strconv.Atoi(c.Params("run_id"))
+		if err != nil || runId < 0 {
+			data := websocket.FormatCloseMessage(1003, "bad run id")
+			if err = c.WriteMessage(websocket.CloseMessage, data); err != nil {
+				log.Warn("Error writing close message", err)
+			}
+			return
+		}
+		speed, err := strconv.ParseFloat(c.Query("speed", "1"), 64)
+		if err != nil {
+			data := formatCloseMessage(1003, projectErrors.ErrBadPlaybackSpeed.Error())
+			if err = c.WriteMessage(websocket.CloseMessage, data); err != nil {
+				log.Warn("Error writing close message", err)
+			}
+			return
+		}
+		if _, err := s.getRunWithAccess(websocketUser(c), uint(runId), entities.AccessView); err != nil {
+			data := websocket.FormatCloseMessage(1011, "unexpected error while getting run")
+			if errors.Is(err, fiber.ErrNotFound) {
+				data = websocket.FormatCloseMessage(4004, "run not found")
+			} else if errors.Is(err, fiber.ErrForbidden) {
+				data = websocket.FormatCloseMessage(4003, "forbidden")
+			}
+			if err = c.WriteMessage(websocket.CloseMessage, data); err != nil {
+				log.Warn("Error writing close message: ", err)
+			}
+			return
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
 
-		// Writer in interval
+		playback, err := s.runs.PlayRun(ctx, uint(runId), speed)
+		if err != nil {
+			if errors.Is(err, projectErrors.ErrNotFound) {
+				data := websocket.FormatCloseMessage(4004, "recording not found")
+				if err = c.WriteMessage(websocket.CloseMessage, data); err != nil {
+					log.Warn("Error writing close message: ", err)
+				}
+				return
+			} else if errors.Is(err, projectErrors.ErrBadPlaybackSpeed) {
+				data := formatCloseMessage(1003, err.Error())
+				if err = c.WriteMessage(websocket.CloseMessage, data); err != nil {
+					log.Warn("Error writing close message: ", err)
+				}
+				return
+			}
+			log.Warn("Error while playing run: ", err)
+			data := websocket.FormatCloseMessage(1011, "unexpected error while playing run")
+			if err = c.WriteMessage(websocket.CloseMessage, data); err != nil {
+				log.Warn("Error writing close message: ", err)
+			}
+			return
+		}
+
+		websocketWriteMutex := &sync.Mutex{}
+		s.startHeartbeat(c, ctx, websocketWriteMutex)
+
+		// Writer, same frames as for running command
 		go func() {
 			defer func() {
-				data := websocket.FormatCloseMessage(1000, "command run finished")
+				data := formatCloseMessage(1000, "playback finished")
 				websocketWriteMutex.Lock()
 				_ = c.WriteMessage(websocket.CloseMessage, data)
 				websocketWriteMutex.Unlock()
 				cancel()
 			}()
-
-			ticker := time.NewTicker(s.websocketWriteInterval)
-			defer ticker.Stop()
-			for {
-				select {
-				case <-ticker.C:
-					if outBuffer == "" {
-						if outBufferEOF {
-							return
-						}
-						continue
-					}
-					outMutex.Lock()
-					data, err := json.Marshal(outMessageStruct{"data", outBuffer})
-					if err != nil {
-						log.Debug(fmt.Errorf("error marshaling message for websocket %w", err))
-						outMutex.Unlock()
-						continue
-					}
-					outBuffer = ""
-					outMutex.Unlock()
-
-					websocketWriteMutex.Lock()
-					err = c.WriteMessage(websocket.TextMessage, data)
-					websocketWriteMutex.Unlock()
-					if err != nil {
-						return
-					}
-				case <-ctx.Done():
+			for data := range playback.Output {
+				websocketWriteMutex.Lock()
+				err := c.WriteMessage(websocket.BinaryMessage, data)
+				websocketWriteMutex.Unlock()
+				if err != nil {
 					return
 				}
 			}
+			if ctx.Err() != nil || playback.Exit == nil {
+				return
+			}
+			data, err := json.Marshal(outMessageStruct{MessageType: "exit", Exit: playback.Exit})
+			if err != nil {
+				log.Debug(fmt.Errorf("error marshaling message for websocket %w", err))
+				return
+			}
+			websocketWriteMutex.Lock()
+			defer websocketWriteMutex.Unlock()
+			if err = c.WriteMessage(websocket.TextMessage, data); err != nil {
+				log.Debug("Error writing exit message: ", err)
+			}
 		}()
 
-		// Input loop
+		// Input loop, only for speed changes, pings and detecting disconnect
 		for {
-			if mt, msg, err = c.ReadMessage(); err != nil {
+			mt, msg, err := c.ReadMessage()
+			if err != nil {
 				return
 			}
-			if mt == websocket.CloseMessage {
-				cancel()
+			s.extendReadDeadline(c)
+			if mt != websocket.TextMessage {
+				continue
+			}
+			inputData := &inputMessageStruct{}
+			if err := json.Unmarshal(msg, inputData); err != nil {
+				continue
+			}
+			if inputData.MessageType == "ping" {
+				if err := s.writePongMessage(c, websocketWriteMutex, inputData.Data); err != nil {
+					return
+				}
+				continue
+			}
+			if inputData.MessageType != "playback-speed" {
+				continue
+			}
+			select {
+			case playback.Speed <- inputData.Speed:
+			case <-ctx.Done():
 				return
 			}
-			if mt != websocket.TextMessage {
-				data := websocket.FormatCloseMessage(1003, "expected TextMessage, not BinaryData")
+		}
+	})
+}
+
+// startHeartbeat ping client every ping interval until ctx is done. Read deadline is set, so reading fails,
+// if client sent neither pong nor message for ping interval and timeout. It closes half-open connections behind proxies
+func (s *Server) startHeartbeat(c *websocket.Conn, ctx context.Context, websocketWriteMutex *sync.Mutex) {
+	if s.pingInterval <= 0 {
+		return
+	}
+	s.extendReadDeadline(c)
+	c.SetPongHandler(func(string) error {
+		s.extendReadDeadline(c)
+		return nil
+	})
+	go func() {
+		ticker := time.NewTicker(s.pingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
 				websocketWriteMutex.Lock()
-				if err = c.WriteMessage(websocket.CloseMessage, data); err != nil {
-					log.Warn("Error writing close message: ", err)
-				}
+				err := c.WriteControl(websocket.PingMessage, nil, time.Now().Add(s.pingTimeout))
 				websocketWriteMutex.Unlock()
+				if err != nil {
+					return
+				}
+			case <-ctx.Done():
 				return
 			}
-			inputData := &inputMessageStruct{}
-			err = json.Unmarshal(msg, inputData)
-			if err != nil {
-				data := websocket.FormatCloseMessage(1003, "bad input json")
+		}
+	}()
+}
+
+// extendReadDeadline must be called after every message read from client with heartbeat
+func (s *Server) extendReadDeadline(c *websocket.Conn) {
+	if s.pingInterval <= 0 {
+		return
+	}
+	if err := c.SetReadDeadline(time.Now().Add(s.pingInterval + s.pingTimeout)); err != nil {
+		log.Debug("Error setting read deadline: ", err)
+	}
+}
+
+// writePongMessage answer client ping message, browsers can't send websocket pings
+func (s *Server) writePongMessage(c *websocket.Conn, websocketWriteMutex *sync.Mutex, data string) error {
+	message, err := json.Marshal(outMessageStruct{MessageType: "pong", Data: data})
+	if err != nil {
+		return err
+	}
+	websocketWriteMutex.Lock()
+	defer websocketWriteMutex.Unlock()
+	return c.WriteMessage(websocket.TextMessage, message)
+}
+
+// streamTerminal send session id and command output to client and pass client input to command.
+// Closing connection with code 4001 by controller terminates the session, any other disconnect only detaches from it.
+func (s *Server) streamTerminal(c *websocket.Conn, ctx context.Context, cancel context.CancelFunc, runningCommand *entities.CommandInputOutput) {
+	var (
+		mt  int
+		msg []byte
+		err error
+	)
+	sessionMessage, err := json.Marshal(outMessageStruct{MessageType: "session", Data: runningCommand.SessionID, Viewer: &runningCommand.Viewer})
+	if err != nil {
+		log.Warn("Error marshaling session message: ", err)
+		return
+	}
+	if err = c.WriteMessage(websocket.TextMessage, sessionMessage); err != nil {
+		return
+	}
+
+	websocketWriteMutex := &sync.Mutex{}
+	s.startHeartbeat(c, ctx, websocketWriteMutex)
+
+	// Writer, output sent as binary frames, control messages as json text frames
+	go func() {
+		closeText := "command run finished"
+		defer func() {
+			data := formatCloseMessage(1000, closeText)
+			websocketWriteMutex.Lock()
+			_ = c.WriteMessage(websocket.CloseMessage, data)
+			websocketWriteMutex.Unlock()
+			cancel()
+		}()
+
+		for {
+			select {
+			case data, ok := <-runningCommand.Output:
+				if !ok {
+					if exitStatus := s.writeExitMessage(c, websocketWriteMutex, runningCommand); exitStatus != nil && exitStatus.StopReason == entities.StopReasonTimeout {
+						closeText = "command timed out"
+					}
+					return
+				}
 				websocketWriteMutex.Lock()
-				if err = c.WriteMessage(websocket.CloseMessage, data); err != nil {
-					log.Warn("Error writing close message: ", err)
+				err := c.WriteMessage(websocket.BinaryMessage, data)
+				websocketWriteMutex.Unlock()
+				if err != nil {
+					return
+				}
+			case warning := <-runningCommand.Warnings:
+				data, err := json.Marshal(outMessageStruct{MessageType: "warning", Data: warning})
+				if err != nil {
+					log.Debug(fmt.Errorf("error marshaling message for websocket %w", err))
+					continue
+				}
+				websocketWriteMutex.Lock()
+				err = c.WriteMessage(websocket.TextMessage, data)
+				websocketWriteMutex.Unlock()
+				if err != nil {
+					return
+				}
+			case viewers := <-runningCommand.Viewers:
+				data, err := json.Marshal(outMessageStruct{MessageType: "viewers", Viewers: viewers})
+				if err != nil {
+					log.Debug(fmt.Errorf("error marshaling message for websocket %w", err))
+					continue
 				}
+				websocketWriteMutex.Lock()
+				err = c.WriteMessage(websocket.TextMessage, data)
 				websocketWriteMutex.Unlock()
+				if err != nil {
+					return
+				}
+			case <-ctx.Done():
 				return
 			}
-			switch inputData.MessageType {
-			case "terminal-input":
-				select {
-				case runningCommand.Input <- inputData.Data:
-				case <-ctx.Done():
-					return
+		}
+	}()
+
+	// Input loop
+	for {
+		if mt, msg, err = c.ReadMessage(); err != nil {
+			if websocket.IsCloseError(err, 4001) && runningCommand.Viewer.Role == entities.ViewerController {
+				if err := s.runner.TerminateSession(websocketUser(c), runningCommand.SessionID); err == nil {
+					s.recordSessionRun(websocketUser(c), c.IP(), runningCommand.SessionID, entities.AuditRunStop, map[string]any{"terminated": true})
+				} else if !errors.Is(err, projectErrors.ErrNotFound) {
+					log.Warn("Error terminating session: ", err)
 				}
+			} else if errors.Is(err, os.ErrDeadlineExceeded) {
+				log.Debug("Client does not answer pings, detaching from session ", runningCommand.SessionID)
 			}
+			return
 		}
-	})
+		s.extendReadDeadline(c)
+		if mt != websocket.TextMessage {
+			data := websocket.FormatCloseMessage(1003, "expected TextMessage, not BinaryData")
+			websocketWriteMutex.Lock()
+			if err = c.WriteMessage(websocket.CloseMessage, data); err != nil {
+				log.Warn("Error writing close message: ", err)
+			}
+			websocketWriteMutex.Unlock()
+			return
+		}
+		inputData := &inputMessageStruct{}
+		err = json.Unmarshal(msg, inputData)
+		if err != nil {
+			data := websocket.FormatCloseMessage(1003, "bad input json")
+			websocketWriteMutex.Lock()
+			if err = c.WriteMessage(websocket.CloseMessage, data); err != nil {
+				log.Warn("Error writing close message: ", err)
+			}
+			websocketWriteMutex.Unlock()
+			return
+		}
+		switch inputData.MessageType {
+		case "ping":
+			if err := s.writePongMessage(c, websocketWriteMutex, inputData.Data); err != nil {
+				return
+			}
+		case "terminal-input":
+			select {
+			case runningCommand.Input <- inputData.Data:
+			case <-ctx.Done():
+				return
+			}
+		case "resize":
+			err := s.runner.ResizeSession(websocketUser(c), runningCommand.SessionID, runningCommand.Viewer.ID, inputData.Options.Cols, inputData.Options.Rows)
+			if errors.Is(err, projectErrors.ErrSessionFinished) || errors.Is(err, projectErrors.ErrNotFound) {
+				continue
+			} else if err != nil {
+				s.writeErrorMessage(c, websocketWriteMutex, err)
+			}
+		case "signal":
+			err := s.runner.SignalSession(websocketUser(c), runningCommand.SessionID, runningCommand.Viewer.ID, inputData.Signal)
+			if errors.Is(err, projectErrors.ErrSessionFinished) || errors.Is(err, projectErrors.ErrNotFound) {
+				continue
+			} else if err != nil {
+				s.writeErrorMessage(c, websocketWriteMutex, err)
+			} else {
+				s.recordSessionRun(websocketUser(c), c.IP(), runningCommand.SessionID, signalAction(inputData.Signal), map[string]any{"signal": inputData.Signal})
+			}
+		}
+	}
+}
+
+// writeErrorMessage send error of client request, that does not break connection
+func (s *Server) writeErrorMessage(c *websocket.Conn, websocketWriteMutex *sync.Mutex, err error) {
+	data, err := json.Marshal(outMessageStruct{MessageType: "error", Data: err.Error()})
+	if err != nil {
+		log.Debug(fmt.Errorf("error marshaling message for websocket %w", err))
+		return
+	}
+	websocketWriteMutex.Lock()
+	defer websocketWriteMutex.Unlock()
+	if err = c.WriteMessage(websocket.TextMessage, data); err != nil {
+		log.Debug("Error writing error message: ", err)
+	}
+}
+
+// writeExitMessage send exit status of command, if command finished. Return sent exit status or nil
+func (s *Server) writeExitMessage(c *websocket.Conn, websocketWriteMutex *sync.Mutex, runningCommand *entities.CommandInputOutput) *entities.ExitStatus {
+	var exitStatus entities.ExitStatus
+	select {
+	case exitStatus = <-runningCommand.Exit:
+	default:
+		return nil
+	}
+	data, err := json.Marshal(outMessageStruct{MessageType: "exit", Exit: &exitStatus})
+	if err != nil {
+		log.Debug(fmt.Errorf("error marshaling message for websocket %w", err))
+		return &exitStatus
+	}
+	websocketWriteMutex.Lock()
+	defer websocketWriteMutex.Unlock()
+	if err = c.WriteMessage(websocket.TextMessage, data); err != nil {
+		log.Debug("Error writing exit message: ", err)
+	}
+	return &exitStatus
 }
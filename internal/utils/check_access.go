@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"strconv"
+	"strings"
+)
+
+func CheckRole(role entities.UserRole) error {
+	switch role {
+	case entities.RoleAdmin, entities.RoleEditor, entities.RoleOperator, entities.RoleViewer:
+		return nil
+	}
+	return projectErrors.ErrBadRole
+}
+
+func CheckAccessLevel(level entities.AccessLevel) error {
+	switch level {
+	case entities.AccessNone, entities.AccessView, entities.AccessRun, entities.AccessEdit:
+		return nil
+	}
+	return projectErrors.ErrBadAccessLevel
+}
+
+func CheckScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return projectErrors.ErrBadScope
+	}
+	for _, scope := range scopes {
+		switch scope {
+		case entities.ScopeAll, entities.ScopeRead, entities.ScopeRunAll, entities.ScopeCommandsWrite, entities.ScopeFilesWrite, entities.ScopeAdmin:
+			continue
+		}
+		commandId, ok := strings.CutPrefix(scope, entities.ScopeRunCommandPrefix)
+		if !ok {
+			return projectErrors.ErrBadScope
+		}
+		if _, err := strconv.ParseUint(commandId, 10, 0); err != nil {
+			return projectErrors.ErrBadScope
+		}
+	}
+	return nil
+}
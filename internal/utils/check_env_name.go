@@ -0,0 +1,31 @@
+package utils
+
+import (
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"strings"
+)
+
+func CheckEnvName(name string) error {
+	if name == "" || strings.ContainsAny(name, "=\x00") {
+		return projectErrors.ErrBadEnvVariable
+	}
+	return nil
+}
+
+func CheckEnv(env map[string]string) error {
+	for name := range env {
+		if err := CheckEnvName(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func CheckEnvNames(names []string) error {
+	for _, name := range names {
+		if err := CheckEnvName(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
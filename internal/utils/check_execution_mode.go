@@ -0,0 +1,14 @@
+package utils
+
+import (
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+)
+
+func CheckExecutionMode(mode string) error {
+	switch mode {
+	case "", entities.ExecutionModeTerminal, entities.ExecutionModePipe:
+		return nil
+	}
+	return projectErrors.ErrBadExecutionMode
+}
@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"fmt"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"regexp"
+	"slices"
+	"strconv"
+)
+
+var parameterNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// CheckParameters validate parameters declaration of command, including their default values
+func CheckParameters(parameters []entities.CommandParameter) error {
+	names := make(map[string]bool, len(parameters))
+	for _, parameter := range parameters {
+		if !parameterNameRegexp.MatchString(parameter.Name) {
+			return fmt.Errorf("%w: invalid name %q", projectErrors.ErrBadParameter, parameter.Name)
+		}
+		if names[parameter.Name] {
+			return fmt.Errorf("%w: duplicated name %q", projectErrors.ErrBadParameter, parameter.Name)
+		}
+		names[parameter.Name] = true
+
+		switch parameter.Type {
+		case "", entities.ParameterTypeString, entities.ParameterTypeNumber, entities.ParameterTypeBoolean:
+		case entities.ParameterTypeEnum:
+			if len(parameter.Options) == 0 {
+				return fmt.Errorf("%w: enum %q has no options", projectErrors.ErrBadParameter, parameter.Name)
+			}
+		default:
+			return fmt.Errorf("%w: unknown type %q of %q", projectErrors.ErrBadParameter, parameter.Type, parameter.Name)
+		}
+		if parameter.Validation != "" {
+			if _, err := regexp.Compile(parameter.Validation); err != nil {
+				return fmt.Errorf("%w: invalid validation regexp of %q", projectErrors.ErrBadParameter, parameter.Name)
+			}
+		}
+		if parameter.Default != "" {
+			if _, err := CheckParameterValue(parameter, parameter.Default); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// CheckParameterValue validate value by parameter type and validation regexp, return normalized value
+func CheckParameterValue(parameter entities.CommandParameter, value string) (string, error) {
+	switch parameter.Type {
+	case entities.ParameterTypeNumber:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return "", fmt.Errorf("%w: %q must be a number", projectErrors.ErrBadParameter, parameter.Name)
+		}
+	case entities.ParameterTypeBoolean:
+		boolValue, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", fmt.Errorf("%w: %q must be true or false", projectErrors.ErrBadParameter, parameter.Name)
+		}
+		value = strconv.FormatBool(boolValue)
+	case entities.ParameterTypeEnum:
+		if !slices.Contains(parameter.Options, value) {
+			return "", fmt.Errorf("%w: %q must be one of %v", projectErrors.ErrBadParameter, parameter.Name, parameter.Options)
+		}
+	}
+	if parameter.Validation != "" {
+		validation, err := regexp.Compile("^(?:" + parameter.Validation + ")$")
+		if err != nil {
+			return "", fmt.Errorf("%w: invalid validation regexp of %q", projectErrors.ErrBadParameter, parameter.Name)
+		}
+		if !validation.MatchString(value) {
+			return "", fmt.Errorf("%w: %q does not match %s", projectErrors.ErrBadParameter, parameter.Name, parameter.Validation)
+		}
+	}
+	return value, nil
+}
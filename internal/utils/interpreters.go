@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"fmt"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+	projectErrors "github.com/KalashnikovProjects/WebButtonCommandRun/internal/errors"
+	"slices"
+	"strings"
+)
+
+// CommandPlaceholder is replaced with command text in interpreter argv
+const CommandPlaceholder = "{{command}}"
+
+type Interpreter struct {
+	Argv  []string
+	Quote func(value string) string // quoting of parameter values, nil for custom argv, which is not used with parameters
+}
+
+var Interpreters = map[string]Interpreter{
+	"sh":      {Argv: []string{"sh", "-c", CommandPlaceholder}, Quote: QuoteSh},
+	"bash":    {Argv: []string{"bash", "-c", CommandPlaceholder}, Quote: QuoteSh},
+	"zsh":     {Argv: []string{"zsh", "-c", CommandPlaceholder}, Quote: QuoteSh},
+	"cmd":     {Argv: []string{"cmd", "/C", CommandPlaceholder}, Quote: QuoteCmd},
+	"python3": {Argv: []string{"python3", "-c", CommandPlaceholder}, Quote: QuoteJSON},
+	"node":    {Argv: []string{"node", "-e", CommandPlaceholder}, Quote: QuoteJSON},
+	"pwsh":    {Argv: []string{"pwsh", "-NoLogo", "-NoProfile", "-Command", CommandPlaceholder}, Quote: QuotePwsh},
+}
+
+// CommandInterpreter return interpreter of command, nil if command runs in default console.
+// Command interpreter is preset name, like ["python3"], or argv template with {{command}}, like ["ruby", "-e", "{{command}}"].
+// Quoting of custom argv language is unknown, so command with it can not have parameters.
+func CommandInterpreter(command *entities.Command) (*Interpreter, error) {
+	if len(command.Interpreter) == 0 {
+		return nil, nil
+	}
+	if len(command.Interpreter) == 1 {
+		interpreter, ok := Interpreters[command.Interpreter[0]]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown interpreter %q", projectErrors.ErrBadInterpreter, command.Interpreter[0])
+		}
+		return &interpreter, nil
+	}
+	if strings.TrimSpace(command.Interpreter[0]) == "" {
+		return nil, fmt.Errorf("%w: empty executable", projectErrors.ErrBadInterpreter)
+	}
+	if !slices.ContainsFunc(command.Interpreter[1:], func(arg string) bool {
+		return strings.Contains(arg, CommandPlaceholder)
+	}) {
+		return nil, fmt.Errorf("%w: argv must contain %s", projectErrors.ErrBadInterpreter, CommandPlaceholder)
+	}
+	if len(command.Parameters) != 0 {
+		return nil, fmt.Errorf("%w: parameters can not be quoted for custom argv, use preset interpreter", projectErrors.ErrBadInterpreter)
+	}
+	return &Interpreter{Argv: command.Interpreter}, nil
+}
@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"bytes"
+	"github.com/KalashnikovProjects/WebButtonCommandRun/internal/entities"
+)
+
+const stderrColor = "\x1b[31m"
+const defaultColor = "\x1b[39m"
+
+// PipedOutputToTerminal prepare output of piped command to be shown in terminal:
+// line feeds get carriage returns, terminal would do it for command with pty, stderr is red
+func PipedOutputToTerminal(data []byte, source entities.OutputSource) []byte {
+	data = bytes.ReplaceAll(data, []byte("\n"), []byte("\r\n"))
+	if source == entities.OutputStderr {
+		data = append(append([]byte(stderrColor), data...), defaultColor...)
+	}
+	return data
+}
+
+// SplitOutputBySource split output of piped command, that starts at offset start, to chunks from one source.
+// Spans are sorted by offset, output before the first span is stdout
+func SplitOutputBySource(data []byte, start int64, spans []entities.OutputSpan) []entities.OutputEvent {
+	var res []entities.OutputEvent
+	source := entities.OutputStdout
+	for _, span := range spans {
+		if span.Offset >= start+int64(len(data)) {
+			break
+		}
+		if span.Offset > start {
+			size := span.Offset - start
+			res = append(res, entities.OutputEvent{Data: data[:size], Offset: span.Offset, Source: source})
+			data = data[size:]
+			start = span.Offset
+		}
+		source = span.Source
+	}
+	if len(data) != 0 {
+		res = append(res, entities.OutputEvent{Data: data, Offset: start + int64(len(data)), Source: source})
+	}
+	return res
+}
@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// QuoteSh quote value in single quotes for sh, where nothing inside is expanded
+func QuoteSh(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// QuoteCmd quote value for cmd /C. Value first quoted by CommandLineToArgvW rules, that most programs use
+// for parsing arguments, then every cmd metacharacter escaped with ^, so cmd does not expand or redirect anything.
+func QuoteCmd(value string) string {
+	var quoted strings.Builder
+	quoted.WriteByte('"')
+	backslashes := 0
+	for _, char := range value {
+		switch char {
+		case '\\':
+			backslashes++
+			continue
+		case '"':
+			quoted.WriteString(strings.Repeat("\\", backslashes*2+1))
+		default:
+			quoted.WriteString(strings.Repeat("\\", backslashes))
+		}
+		backslashes = 0
+		quoted.WriteRune(char)
+	}
+	quoted.WriteString(strings.Repeat("\\", backslashes*2))
+	quoted.WriteByte('"')
+
+	var escaped strings.Builder
+	for _, char := range quoted.String() {
+		if strings.ContainsRune(`()%!^"<>&|`, char) {
+			escaped.WriteByte('^')
+		}
+		escaped.WriteRune(char)
+	}
+	return escaped.String()
+}
+
+// QuoteJSON quote value as JSON string, that is valid string literal in python and javascript
+func QuoteJSON(value string) string {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	_ = encoder.Encode(value) // encoding of string never fails
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// QuotePwsh quote value in single quotes for powershell, that also treats typographic single quotes as quotes
+func QuotePwsh(value string) string {
+	var quoted strings.Builder
+	quoted.WriteByte('\'')
+	for _, char := range value {
+		if strings.ContainsRune("'‘’‚‛", char) {
+			quoted.WriteRune(char)
+		}
+		quoted.WriteRune(char)
+	}
+	quoted.WriteByte('\'')
+	return quoted.String()
+}
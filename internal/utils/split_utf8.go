@@ -0,0 +1,16 @@
+package utils
+
+import "unicode/utf8"
+
+// SplitIncompleteRune split data before UTF-8 character, that is cut at the end, so it can be joined with next data
+func SplitIncompleteRune(data []byte) ([]byte, []byte) {
+	for i := 1; i < utf8.UTFMax && i <= len(data); i++ {
+		if utf8.RuneStart(data[len(data)-i]) {
+			if !utf8.FullRune(data[len(data)-i:]) {
+				return data[:len(data)-i], data[len(data)-i:]
+			}
+			break
+		}
+	}
+	return data, nil
+}
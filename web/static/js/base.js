@@ -1,4 +1,8 @@
 const WebsocketSendInterval = 50
+const SessionReconnectDelay = 1000
+const MaxSessionReconnectTries = 10
+const ClientPingInterval = 15000
+const InterpreterPresets = ["sh", "bash", "zsh", "cmd", "python3", "node", "pwsh"]
 
 let consoleUsing
 const apiBase = "/api/v1/"
@@ -6,6 +10,25 @@ let commandsList
 let commandId = -1
 let currentCommand
 let terminalWebsocket
+let sessionId = null
+let sessionReconnectTries = 0
+let runParameters = {}
+let viewerRole = "controller"
+let playbackRunId = null
+let currentUser = null
+let configFrozen = false
+const UserRoles = ["admin", "editor", "operator", "viewer"]
+const AccessLevels = ["none", "view", "run", "edit"]
+
+// Api needs login, so expired login session sends user to login page
+const apiFetch = window.fetch;
+window.fetch = async (...args) => {
+    const response = await apiFetch(...args);
+    if (response.status === 401) {
+        window.location.href = "/login";
+    }
+    return response;
+};
 
 initPage();
 
@@ -54,27 +77,91 @@ function runCommand(event) {
     if (commandId === -1 || !currentCommand) {
         return;
     }
+    if (currentCommand.parameters && currentCommand.parameters.length !== 0) {
+        askParameters(currentCommand, startCommand);
+        return;
+    }
+    startCommand({});
+}
+
+function startCommand(parameters) {
+    runParameters = parameters;
+    viewerRole = "controller";
+    playbackRunId = null;
     if (typeof fitAddon !== 'undefined' && typeof term !== 'undefined') {
-        try {
-            fitAddon.fit();
-            term.resize(term.cols - 2, term.rows);
-        } catch (_) {}
+        fitTerminal();
     }
-    const protocol = getWebSocketProtocol();
-    terminalWebsocket = new WebSocket(`${protocol}://${location.host}${apiBase}ws/commands/${commandId}`);
     document.getElementById("command-up-terminal").innerText = currentCommand.name;
+    sessionReconnectTries = 0;
+    connectTerminal(`ws/commands/${commandId}`, true);
+}
+
+function reattachSession() {
+    if (!sessionId) {
+        return;
+    }
+    sessionReconnectTries++;
+    connectTerminal(`ws/sessions/${sessionId}?role=${viewerRole}`, false);
+}
+
+function formatCommandExit(exit) {
+    if (!exit) {
+        return `\x1b[1;32mFinished\x1b[0m`;
+    }
+    const duration = (exit["duration-ms"] / 1000).toFixed(1);
+    if (exit["stop-reason"] === "timeout") {
+        return `\x1b[1;31mTimed out after ${duration}s\x1b[0m`;
+    }
+    if (exit["stop-reason"] === "idle") {
+        return `\x1b[1;31mStopped for no input after ${duration}s\x1b[0m`;
+    }
+    if (exit.signal) {
+        return `\x1b[1;31mKilled by ${exit.signal} after ${duration}s\x1b[0m`;
+    }
+    if (exit.code !== 0) {
+        return `\x1b[1;31mFinished with exit code ${exit.code} in ${duration}s\x1b[0m`;
+    }
+    return `\x1b[1;32mFinished in ${duration}s\x1b[0m`;
+}
+
+function connectTerminal(path, sendOptions) {
+    const protocol = getWebSocketProtocol();
+    const command = currentCommand;
+    let commandExit = null;
+    terminalWebsocket = new WebSocket(`${protocol}://${location.host}${apiBase}${path}`);
+    // Output comes as raw bytes in binary frames, control messages as json
+    terminalWebsocket.binaryType = "arraybuffer";
     let interval;
+    let pingInterval;
+    // Half-open connection gets no close event for long, so it is detected by missing answers to pings
+    let lastMessageAt = Date.now();
     terminalWebsocket.onopen = () => {
         term.write('\x1b[?25h');
         term.reset();
-        term.writeln("> " + currentCommand.command);
+        if (sendOptions) {
+            term.writeln("> " + command.command);
+        }
         commandRunning = true;
-        term.options.disableStdin = false;
+        term.options.disableStdin = viewerRole !== "controller";
+        document.getElementById("signal-select").disabled = viewerRole !== "controller";
+        document.body.classList.toggle("playback", playbackRunId !== null);
         document.body.classList.add("terminal-opened");
-        terminalWebsocket.send(JSON.stringify({
-            "message-type": "options",
-            "options": { "rows": term.rows, "cols": term.cols }
-        }));
+        if (sendOptions) {
+            terminalWebsocket.send(JSON.stringify({
+                "message-type": "options",
+                "options": { "rows": term.rows, "cols": term.cols, "parameters": runParameters }
+            }));
+        } else {
+            // Terminal could be resized while connection was lost
+            sendTerminalSize(term.cols, term.rows);
+        }
+        pingInterval = setInterval(() => {
+            if (Date.now() - lastMessageAt > 2 * ClientPingInterval) {
+                terminalWebsocket.close(4000, "ping timeout");
+                return;
+            }
+            terminalWebsocket.send(JSON.stringify({"message-type": "ping"}));
+        }, ClientPingInterval);
         interval = setInterval(() => {
             if (commandRunning && termInputedText && termInputedText.length !== 0) {
                 terminalWebsocket.send(JSON.stringify({
@@ -86,12 +173,32 @@ function runCommand(event) {
         }, WebsocketSendInterval);
     };
     terminalWebsocket.onmessage = (event) => {
+        lastMessageAt = Date.now();
+        if (event.data instanceof ArrayBuffer) {
+            sessionReconnectTries = 0;
+            term.write(new Uint8Array(event.data));
+            return;
+        }
         try {
             let data = JSON.parse(event.data);
             switch (data["message-type"]) {
-                case "data":
-                    console.log("get data", data);
-                    term.write(data.data);
+                case "session":
+                    sessionId = data.data;
+                    if (data.viewer) {
+                        viewerRole = data.viewer.role;
+                    }
+                    break
+                case "viewers":
+                    renderViewers(data.viewers);
+                    break
+                case "exit":
+                    commandExit = data.exit;
+                    break
+                case "error":
+                    term.writeln(`\r\n\x1b[1;31m${data.data}\x1b[0m`);
+                    break
+                case "warning":
+                    term.writeln(`\r\n\x1b[1;33m${data.data}\x1b[0m`);
                     break
             }
         } catch (_) {}
@@ -100,19 +207,40 @@ function runCommand(event) {
         if (interval) {
             clearInterval(interval);
         }
+        if (pingInterval) {
+            clearInterval(pingInterval);
+        }
         term.write('\x1b[?25l');
+        renderViewers([]);
         termInputedText = [];
         commandRunning = false;
         term.options.disableStdin = true;
         try {
             switch (event.code) {
                 case 1000:
+                    sessionId = null;
                     term.writeln('\n');
-                    term.writeln(`\x1b[1;32mFinished\x1b[0m`);
+                    term.writeln(formatCommandExit(commandExit));
                     break
                 case 4001:
+                case 4002:
+                    sessionId = null;
                     return
+                case 1001:
+                case 1006:
+                case 4000:
+                    if (sessionId && sessionReconnectTries < MaxSessionReconnectTries) {
+                        term.writeln('\n');
+                        term.writeln(`\x1b[1;33mConnection lost, reconnecting...\x1b[0m`);
+                        setTimeout(reattachSession, SessionReconnectDelay);
+                        return
+                    }
+                    sessionId = null;
+                    term.writeln('\n');
+                    term.writeln(`\x1b[1;31mDisconected: ${event.code} ${event.reason}\x1b[0m`);
+                    break
                 default:
+                    sessionId = null;
                     term.writeln('\n');
                     term.writeln(`\x1b[1;31mDisconected: ${event.code} ${event.reason}\x1b[0m`);
             }
@@ -120,13 +248,374 @@ function runCommand(event) {
     };
 }
 
+function renderParameterInput(parameter, value) {
+    const id = `popup-parameter-${parameter.name}`;
+    switch (parameter.type) {
+        case "enum":
+            return `<select id="${id}" class="command-text">${parameter.options.map(option =>
+                `<option value="${escapeHTML(option)}" ${option === value ? "selected" : ""}>${escapeHTML(option)}</option>`
+            ).join("")}</select>`;
+        case "boolean":
+            return `<input id="${id}" type="checkbox" ${value === "true" ? "checked" : ""}>`;
+        case "number":
+            return `<input id="${id}" type="number" step="any" class="command-text" value="${escapeHTML(value)}">`;
+        default:
+            return `<input id="${id}" type="text" class="command-text" spellcheck="false" value="${escapeHTML(value)}">`;
+    }
+}
+
+function askParameters(command, onConfirm) {
+    const popup = document.createElement('div');
+    popup.id = 'popup';
+    popup.innerHTML = `
+                  <div class="popup-backdrop hidden"></div>
+                  <div class="popup-content big-popup hidden">
+                    <h2>Run ${escapeHTML(command.name)}</h2>
+                    ${command.parameters.map(parameter => `
+                        <div class="input-line">
+                            <label for="popup-parameter-${parameter.name}">${escapeHTML(parameter.name)}</label>
+                            ${renderParameterInput(parameter, runParameters[parameter.name] ?? parameter.default ?? "")}
+                        </div>`).join("")}
+                    <div class="popup-buttons" style="margin-top: 30px">
+                      <button id="popup-cancel-btn" class="normal-button red-button">Cancel</button>
+                      <button id="popup-confirm-btn" class="normal-button">Run</button>
+                    </div>
+                  </div>`;
+    document.body.appendChild(popup);
+    setTimeout(() => {
+        document.querySelector(".popup-backdrop").classList.remove("hidden");
+        document.querySelector(".popup-content").classList.remove("hidden");
+    }, 20)
+    const closePopup = () => {
+        document.querySelector(".popup-backdrop").classList.add("hidden");
+        document.querySelector(".popup-content").classList.add("hidden");
+        setTimeout(
+            () => {
+                document.body.removeChild(popup);
+            },
+            300
+        );
+    };
+    document.getElementById('popup-confirm-btn').onclick = function() {
+        const parameters = {};
+        for (const parameter of command.parameters) {
+            const input = document.getElementById(`popup-parameter-${parameter.name}`);
+            parameters[parameter.name] = parameter.type === "boolean" ? String(input.checked) : input.value;
+        }
+        closePopup();
+        onConfirm(parameters);
+    };
+    document.getElementById('popup-cancel-btn').onclick = closePopup;
+}
+
+// leaveTerminal close connection, command is terminated only when controller leaves
+function leaveTerminal() {
+    if (viewerRole === "controller") {
+        terminalWebsocket.close(4001, "terminal closed from frontend");
+    } else {
+        terminalWebsocket.close(4002, "spectator left");
+    }
+}
+
 function closeTerminal(event) {
-    terminalWebsocket.close(4001, "terminal closed from frontend");
+    leaveTerminal();
     document.body.classList.remove("terminal-opened");
 }
 
+function renderViewers(viewers) {
+    const elem = document.getElementById("terminal-viewers");
+    if (!viewers || viewers.length < 2) {
+        elem.innerText = "";
+        elem.title = "";
+        return;
+    }
+    elem.innerText = `${viewers.length} viewers`;
+    elem.title = viewers.map(viewer => `${viewer.name} (${viewer.role})`).join("\n");
+}
+
+function joinSession(session, role) {
+    if (document.body.classList.contains("terminal-opened")) {
+        closeTerminal();
+    }
+    commandId = session["command-id"];
+    runParameters = {};
+    selectButtonIcons(commandId);
+    Promise.resolve(loadCommand()).then(() => {
+        viewerRole = role;
+        playbackRunId = null;
+        sessionReconnectTries = 0;
+        document.getElementById("command-up-terminal").innerText = currentCommand ? currentCommand.name : "";
+        fitTerminal();
+        connectTerminal(`ws/sessions/${session["session-id"]}?role=${role}`, false);
+    });
+}
+
+function showSessions(event) {
+    const popup = document.createElement('div');
+    popup.id = 'popup';
+    popup.innerHTML = `
+                  <div class="popup-backdrop hidden"></div>
+                  <div class="popup-content big-popup hidden">
+                    <h2>Running commands</h2>
+                    <div id="sessions-list">
+                        <p>Loading sessions...</p>
+                    </div>
+                    <div class="popup-buttons" style="margin-top: 30px">
+                      <button id="popup-cancel-btn" class="normal-button red-button">Close</button>
+                    </div>
+                  </div>`;
+    document.body.appendChild(popup);
+    setTimeout(() => {
+        document.querySelector(".popup-backdrop").classList.remove("hidden");
+        document.querySelector(".popup-content").classList.remove("hidden");
+    }, 20)
+    const closePopup = () => {
+        document.querySelector(".popup-backdrop").classList.add("hidden");
+        document.querySelector(".popup-content").classList.add("hidden");
+        setTimeout(
+            () => {
+                document.body.removeChild(popup);
+            },
+            300
+        );
+    };
+    document.getElementById('popup-cancel-btn').onclick = closePopup;
+    fetch(`${apiBase}sessions`).then(async response => {
+        if (!response.ok) {
+            const errorText = await response.text();
+            throw new Error(`Server error: ${response.status} - ${errorText}`);
+        }
+        return response.json();
+    }).then(sessions => {
+        const list = document.getElementById("sessions-list");
+        sessions = sessions.filter(session => !session.finished);
+        if (sessions.length === 0) {
+            list.innerHTML = `<p>No running commands</p>`;
+            return;
+        }
+        list.innerHTML = sessions.map((session, index) => {
+            const command = commandsList.find(command => command.id === session["command-id"]);
+            const viewerNames = session.viewers.map(viewer => viewer.name).join(", ");
+            const startedAt = new Date(session["started-at"]).toLocaleTimeString();
+            return `
+            <div class="input-line">
+                <span class="command-text" title="${escapeHTML(viewerNames)}">${escapeHTML(command ? command.name : "Deleted command")}, ${startedAt}, ${session.viewers.length} viewers</span>
+                <button class="normal-button" data-session="${index}" data-role="spectator">Watch</button>
+                <button class="normal-button" data-session="${index}" data-role="controller">Join</button>
+            </div>`;
+        }).join("");
+        for (const button of list.querySelectorAll("button[data-session]")) {
+            button.addEventListener("click", () => {
+                closePopup();
+                joinSession(sessions[Number(button.dataset.session)], button.dataset.role);
+            });
+        }
+    }).catch(err => {
+        console.error('Ошибка:', err);
+        showErrorPopup(
+            'Ошибка загрузки сессий',
+            'Не удалось загрузить запущенные команды.',
+            err.message
+        );
+    });
+}
+
+// replayRun play recorded output of run in terminal, like spectator of finished command
+function replayRun(run, speed) {
+    if (document.body.classList.contains("terminal-opened")) {
+        closeTerminal();
+    }
+    viewerRole = "spectator";
+    playbackRunId = run.id;
+    sessionId = null;
+    document.getElementById("playback-speed").value = String(speed);
+    document.getElementById("command-up-terminal").innerText = `${currentCommand ? currentCommand.name : ""} replay #${run.id}`;
+    fitTerminal();
+    connectTerminal(`ws/runs/${run.id}/playback?speed=${speed}`, false);
+}
+
+function changePlaybackSpeed(event) {
+    if (!commandRunning || playbackRunId === null) {
+        return;
+    }
+    terminalWebsocket.send(JSON.stringify({
+        "message-type": "playback-speed",
+        "speed": Number(event.target.value)
+    }));
+}
+
+const RunExportExtensions = {text: "txt", ansi: "log", html: "html", jsonl: "jsonl"};
+
+async function downloadRunRecording(runId) {
+    try {
+        const response = await fetch(`${apiBase}runs/${runId}/recording`);
+        if (!response.ok) {
+            const errorText = await response.text();
+            throw new Error(`Server error: ${response.status} - ${errorText}`);
+        }
+        saveFile(`run-${runId}.cast`, await response.blob());
+    } catch (err) {
+        console.error('Ошибка:', err);
+        showErrorPopup(
+            'Ошибка скачивания записи',
+            'Не удалось скачать запись запуска.',
+            err.message
+        );
+    }
+}
+
+async function downloadRunExport(runId, format) {
+    try {
+        const response = await fetch(`${apiBase}runs/${runId}/export?format=${format}`);
+        if (!response.ok) {
+            const errorText = await response.text();
+            throw new Error(`Server error: ${response.status} - ${errorText}`);
+        }
+        saveFile(`run-${runId}.${RunExportExtensions[format]}`, await response.blob());
+    } catch (err) {
+        console.error('Ошибка:', err);
+        showErrorPopup(
+            'Ошибка скачивания вывода',
+            'Не удалось скачать вывод запуска.',
+            err.message
+        );
+    }
+}
+
+function showRunHistory(event) {
+    if (commandId === -1 || !currentCommand) {
+        return;
+    }
+    const popup = document.createElement('div');
+    popup.id = 'popup';
+    popup.innerHTML = `
+                  <div class="popup-backdrop hidden"></div>
+                  <div class="popup-content big-popup hidden">
+                    <h2>Run history of ${escapeHTML(currentCommand.name)}</h2>
+                    <div class="input-line">
+                        <label for="popup-replay-speed">Replay speed</label>
+                        <select id="popup-replay-speed" class="command-text">
+                            <option value="0.5">0.5x</option>
+                            <option value="1" selected>1x</option>
+                            <option value="2">2x</option>
+                            <option value="4">4x</option>
+                            <option value="8">8x</option>
+                            <option value="16">16x</option>
+                        </select>
+                    </div>
+                    <div id="runs-list">
+                        <p>Loading runs...</p>
+                    </div>
+                    <div class="popup-buttons" style="margin-top: 30px">
+                      <button id="popup-cancel-btn" class="normal-button red-button">Close</button>
+                    </div>
+                  </div>`;
+    document.body.appendChild(popup);
+    setTimeout(() => {
+        document.querySelector(".popup-backdrop").classList.remove("hidden");
+        document.querySelector(".popup-content").classList.remove("hidden");
+    }, 20)
+    const closePopup = () => {
+        document.querySelector(".popup-backdrop").classList.add("hidden");
+        document.querySelector(".popup-content").classList.add("hidden");
+        setTimeout(
+            () => {
+                document.body.removeChild(popup);
+            },
+            300
+        );
+    };
+    document.getElementById('popup-cancel-btn').onclick = closePopup;
+    fetch(`${apiBase}commands/${commandId}/runs`).then(async response => {
+        if (!response.ok) {
+            const errorText = await response.text();
+            throw new Error(`Server error: ${response.status} - ${errorText}`);
+        }
+        return response.json();
+    }).then(runs => {
+        const list = document.getElementById("runs-list");
+        if (runs.length === 0) {
+            list.innerHTML = `<p>No runs yet</p>`;
+            return;
+        }
+        list.innerHTML = runs.map((run, index) => {
+            const startedAt = new Date(run["started-at"]).toLocaleString();
+            let result = "running";
+            if (run["finished-at"]) {
+                result = run["exit-signal"] ? run["exit-signal"] : `exit code ${run["exit-code"]}`;
+            }
+            return `
+            <div class="input-line">
+                <span class="command-text" title="${escapeHTML(run.command)}">#${run.id}, ${startedAt}, ${escapeHTML(result)}</span>
+                <button class="normal-button" data-run="${index}" data-action="replay">Replay</button>
+                <button class="normal-button" data-run="${index}" data-action="download">.cast</button>
+                <select class="command-text" data-run="${index}" data-action="export">
+                    <option value="">Export</option>
+                    <option value="text">Plain text</option>
+                    <option value="ansi">Raw ANSI</option>
+                    <option value="html">HTML</option>
+                    <option value="jsonl">JSON lines</option>
+                </select>
+            </div>`;
+        }).join("");
+        for (const select of list.querySelectorAll("select[data-run]")) {
+            select.addEventListener("change", () => {
+                if (select.value !== "") {
+                    downloadRunExport(runs[Number(select.dataset.run)].id, select.value);
+                    select.value = "";
+                }
+            });
+        }
+        for (const button of list.querySelectorAll("button[data-run]")) {
+            button.addEventListener("click", () => {
+                const run = runs[Number(button.dataset.run)];
+                if (button.dataset.action === "download") {
+                    downloadRunRecording(run.id);
+                    return;
+                }
+                const speed = Number(document.getElementById("popup-replay-speed").value);
+                closePopup();
+                replayRun(run, speed);
+            });
+        }
+    }).catch(err => {
+        console.error('Ошибка:', err);
+        showErrorPopup(
+            'Ошибка загрузки истории',
+            'Не удалось загрузить историю запусков.',
+            err.message
+        );
+    });
+}
+
+function sendTerminalSize(cols, rows) {
+    if (!commandRunning || viewerRole !== "controller" || !terminalWebsocket || terminalWebsocket.readyState !== WebSocket.OPEN) {
+        return;
+    }
+    terminalWebsocket.send(JSON.stringify({
+        "message-type": "resize",
+        "options": { "cols": cols, "rows": rows }
+    }));
+}
+
+function sendSignal(event) {
+    const signal = event.target.value;
+    event.target.value = "";
+    if (!commandRunning || !signal) {
+        return;
+    }
+    terminalWebsocket.send(JSON.stringify({
+        "message-type": "signal",
+        "signal": signal
+    }));
+}
+
 function restartCommand(event) {
-    terminalWebsocket.close(4001, "terminal closed from frontend");
+    if (playbackRunId !== null) {
+        replayRun({ id: playbackRunId }, Number(document.getElementById("playback-speed").value));
+        return;
+    }
+    leaveTerminal();
     runCommand(event);
 }
 
@@ -274,6 +763,15 @@ function renderRunButtonContainer() {
     document.getElementById("big-run-button").addEventListener("click", runCommand);
     document.getElementById("main-name-input").value = currentCommand.name;
     document.getElementById("main-command-input").value = currentCommand.command;
+    if (currentCommand.access !== "edit" || configFrozen) {
+        document.getElementById("edit-button").style.display = "none";
+        document.getElementById("main-name-input").readOnly = true;
+        document.getElementById("main-command-input").readOnly = true;
+    }
+    if (currentCommand.access === "view") {
+        document.getElementById("big-run-button").disabled = true;
+        document.getElementById("big-run-button").title = "You can only view this command";
+    }
 
     document.getElementById("main-name-input").addEventListener("blur", (event) => {
         if (currentCommand.name !== event.target.value) {
@@ -372,6 +870,40 @@ function renderNoCommands() {
         </button>
     `;
     document.getElementById("add-new-command-center").addEventListener("click", addNewCommand);
+    if (configFrozen) {
+        document.querySelector(".big-run-button-container h3").textContent = "Config is frozen";
+        document.getElementById("add-new-command-center").style.display = "none";
+    } else if (!hasRole("editor")) {
+        document.querySelector(".big-run-button-container h3").textContent = "Ask admin to give you access";
+        document.getElementById("add-new-command-center").style.display = "none";
+    }
+}
+
+// hasRole check, that role of logged in user is role or higher, same as server does
+function hasRole(role) {
+    return currentUser !== null && UserRoles.indexOf(currentUser.role) <= UserRoles.indexOf(role);
+}
+
+// applyUserRole hide menu buttons, that are forbidden for role of logged in user
+function applyUserRole() {
+    const adminButtons = ["save-config-button", "import-config-button", "export-files-button", "import-files-button", "audit-button"];
+    for (const id of adminButtons) {
+        document.getElementById(id).style.display = hasRole("admin") ? "" : "none";
+    }
+    for (const id of ["global-env-button", "secrets-button"]) {
+        document.getElementById(id).style.display = hasRole("editor") ? "" : "none";
+    }
+    // Frozen config can not be imported, commands can only be run
+    if (configFrozen) {
+        for (const id of ["import-config-button", "import-files-button"]) {
+            document.getElementById(id).style.display = "none";
+        }
+    }
+}
+
+// canEditCommands check, that logged in user can add commands
+function canEditCommands() {
+    return hasRole("editor") && !configFrozen;
 }
 
 function initPage() {
@@ -407,8 +939,17 @@ function initPage() {
     document.getElementById("open-menu-button").addEventListener("click", toggleMenu);
     document.getElementById("close-button").addEventListener("click", closeTerminal);
     document.getElementById("restart-button").addEventListener("click", restartCommand);
+    document.getElementById("signal-select").addEventListener("change", sendSignal);
     document.getElementById("save-config-button").addEventListener("click", saveConfig);
     document.getElementById("import-config-button").addEventListener("click", importConfig);
+    document.getElementById("global-env-button").addEventListener("click", editGlobalEnv);
+    document.getElementById("secrets-button").addEventListener("click", editSecrets);
+    document.getElementById("sessions-button").addEventListener("click", showSessions);
+    document.getElementById("runs-button").addEventListener("click", showRunHistory);
+    document.getElementById("users-button").addEventListener("click", editUsers);
+    document.getElementById("audit-button").addEventListener("click", showAuditLog);
+    document.getElementById("logout-button").addEventListener("click", logout);
+    document.getElementById("playback-speed").addEventListener("change", changePlaybackSpeed);
     document.getElementById("export-files-button").addEventListener("click", exportFiles);
     document.getElementById("import-files-button").addEventListener("click", importFiles);
 
@@ -416,7 +957,21 @@ function initPage() {
 
     let hash = window.location.hash.split("-");
 
-    let prom = loadCommands().then(() => {
+    let prom = fetch(`${apiBase}auth/me`).then(async response => {
+        if (!response.ok) {
+            const errorText = await response.text();
+            throw new Error(`Server error: ${response.status} - ${errorText}`);
+        }
+        currentUser = await response.json();
+        return fetch(`${apiBase}config-frozen`);
+    }).then(async response => {
+        if (!response.ok) {
+            const errorText = await response.text();
+            throw new Error(`Server error: ${response.status} - ${errorText}`);
+        }
+        configFrozen = await response.json();
+        applyUserRole();
+    }).then(loadCommands).then(() => {
         if (hash.length === 2) {
             commandId = parseInt(hash[1]);
             if (isNaN(commandId)) {
@@ -452,6 +1007,32 @@ function editCommand(event) {
                       </div>
                       <h3 style="text-align: left; margin-bottom: 5px">Command execution dir</h3>
                       <textarea id="command-execution-dir-input" class="command-text main-command-text" spellcheck="false" onWheel="smoothHorizontalScroll(event)" value="${currentCommand.executionDir}">${currentCommand.executionDir}</textarea>
+                      <h3 style="text-align: left; margin-bottom: 5px">Interpreter</h3>
+                      <select id="command-interpreter-select" class="command-text">
+                          <option value="">Default console</option>
+                          ${InterpreterPresets.map(preset => `<option value="${preset}">${preset}</option>`).join("")}
+                          <option value="custom">Custom argv</option>
+                      </select>
+                      <textarea id="command-interpreter-input" class="command-text main-command-text" spellcheck="false" placeholder='["ruby", "-e", "{{command}}"]'></textarea>
+                      <h3 style="text-align: left; margin-bottom: 5px">Execution mode</h3>
+                      <select id="command-execution-mode-select" class="command-text">
+                          <option value="terminal">Terminal</option>
+                          <option value="pipe">Pipes (separate stdout and stderr, no terminal)</option>
+                      </select>
+                      <h3 style="text-align: left; margin-bottom: 5px">Environment variables</h3>
+                      <textarea id="command-env-input" class="command-text main-command-text" spellcheck="false" placeholder="NAME=value">${escapeHTML(formatEnv(currentCommand.env))}</textarea>
+                      <h3 style="text-align: left; margin-bottom: 5px">Env file</h3>
+                      <textarea id="command-env-file-input" class="command-text main-command-text" spellcheck="false" placeholder=".env">${escapeHTML(currentCommand.envFile ?? "")}</textarea>
+                      <h3 style="text-align: left; margin-bottom: 5px">Timeout (seconds)</h3>
+                      <textarea id="command-timeout-input" class="command-text main-command-text" spellcheck="false" placeholder="No timeout">${currentCommand.timeoutMs ? currentCommand.timeoutMs / 1000 : ""}</textarea>
+                      <h3 style="text-align: left; margin-bottom: 5px">Secrets</h3>
+                      <textarea id="command-secrets-input" class="command-text main-command-text" spellcheck="false" placeholder="API_TOKEN, PASSWORD">${escapeHTML((currentCommand.secrets ?? []).join(", "))}</textarea>
+                      <div id="command-grants" style="display: none">
+                          <h3 style="text-align: left; margin-bottom: 5px">Access of users</h3>
+                          <div id="grants-list">
+                              <p>Loading access...</p>
+                          </div>
+                      </div>
                       <h3 style="text-align: left; margin-bottom: 5px">Delete command</h3>
 
                       <button id="delete-command-btn" class="normal-button red-button">Delete <img src="../static/vectors/delete.svg" alt=""/></button>
@@ -467,6 +1048,10 @@ function editCommand(event) {
     }, 20)
 
     loadCommandFiles();
+    if (hasRole("admin")) {
+        document.getElementById("command-grants").style.display = "";
+        loadCommandGrants();
+    }
 
     document.getElementById("select-files-btn").addEventListener("click", () => {
         document.getElementById("file-input").click();
@@ -542,17 +1127,805 @@ function editCommand(event) {
             });
         }
     });
-    document.getElementById('popup-confirm-btn').onclick = function() {
-        cleanupFileHandlers();
-        document.querySelector(".popup-backdrop").classList.add("hidden");
-        document.querySelector(".popup-content").classList.add("hidden");
-        setTimeout(
-            () => document.body.removeChild(popup),
-            300
-        );
+    document.getElementById("command-env-input").addEventListener("blur", (event) => {
+        const env = parseEnv(event.target.value);
+        if (formatEnv(env) === formatEnv(currentCommand.env)) {
+            return;
+        }
+        patchCurrentCommand({env: env}).then(() => {
+            currentCommand.env = env;
+        }).catch(() => {
+            event.target.value = formatEnv(currentCommand.env);
+        });
+    });
+    document.getElementById("command-env-file-input").addEventListener("blur", (event) => {
+        if ((currentCommand.envFile ?? "") === event.target.value || event.target.value === "") {
+            event.target.value = currentCommand.envFile ?? "";
+            return;
+        }
+        patchCurrentCommand({envFile: event.target.value}).then(() => {
+            currentCommand.envFile = event.target.value;
+        }).catch(() => {
+            event.target.value = currentCommand.envFile ?? "";
+        });
+    });
+    document.getElementById("command-timeout-input").addEventListener("blur", (event) => {
+        const timeoutMs = Math.round(Number(event.target.value) * 1000);
+        const oldValue = currentCommand.timeoutMs ? currentCommand.timeoutMs / 1000 : "";
+        if (event.target.value === "" || !(timeoutMs > 0) || timeoutMs === currentCommand.timeoutMs) {
+            event.target.value = oldValue;
+            return;
+        }
+        patchCurrentCommand({timeoutMs: timeoutMs}).then(() => {
+            currentCommand.timeoutMs = timeoutMs;
+        }).catch(() => {
+            event.target.value = oldValue;
+        });
+    });
+    const interpreterSelect = document.getElementById("command-interpreter-select");
+    const interpreterInput = document.getElementById("command-interpreter-input");
+    const renderInterpreter = () => {
+        const interpreter = currentCommand.interpreter ?? [];
+        const isPreset = interpreter.length === 1 && InterpreterPresets.includes(interpreter[0]);
+        interpreterSelect.value = interpreter.length === 0 ? "" : isPreset ? interpreter[0] : "custom";
+        interpreterInput.value = isPreset || interpreter.length === 0 ? "" : JSON.stringify(interpreter);
+        interpreterInput.hidden = interpreterSelect.value !== "custom";
     };
-}
-
+    const saveInterpreter = (interpreter) => {
+        if (JSON.stringify(interpreter) === JSON.stringify(currentCommand.interpreter ?? [])) {
+            return;
+        }
+        patchCurrentCommand({interpreter: interpreter}).then(() => {
+            currentCommand.interpreter = interpreter;
+        }).catch(renderInterpreter);
+    };
+    renderInterpreter();
+    interpreterSelect.addEventListener("change", () => {
+        interpreterInput.hidden = interpreterSelect.value !== "custom";
+        if (interpreterSelect.value !== "custom") {
+            saveInterpreter(interpreterSelect.value === "" ? [] : [interpreterSelect.value]);
+        }
+    });
+    interpreterInput.addEventListener("blur", (event) => {
+        let interpreter;
+        try {
+            interpreter = JSON.parse(event.target.value);
+        } catch (_) {
+            showErrorPopup(
+                'Неверный ввод',
+                'Интерпретатор должен быть JSON массивом аргументов, например ["ruby", "-e", "{{command}}"]',
+            );
+            return;
+        }
+        saveInterpreter(interpreter);
+    });
+    const executionModeSelect = document.getElementById("command-execution-mode-select");
+    executionModeSelect.value = currentCommand.executionMode || "terminal";
+    executionModeSelect.addEventListener("change", () => {
+        const executionMode = executionModeSelect.value;
+        patchCurrentCommand({executionMode: executionMode}).then(() => {
+            currentCommand.executionMode = executionMode;
+        }).catch(() => {
+            executionModeSelect.value = currentCommand.executionMode || "terminal";
+        });
+    });
+    document.getElementById("command-secrets-input").addEventListener("blur", (event) => {
+        const secrets = event.target.value.split(",").map(name => name.trim()).filter(name => name !== "");
+        if (secrets.join(",") === (currentCommand.secrets ?? []).join(",")) {
+            return;
+        }
+        patchCurrentCommand({secrets: secrets}).then(() => {
+            currentCommand.secrets = secrets;
+        }).catch(() => {
+            event.target.value = (currentCommand.secrets ?? []).join(", ");
+        });
+    });
+    document.getElementById('popup-confirm-btn').onclick = function() {
+        cleanupFileHandlers();
+        document.querySelector(".popup-backdrop").classList.add("hidden");
+        document.querySelector(".popup-content").classList.add("hidden");
+        setTimeout(
+            () => document.body.removeChild(popup),
+            300
+        );
+    };
+}
+
+function formatEnv(env) {
+    return Object.entries(env ?? {}).map(([name, value]) => `${name}=${value}`).join("\n");
+}
+
+function parseEnv(text) {
+    const env = {};
+    for (const line of text.split("\n")) {
+        const separator = line.indexOf("=");
+        if (line.trim() === "" || separator <= 0) {
+            continue;
+        }
+        env[line.slice(0, separator).trim()] = line.slice(separator + 1);
+    }
+    return env;
+}
+
+function patchCurrentCommand(patch) {
+    return fetch(`${apiBase}commands/${commandId}`, {
+        method: "PATCH",
+        headers: {
+            'Content-Type': 'application/json'
+        },
+        body: JSON.stringify(patch)
+    }).then(async response => {
+        if (!response.ok) {
+            const errorText = await response.text();
+            throw new Error(`Server error: ${response.status} - ${errorText}`);
+        }
+    }).catch(err => {
+        console.error('Ошибка:', err);
+        showErrorPopup(
+            'Ошибка обновления команды',
+            'Не удалось обновить команду.',
+            err.message
+        );
+        throw err;
+    });
+}
+
+function editGlobalEnv(event) {
+    fetch(`${apiBase}env`).then(async response => {
+        if (!response.ok) {
+            const errorText = await response.text();
+            throw new Error(`Server error: ${response.status} - ${errorText}`);
+        }
+        return response.json();
+    }).then(env => {
+        const popup = document.createElement('div');
+        popup.id = 'popup';
+        popup.innerHTML = `
+                  <div class="popup-backdrop hidden"></div>
+                  <div class="popup-content big-popup hidden">
+                    <h2>Environment for all commands</h2>
+                    <textarea id="global-env-input" class="command-text main-command-text" spellcheck="false" placeholder="NAME=value">${escapeHTML(formatEnv(env))}</textarea>
+                    <div class="popup-buttons" style="margin-top: 30px">
+                      <button id="popup-cancel-btn" class="normal-button red-button">Cancel</button>
+                      <button id="popup-confirm-btn" class="normal-button">Save</button>
+                    </div>
+                  </div>`;
+        document.body.appendChild(popup);
+        setTimeout(() => {
+            document.querySelector(".popup-backdrop").classList.remove("hidden");
+            document.querySelector(".popup-content").classList.remove("hidden");
+        }, 20)
+        const closePopup = () => {
+            document.querySelector(".popup-backdrop").classList.add("hidden");
+            document.querySelector(".popup-content").classList.add("hidden");
+            setTimeout(
+                () => {
+                    document.body.removeChild(popup);
+                },
+                300
+            );
+        };
+        document.getElementById('popup-confirm-btn').onclick = function() {
+            fetch(`${apiBase}env`, {
+                method: "PUT",
+                headers: {
+                    'Content-Type': 'application/json'
+                },
+                body: JSON.stringify(parseEnv(document.getElementById("global-env-input").value))
+            }).then(async response => {
+                if (!response.ok) {
+                    const errorText = await response.text();
+                    throw new Error(`Server error: ${response.status} - ${errorText}`);
+                }
+                closePopup();
+            }).catch(err => {
+                console.error('Ошибка:', err);
+                showErrorPopup(
+                    'Ошибка сохранения окружения',
+                    'Не удалось сохранить переменные окружения.',
+                    err.message
+                );
+            });
+        };
+        document.getElementById('popup-cancel-btn').onclick = closePopup;
+    }).catch(err => {
+        console.error('Ошибка:', err);
+        showErrorPopup(
+            'Ошибка загрузки окружения',
+            'Не удалось загрузить переменные окружения.',
+            err.message
+        );
+    });
+}
+
+function renderSecretsList(secrets) {
+    const list = document.getElementById("secrets-list");
+    if (secrets.length === 0) {
+        list.innerHTML = `<p>No secrets</p>`;
+        return;
+    }
+    list.innerHTML = secrets.map(secret => `
+        <div class="input-line">
+            <span class="command-text">${escapeHTML(secret.name)}</span>
+            <button class="normal-button red-button small-button" data-secret="${escapeHTML(secret.name)}">Delete</button>
+        </div>`).join("");
+    for (const button of list.querySelectorAll("button[data-secret]")) {
+        button.addEventListener("click", () => {
+            fetch(`${apiBase}secrets/${encodeURIComponent(button.dataset.secret)}`, {
+                method: "DELETE"
+            }).then(async response => {
+                if (!response.ok) {
+                    const errorText = await response.text();
+                    throw new Error(`Server error: ${response.status} - ${errorText}`);
+                }
+                loadSecrets();
+            }).catch(err => {
+                console.error('Ошибка:', err);
+                showErrorPopup(
+                    'Ошибка удаления секрета',
+                    'Не удалось удалить секрет.',
+                    err.message
+                );
+            });
+        });
+    }
+}
+
+function loadSecrets() {
+    return fetch(`${apiBase}secrets`).then(async response => {
+        if (!response.ok) {
+            const errorText = await response.text();
+            throw new Error(`Server error: ${response.status} - ${errorText}`);
+        }
+        return response.json();
+    }).then(renderSecretsList).catch(err => {
+        console.error('Ошибка:', err);
+        showErrorPopup(
+            'Ошибка загрузки секретов',
+            'Не удалось загрузить список секретов.',
+            err.message
+        );
+    });
+}
+
+function editSecrets(event) {
+    const popup = document.createElement('div');
+    popup.id = 'popup';
+    popup.innerHTML = `
+                  <div class="popup-backdrop hidden"></div>
+                  <div class="popup-content big-popup hidden">
+                    <h2>Secrets</h2>
+                    <div id="secrets-list">
+                        <p>Loading secrets...</p>
+                    </div>
+                    <h3 style="text-align: left; margin-bottom: 5px">Add or replace secret</h3>
+                    <div class="input-line">
+                        <label for="popup-secret-name">Name</label>
+                        <input id="popup-secret-name" type="text" class="command-text" spellcheck="false" autocomplete="off">
+                    </div>
+                    <div class="input-line">
+                        <label for="popup-secret-value">Value</label>
+                        <input id="popup-secret-value" type="password" class="command-text" autocomplete="new-password">
+                    </div>
+                    <div class="popup-buttons" style="margin-top: 30px">
+                      <button id="popup-cancel-btn" class="normal-button red-button">Close</button>
+                      <button id="popup-confirm-btn" class="normal-button">Save secret</button>
+                    </div>
+                  </div>`;
+    document.body.appendChild(popup);
+    setTimeout(() => {
+        document.querySelector(".popup-backdrop").classList.remove("hidden");
+        document.querySelector(".popup-content").classList.remove("hidden");
+    }, 20)
+    loadSecrets();
+    document.getElementById('popup-confirm-btn').onclick = function() {
+        const nameInput = document.getElementById("popup-secret-name");
+        const valueInput = document.getElementById("popup-secret-value");
+        fetch(`${apiBase}secrets/${encodeURIComponent(nameInput.value)}`, {
+            method: "PUT",
+            headers: {
+                'Content-Type': 'application/json'
+            },
+            body: JSON.stringify({value: valueInput.value})
+        }).then(async response => {
+            if (!response.ok) {
+                const errorText = await response.text();
+                throw new Error(`Server error: ${response.status} - ${errorText}`);
+            }
+            nameInput.value = "";
+            valueInput.value = "";
+            loadSecrets();
+        }).catch(err => {
+            console.error('Ошибка:', err);
+            showErrorPopup(
+                'Ошибка сохранения секрета',
+                'Не удалось сохранить секрет.',
+                err.message
+            );
+        });
+    };
+    document.getElementById('popup-cancel-btn').onclick = function() {
+        document.querySelector(".popup-backdrop").classList.add("hidden");
+        document.querySelector(".popup-content").classList.add("hidden");
+        setTimeout(
+            () => {
+                document.body.removeChild(popup);
+            },
+            300
+        );
+    };
+}
+
+function renderUsersList(users) {
+    const list = document.getElementById("users-list");
+    list.innerHTML = users.map(user => `
+        <div class="input-line">
+            <span class="command-text">${escapeHTML(user.username)}</span>
+            <select class="command-text" data-user-id="${user.id}">
+                ${UserRoles.map(role => `<option value="${role}" ${role === user.role ? "selected" : ""}>${role}</option>`).join("")}
+            </select>
+            <button class="normal-button red-button small-button" data-user-id="${user.id}">Delete</button>
+        </div>`).join("");
+    for (const select of list.querySelectorAll("select[data-user-id]")) {
+        select.addEventListener("change", () => {
+            fetch(`${apiBase}users/${select.dataset.userId}`, {
+                method: "PATCH",
+                headers: {
+                    'Content-Type': 'application/json'
+                },
+                body: JSON.stringify({role: select.value})
+            }).then(async response => {
+                if (!response.ok) {
+                    const errorText = await response.text();
+                    throw new Error(`Server error: ${response.status} - ${errorText}`);
+                }
+            }).catch(err => {
+                console.error('Ошибка:', err);
+                showErrorPopup(
+                    'Ошибка смены роли',
+                    'Не удалось сменить роль пользователя.',
+                    err.message
+                );
+            }).finally(loadUsers);
+        });
+    }
+    for (const button of list.querySelectorAll("button[data-user-id]")) {
+        button.addEventListener("click", () => {
+            fetch(`${apiBase}users/${button.dataset.userId}`, {
+                method: "DELETE"
+            }).then(async response => {
+                if (!response.ok) {
+                    const errorText = await response.text();
+                    throw new Error(`Server error: ${response.status} - ${errorText}`);
+                }
+                loadUsers();
+            }).catch(err => {
+                console.error('Ошибка:', err);
+                showErrorPopup(
+                    'Ошибка удаления пользователя',
+                    'Не удалось удалить пользователя.',
+                    err.message
+                );
+            });
+        });
+    }
+}
+
+function loadUsers() {
+    return fetch(`${apiBase}users`).then(async response => {
+        if (!response.ok) {
+            const errorText = await response.text();
+            throw new Error(`Server error: ${response.status} - ${errorText}`);
+        }
+        return response.json();
+    }).then(renderUsersList).catch(err => {
+        console.error('Ошибка:', err);
+        showErrorPopup(
+            'Ошибка загрузки пользователей',
+            'Не удалось загрузить список пользователей.',
+            err.message
+        );
+    });
+}
+
+function renderApiTokens(tokens) {
+    const list = document.getElementById("tokens-list");
+    if (tokens.length === 0) {
+        list.innerHTML = `<p>No tokens</p>`;
+        return;
+    }
+    list.innerHTML = tokens.map(token => `
+        <div class="input-line">
+            <span class="command-text">${escapeHTML(token.name)}</span>
+            <span class="command-text">${escapeHTML(token.scopes.join(" "))}</span>
+            <span class="command-text">used: ${token["last-used-at"] ? new Date(token["last-used-at"]).toLocaleString() : "never"}</span>
+            <span class="command-text">expires: ${token["expires-at"] ? new Date(token["expires-at"]).toLocaleString() : "never"}</span>
+            <button class="normal-button red-button small-button" data-token-id="${token.id}">Revoke</button>
+        </div>`).join("");
+    for (const button of list.querySelectorAll("button[data-token-id]")) {
+        button.addEventListener("click", () => {
+            fetch(`${apiBase}tokens/${button.dataset.tokenId}`, {
+                method: "DELETE"
+            }).then(async response => {
+                if (!response.ok) {
+                    const errorText = await response.text();
+                    throw new Error(`Server error: ${response.status} - ${errorText}`);
+                }
+                loadApiTokens();
+            }).catch(err => {
+                console.error('Ошибка:', err);
+                showErrorPopup(
+                    'Ошибка отзыва токена',
+                    'Не удалось отозвать токен.',
+                    err.message
+                );
+            });
+        });
+    }
+}
+
+function loadApiTokens() {
+    return fetch(`${apiBase}tokens`).then(async response => {
+        if (!response.ok) {
+            const errorText = await response.text();
+            throw new Error(`Server error: ${response.status} - ${errorText}`);
+        }
+        return response.json();
+    }).then(renderApiTokens).catch(err => {
+        console.error('Ошибка:', err);
+        showErrorPopup(
+            'Ошибка загрузки токенов',
+            'Не удалось загрузить список токенов.',
+            err.message
+        );
+    });
+}
+
+function editUsers(event) {
+    const popup = document.createElement('div');
+    popup.id = 'popup';
+    popup.innerHTML = `
+                  <div class="popup-backdrop hidden"></div>
+                  <div class="popup-content big-popup hidden">
+                    <h2>Users</h2>
+                    <div id="users-management">
+                        <div id="users-list">
+                            <p>Loading users...</p>
+                        </div>
+                        <h3 style="text-align: left; margin-bottom: 5px">Add user</h3>
+                        <div class="input-line">
+                            <label for="popup-user-name">Username</label>
+                            <input id="popup-user-name" type="text" class="command-text" spellcheck="false" autocomplete="off">
+                        </div>
+                        <div class="input-line">
+                            <label for="popup-user-password">Password</label>
+                            <input id="popup-user-password" type="password" class="command-text" autocomplete="new-password">
+                        </div>
+                        <div class="input-line">
+                            <label for="popup-user-role">Role</label>
+                            <select id="popup-user-role" class="command-text">
+                                ${UserRoles.map(role => `<option value="${role}" ${role === "viewer" ? "selected" : ""}>${role}</option>`).join("")}
+                            </select>
+                        </div>
+                        <button id="popup-add-user-btn" class="normal-button small-button" style="align-self: flex-end">Add user</button>
+                    </div>
+                    <h3 style="text-align: left; margin-bottom: 5px">Change my password</h3>
+                    <div class="input-line">
+                        <label for="popup-old-password">Old password</label>
+                        <input id="popup-old-password" type="password" class="command-text" autocomplete="current-password">
+                    </div>
+                    <div class="input-line">
+                        <label for="popup-new-password">New password</label>
+                        <input id="popup-new-password" type="password" class="command-text" autocomplete="new-password">
+                    </div>
+                    <button id="popup-change-password-btn" class="normal-button small-button" style="align-self: flex-end">Change password</button>
+                    <h3 style="text-align: left; margin-bottom: 5px">API tokens</h3>
+                    <div id="tokens-list">
+                        <p>Loading tokens...</p>
+                    </div>
+                    <div class="input-line">
+                        <label for="popup-token-name">Name</label>
+                        <input id="popup-token-name" type="text" class="command-text" spellcheck="false" autocomplete="off">
+                    </div>
+                    <div class="input-line">
+                        <label for="popup-token-scopes">Scopes</label>
+                        <input id="popup-token-scopes" type="text" class="command-text" spellcheck="false" autocomplete="off" placeholder="read run:* run:command:12 commands:write files:write admin *">
+                    </div>
+                    <div class="input-line">
+                        <label for="popup-token-expires">Expires</label>
+                        <input id="popup-token-expires" type="datetime-local" class="command-text">
+                    </div>
+                    <div class="input-line" id="popup-new-token-line" style="display: none">
+                        <label for="popup-new-token">New token, it is shown only once</label>
+                        <input id="popup-new-token" type="text" class="command-text" readonly>
+                    </div>
+                    <button id="popup-add-token-btn" class="normal-button small-button" style="align-self: flex-end">Create token</button>
+                    <div class="popup-buttons" style="margin-top: 30px">
+                      <button id="popup-cancel-btn" class="normal-button red-button">Close</button>
+                    </div>
+                  </div>`;
+    document.body.appendChild(popup);
+    setTimeout(() => {
+        document.querySelector(".popup-backdrop").classList.remove("hidden");
+        document.querySelector(".popup-content").classList.remove("hidden");
+    }, 20)
+    // Only admin manages users, others can only change own password here
+    if (hasRole("admin")) {
+        loadUsers();
+    } else {
+        document.getElementById("users-management").style.display = "none";
+    }
+    document.getElementById('popup-add-user-btn').onclick = function() {
+        const nameInput = document.getElementById("popup-user-name");
+        const passwordInput = document.getElementById("popup-user-password");
+        const roleSelect = document.getElementById("popup-user-role");
+        fetch(`${apiBase}users`, {
+            method: "POST",
+            headers: {
+                'Content-Type': 'application/json'
+            },
+            body: JSON.stringify({username: nameInput.value, password: passwordInput.value, role: roleSelect.value})
+        }).then(async response => {
+            if (!response.ok) {
+                const errorText = await response.text();
+                throw new Error(`Server error: ${response.status} - ${errorText}`);
+            }
+            nameInput.value = "";
+            passwordInput.value = "";
+            loadUsers();
+        }).catch(err => {
+            console.error('Ошибка:', err);
+            showErrorPopup(
+                'Ошибка создания пользователя',
+                'Не удалось создать пользователя.',
+                err.message
+            );
+        });
+    };
+    loadApiTokens();
+    document.getElementById('popup-add-token-btn').onclick = function() {
+        const nameInput = document.getElementById("popup-token-name");
+        const scopesInput = document.getElementById("popup-token-scopes");
+        const expiresInput = document.getElementById("popup-token-expires");
+        const request = {name: nameInput.value, scopes: scopesInput.value.split(/\s+/).filter(scope => scope)};
+        if (expiresInput.value) {
+            request["expires-at"] = new Date(expiresInput.value).toISOString();
+        }
+        fetch(`${apiBase}tokens`, {
+            method: "POST",
+            headers: {
+                'Content-Type': 'application/json'
+            },
+            body: JSON.stringify(request)
+        }).then(async response => {
+            if (!response.ok) {
+                const errorText = await response.text();
+                throw new Error(`Server error: ${response.status} - ${errorText}`);
+            }
+            return response.json();
+        }).then(created => {
+            nameInput.value = "";
+            scopesInput.value = "";
+            expiresInput.value = "";
+            document.getElementById("popup-new-token").value = created.token;
+            document.getElementById("popup-new-token-line").style.display = "";
+            loadApiTokens();
+        }).catch(err => {
+            console.error('Ошибка:', err);
+            showErrorPopup(
+                'Ошибка создания токена',
+                'Не удалось создать токен.',
+                err.message
+            );
+        });
+    };
+    document.getElementById('popup-change-password-btn').onclick = function() {
+        const oldInput = document.getElementById("popup-old-password");
+        const newInput = document.getElementById("popup-new-password");
+        fetch(`${apiBase}auth/password`, {
+            method: "PUT",
+            headers: {
+                'Content-Type': 'application/json'
+            },
+            body: JSON.stringify({"old-password": oldInput.value, "new-password": newInput.value})
+        }).then(async response => {
+            if (!response.ok) {
+                const errorText = await response.text();
+                throw new Error(`Server error: ${response.status} - ${errorText}`);
+            }
+            oldInput.value = "";
+            newInput.value = "";
+        }).catch(err => {
+            console.error('Ошибка:', err);
+            showErrorPopup(
+                'Ошибка смены пароля',
+                'Не удалось сменить пароль.',
+                err.message
+            );
+        });
+    };
+    document.getElementById('popup-cancel-btn').onclick = function() {
+        document.querySelector(".popup-backdrop").classList.add("hidden");
+        document.querySelector(".popup-content").classList.add("hidden");
+        setTimeout(
+            () => {
+                document.body.removeChild(popup);
+            },
+            300
+        );
+    };
+}
+
+// formatAuditDiff show changed fields of audit entry as "field: old → new"
+function formatAuditDiff(diff) {
+    if (!diff) return "";
+    return Object.entries(diff).map(([name, change]) => {
+        const format = value => value === undefined ? "∅" : JSON.stringify(value);
+        return `${name}: ${format(change.old)} → ${format(change.new)}`;
+    }).join("\n");
+}
+
+function loadAuditLog() {
+    const params = new URLSearchParams();
+    for (const name of ["actor", "action", "target"]) {
+        const value = document.getElementById(`popup-audit-${name}`).value.trim();
+        if (value) {
+            params.set(name, value);
+        }
+    }
+    const list = document.getElementById("audit-list");
+    Promise.all([
+        fetch(`${apiBase}audit?${params}`),
+        fetch(`${apiBase}audit/verify`)
+    ]).then(async responses => {
+        for (const response of responses) {
+            if (!response.ok) {
+                const errorText = await response.text();
+                throw new Error(`Server error: ${response.status} - ${errorText}`);
+            }
+        }
+        return Promise.all(responses.map(response => response.json()));
+    }).then(([entries, verification]) => {
+        document.getElementById("audit-verification").textContent = verification.valid
+            ? `Chain is valid, ${verification.checked} entries checked`
+            : `Chain is broken at entry #${verification["broken-id"]}, log was changed`;
+        if (entries.length === 0) {
+            list.innerHTML = `<p>No entries</p>`;
+            return;
+        }
+        list.innerHTML = entries.map(entry => `
+            <div class="input-line">
+                <span class="command-text" title="${escapeHTML(formatAuditDiff(entry.diff))}">#${entry.id}, ${new Date(entry.time).toLocaleString()}, ${escapeHTML(entry.actor)} (${escapeHTML(entry.ip)}), ${escapeHTML(entry.action)} ${escapeHTML(entry.target)}</span>
+            </div>`).join("");
+    }).catch(err => {
+        console.error('Ошибка:', err);
+        showErrorPopup(
+            'Ошибка загрузки журнала',
+            'Не удалось загрузить журнал аудита.',
+            err.message
+        );
+    });
+}
+
+function showAuditLog(event) {
+    const popup = document.createElement('div');
+    popup.id = 'popup';
+    popup.innerHTML = `
+                  <div class="popup-backdrop hidden"></div>
+                  <div class="popup-content big-popup hidden">
+                    <h2>Audit log</h2>
+                    <p id="audit-verification"></p>
+                    <div class="input-line">
+                        <input id="popup-audit-actor" type="text" class="command-text" spellcheck="false" autocomplete="off" placeholder="actor">
+                        <input id="popup-audit-action" type="text" class="command-text" spellcheck="false" autocomplete="off" placeholder="action, like command.update">
+                        <input id="popup-audit-target" type="text" class="command-text" spellcheck="false" autocomplete="off" placeholder="target, like command:12">
+                        <button id="popup-audit-filter-btn" class="normal-button small-button">Filter</button>
+                    </div>
+                    <div id="audit-list">
+                        <p>Loading entries...</p>
+                    </div>
+                    <div class="popup-buttons" style="margin-top: 30px">
+                      <button id="popup-cancel-btn" class="normal-button red-button">Close</button>
+                    </div>
+                  </div>`;
+    document.body.appendChild(popup);
+    setTimeout(() => {
+        document.querySelector(".popup-backdrop").classList.remove("hidden");
+        document.querySelector(".popup-content").classList.remove("hidden");
+    }, 20)
+    loadAuditLog();
+    document.getElementById('popup-audit-filter-btn').onclick = loadAuditLog;
+    document.getElementById('popup-cancel-btn').onclick = function() {
+        document.querySelector(".popup-backdrop").classList.add("hidden");
+        document.querySelector(".popup-content").classList.add("hidden");
+        setTimeout(
+            () => {
+                document.body.removeChild(popup);
+            },
+            300
+        );
+    };
+}
+
+function renderCommandGrants(users, grants) {
+    const list = document.getElementById("grants-list");
+    if (!list) return;
+    const levels = {};
+    for (const grant of grants) {
+        levels[grant["user-id"]] = grant.level;
+    }
+    // Admins always have full access, grants are not used for them
+    const others = users.filter(user => user.role !== "admin");
+    if (others.length === 0) {
+        list.innerHTML = '<p style="opacity: 0.7;">Only admins, they can do anything</p>';
+        return;
+    }
+    list.innerHTML = others.map(user => `
+        <div class="input-line">
+            <span class="command-text">${escapeHTML(user.username)}</span>
+            <select class="command-text" data-user-id="${user.id}">
+                <option value="">By role (${user.role})</option>
+                ${AccessLevels.map(level => `<option value="${level}" ${level === levels[user.id] ? "selected" : ""}>${level}</option>`).join("")}
+            </select>
+        </div>`).join("");
+    for (const select of list.querySelectorAll("select[data-user-id]")) {
+        select.addEventListener("change", () => {
+            const url = `${apiBase}commands/${commandId}/grants/${select.dataset.userId}`;
+            const request = select.value === "" ?
+                fetch(url, {method: "DELETE"}) :
+                fetch(url, {
+                    method: "PUT",
+                    headers: {
+                        'Content-Type': 'application/json'
+                    },
+                    body: JSON.stringify({level: select.value})
+                });
+            request.then(async response => {
+                if (!response.ok) {
+                    const errorText = await response.text();
+                    throw new Error(`Server error: ${response.status} - ${errorText}`);
+                }
+            }).catch(err => {
+                console.error('Ошибка:', err);
+                showErrorPopup(
+                    'Ошибка изменения доступа',
+                    'Не удалось изменить доступ пользователя к команде.',
+                    err.message
+                );
+                loadCommandGrants();
+            });
+        });
+    }
+}
+
+function loadCommandGrants() {
+    const getJson = url => fetch(url).then(async response => {
+        if (!response.ok) {
+            const errorText = await response.text();
+            throw new Error(`Server error: ${response.status} - ${errorText}`);
+        }
+        return response.json();
+    });
+    return Promise.all([getJson(`${apiBase}users`), getJson(`${apiBase}commands/${commandId}/grants`)])
+        .then(([users, grants]) => renderCommandGrants(users, grants))
+        .catch(err => {
+            console.error('Ошибка:', err);
+            showErrorPopup(
+                'Ошибка загрузки доступа',
+                'Не удалось загрузить доступ пользователей к команде.',
+                err.message
+            );
+        });
+}
+
+function logout(event) {
+    fetch(`${apiBase}auth/logout`, {
+        method: "POST"
+    }).then(() => {
+        window.location.href = "/login";
+    });
+}
+
 function addNewCommand(event) {
     const popup = document.createElement('div');
     popup.id = 'popup';
@@ -644,6 +2017,7 @@ function selectCommand(num) {
         closeTerminal();
     }
     commandId=num;
+    runParameters = {};
     selectButtonIcons(num);
     loadCommand();
 }
@@ -712,24 +2086,27 @@ function renderCommandsList(withAnimation = false) {
                 </p>`;
         listElem.appendChild(elem);
     }
-    let elem = document.createElement("li");
-    elem.id = `new-command-btn`;
-    if (withAnimation) {
-        elem.classList.add("width-0-for-animation");
+    // Only editors can add commands
+    if (canEditCommands()) {
+        let elem = document.createElement("li");
+        elem.id = `new-command-btn`;
+        if (withAnimation) {
+            elem.classList.add("width-0-for-animation");
+        }
+        elem.innerHTML = `<button class="round-button">
+                        <img width="100%" src="../static/vectors/plus.svg" alt="Add new command"/>
+                    </button>
+                    <p class="command-text command-name" onWheel="smoothHorizontalScroll(event)">
+                        Add new
+                    </p>`;
+        elem.addEventListener("click", addNewCommand);
+        listElem.appendChild(elem);
     }
-    elem.innerHTML = `<button class="round-button">
-                    <img width="100%" src="../static/vectors/plus.svg" alt="Add new command"/>
-                </button>
-                <p class="command-text command-name" onWheel="smoothHorizontalScroll(event)">
-                    Add new
-                </p>`;
-    elem.addEventListener("click", addNewCommand);
-    listElem.appendChild(elem);
     selectButtonIcons(commandId);
     if (withAnimation) {
         setTimeout(() => {
             document.getElementById("command-list").classList.remove("gap-0-for-animation");
-            for (elem of document.querySelectorAll(".width-0-for-animation")) {
+            for (const elem of document.querySelectorAll(".width-0-for-animation")) {
                 elem.classList.remove("width-0-for-animation");
             }
         }, 30);
@@ -0,0 +1,32 @@
+const apiBase = "/api/v1/"
+
+document.getElementById("login-form").addEventListener("submit", login);
+
+function login(event) {
+    event.preventDefault();
+    const error = document.getElementById("login-error");
+    error.textContent = "";
+    fetch(`${apiBase}auth/login`, {
+        method: "POST",
+        headers: {
+            'Content-Type': 'application/json'
+        },
+        body: JSON.stringify({
+            username: document.getElementById("login-username").value,
+            password: document.getElementById("login-password").value
+        })
+    }).then(async response => {
+        if (response.status === 401) {
+            error.textContent = "Wrong username or password";
+            return;
+        }
+        if (!response.ok) {
+            const errorText = await response.text();
+            throw new Error(`Server error: ${response.status} - ${errorText}`);
+        }
+        window.location.href = "/";
+    }).catch(err => {
+        console.error('Ошибка:', err);
+        error.textContent = err.message;
+    });
+}
@@ -2,6 +2,8 @@ let termInputedText = [];
 let term;
 let commandRunning = false
 const fitAddon = new FitAddon.FitAddon();
+const TerminalResizeDelay = 100
+let terminalResizeTimeout
 
 function initTerminal() {
     let termElem = document.getElementById('terminal')
@@ -27,6 +29,11 @@ function initTerminal() {
     term.resize(term.cols - 2, term.rows)
 
     term.onData(onTermData)
+    term.onResize(onTermResize)
+    window.addEventListener("resize", () => {
+        clearTimeout(terminalResizeTimeout);
+        terminalResizeTimeout = setTimeout(fitTerminal, TerminalResizeDelay);
+    })
     // term.onKey(onKey)
 }
 
@@ -47,6 +54,19 @@ function onTermData(data){
 }
 
 
+function fitTerminal() {
+    try {
+        fitAddon.fit();
+        term.resize(term.cols - 2, term.rows);
+    } catch (_) {}
+}
+
+// Size is sent only after the last resize, fitTerminal resizes twice
+function onTermResize({cols, rows}) {
+    clearTimeout(terminalResizeTimeout);
+    terminalResizeTimeout = setTimeout(() => sendTerminalSize(cols, rows), TerminalResizeDelay);
+}
+
 // function onKey(e){
 //     let char = e.key
 //     if (!commandRunning) {